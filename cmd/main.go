@@ -1,40 +1,49 @@
 package main
 
 import (
-    "MyOwnRedis/internal/database/inMemoryDatabase"
+    "MyOwnRedis/internal/config"
     "MyOwnRedis/internal/server"
     "context"
-    "fmt"
+    "errors"
     "log"
+    "net"
     "os"
     "os/signal"
     "syscall"
-    "time"
 )
 
-const RedisDefaultPort = 6379
-
 func main() {
-    db := inMemoryDatabase.New()
-    srv := server.New(fmt.Sprintf("localhost:%d", RedisDefaultPort), db)
+    // Usage: rredis [/path/to/redis.conf] [--name value ...]
+    file, err := config.Load(os.Args[1:])
+    if err != nil {
+        log.Fatalf("RRedis %v", err)
+    }
+    srv, err := server.NewFromConfig(file)
+    if err != nil {
+        log.Fatalf("RRedis %v", err)
+    }
 
     go func() {
         err := srv.Run()
-        if err != nil {
+        if err != nil && !errors.Is(err, net.ErrClosed) {
             log.Fatalf("RRedis server start up error: %v", err)
         }
     }()
 
     sigChan := make(chan os.Signal, 1)
     signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-    // Try receiving from channel sigChan (blocking), main routine blocked.
-    <-sigChan
-    shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    // Cancel the context after timeout (sends signal to the Done chan).
-    defer cancel()
-
-    if err := srv.Close(shutdownCtx); err != nil {
-        log.Fatalf("RRedis server shutdown error: %v", err)
+    for {
+        select {
+        case <-sigChan:
+            // Saves the databases if save points are configured, the server keeps on running if that fails.
+            if err := srv.Shutdown(context.Background(), server.ShutdownOptions{}); err != nil {
+                log.Printf("RRedis server shutdown error: %v", err)
+                continue
+            }
+        case <-srv.Done():
+            // Shut down with SHUTDOWN.
+        }
+        log.Println("RRedis shutdown complete.")
+        return
     }
-    log.Println("RRedis shutdown complete.")
 }
@@ -0,0 +1,531 @@
+// Package acl implements the access control lists of Redis: users, authenticated by password, allowed to run some commands,
+// on some keys and channels only.
+//
+// The permissions of a user are set by rules, as given to ACL SETUSER or written in an ACL file, e.g.
+// `on >secret ~cache:* %R~app:* &news:* +@read -keys`.
+package acl
+
+import (
+    "MyOwnRedis/internal/glob"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "slices"
+    "strings"
+    "sync"
+)
+
+// DefaultUser is the user clients are authenticated as when they connect.
+const DefaultUser = "default"
+
+var (
+    ErrNoSuchUser          = errors.New("error no such user")
+    ErrDefaultUser         = errors.New("error the 'default' user cannot be removed")
+    ErrInvalidUsername     = errors.New("error usernames can't contain spaces or null characters")
+    ErrSyntax              = errors.New("Syntax error")
+    ErrUnknownCommand      = errors.New("Unknown command or category name in ACL")
+    ErrInvalidPasswordHash = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+    ErrNoSuchPassword      = errors.New("no such password")
+)
+
+// Reason is the reason a command is denied, as reported by ACL LOG.
+type Reason string
+
+const (
+    ReasonCommand Reason = "command"
+    ReasonKey     Reason = "key"
+    ReasonChannel Reason = "channel"
+    ReasonAuth    Reason = "auth"
+)
+
+// PermissionError is returned when a user isn't allowed to run a command: Object is the command, key or channel denied.
+type PermissionError struct {
+    Reason Reason
+    Object string
+}
+
+func (e *PermissionError) Error() string {
+    return fmt.Sprintf("no permissions to access the '%s' %s", e.Object, e.Reason)
+}
+
+// keyPattern is a pattern of the keys a user can access, for reading, writing or both.
+type keyPattern struct {
+    pattern string
+    access  Access
+}
+
+// User is a user of the server and its permissions.
+type User struct {
+    Name    string
+    enabled bool
+    // nopass users authenticate with any password, passwords holds the SHA-256 of the passwords of the others.
+    nopass    bool
+    passwords []string
+    // commands are the command rules applied in order, e.g. `+@read` or `-keys`, starting from no commands at all.
+    commands []string
+    keys     []keyPattern
+    channels []string
+}
+
+// newUser creates a user without any permission, which can't authenticate.
+func newUser(name string) *User {
+    return &User{Name: name}
+}
+
+// clone returns a deep copy of the user, so rules are applied all or nothing.
+func (u *User) clone() *User {
+    c := *u
+    c.passwords = slices.Clone(u.passwords)
+    c.commands = slices.Clone(u.commands)
+    c.keys = slices.Clone(u.keys)
+    c.channels = slices.Clone(u.channels)
+    return &c
+}
+
+// hashPassword returns the SHA-256 of a password, in hexadecimal.
+func hashPassword(password string) string {
+    sum := sha256.Sum256([]byte(password))
+    return hex.EncodeToString(sum[:])
+}
+
+// isPasswordHash reports whether s is a SHA-256 in lowercase hexadecimal.
+func isPasswordHash(s string) bool {
+    if len(s) != 64 {
+        return false
+    }
+    for _, c := range []byte(s) {
+        if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+            return false
+        }
+    }
+    return true
+}
+
+// apply applies a single rule to the user.
+func (u *User) apply(rule string) error {
+    lower := strings.ToLower(rule)
+    switch lower {
+    case "on":
+        u.enabled = true
+    case "off":
+        u.enabled = false
+    case "nopass":
+        u.nopass, u.passwords = true, nil
+    case "resetpass":
+        u.nopass, u.passwords = false, nil
+    case "allkeys":
+        u.keys = []keyPattern{{pattern: "*", access: Read | Write}}
+    case "resetkeys":
+        u.keys = nil
+    case "allchannels":
+        u.channels = []string{"*"}
+    case "resetchannels":
+        u.channels = nil
+    case "allcommands":
+        u.commands = []string{"+@all"}
+    case "nocommands":
+        u.commands = nil
+    case "reset":
+        *u = *newUser(u.Name)
+    default:
+        return u.applyPattern(rule)
+    }
+    return nil
+}
+
+// applyPattern applies a rule with an argument: a password, a key or channel pattern, or a command rule.
+func (u *User) applyPattern(rule string) error {
+    if rule == "" {
+        return ErrSyntax
+    }
+    switch rule[0] {
+    case '>':
+        u.addPassword(hashPassword(rule[1:]))
+    case '#':
+        if !isPasswordHash(rule[1:]) {
+            return ErrInvalidPasswordHash
+        }
+        u.addPassword(rule[1:])
+    case '<', '!':
+        hash := rule[1:]
+        if rule[0] == '<' {
+            hash = hashPassword(hash)
+        } else if !isPasswordHash(hash) {
+            return ErrInvalidPasswordHash
+        }
+        i := slices.Index(u.passwords, hash)
+        if i < 0 {
+            return ErrNoSuchPassword
+        }
+        u.passwords = slices.Delete(u.passwords, i, i+1)
+    case '~':
+        u.addKeyPattern(rule[1:], Read|Write)
+    case '%':
+        flags, pattern, found := strings.Cut(rule[1:], "~")
+        if !found || flags == "" {
+            return ErrSyntax
+        }
+        var access Access
+        for _, flag := range strings.ToUpper(flags) {
+            switch flag {
+            case 'R':
+                access |= Read
+            case 'W':
+                access |= Write
+            default:
+                return ErrSyntax
+            }
+        }
+        u.addKeyPattern(pattern, access)
+    case '&':
+        if !slices.Contains(u.channels, "*") && !slices.Contains(u.channels, rule[1:]) {
+            u.channels = append(u.channels, rule[1:])
+        }
+    case '+', '-':
+        return u.addCommandRule(rule)
+    default:
+        return ErrSyntax
+    }
+    return nil
+}
+
+// addPassword adds the hash of a password, which turns nopass off.
+func (u *User) addPassword(hash string) {
+    u.nopass = false
+    if !slices.Contains(u.passwords, hash) {
+        u.passwords = append(u.passwords, hash)
+    }
+}
+
+// addKeyPattern allows access to the keys matching pattern, on top of the access already allowed.
+func (u *User) addKeyPattern(pattern string, access Access) {
+    for i, p := range u.keys {
+        if p.pattern == pattern {
+            u.keys[i].access |= access
+            return
+        }
+    }
+    u.keys = append(u.keys, keyPattern{pattern: pattern, access: access})
+}
+
+// addCommandRule adds a rule allowing or denying a command, a subcommand or a category, e.g. `+get`, `-client|kill` or `+@read`.
+func (u *User) addCommandRule(rule string) error {
+    rule = strings.ToLower(rule)
+    name := rule[1:]
+    if category, ok := strings.CutPrefix(name, "@"); ok {
+        if category == "all" {
+            // Every previous rule is overridden.
+            u.commands = nil
+            if rule[0] == '+' {
+                u.commands = []string{rule}
+            }
+            return nil
+        }
+        if !slices.Contains(categories, category) {
+            return ErrUnknownCommand
+        }
+    } else if !CommandExists(name) {
+        return ErrUnknownCommand
+    }
+    // A later rule on the same command overrides the previous ones.
+    u.commands = slices.DeleteFunc(u.commands, func(r string) bool {
+        return r[1:] == name
+    })
+    u.commands = append(u.commands, rule)
+    return nil
+}
+
+// canRun reports whether the user may run a command, given its full name.
+func (u *User) canRun(fullName string) bool {
+    cmd, ok := lookupCommand(fullName)
+    if !ok {
+        return false
+    }
+    parent, _, _ := strings.Cut(fullName, "|")
+    allowed := false
+    for _, rule := range u.commands {
+        name := rule[1:]
+        var matches bool
+        if category, ok := strings.CutPrefix(name, "@"); ok {
+            matches = category == "all" || slices.Contains(cmd.categories, category)
+        } else {
+            matches = name == fullName || name == parent
+        }
+        if matches {
+            allowed = rule[0] == '+'
+        }
+    }
+    return allowed
+}
+
+// canAccessKey reports whether the user may access a key.
+func (u *User) canAccessKey(key string, access Access) bool {
+    for _, p := range u.keys {
+        if p.access&access == access && glob.Match(p.pattern, key, false) {
+            return true
+        }
+    }
+    return false
+}
+
+// canAccessChannel reports whether the user may access a channel, or a pattern of channels, which must then be allowed literally.
+func (u *User) canAccessChannel(channel string, pattern bool) bool {
+    for _, p := range u.channels {
+        if p == "*" || pattern && p == channel || !pattern && glob.Match(p, channel, false) {
+            return true
+        }
+    }
+    return false
+}
+
+// check returns a *PermissionError if the user isn't allowed to run a command, args being the arguments after the command name.
+func (u *User) check(name string, args []string) error {
+    fullName := CommandName(name, args)
+    cmd, ok := lookupCommand(fullName)
+    // A command missing from the commands table is denied to everyone, rather than allowed to everyone.
+    if !ok {
+        return &PermissionError{Reason: ReasonCommand, Object: fullName}
+    }
+    if cmd.noAuth {
+        return nil
+    }
+    if !u.canRun(fullName) {
+        return &PermissionError{Reason: ReasonCommand, Object: fullName}
+    }
+    for _, key := range cmd.keys.args(args) {
+        if !u.canAccessKey(key, cmd.access) {
+            return &PermissionError{Reason: ReasonKey, Object: key}
+        }
+    }
+    for _, channel := range cmd.channels.args(args) {
+        if !u.canAccessChannel(channel, cmd.patterns) {
+            return &PermissionError{Reason: ReasonChannel, Object: channel}
+        }
+    }
+    return nil
+}
+
+// Rules describes the permissions of the user as rules, in the format of ACL LIST and ACL files.
+func (u *User) Rules() []string {
+    rules := []string{"off"}
+    if u.enabled {
+        rules[0] = "on"
+    }
+    if u.nopass {
+        rules = append(rules, "nopass")
+    }
+    for _, hash := range u.passwords {
+        rules = append(rules, "#"+hash)
+    }
+    rules = append(rules, u.keyRules()...)
+    rules = append(rules, u.channelRules()...)
+    return append(rules, u.commandRules())
+}
+
+// keyRules describes the key patterns of the user, e.g. `~cache:*` or `%R~app:*`.
+func (u *User) keyRules() []string {
+    rules := make([]string, 0, len(u.keys))
+    for _, p := range u.keys {
+        switch p.access {
+        case Read | Write:
+            rules = append(rules, "~"+p.pattern)
+        case Read:
+            rules = append(rules, "%R~"+p.pattern)
+        case Write:
+            rules = append(rules, "%W~"+p.pattern)
+        }
+    }
+    return rules
+}
+
+// channelRules describes the channel patterns of the user, `resetchannels` if there's none.
+func (u *User) channelRules() []string {
+    if len(u.channels) == 0 {
+        return []string{"resetchannels"}
+    }
+    rules := make([]string, 0, len(u.channels))
+    for _, channel := range u.channels {
+        rules = append(rules, "&"+channel)
+    }
+    return rules
+}
+
+// commandRules describes the command rules of the user, starting with either `+@all` or `-@all`.
+func (u *User) commandRules() string {
+    if len(u.commands) == 0 || u.commands[0] != "+@all" {
+        return strings.Join(append([]string{"-@all"}, u.commands...), " ")
+    }
+    return strings.Join(u.commands, " ")
+}
+
+// UserInfo is the description of a user replied by ACL GETUSER.
+type UserInfo struct {
+    Flags     []string
+    Passwords []string
+    Commands  string
+    Keys      string
+    Channels  string
+}
+
+// ACL holds the users of the server. It is safe for concurrent use.
+type ACL struct {
+    users map[string]*User
+    sync.RWMutex
+}
+
+// New creates the ACL of a server with the default user only, allowed to run every command without password.
+func New() *ACL {
+    return &ACL{users: map[string]*User{DefaultUser: newDefaultUser()}}
+}
+
+// newDefaultUser creates the default user, as configured unless an ACL file says otherwise.
+func newDefaultUser() *User {
+    u := newUser(DefaultUser)
+    for _, rule := range []string{"on", "nopass", "allkeys", "allchannels", "allcommands"} {
+        _ = u.apply(rule)
+    }
+    return u
+}
+
+// SetUser creates or modifies a user, applying rules in order. The rules are applied all or nothing:
+// if a rule is invalid, the user is left untouched and the error tells which rule.
+func (a *ACL) SetUser(name string, rules ...string) error {
+    if name == "" || strings.ContainsAny(name, " \x00") {
+        return ErrInvalidUsername
+    }
+    a.Lock()
+    defer a.Unlock()
+    u, ok := a.users[name]
+    if ok {
+        u = u.clone()
+    } else {
+        u = newUser(name)
+    }
+    for _, rule := range rules {
+        if err := u.apply(rule); err != nil {
+            return fmt.Errorf("Error in ACL SETUSER modifier '%s': %w", rule, err)
+        }
+    }
+    a.users[name] = u
+    return nil
+}
+
+// DelUser removes users and returns the number of users removed. The default user can't be removed.
+func (a *ACL) DelUser(names ...string) (int, error) {
+    if slices.Contains(names, DefaultUser) {
+        return 0, ErrDefaultUser
+    }
+    a.Lock()
+    defer a.Unlock()
+    n := 0
+    for _, name := range names {
+        if _, ok := a.users[name]; ok {
+            delete(a.users, name)
+            n++
+        }
+    }
+    return n, nil
+}
+
+// Users returns the names of the users, sorted.
+func (a *ACL) Users() []string {
+    a.RLock()
+    defer a.RUnlock()
+    names := make([]string, 0, len(a.users))
+    for name := range a.users {
+        names = append(names, name)
+    }
+    slices.Sort(names)
+    return names
+}
+
+// List describes every user, sorted by name, e.g. `user default on nopass ~* &* +@all`.
+func (a *ACL) List() []string {
+    a.RLock()
+    defer a.RUnlock()
+    lines := make([]string, 0, len(a.users))
+    for _, u := range a.users {
+        lines = append(lines, "user "+u.Name+" "+strings.Join(u.Rules(), " "))
+    }
+    slices.Sort(lines)
+    return lines
+}
+
+// GetUser describes a user, false if the user doesn't exist.
+func (a *ACL) GetUser(name string) (UserInfo, bool) {
+    a.RLock()
+    defer a.RUnlock()
+    u, ok := a.users[name]
+    if !ok {
+        return UserInfo{}, false
+    }
+    info := UserInfo{
+        Flags:     []string{"off"},
+        Passwords: slices.Clone(u.passwords),
+        Commands:  u.commandRules(),
+        Keys:      strings.Join(u.keyRules(), " "),
+        Channels:  strings.Join(u.channelRules(), " "),
+    }
+    if u.enabled {
+        info.Flags[0] = "on"
+    }
+    if u.nopass {
+        info.Flags = append(info.Flags, "nopass")
+    }
+    if info.Channels == "resetchannels" {
+        info.Channels = ""
+    }
+    return info, true
+}
+
+// Exists reports whether a user exists.
+func (a *ACL) Exists(name string) bool {
+    a.RLock()
+    defer a.RUnlock()
+    _, ok := a.users[name]
+    return ok
+}
+
+// Authenticate reports whether password is the password of the user, which must be enabled.
+func (a *ACL) Authenticate(name, password string) bool {
+    a.RLock()
+    defer a.RUnlock()
+    u, ok := a.users[name]
+    if !ok || !u.enabled {
+        return false
+    }
+    if u.nopass {
+        return true
+    }
+    hash := []byte(hashPassword(password))
+    ok = false
+    for _, h := range u.passwords {
+        // Every password is compared, in constant time, so the time taken doesn't tell which one matched.
+        if subtle.ConstantTimeCompare(hash, []byte(h)) == 1 {
+            ok = true
+        }
+    }
+    return ok
+}
+
+// NoPass reports whether a user is enabled and authenticates without password, e.g. the default user unless requirepass is set.
+func (a *ACL) NoPass(name string) bool {
+    a.RLock()
+    defer a.RUnlock()
+    u, ok := a.users[name]
+    return ok && u.enabled && u.nopass
+}
+
+// Check returns a *PermissionError if a user isn't allowed to run a command, args being the arguments after the command name,
+// in lowercase. ErrNoSuchUser is returned if the user doesn't exist, e.g. it was removed.
+func (a *ACL) Check(user, name string, args []string) error {
+    a.RLock()
+    defer a.RUnlock()
+    u, ok := a.users[user]
+    if !ok {
+        return ErrNoSuchUser
+    }
+    return u.check(name, args)
+}
@@ -0,0 +1,222 @@
+package acl
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestACL_SetUser(t *testing.T) {
+    a := New()
+    testCases := []struct {
+        rules    []string
+        expected string
+        err      error
+    }{
+        {nil, "user alice off resetchannels -@all", nil},
+        {[]string{"on", ">secret", "~cache:*", "%R~app:*", "&news:*", "+@read", "-keys"},
+            "user alice on #2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b ~cache:* %R~app:* &news:* -@all +@read -keys", nil},
+        {[]string{"%W~app:*", "+client", "-client|kill"},
+            "user alice on #2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b ~cache:* ~app:* &news:* -@all +@read -keys +client -client|kill", nil},
+        // All or nothing.
+        {[]string{"off", "+nosuchcommand"}, "", ErrUnknownCommand},
+        {[]string{"#123"}, "", ErrInvalidPasswordHash},
+        {[]string{"%X~foo"}, "", ErrSyntax},
+        {[]string{"<wrong"}, "", ErrNoSuchPassword},
+        {[]string{"<secret", "nopass", "resetkeys", "allchannels", "allcommands", "-flushall"},
+            "user alice on nopass &* +@all -flushall", nil},
+        {[]string{"reset"}, "user alice off resetchannels -@all", nil},
+    }
+    for _, tc := range testCases {
+        err := a.SetUser("alice", tc.rules...)
+        if !errors.Is(err, tc.err) {
+            t.Errorf("Error SETUSER %q: expected %v, got %v.\n", tc.rules, tc.err, err)
+        }
+        if err != nil {
+            continue
+        }
+        if list := a.List(); len(list) != 2 || list[0] != tc.expected {
+            t.Errorf("Error SETUSER %q: expected %q, got %q.\n", tc.rules, tc.expected, list)
+        }
+    }
+
+    if err := a.SetUser("bad name"); !errors.Is(err, ErrInvalidUsername) {
+        t.Errorf("Error SETUSER with a space: expected %v, got %v.\n", ErrInvalidUsername, err)
+    }
+    if _, err := a.DelUser(DefaultUser); !errors.Is(err, ErrDefaultUser) {
+        t.Errorf("Error DELUSER default: expected %v, got %v.\n", ErrDefaultUser, err)
+    }
+    if n, err := a.DelUser("alice", "missing"); n != 1 || err != nil {
+        t.Errorf("Error DELUSER: expected 1, got %d ( %v ).\n", n, err)
+    }
+    if users := a.Users(); !reflect.DeepEqual(users, []string{DefaultUser}) {
+        t.Errorf("Error USERS: expected %q, got %q.\n", []string{DefaultUser}, users)
+    }
+}
+
+func TestACL_Check(t *testing.T) {
+    a := New()
+    if err := a.SetUser("alice", "on", ">secret", "~cache:*", "%R~app:*", "&news:*", "+@read", "+@pubsub", "+set", "-keys", "+client", "-client|kill"); err != nil {
+        t.Fatal(err)
+    }
+
+    testCases := []struct {
+        args     []string
+        expected *PermissionError
+    }{
+        {[]string{"get", "cache:1"}, nil},
+        {[]string{"get", "app:1"}, nil},
+        {[]string{"get", "other"}, &PermissionError{ReasonKey, "other"}},
+        {[]string{"set", "cache:1", "v"}, nil},
+        {[]string{"set", "app:1", "v"}, &PermissionError{ReasonKey, "app:1"}},
+        {[]string{"exists", "cache:1", "secret"}, &PermissionError{ReasonKey, "secret"}},
+        {[]string{"keys", "*"}, &PermissionError{ReasonCommand, "keys"}},
+        {[]string{"flushall"}, &PermissionError{ReasonCommand, "flushall"}},
+        {[]string{"incr", "cache:1"}, &PermissionError{ReasonCommand, "incr"}},
+        {[]string{"client", "list"}, nil},
+        {[]string{"client", "KILL", "id", "1"}, &PermissionError{ReasonCommand, "client|kill"}},
+        {[]string{"publish", "news:sport", "goal"}, nil},
+        {[]string{"subscribe", "news:sport", "weather"}, &PermissionError{ReasonChannel, "weather"}},
+        {[]string{"psubscribe", "news:*"}, nil},
+        {[]string{"psubscribe", "news:s*"}, &PermissionError{ReasonChannel, "news:s*"}},
+        // AUTH and HELLO are always allowed.
+        {[]string{"auth", "alice", "secret"}, nil},
+        // Unknown commands are denied.
+        {[]string{"nosuchcommand"}, &PermissionError{ReasonCommand, "nosuchcommand"}},
+    }
+    for _, tc := range testCases {
+        err := a.Check("alice", tc.args[0], tc.args[1:])
+        var denied *PermissionError
+        if tc.expected == nil && err != nil || tc.expected != nil && (!errors.As(err, &denied) || *denied != *tc.expected) {
+            t.Errorf("Error checking %q: expected %v, got %v.\n", tc.args, tc.expected, err)
+        }
+    }
+    if err := a.Check(DefaultUser, "nosuchcommand", nil); err == nil {
+        t.Errorf("Error unknown commands should be denied even with +@all.\n")
+    }
+    if err := a.Check("missing", "ping", nil); !errors.Is(err, ErrNoSuchUser) {
+        t.Errorf("Error checking a missing user: expected %v, got %v.\n", ErrNoSuchUser, err)
+    }
+
+    if !a.Authenticate("alice", "secret") || a.Authenticate("alice", "wrong") || a.Authenticate("missing", "secret") {
+        t.Errorf("Error authenticating alice.\n")
+    }
+    if err := a.SetUser("alice", "off"); err != nil {
+        t.Fatal(err)
+    }
+    if a.Authenticate("alice", "secret") {
+        t.Errorf("Error a disabled user shouldn't authenticate.\n")
+    }
+    if !a.NoPass(DefaultUser) || !a.Authenticate(DefaultUser, "anything") {
+        t.Errorf("Error the default user should authenticate without password.\n")
+    }
+}
+
+func TestCategoryCommands(t *testing.T) {
+    commands, ok := CategoryCommands("transaction")
+    expected := []string{"discard", "exec", "multi", "unwatch", "watch"}
+    if !ok || !reflect.DeepEqual(commands, expected) {
+        t.Errorf("Error CAT transaction: expected %q, got %q.\n", expected, commands)
+    }
+    if _, ok = CategoryCommands("missing"); ok {
+        t.Errorf("Error CAT missing: expected an unknown category.\n")
+    }
+}
+
+func TestCommandName(t *testing.T) {
+    testCases := []struct {
+        name     string
+        args     []string
+        expected string
+    }{
+        {"get", []string{"key"}, "get"},
+        {"client", []string{"KILL", "id", "1"}, "client|kill"},
+        {"config", []string{"get", "port"}, "config|get"},
+        // Unknown subcommands are named after their command.
+        {"config", []string{"nosuchsubcommand"}, "config"},
+        {"acl", nil, "acl"},
+    }
+    for _, tc := range testCases {
+        if name := CommandName(tc.name, tc.args); name != tc.expected {
+            t.Errorf("Error naming %s %q: expected %s, got %s.\n", tc.name, tc.args, tc.expected, name)
+        }
+    }
+}
+
+func TestLog(t *testing.T) {
+    l := NewLog(2)
+    now := time.Now()
+    l.Add(ReasonCommand, "toplevel", "flushall", "alice", "id=1", now)
+    l.Add(ReasonKey, "toplevel", "secret", "alice", "id=1", now)
+    // The same denial is counted by the same entry, which becomes the most recent one.
+    l.Add(ReasonCommand, "toplevel", "flushall", "alice", "id=2", now.Add(time.Second))
+    entries := l.Entries(-1)
+    if len(entries) != 2 || entries[0].Count != 2 || entries[0].ClientInfo != "id=2" || entries[0].EntryID != 0 || entries[1].Object != "secret" {
+        t.Fatalf("Error grouping log entries: got %+v.\n", entries)
+    }
+
+    // Unless it happened long after.
+    l.Add(ReasonCommand, "toplevel", "flushall", "alice", "id=3", now.Add(2*time.Minute))
+    entries = l.Entries(10)
+    if len(entries) != 2 || entries[0].Count != 1 || entries[0].EntryID != 2 || entries[1].EntryID != 0 {
+        t.Errorf("Error adding log entries: got %+v.\n", entries)
+    }
+    l.SetMaxLen(1)
+    if entries = l.Entries(-1); len(entries) != 1 || entries[0].EntryID != 2 {
+        t.Errorf("Error trimming the log: got %+v.\n", entries)
+    }
+    l.Reset()
+    if entries = l.Entries(-1); len(entries) != 0 {
+        t.Errorf("Error resetting the log: got %+v.\n", entries)
+    }
+}
+
+func TestACL_LoadSave(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "users.acl")
+    content := "# Users.\nuser alice on >secret ~cache:* +@read\n\nuser bob off nopass\n"
+    if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+        t.Fatal(err)
+    }
+    a := New()
+    if err := a.SetUser("carol", "on"); err != nil {
+        t.Fatal(err)
+    }
+    if err := a.Load(path); err != nil {
+        t.Fatalf("Error loading the ACL file: %v.\n", err)
+    }
+    // The default user is created if missing, carol is removed.
+    expected := []string{"alice", "bob", DefaultUser}
+    if users := a.Users(); !reflect.DeepEqual(users, expected) {
+        t.Errorf("Error loading the ACL file: expected %q, got %q.\n", expected, users)
+    }
+    if !a.Authenticate("alice", "secret") {
+        t.Errorf("Error alice should authenticate.\n")
+    }
+
+    if err := a.Save(path); err != nil {
+        t.Fatal(err)
+    }
+    saved, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if lines := strings.Split(strings.TrimSpace(string(saved)), "\n"); !reflect.DeepEqual(lines, a.List()) {
+        t.Errorf("Error saving the ACL file: expected %q, got %q.\n", a.List(), lines)
+    }
+
+    for _, content := range []string{"alice on\n", "user alice +nosuchcommand\n", "user alice\nuser alice\n"} {
+        if err = os.WriteFile(path, []byte(content), 0644); err != nil {
+            t.Fatal(err)
+        }
+        if err = a.Load(path); err == nil {
+            t.Errorf("Error loading %q: expected an error.\n", content)
+        }
+    }
+    if users := a.Users(); !reflect.DeepEqual(users, expected) {
+        t.Errorf("Error a failed load should leave the users untouched, got %q.\n", users)
+    }
+}
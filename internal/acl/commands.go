@@ -0,0 +1,185 @@
+package acl
+
+import (
+    "slices"
+    "strings"
+)
+
+// Access is the access of a command to a key: read, write or both.
+type Access int
+
+const (
+    Read Access = 1 << iota
+    Write
+)
+
+// argSpec tells which arguments of a command are keys or channels: from first, every step arguments up to last,
+// counted from 1 after the command name. A negative last counts from the end, -1 being the last argument.
+type argSpec struct {
+    first, last, step int
+}
+
+// args returns the arguments of a command selected by spec.
+func (spec argSpec) args(args []string) []string {
+    if spec.first == 0 {
+        return nil
+    }
+    last := spec.last
+    if last < 0 {
+        last += len(args) + 1
+    }
+    var selected []string
+    for i := spec.first; i <= last && i <= len(args); i += max(spec.step, 1) {
+        selected = append(selected, args[i-1])
+    }
+    return selected
+}
+
+// command describes the permissions needed to run a command.
+type command struct {
+    categories []string
+    keys       argSpec
+    access     Access
+    channels   argSpec
+    // patterns is set if the channels are patterns, e.g. PSUBSCRIBE: they must be allowed as such.
+    patterns bool
+    // noAuth commands can run before the client is authenticated, whatever the permissions of the user.
+    noAuth bool
+}
+
+// Categories of commands, as listed by ACL CAT.
+var categories = []string{
+    "keyspace", "read", "write", "list", "string", "pubsub", "admin", "fast", "slow", "dangerous", "connection", "transaction",
+}
+
+// commands are the commands the server implements, subcommands being named `command|subcommand`.
+var commands = map[string]command{
+    "command":         {categories: []string{"slow", "connection"}},
+    "ping":            {categories: []string{"fast", "connection"}},
+    "echo":            {categories: []string{"fast", "connection"}},
+    "auth":            {categories: []string{"fast", "connection"}, noAuth: true},
+    "hello":           {categories: []string{"fast", "connection"}, noAuth: true},
+    "select":          {categories: []string{"fast", "connection"}},
+    "keys":            {categories: []string{"keyspace", "read", "slow", "dangerous"}},
+    "scan":            {categories: []string{"keyspace", "read", "slow"}},
+    "exists":          {categories: []string{"keyspace", "read", "fast"}, keys: argSpec{1, -1, 1}, access: Read},
+    "del":             {categories: []string{"keyspace", "write", "slow"}, keys: argSpec{1, -1, 1}, access: Write},
+    "move":            {categories: []string{"keyspace", "write", "fast"}, keys: argSpec{1, 1, 1}, access: Read | Write},
+    "swapdb":          {categories: []string{"keyspace", "write", "fast", "dangerous"}},
+    "pexpireat":       {categories: []string{"keyspace", "write", "fast"}, keys: argSpec{1, 1, 1}, access: Write},
+    "dbsize":          {categories: []string{"keyspace", "read", "fast"}},
+    "flushdb":         {categories: []string{"keyspace", "write", "slow", "dangerous"}},
+    "flushall":        {categories: []string{"keyspace", "write", "slow", "dangerous"}},
+    "get":             {categories: []string{"read", "string", "fast"}, keys: argSpec{1, 1, 1}, access: Read},
+    "set":             {categories: []string{"write", "string", "slow"}, keys: argSpec{1, 1, 1}, access: Write},
+    "incr":            {categories: []string{"write", "string", "fast"}, keys: argSpec{1, 1, 1}, access: Read | Write},
+    "decr":            {categories: []string{"write", "string", "fast"}, keys: argSpec{1, 1, 1}, access: Read | Write},
+    "lpush":           {categories: []string{"write", "list", "fast"}, keys: argSpec{1, 1, 1}, access: Write},
+    "rpush":           {categories: []string{"write", "list", "fast"}, keys: argSpec{1, 1, 1}, access: Write},
+    "lrange":          {categories: []string{"read", "list", "slow"}, keys: argSpec{1, 1, 1}, access: Read},
+    "multi":           {categories: []string{"fast", "transaction"}},
+    "exec":            {categories: []string{"slow", "transaction"}},
+    "discard":         {categories: []string{"fast", "transaction"}},
+    "watch":           {categories: []string{"fast", "transaction"}, keys: argSpec{1, -1, 1}, access: Read},
+    "unwatch":         {categories: []string{"fast", "transaction"}},
+    "subscribe":       {categories: []string{"pubsub", "slow"}, channels: argSpec{1, -1, 1}},
+    "psubscribe":      {categories: []string{"pubsub", "slow"}, channels: argSpec{1, -1, 1}, patterns: true},
+    "ssubscribe":      {categories: []string{"pubsub", "slow"}, channels: argSpec{1, -1, 1}},
+    "unsubscribe":     {categories: []string{"pubsub", "slow"}},
+    "punsubscribe":    {categories: []string{"pubsub", "slow"}},
+    "sunsubscribe":    {categories: []string{"pubsub", "slow"}},
+    "publish":         {categories: []string{"pubsub", "fast"}, channels: argSpec{1, 1, 1}},
+    "spublish":        {categories: []string{"pubsub", "fast"}, channels: argSpec{1, 1, 1}},
+    "pubsub":          {categories: []string{"pubsub", "slow"}},
+    "save":            {categories: []string{"admin", "slow", "dangerous"}},
+    "bgsave":          {categories: []string{"admin", "slow", "dangerous"}},
+    "bgrewriteaof":    {categories: []string{"admin", "slow", "dangerous"}},
+    "lastsave":        {categories: []string{"fast", "admin", "dangerous"}},
+    "shutdown":        {categories: []string{"admin", "slow", "dangerous"}},
+    "info":            {categories: []string{"slow", "dangerous"}},
+    "config":          {categories: []string{"admin", "slow", "dangerous"}},
+    "client":          {categories: []string{"slow", "connection"}},
+    "client|kill":     {categories: []string{"admin", "slow", "dangerous", "connection"}},
+    "client|list":     {categories: []string{"admin", "slow", "dangerous", "connection"}},
+    "client|pause":    {categories: []string{"admin", "slow", "dangerous", "connection"}},
+    "client|unpause":  {categories: []string{"admin", "slow", "dangerous", "connection"}},
+    "client|no-evict": {categories: []string{"admin", "slow", "dangerous", "connection"}},
+    "acl":             {categories: []string{"slow"}},
+    "acl|cat":         {categories: []string{"slow"}},
+    "acl|whoami":      {categories: []string{"slow"}},
+    "acl|dryrun":      {categories: []string{"admin", "slow", "dangerous"}},
+    "acl|setuser":     {categories: []string{"admin", "slow", "dangerous"}},
+    "acl|getuser":     {categories: []string{"admin", "slow", "dangerous"}},
+    "acl|deluser":     {categories: []string{"admin", "slow", "dangerous"}},
+    "acl|list":        {categories: []string{"admin", "slow", "dangerous"}},
+    "acl|users":       {categories: []string{"admin", "slow", "dangerous"}},
+    "acl|log":         {categories: []string{"admin", "slow", "dangerous"}},
+    "acl|load":        {categories: []string{"admin", "slow", "dangerous"}},
+    "acl|save":        {categories: []string{"admin", "slow", "dangerous"}},
+}
+
+// subcommands are the subcommands of the commands having some, by command.
+var subcommands = map[string][]string{
+    "client": {"id", "info", "list", "setname", "getname", "kill", "pause", "unpause", "no-evict", "reply", "getredir", "tracking",
+        "trackinginfo", "caching"},
+    "config": {"get", "set", "rewrite", "resetstat"},
+    "pubsub": {"channels", "numsub", "numpat", "shardchannels", "shardnumsub"},
+    "acl":    {"cat", "whoami", "setuser", "getuser", "deluser", "list", "users", "log", "dryrun", "load", "save"},
+}
+
+// CommandName returns the full name of a command: `command|subcommand` for the commands with subcommands, e.g. `client|kill`.
+// An unknown subcommand is named after its command, as with Redis, so the names are known in advance whatever the clients send.
+// name is in lowercase.
+func CommandName(name string, args []string) string {
+    if len(args) > 0 {
+        if subcommand := strings.ToLower(args[0]); slices.Contains(subcommands[name], subcommand) {
+            return name + "|" + subcommand
+        }
+    }
+    return name
+}
+
+// lookupCommand returns the description of a command given its full name, falling back to the parent command for subcommands.
+func lookupCommand(fullName string) (command, bool) {
+    if cmd, ok := commands[fullName]; ok {
+        return cmd, true
+    }
+    parent, _, found := strings.Cut(fullName, "|")
+    if !found {
+        return command{}, false
+    }
+    cmd, ok := commands[parent]
+    return cmd, ok
+}
+
+// Categories returns the categories of commands.
+func Categories() []string {
+    return slices.Clone(categories)
+}
+
+// CategoryCommands returns the commands of a category, sorted, false if the category doesn't exist.
+func CategoryCommands(category string) ([]string, bool) {
+    category = strings.ToLower(category)
+    if !slices.Contains(categories, category) {
+        return nil, false
+    }
+    var names []string
+    for name, cmd := range commands {
+        if slices.Contains(cmd.categories, category) {
+            names = append(names, name)
+        }
+    }
+    slices.Sort(names)
+    return names, true
+}
+
+// CommandExists reports whether a command exists, given its full name.
+func CommandExists(fullName string) bool {
+    _, ok := lookupCommand(fullName)
+    return ok
+}
+
+// NoAuth reports whether a command can run before the client is authenticated, e.g. AUTH or HELLO.
+func NoAuth(name string) bool {
+    return commands[name].noAuth
+}
@@ -0,0 +1,71 @@
+package acl
+
+import (
+    "MyOwnRedis/internal/config"
+    "bufio"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// Load replaces the users with those of an ACL file, one `user <name> [rule ...]` line per user, e.g. as written by Save.
+// The file is loaded all or nothing: on error, the users are left untouched. The default user is created
+// with every permission if the file doesn't set it.
+func (a *ACL) Load(path string) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    users := make(map[string]*User)
+    scanner := bufio.NewScanner(f)
+    for n := 1; scanner.Scan(); n++ {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        args, err := config.SplitArgs(line)
+        if err != nil {
+            return fmt.Errorf("error %s:%d: %w", path, n, err)
+        }
+        if len(args) < 2 || args[0] != "user" {
+            return fmt.Errorf("error %s:%d: line should start with user keyword", path, n)
+        }
+        name := args[1]
+        if _, ok := users[name]; ok {
+            return fmt.Errorf("error %s:%d: duplicate user '%s' found", path, n, name)
+        }
+        if strings.ContainsRune(name, 0) {
+            return fmt.Errorf("error %s:%d: %w", path, n, ErrInvalidUsername)
+        }
+        u := newUser(name)
+        for _, rule := range args[2:] {
+            if err := u.apply(rule); err != nil {
+                return fmt.Errorf("error %s:%d: invalid rule '%s': %w", path, n, rule, err)
+            }
+        }
+        users[name] = u
+    }
+    if err = scanner.Err(); err != nil {
+        return err
+    }
+    if _, ok := users[DefaultUser]; !ok {
+        users[DefaultUser] = newDefaultUser()
+    }
+
+    a.Lock()
+    defer a.Unlock()
+    a.users = users
+    return nil
+}
+
+// Save writes the users to an ACL file, atomically, in the format read by Load.
+func (a *ACL) Save(path string) error {
+    var b strings.Builder
+    for _, line := range a.List() {
+        b.WriteString(line)
+        b.WriteByte('\n')
+    }
+    return config.WriteFileAtomic(path, []byte(b.String()))
+}
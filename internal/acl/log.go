@@ -0,0 +1,106 @@
+package acl
+
+import (
+    "sync"
+    "time"
+)
+
+// DefaultLogMaxLen is the default of acllog-max-len.
+const DefaultLogMaxLen = 128
+
+// logGroupingDelay is the time during which the same denial is counted by the same entry instead of adding a new one.
+const logGroupingDelay = time.Minute
+
+// LogEntry is a denied command or authentication, as reported by ACL LOG.
+type LogEntry struct {
+    // Count is the number of times the same denial happened, see logGroupingDelay.
+    Count  int
+    Reason Reason
+    // Context is where the command was run: toplevel or multi.
+    Context    string
+    Object     string
+    Username   string
+    ClientInfo string
+    EntryID    int64
+    Created    time.Time
+    Updated    time.Time
+}
+
+// Log records the recent denials, the most recent first. It is safe for concurrent use.
+type Log struct {
+    entries []*LogEntry
+    nextID  int64
+    maxLen  int
+    sync.Mutex
+}
+
+// NewLog creates a log keeping at most maxLen entries.
+func NewLog(maxLen int) *Log {
+    return &Log{maxLen: maxLen}
+}
+
+// Add records a denial, or counts it once more if the same denial was recorded lately.
+func (l *Log) Add(reason Reason, context, object, username, clientInfo string, now time.Time) {
+    l.Lock()
+    defer l.Unlock()
+    for i, e := range l.entries {
+        if e.Reason == reason && e.Context == context && e.Object == object && e.Username == username && now.Sub(e.Created) < logGroupingDelay {
+            e.Count++
+            e.ClientInfo = clientInfo
+            e.Updated = now
+            // The entry becomes the most recent one.
+            copy(l.entries[1:i+1], l.entries[:i])
+            l.entries[0] = e
+            return
+        }
+    }
+
+    e := &LogEntry{Count: 1, Reason: reason, Context: context, Object: object, Username: username, ClientInfo: clientInfo,
+        EntryID: l.nextID, Created: now, Updated: now}
+    l.nextID++
+    l.entries = append([]*LogEntry{e}, l.entries...)
+    l.trim()
+}
+
+// trim drops the oldest entries beyond maxLen. Callers must hold the lock.
+func (l *Log) trim() {
+    if len(l.entries) > l.maxLen {
+        l.entries = l.entries[:l.maxLen]
+    }
+}
+
+// Entries returns the count most recent entries, every entry if count is negative.
+func (l *Log) Entries(count int) []LogEntry {
+    l.Lock()
+    defer l.Unlock()
+    if count < 0 || count > len(l.entries) {
+        count = len(l.entries)
+    }
+    entries := make([]LogEntry, 0, count)
+    for _, e := range l.entries[:count] {
+        entries = append(entries, *e)
+    }
+    return entries
+}
+
+// Reset drops every entry.
+func (l *Log) Reset() {
+    l.Lock()
+    defer l.Unlock()
+    l.entries = nil
+}
+
+// MaxLen returns the maximum number of entries kept.
+func (l *Log) MaxLen() int {
+    l.Lock()
+    defer l.Unlock()
+    return l.maxLen
+}
+
+// SetMaxLen sets the maximum number of entries kept, dropping the oldest ones beyond it.
+func (l *Log) SetMaxLen(maxLen int) {
+    l.Lock()
+    defer l.Unlock()
+    l.maxLen = maxLen
+    l.trim()
+}
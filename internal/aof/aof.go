@@ -0,0 +1,327 @@
+// Package aof implements the append only file: a log of every write command, in RESP format, replayed on startup to rebuild the dataset.
+package aof
+
+import (
+    "errors"
+    "log"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// FsyncPolicy tells how often the AOF is flushed to disk, trading durability for speed.
+type FsyncPolicy int
+
+const (
+    // FsyncEverySec fsyncs once per second, losing at most one second of writes on a crash.
+    FsyncEverySec FsyncPolicy = iota
+    // FsyncAlways fsyncs after every write, before the client is replied to.
+    FsyncAlways
+    // FsyncNo leaves flushing to the operating system.
+    FsyncNo
+)
+
+var ErrInvalidFsyncPolicy = errors.New("error appendfsync must be one of always, everysec or no")
+
+// ParseFsyncPolicy parses the value of appendfsync.
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+    switch strings.ToLower(s) {
+    case "always":
+        return FsyncAlways, nil
+    case "everysec":
+        return FsyncEverySec, nil
+    case "no":
+        return FsyncNo, nil
+    }
+    return 0, ErrInvalidFsyncPolicy
+}
+
+// String returns the appendfsync value of the policy.
+func (p FsyncPolicy) String() string {
+    switch p {
+    case FsyncAlways:
+        return "always"
+    case FsyncNo:
+        return "no"
+    }
+    return "everysec"
+}
+
+// Config configures an AOF.
+type Config struct {
+    // Dir is the data directory of the server.
+    Dir string
+    // DirName is the name of the directory holding the files of the AOF and its manifest, in Dir.
+    DirName string
+    // Filename prefixes the names of the files of the AOF, e.g. appendonly.aof.1.base.aof and appendonly.aof.manifest.
+    // A single file AOF of that name in Dir, written by an older version, is upgraded to a multi-part AOF.
+    Filename string
+    Fsync    FsyncPolicy
+    // LoadTruncated loads an AOF ending with a partial command, see Load.
+    LoadTruncated bool
+}
+
+// AOF is an append only file opened for logging write commands.
+// It is made of multiple files listed by a manifest: a base file holding the dataset at the time of the last rewrite,
+// followed by incr files holding the commands appended since then, the last one being appended to.
+type AOF struct {
+    config   Config
+    manifest *manifest
+    // incr is the incr file the commands are appended to.
+    incr   *os.File
+    policy FsyncPolicy
+    // selectedDb is the database the commands logged last were executed against, -1 if none yet.
+    selectedDb int
+    // dirty is set when data was written since the last fsync.
+    dirty bool
+    // size is the size of all the files of the AOF, baseSize its size after the last rewrite, or when it was opened.
+    size, baseSize int64
+    // rewriting is set while a rewrite is in progress, see StartRewrite.
+    rewriting bool
+    // lastWriteErr is the error of the last write, nil if it succeeded.
+    lastWriteErr error
+    closed       bool
+    done         chan struct{}
+    sync.Mutex
+}
+
+// Status reports the state of an AOF.
+type Status struct {
+    Size, BaseSize int64
+    Rewriting      bool
+    LastWriteErr   error
+}
+
+// Open opens the AOF for appending, creating its directory and manifest if needed.
+func Open(config Config) (*AOF, error) {
+    a := &AOF{config: config, policy: config.Fsync, selectedDb: -1, done: make(chan struct{})}
+    if err := os.MkdirAll(a.path(""), 0755); err != nil {
+        return nil, err
+    }
+
+    m, err := readManifest(a.path(a.manifestName()))
+    if errors.Is(err, os.ErrNotExist) {
+        m, err = a.upgrade()
+    }
+    if err != nil {
+        return nil, err
+    }
+    // A crash may have happened between writing the manifest and moving the upgraded file.
+    if err = a.moveLegacyFile(m); err != nil {
+        return nil, err
+    }
+
+    if len(m.incrs) == 0 {
+        m.incrs = append(m.incrs, m.nextIncr(config.Filename))
+        if err = writeManifest(a.path(a.manifestName()), m); err != nil {
+            return nil, err
+        }
+    }
+    a.manifest = m
+
+    // Commands are appended to the last incr file.
+    a.incr, err = os.OpenFile(a.path(m.incrs[len(m.incrs)-1].name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return nil, err
+    }
+    if a.size, err = a.filesSize(); err != nil {
+        _ = a.incr.Close()
+        return nil, err
+    }
+    a.baseSize = a.size
+
+    go a.fsyncLoop()
+    return a, nil
+}
+
+// upgrade creates the manifest of a new AOF, whose base file is the single file AOF written by an older version, if any.
+func (a *AOF) upgrade() (*manifest, error) {
+    m := &manifest{}
+    if _, err := os.Stat(filepath.Join(a.config.Dir, a.config.Filename)); err == nil {
+        m.base = &aofFile{name: a.config.Filename, seq: 1, typ: baseFile}
+    }
+    return m, writeManifest(a.path(a.manifestName()), m)
+}
+
+// moveLegacyFile moves the single file AOF upgraded to the base file of m to the AOF directory.
+func (a *AOF) moveLegacyFile(m *manifest) error {
+    if m.base == nil || m.base.name != a.config.Filename {
+        return nil
+    }
+    legacy := filepath.Join(a.config.Dir, a.config.Filename)
+    if _, err := os.Stat(legacy); err != nil {
+        return nil
+    }
+    if _, err := os.Stat(a.path(m.base.name)); err == nil {
+        return nil
+    }
+    if err := os.Rename(legacy, a.path(m.base.name)); err != nil {
+        return err
+    }
+    return syncDir(a.path(""))
+}
+
+// path returns the path of a file of the AOF.
+func (a *AOF) path(name string) string {
+    return filepath.Join(a.config.Dir, a.config.DirName, name)
+}
+
+// manifestName returns the name of the manifest file.
+func (a *AOF) manifestName() string {
+    return a.config.Filename + ".manifest"
+}
+
+// filesSize returns the size of all the files of the AOF. Callers must hold the lock.
+func (a *AOF) filesSize() (int64, error) {
+    var size int64
+    for _, f := range a.manifest.files() {
+        info, err := os.Stat(a.path(f.name))
+        if err != nil {
+            return 0, err
+        }
+        size += info.Size()
+    }
+    return size, nil
+}
+
+// Append logs a command executed against the database db, e.g. `Append(0, "SET", "foo", "bar")`.
+// With FsyncAlways, the command is on disk when Append returns.
+func (a *AOF) Append(db int, args ...string) error {
+    a.Lock()
+    defer a.Unlock()
+
+    var buf []byte
+    if db != a.selectedDb {
+        // Commands are replayed against the database selected last, as they would be from a client.
+        buf = appendCommand(buf, "SELECT", strconv.Itoa(db))
+        a.selectedDb = db
+    }
+    buf = appendCommand(buf, args...)
+
+    n, err := a.incr.Write(buf)
+    a.size += int64(n)
+    if err == nil {
+        a.dirty = true
+        if a.policy == FsyncAlways {
+            err = a.sync()
+        }
+    }
+    if err != nil {
+        // The tail of the file may now hold a partial command, the database must be selected again.
+        a.selectedDb = -1
+    }
+    a.lastWriteErr = err
+    return err
+}
+
+// SetFsyncPolicy changes how often the AOF is flushed to disk.
+func (a *AOF) SetFsyncPolicy(policy FsyncPolicy) {
+    a.Lock()
+    defer a.Unlock()
+    a.policy = policy
+}
+
+// FsyncPolicy returns how often the AOF is flushed to disk.
+func (a *AOF) FsyncPolicy() FsyncPolicy {
+    a.Lock()
+    defer a.Unlock()
+    return a.policy
+}
+
+// Status returns the current state of the AOF.
+func (a *AOF) Status() Status {
+    a.Lock()
+    defer a.Unlock()
+    return Status{Size: a.size, BaseSize: a.baseSize, Rewriting: a.rewriting, LastWriteErr: a.lastWriteErr}
+}
+
+// RewriteNeeded reports whether the AOF grew by at least percentage since the last rewrite, and is at least minSize bytes.
+// A percentage of 0 disables automatic rewrites.
+func (a *AOF) RewriteNeeded(percentage int, minSize int64) bool {
+    a.Lock()
+    defer a.Unlock()
+
+    if percentage <= 0 || a.rewriting || a.closed || a.size < minSize {
+        return false
+    }
+    base := max(a.baseSize, 1)
+    return (a.size-base)*100/base >= int64(percentage)
+}
+
+// Close flushes the AOF to disk and closes it. A rewrite in progress is abandoned.
+func (a *AOF) Close() error {
+    a.Lock()
+    defer a.Unlock()
+    if a.closed {
+        return nil
+    }
+    a.closed = true
+    close(a.done)
+
+    if err := a.sync(); err != nil {
+        _ = a.incr.Close()
+        return err
+    }
+    return a.incr.Close()
+}
+
+// Sync flushes the commands appended so far to disk, whatever the fsync policy.
+func (a *AOF) Sync() error {
+    a.Lock()
+    defer a.Unlock()
+    if a.closed {
+        return ErrClosed
+    }
+    return a.sync()
+}
+
+// fsyncLoop flushes the AOF to disk every second when the policy is FsyncEverySec, until the AOF is closed.
+func (a *AOF) fsyncLoop() {
+    ticker := time.NewTicker(time.Second)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-a.done:
+            return
+        case <-ticker.C:
+            a.Lock()
+            if a.policy == FsyncEverySec {
+                if err := a.sync(); err != nil {
+                    log.Printf("RRedis error fsyncing the AOF: %v", err)
+                }
+            }
+            a.Unlock()
+        }
+    }
+}
+
+// sync flushes the data written since the last call to disk. Callers must hold the lock.
+func (a *AOF) sync() error {
+    if !a.dirty {
+        return nil
+    }
+    if err := a.incr.Sync(); err != nil {
+        return err
+    }
+    a.dirty = false
+    return nil
+}
+
+// appendCommand appends a command to buf, as a RESP array of bulk strings.
+func appendCommand(buf []byte, args ...string) []byte {
+    buf = append(buf, '*')
+    buf = strconv.AppendInt(buf, int64(len(args)), 10)
+    buf = append(buf, '\r', '\n')
+    for _, arg := range args {
+        buf = append(buf, '$')
+        buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+        buf = append(buf, '\r', '\n')
+        buf = append(buf, arg...)
+        buf = append(buf, '\r', '\n')
+    }
+    return buf
+}
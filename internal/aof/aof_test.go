@@ -0,0 +1,277 @@
+package aof
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+func TestParseFsyncPolicy(t *testing.T) {
+    testCases := []struct {
+        value  string
+        policy FsyncPolicy
+        err    error
+    }{
+        {value: "always", policy: FsyncAlways},
+        {value: "EVERYSEC", policy: FsyncEverySec},
+        {value: "no", policy: FsyncNo},
+        {value: "sometimes", err: ErrInvalidFsyncPolicy},
+    }
+
+    for _, tc := range testCases {
+        policy, err := ParseFsyncPolicy(tc.value)
+        if !errors.Is(err, tc.err) || err == nil && policy != tc.policy {
+            t.Errorf("Error parsing %s: expected %v ( %v ), got %v ( %v ).\n", tc.value, tc.policy, tc.err, policy, err)
+        }
+        if err == nil && policy.String() != tc.value && tc.value != "EVERYSEC" {
+            t.Errorf("Error formatting %v: expected %s, got %s.\n", policy, tc.value, policy.String())
+        }
+    }
+}
+
+func TestAOF_AppendAndLoad(t *testing.T) {
+    config := Config{Dir: t.TempDir(), DirName: "appendonlydir", Filename: "appendonly.aof", Fsync: FsyncAlways}
+    a, err := Open(config)
+    if err != nil {
+        t.Fatalf("Error opening the AOF: %v.\n", err)
+    }
+    commands := []struct {
+        db   int
+        args []string
+    }{
+        {db: 0, args: []string{"SET", "foo", "bar"}},
+        {db: 0, args: []string{"RPUSH", "list", "a", ""}},
+        {db: 3, args: []string{"INCR", "counter"}},
+        {db: 0, args: []string{"DEL", "foo"}},
+    }
+    for _, command := range commands {
+        if err = a.Append(command.db, command.args...); err != nil {
+            t.Fatalf("Error appending %v: %v.\n", command.args, err)
+        }
+    }
+    if err = a.Close(); err != nil {
+        t.Fatalf("Error closing the AOF: %v.\n", err)
+    }
+
+    loaded := load(t, config)
+
+    expected := [][]string{
+        {"SELECT", "0"}, {"SET", "foo", "bar"}, {"RPUSH", "list", "a", ""},
+        {"SELECT", "3"}, {"INCR", "counter"},
+        {"SELECT", "0"}, {"DEL", "foo"},
+    }
+    if !reflect.DeepEqual(loaded, expected) {
+        t.Errorf("Error loading the AOF: expected %q, got %q.\n", expected, loaded)
+    }
+}
+
+func TestLoad(t *testing.T) {
+    const complete = "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"
+    const transaction = "*1\r\n$5\r\nMULTI\r\n*2\r\n$4\r\nINCR\r\n$1\r\nx\r\n*1\r\n$4\r\nEXEC\r\n"
+    testCases := []struct {
+        name          string
+        content       string
+        loadTruncated bool
+        commands      int
+        err           error
+        // size is the size of the file after loading it.
+        size int
+    }{
+        {name: "complete", content: complete, commands: 2, size: len(complete)},
+        {name: "empty", content: "", commands: 0},
+        {name: "truncated", content: complete + "*3\r\n$3\r\nSET\r\n$3\r\nba", err: ErrTruncated},
+        {name: "truncated length", content: complete + "*3\r\n$3", err: ErrTruncated},
+        {name: "load truncated", content: complete + "*3\r\n$3\r\nSET\r\n$3\r\nba", loadTruncated: true, commands: 2, size: len(complete)},
+        {
+            name:          "unfinished transaction",
+            content:       complete + transaction[:len(transaction)-len("*1\r\n$4\r\nEXEC\r\n")],
+            loadTruncated: true,
+            commands:      2,
+            size:          len(complete),
+        },
+        {
+            name:     "transaction",
+            content:  complete + transaction,
+            commands: 3,
+            size:     len(complete + transaction),
+        },
+        {name: "bad format", content: complete + "SET foo bar\r\n", loadTruncated: true, err: ErrBadFormat},
+        {name: "bad bulk", content: "*1\r\n$3\r\nSETX\r\n", err: ErrBadFormat},
+    }
+
+    for _, tc := range testCases {
+        t.Run(tc.name, func(t *testing.T) {
+            path := filepath.Join(t.TempDir(), "appendonly.aof")
+            if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+                t.Fatal(err)
+            }
+
+            var commands int
+            err := loadFile(path, tc.loadTruncated, func(args []string) error {
+                commands++
+                return nil
+            })
+            if !errors.Is(err, tc.err) {
+                t.Fatalf("Error loading: expected %v, got %v.\n", tc.err, err)
+            }
+            if err != nil {
+                return
+            }
+            if commands != tc.commands {
+                t.Errorf("Error loading: expected %d commands, got %d.\n", tc.commands, commands)
+            }
+            if info, _ := os.Stat(path); info.Size() != int64(tc.size) {
+                t.Errorf("Error loading: expected a file of %d bytes, got %d.\n", tc.size, info.Size())
+            }
+        })
+    }
+
+    if err := loadFile(filepath.Join(t.TempDir(), "missing.aof"), false, nil); err != nil {
+        t.Errorf("Error a missing AOF should be empty, got %v.\n", err)
+    }
+}
+
+func TestAOF_Upgrade(t *testing.T) {
+    config := Config{Dir: t.TempDir(), DirName: "appendonlydir", Filename: "appendonly.aof", Fsync: FsyncAlways}
+    legacy := "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"
+    if err := os.WriteFile(filepath.Join(config.Dir, config.Filename), []byte(legacy), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    a, err := Open(config)
+    if err != nil {
+        t.Fatalf("Error opening the AOF: %v.\n", err)
+    }
+    if err = a.Append(0, "INCR", "x"); err != nil {
+        t.Fatal(err)
+    }
+    if err = a.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err = os.Stat(filepath.Join(config.Dir, config.Filename)); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("Error the single file AOF should have been moved, got %v.\n", err)
+    }
+    manifest, _ := os.ReadFile(filepath.Join(config.Dir, config.DirName, "appendonly.aof.manifest"))
+    expectedManifest := "file appendonly.aof seq 1 type b\nfile appendonly.aof.1.incr.aof seq 1 type i\n"
+    if string(manifest) != expectedManifest {
+        t.Errorf("Error upgrading the AOF: expected the manifest %q, got %q.\n", expectedManifest, manifest)
+    }
+    expected := [][]string{{"SELECT", "0"}, {"SET", "foo", "bar"}, {"SELECT", "0"}, {"INCR", "x"}}
+    if loaded := load(t, config); !reflect.DeepEqual(loaded, expected) {
+        t.Errorf("Error loading the upgraded AOF: expected %q, got %q.\n", expected, loaded)
+    }
+}
+
+func TestAOF_Rewrite(t *testing.T) {
+    config := Config{Dir: t.TempDir(), DirName: "appendonlydir", Filename: "appendonly.aof", Fsync: FsyncAlways}
+    a, err := Open(config)
+    if err != nil {
+        t.Fatalf("Error opening the AOF: %v.\n", err)
+    }
+    defer a.Close()
+    for i := 0; i < 10; i++ {
+        if err = a.Append(0, "INCR", "x"); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if !a.RewriteNeeded(100, 0) {
+        t.Errorf("Error the AOF grew from empty, a rewrite should be needed.\n")
+    }
+
+    rw, err := a.StartRewrite()
+    if err != nil {
+        t.Fatalf("Error starting the rewrite: %v.\n", err)
+    }
+    if _, err = a.StartRewrite(); !errors.Is(err, ErrRewriteInProgress) {
+        t.Errorf("Error starting a second rewrite: expected %v, got %v.\n", ErrRewriteInProgress, err)
+    }
+    // Commands appended during the rewrite go to the new incr file, which is kept.
+    if err = a.Append(0, "INCR", "x"); err != nil {
+        t.Fatal(err)
+    }
+    if err = rw.Finish(func(w *Writer) error {
+        if err := w.Command("SELECT", "0"); err != nil {
+            return err
+        }
+        return w.Command("SET", "x", "10")
+    }); err != nil {
+        t.Fatalf("Error finishing the rewrite: %v.\n", err)
+    }
+
+    if status := a.Status(); status.Rewriting || status.Size != status.BaseSize || a.RewriteNeeded(100, 0) {
+        t.Errorf("Error the rewrite should be over and its size the base size, got %+v.\n", status)
+    }
+    manifest, _ := os.ReadFile(filepath.Join(config.Dir, config.DirName, "appendonly.aof.manifest"))
+    expectedManifest := "file appendonly.aof.1.base.aof seq 1 type b\nfile appendonly.aof.2.incr.aof seq 2 type i\n"
+    if string(manifest) != expectedManifest {
+        t.Errorf("Error rewriting the AOF: expected the manifest %q, got %q.\n", expectedManifest, manifest)
+    }
+    if _, err = os.Stat(filepath.Join(config.Dir, config.DirName, "appendonly.aof.1.incr.aof")); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("Error the obsolete incr file should have been removed, got %v.\n", err)
+    }
+    if err = a.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    expected := [][]string{{"SELECT", "0"}, {"SET", "x", "10"}, {"SELECT", "0"}, {"INCR", "x"}}
+    if loaded := load(t, config); !reflect.DeepEqual(loaded, expected) {
+        t.Errorf("Error loading the rewritten AOF: expected %q, got %q.\n", expected, loaded)
+    }
+}
+
+func TestReadManifest(t *testing.T) {
+    testCases := []struct {
+        name    string
+        content string
+        files   []aofFile
+        err     error
+    }{
+        {
+            name:    "valid",
+            content: "# comment\nfile a.1.base.aof seq 1 type b\nfile a.0.incr.aof seq 0 type h\nseq 2 type i file a.2.incr.aof\n",
+            files:   []aofFile{{name: "a.1.base.aof", seq: 1, typ: baseFile}, {name: "a.2.incr.aof", seq: 2, typ: incrFile}},
+        },
+        {name: "two bases", content: "file a seq 1 type b\nfile b seq 2 type b\n", err: ErrBadManifest},
+        {name: "bad type", content: "file a seq 1 type x\n", err: ErrBadManifest},
+        {name: "bad seq", content: "file a seq one type i\n", err: ErrBadManifest},
+        {name: "path", content: "file ../a seq 1 type i\n", err: ErrBadManifest},
+    }
+
+    for _, tc := range testCases {
+        t.Run(tc.name, func(t *testing.T) {
+            path := filepath.Join(t.TempDir(), "appendonly.aof.manifest")
+            if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+                t.Fatal(err)
+            }
+            m, err := readManifest(path)
+            if !errors.Is(err, tc.err) {
+                t.Fatalf("Error reading the manifest: expected %v, got %v.\n", tc.err, err)
+            }
+            if err == nil && !reflect.DeepEqual(m.files(), tc.files) {
+                t.Errorf("Error reading the manifest: expected %v, got %v.\n", tc.files, m.files())
+            }
+        })
+    }
+}
+
+// load opens the AOF of config and returns the commands it holds.
+func load(t *testing.T, config Config) [][]string {
+    t.Helper()
+    a, err := Open(config)
+    if err != nil {
+        t.Fatalf("Error opening the AOF: %v.\n", err)
+    }
+    defer a.Close()
+
+    var loaded [][]string
+    if err = a.Load(func(args []string) error {
+        loaded = append(loaded, args)
+        return nil
+    }); err != nil {
+        t.Fatalf("Error loading the AOF: %v.\n", err)
+    }
+    return loaded
+}
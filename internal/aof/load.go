@@ -0,0 +1,174 @@
+package aof
+
+import (
+    "bufio"
+    "errors"
+    "io"
+    "log"
+    "os"
+    "strconv"
+    "strings"
+)
+
+var (
+    // ErrTruncated is returned by Load when the AOF ends with a partial command and aof-load-truncated is off.
+    ErrTruncated = errors.New("error unexpected end of file reading the append only file, enable aof-load-truncated to load it anyway")
+    // ErrBadFormat is returned by Load when the AOF holds something else than RESP commands.
+    ErrBadFormat = errors.New("error bad file format reading the append only file")
+)
+
+// Load replays the AOF, calling apply with the arguments of every command it holds, in order: the base file, then each incr file.
+// It must be called before appending any command.
+//
+// A crash while appending may leave a partial command at the end of the last incr file. If Config.LoadTruncated is set,
+// the partial command is dropped, and so is an unfinished MULTI/EXEC transaction, and the file is truncated
+// to its last complete command so new commands can be appended. Otherwise ErrTruncated is returned.
+func (a *AOF) Load(apply func(args []string) error) error {
+    a.Lock()
+    defer a.Unlock()
+
+    files := a.manifest.files()
+    for i, f := range files {
+        // Only the file being appended to when crashing can be truncated.
+        if err := loadFile(a.path(f.name), a.config.LoadTruncated && i == len(files)-1, apply); err != nil {
+            return err
+        }
+    }
+
+    var err error
+    a.size, err = a.filesSize()
+    a.baseSize = a.size
+    return err
+}
+
+// loadFile replays the file of the AOF at path, see Load. A missing file is empty.
+func loadFile(path string, loadTruncated bool, apply func(args []string) error) error {
+    file, err := os.Open(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    r := &reader{r: bufio.NewReader(file)}
+    // valid is the offset following the last command applied, outside of any transaction.
+    var valid int64
+    // transaction holds the commands of the current MULTI/EXEC block, applied once EXEC is read.
+    var transaction [][]string
+    var multi bool
+
+    for {
+        args, err := r.readCommand()
+        if err == io.EOF && !multi {
+            return nil
+        }
+        if err == io.EOF || err == io.ErrUnexpectedEOF {
+            if !loadTruncated {
+                return ErrTruncated
+            }
+            log.Printf("RRedis !!! Warning: short read while loading the AOF file %s, truncating it to %d bytes !!!", path, valid)
+            return os.Truncate(path, valid)
+        }
+        if err != nil {
+            return err
+        }
+
+        switch strings.ToLower(args[0]) {
+        case "multi":
+            multi = true
+            transaction = nil
+            continue
+        case "exec":
+            if !multi {
+                return ErrBadFormat
+            }
+            for _, command := range transaction {
+                if err = apply(command); err != nil {
+                    return err
+                }
+            }
+            multi = false
+        default:
+            if multi {
+                transaction = append(transaction, args)
+                continue
+            }
+            if err = apply(args); err != nil {
+                return err
+            }
+        }
+        valid = r.offset
+    }
+}
+
+// reader reads RESP commands, keeping track of the number of bytes read.
+type reader struct {
+    r      *bufio.Reader
+    offset int64
+}
+
+// readCommand reads a RESP array of bulk strings.
+// It returns io.EOF at the end of the input and io.ErrUnexpectedEOF if the input ends within the command.
+func (r *reader) readCommand() ([]string, error) {
+    n, err := r.readLength('*')
+    if err != nil {
+        return nil, err
+    }
+    if n < 1 {
+        return nil, ErrBadFormat
+    }
+
+    args := make([]string, 0, n)
+    for i := 0; i < n; i++ {
+        length, err := r.readLength('$')
+        if err != nil {
+            return nil, unexpectedEOF(err)
+        }
+        if length < 0 {
+            return nil, ErrBadFormat
+        }
+
+        arg := make([]byte, length+2)
+        read, err := io.ReadFull(r.r, arg)
+        r.offset += int64(read)
+        if err != nil {
+            return nil, unexpectedEOF(err)
+        }
+        if arg[length] != '\r' || arg[length+1] != '\n' {
+            return nil, ErrBadFormat
+        }
+        args = append(args, string(arg[:length]))
+    }
+    return args, nil
+}
+
+// readLength reads a line made of the prefix followed by a length, e.g. `*3\r\n`.
+func (r *reader) readLength(prefix byte) (int, error) {
+    line, err := r.r.ReadString('\n')
+    r.offset += int64(len(line))
+    if err == io.EOF && len(line) > 0 {
+        return 0, io.ErrUnexpectedEOF
+    }
+    if err != nil {
+        return 0, err
+    }
+
+    if len(line) < 3 || line[0] != prefix || line[len(line)-2] != '\r' {
+        return 0, ErrBadFormat
+    }
+    n, err := strconv.Atoi(line[1 : len(line)-2])
+    if err != nil {
+        return 0, ErrBadFormat
+    }
+    return n, nil
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF, for the input ending within a command.
+func unexpectedEOF(err error) error {
+    if err == io.EOF {
+        return io.ErrUnexpectedEOF
+    }
+    return err
+}
@@ -0,0 +1,166 @@
+package aof
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+)
+
+// fileType is the type of a file of the AOF, as recorded in the manifest.
+type fileType string
+
+const (
+    // baseFile holds the dataset at the time of the last rewrite.
+    baseFile fileType = "b"
+    // incrFile holds the commands appended after the base file, or after the previous incr file.
+    incrFile fileType = "i"
+    // historyFile is a file made obsolete by a rewrite, waiting to be deleted.
+    historyFile fileType = "h"
+)
+
+var ErrBadManifest = errors.New("error bad format of the AOF manifest")
+
+// aofFile is a file of the AOF.
+type aofFile struct {
+    name string
+    seq  int
+    typ  fileType
+}
+
+// manifest lists the files of a multi-part AOF: at most one base file followed by the incr files, in order.
+// The dataset is loaded by replaying the base file, then each incr file.
+type manifest struct {
+    base  *aofFile
+    incrs []aofFile
+}
+
+// files returns the files to load, in order.
+func (m *manifest) files() []aofFile {
+    var files []aofFile
+    if m.base != nil {
+        files = append(files, *m.base)
+    }
+    return append(files, m.incrs...)
+}
+
+// nextIncr returns the next incr file, numbered after the last one.
+func (m *manifest) nextIncr(filename string) aofFile {
+    seq := 1
+    if len(m.incrs) > 0 {
+        seq = m.incrs[len(m.incrs)-1].seq + 1
+    }
+    return aofFile{name: fmt.Sprintf("%s.%d.incr.aof", filename, seq), seq: seq, typ: incrFile}
+}
+
+// nextBase returns the next base file, numbered after the current one.
+func (m *manifest) nextBase(filename string) aofFile {
+    seq := 1
+    if m.base != nil {
+        seq = m.base.seq + 1
+    }
+    return aofFile{name: fmt.Sprintf("%s.%d.base.aof", filename, seq), seq: seq, typ: baseFile}
+}
+
+// encode returns the content of the manifest file, one line per file: `file <name> seq <seq> type <type>`.
+func (m *manifest) encode() []byte {
+    var sb strings.Builder
+    for _, f := range m.files() {
+        _, _ = fmt.Fprintf(&sb, "file %s seq %d type %s\n", f.name, f.seq, f.typ)
+    }
+    return []byte(sb.String())
+}
+
+// readManifest reads the manifest file at path. History files are ignored, they are never loaded.
+func readManifest(path string) (*manifest, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    m := &manifest{}
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || line[0] == '#' {
+            continue
+        }
+
+        // The fields are key value pairs, in any order.
+        fields := strings.Fields(line)
+        if len(fields)%2 != 0 {
+            return nil, ErrBadManifest
+        }
+        var f aofFile
+        for i := 0; i < len(fields); i += 2 {
+            switch fields[i] {
+            case "file":
+                f.name = fields[i+1]
+            case "seq":
+                if f.seq, err = strconv.Atoi(fields[i+1]); err != nil {
+                    return nil, ErrBadManifest
+                }
+            case "type":
+                f.typ = fileType(fields[i+1])
+            }
+        }
+        if f.name == "" || f.name != filepath.Base(f.name) {
+            return nil, ErrBadManifest
+        }
+
+        switch f.typ {
+        case baseFile:
+            if m.base != nil {
+                return nil, ErrBadManifest
+            }
+            m.base = &f
+        case incrFile:
+            m.incrs = append(m.incrs, f)
+        case historyFile:
+        default:
+            return nil, ErrBadManifest
+        }
+    }
+    if err = scanner.Err(); err != nil {
+        return nil, err
+    }
+    return m, nil
+}
+
+// writeManifest atomically replaces the manifest file at path: a crash leaves either the old or the new manifest, never a mix.
+func writeManifest(path string, m *manifest) error {
+    temp := filepath.Join(filepath.Dir(path), "temp-"+filepath.Base(path))
+    if err := writeFileSync(temp, m.encode()); err != nil {
+        return err
+    }
+    if err := os.Rename(temp, path); err != nil {
+        _ = os.Remove(temp)
+        return err
+    }
+    return syncDir(filepath.Dir(path))
+}
+
+// writeFileSync writes data to the file at path and flushes it to disk.
+func writeFileSync(path string, data []byte) error {
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+    if err != nil {
+        return err
+    }
+    if _, err = file.Write(data); err == nil {
+        err = file.Sync()
+    }
+    return errors.Join(err, file.Close())
+}
+
+// syncDir flushes the entries of a directory to disk, making the files created or renamed in it durable.
+func syncDir(dir string) error {
+    d, err := os.Open(dir)
+    if err != nil {
+        return err
+    }
+    return errors.Join(d.Sync(), d.Close())
+}
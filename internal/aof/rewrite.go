@@ -0,0 +1,151 @@
+package aof
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "slices"
+)
+
+var (
+    // ErrRewriteInProgress is returned by StartRewrite when a rewrite is already in progress.
+    ErrRewriteInProgress = errors.New("error background append only file rewriting already in progress")
+    // ErrClosed is returned when rewriting an AOF that was closed.
+    ErrClosed = errors.New("error the append only file is closed")
+)
+
+// Rewrite is a rewrite of the AOF in progress, started by StartRewrite.
+type Rewrite struct {
+    a *AOF
+    // incr is the incr file opened by StartRewrite, the first one following the new base file.
+    incr aofFile
+}
+
+// Writer writes commands to the base file of a rewrite.
+type Writer struct {
+    w   *bufio.Writer
+    buf []byte
+}
+
+// Command writes a command, e.g. `Command("SET", "foo", "bar")`.
+func (w *Writer) Command(args ...string) error {
+    w.buf = appendCommand(w.buf[:0], args...)
+    _, err := w.w.Write(w.buf)
+    return err
+}
+
+// StartRewrite starts compacting the AOF. From now on, commands are appended to a new incr file,
+// so the dataset at this point can be written to a new base file by Finish, replacing the files before the new incr file.
+// Callers must make sure no command is appended until the dataset to write is captured.
+func (a *AOF) StartRewrite() (*Rewrite, error) {
+    a.Lock()
+    defer a.Unlock()
+
+    if a.closed {
+        return nil, ErrClosed
+    }
+    if a.rewriting {
+        return nil, ErrRewriteInProgress
+    }
+
+    incr := a.manifest.nextIncr(a.config.Filename)
+    file, err := os.OpenFile(a.path(incr.name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return nil, err
+    }
+    m := &manifest{base: a.manifest.base, incrs: append(slices.Clone(a.manifest.incrs), incr)}
+    if err = writeManifest(a.path(a.manifestName()), m); err != nil {
+        _ = file.Close()
+        _ = os.Remove(a.path(incr.name))
+        return nil, err
+    }
+
+    // The previous incr file is complete, it is replaced by the new base file once written.
+    if err = a.sync(); err != nil {
+        log.Printf("RRedis error fsyncing the AOF: %v", err)
+    }
+    _ = a.incr.Close()
+    a.incr = file
+    a.manifest = m
+    // The new incr file is replayed after the base file, which may leave any database selected.
+    a.selectedDb = -1
+    a.rewriting = true
+    return &Rewrite{a: a, incr: incr}, nil
+}
+
+// Finish writes the new base file with write, then replaces the files preceding the incr file opened by StartRewrite with it.
+// If write or any step fails, the rewrite is abandoned and the AOF is left as is.
+func (rw *Rewrite) Finish(write func(w *Writer) error) error {
+    a := rw.a
+    defer func() {
+        a.Lock()
+        a.rewriting = false
+        a.Unlock()
+    }()
+
+    temp := a.path(fmt.Sprintf("temp-rewriteaof-bg-%d.aof", os.Getpid()))
+    if err := writeBase(temp, write); err != nil {
+        _ = os.Remove(temp)
+        return err
+    }
+
+    a.Lock()
+    defer a.Unlock()
+    if a.closed {
+        _ = os.Remove(temp)
+        return ErrClosed
+    }
+
+    base := a.manifest.nextBase(a.config.Filename)
+    if err := os.Rename(temp, a.path(base.name)); err != nil {
+        _ = os.Remove(temp)
+        return err
+    }
+    // The files preceding the incr file of the rewrite are covered by the new base file.
+    m := &manifest{base: &base}
+    var obsolete []aofFile
+    if a.manifest.base != nil {
+        obsolete = append(obsolete, *a.manifest.base)
+    }
+    for _, f := range a.manifest.incrs {
+        if f.seq < rw.incr.seq {
+            obsolete = append(obsolete, f)
+        } else {
+            m.incrs = append(m.incrs, f)
+        }
+    }
+    if err := writeManifest(a.path(a.manifestName()), m); err != nil {
+        _ = os.Remove(a.path(base.name))
+        return err
+    }
+    a.manifest = m
+
+    for _, f := range obsolete {
+        if err := os.Remove(a.path(f.name)); err != nil {
+            log.Printf("RRedis error removing an obsolete AOF file: %v", err)
+        }
+    }
+    size, err := a.filesSize()
+    if err != nil {
+        return err
+    }
+    a.size, a.baseSize = size, size
+    return nil
+}
+
+// writeBase writes a base file with write and flushes it to disk.
+func writeBase(path string, write func(w *Writer) error) error {
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+    if err != nil {
+        return err
+    }
+    w := &Writer{w: bufio.NewWriter(file)}
+    if err = write(w); err == nil {
+        if err = w.w.Flush(); err == nil {
+            err = file.Sync()
+        }
+    }
+    return errors.Join(err, file.Close())
+}
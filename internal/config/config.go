@@ -0,0 +1,198 @@
+// Package config reads and rewrites configuration files in the format of redis.conf.
+//
+// Each line of a file is a directive: its name followed by its arguments, separated by spaces. Arguments may be quoted,
+// with double quotes supporting the escape sequences of Redis, e.g. `\n` or `\x00`, or single quotes.
+// Empty lines and lines starting with `#` are ignored.
+package config
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+)
+
+var ErrUnbalancedQuotes = errors.New("error unbalanced quotes in configuration line")
+
+// Directive is a line of a configuration file, or an option given on the command line.
+type Directive struct {
+    // Name is the name of the directive, in lowercase.
+    Name string
+    Args []string
+}
+
+// File is a configuration, read from a file and the command line.
+type File struct {
+    // Path is the path of the configuration file, empty if the configuration comes from the command line only.
+    Path       string
+    Directives []Directive
+}
+
+// Load reads the configuration from the arguments of the command line: the path of a configuration file, optionally,
+// followed by options overriding the directives of the file, e.g. `--port 7000`.
+// An option takes the arguments up to the next option, an option without arguments is set to `yes`, e.g. `--appendonly`.
+func Load(args []string) (*File, error) {
+    file := &File{}
+    if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+        file.Path = args[0]
+        args = args[1:]
+
+        f, err := os.Open(file.Path)
+        if err != nil {
+            return nil, err
+        }
+        defer f.Close()
+        if file.Directives, err = Parse(f); err != nil {
+            return nil, fmt.Errorf("error reading %s: %w", file.Path, err)
+        }
+    }
+
+    for len(args) > 0 {
+        if !strings.HasPrefix(args[0], "--") || len(args[0]) == 2 {
+            return nil, fmt.Errorf("error invalid option %q, expected --name", args[0])
+        }
+        directive := Directive{Name: strings.ToLower(args[0][2:])}
+        for args = args[1:]; len(args) > 0 && !strings.HasPrefix(args[0], "--"); args = args[1:] {
+            directive.Args = append(directive.Args, args[0])
+        }
+        if directive.Args == nil {
+            directive.Args = []string{"yes"}
+        }
+        file.Directives = append(file.Directives, directive)
+    }
+    return file, nil
+}
+
+// Parse reads the directives of a configuration file.
+func Parse(r io.Reader) ([]Directive, error) {
+    var directives []Directive
+    scanner := bufio.NewScanner(r)
+    for line := 1; scanner.Scan(); line++ {
+        text := strings.TrimSpace(scanner.Text())
+        if text == "" || text[0] == '#' {
+            continue
+        }
+        args, err := SplitArgs(text)
+        if err != nil {
+            return nil, fmt.Errorf("line %d: %w", line, err)
+        }
+        directives = append(directives, Directive{Name: strings.ToLower(args[0]), Args: args[1:]})
+    }
+    return directives, scanner.Err()
+}
+
+// Lookup returns the arguments of the last directive named name, the one that takes effect.
+func (f *File) Lookup(name string) ([]string, bool) {
+    for i := len(f.Directives) - 1; i >= 0; i-- {
+        if f.Directives[i].Name == name {
+            return f.Directives[i].Args, true
+        }
+    }
+    return nil, false
+}
+
+// SplitArgs splits a configuration line into arguments, as Redis's sdssplitargs.
+func SplitArgs(line string) ([]string, error) {
+    var args []string
+    for i := 0; ; {
+        for i < len(line) && isSpace(line[i]) {
+            i++
+        }
+        if i == len(line) {
+            return args, nil
+        }
+
+        var arg strings.Builder
+        inDoubleQuotes, inSingleQuotes := false, false
+        for done := false; !done; {
+            switch {
+            case inDoubleQuotes:
+                switch {
+                case i == len(line):
+                    return nil, ErrUnbalancedQuotes
+                case line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+                    arg.WriteByte(unhex(line[i+2])<<4 | unhex(line[i+3]))
+                    i += 3
+                case line[i] == '\\' && i+1 < len(line):
+                    i++
+                    arg.WriteByte(unescape(line[i]))
+                case line[i] == '"':
+                    // The closing quote must be followed by a space or nothing.
+                    if i+1 < len(line) && !isSpace(line[i+1]) {
+                        return nil, ErrUnbalancedQuotes
+                    }
+                    done = true
+                default:
+                    arg.WriteByte(line[i])
+                }
+            case inSingleQuotes:
+                switch {
+                case i == len(line):
+                    return nil, ErrUnbalancedQuotes
+                case line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'':
+                    i++
+                    arg.WriteByte('\'')
+                case line[i] == '\'':
+                    if i+1 < len(line) && !isSpace(line[i+1]) {
+                        return nil, ErrUnbalancedQuotes
+                    }
+                    done = true
+                default:
+                    arg.WriteByte(line[i])
+                }
+            default:
+                switch {
+                case i == len(line) || isSpace(line[i]):
+                    done = true
+                    // The space is skipped by the next argument.
+                    i--
+                case line[i] == '"':
+                    inDoubleQuotes = true
+                case line[i] == '\'':
+                    inSingleQuotes = true
+                default:
+                    arg.WriteByte(line[i])
+                }
+            }
+            i++
+        }
+        args = append(args, arg.String())
+    }
+}
+
+// unescape returns the character escaped by `\c` in double quotes.
+func unescape(c byte) byte {
+    switch c {
+    case 'n':
+        return '\n'
+    case 'r':
+        return '\r'
+    case 't':
+        return '\t'
+    case 'b':
+        return '\b'
+    case 'a':
+        return '\a'
+    }
+    return c
+}
+
+func isSpace(c byte) bool {
+    return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+    return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func unhex(c byte) byte {
+    switch {
+    case c >= '0' && c <= '9':
+        return c - '0'
+    case c >= 'a' && c <= 'f':
+        return c - 'a' + 10
+    }
+    return c - 'A' + 10
+}
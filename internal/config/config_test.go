@@ -0,0 +1,122 @@
+package config
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+    testCases := []struct {
+        line string
+        args []string
+        err  error
+    }{
+        {line: "", args: nil},
+        {line: "port 6379", args: []string{"port", "6379"}},
+        {line: "  save   900 1\t300 100 ", args: []string{"save", "900", "1", "300", "100"}},
+        {line: `save ""`, args: []string{"save", ""}},
+        {line: `dir "/my data"`, args: []string{"dir", "/my data"}},
+        {line: `x "a\"b\\c\n\x41"`, args: []string{"x", "a\"b\\c\nA"}},
+        {line: `x 'it\'s "quoted"'`, args: []string{"x", `it's "quoted"`}},
+        {line: `x "unbalanced`, err: ErrUnbalancedQuotes},
+        {line: `x 'unbalanced`, err: ErrUnbalancedQuotes},
+        {line: `x "a"b`, err: ErrUnbalancedQuotes},
+    }
+
+    for _, tc := range testCases {
+        args, err := SplitArgs(tc.line)
+        if !errors.Is(err, tc.err) || !reflect.DeepEqual(args, tc.args) {
+            t.Errorf("Error splitting %q: expected %q ( %v ), got %q ( %v ).\n", tc.line, tc.args, tc.err, args, err)
+        }
+    }
+}
+
+func TestLoad(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "redis.conf")
+    content := "# The port.\nport 6379\n\n  # Indented comment, don't split it.\nSAVE 900 1\nsave 300 100\n"
+    if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    file, err := Load([]string{path, "--port", "7000", "--appendonly", "--save", "60", "10"})
+    if err != nil {
+        t.Fatalf("Error loading: %v.\n", err)
+    }
+    expected := []Directive{
+        {Name: "port", Args: []string{"6379"}},
+        {Name: "save", Args: []string{"900", "1"}},
+        {Name: "save", Args: []string{"300", "100"}},
+        {Name: "port", Args: []string{"7000"}},
+        {Name: "appendonly", Args: []string{"yes"}},
+        {Name: "save", Args: []string{"60", "10"}},
+    }
+    if file.Path != path || !reflect.DeepEqual(file.Directives, expected) {
+        t.Errorf("Error loading: expected %v, got %v.\n", expected, file.Directives)
+    }
+    if port, _ := file.Lookup("port"); !reflect.DeepEqual(port, []string{"7000"}) {
+        t.Errorf("Error looking up port: expected %v, got %v.\n", []string{"7000"}, port)
+    }
+
+    if file, err = Load(nil); err != nil || file.Path != "" || len(file.Directives) != 0 {
+        t.Errorf("Error loading without arguments: got %+v ( %v ).\n", file, err)
+    }
+    if _, err = Load([]string{"--port", "7000", "6380", "-v"}); err != nil {
+        t.Errorf("Error loading options only: %v.\n", err)
+    }
+    if _, err = Load([]string{path, "port", "7000"}); err == nil {
+        t.Errorf("Error an option should start with --.\n")
+    }
+    if _, err = Load([]string{filepath.Join(t.TempDir(), "missing.conf")}); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("Error loading a missing file: expected %v, got %v.\n", os.ErrNotExist, err)
+    }
+}
+
+func TestRewrite(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "redis.conf")
+    content := "# Comment.\nport 6379\nunknown-directive 1\nsave 900 1\nsave 300 100\ndir tmp\n"
+    if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    options := []Option{
+        {Name: "port", Args: []string{"7000"}},
+        {Name: "save", Args: []string{"60", "10"}},
+        // Already in the file, kept even if it is the default.
+        {Name: "dir", Args: []string{"tmp"}, Default: true},
+        {Name: "dbfilename", Args: []string{"dump.csv"}, Default: true},
+        {Name: "notify-keyspace-events", Args: []string{""}},
+        {Name: "requirepass", Args: []string{`pa"ss word`}},
+    }
+    for i := 0; i < 2; i++ {
+        // Rewriting twice gives the same file.
+        if err := Rewrite(path, options); err != nil {
+            t.Fatalf("Error rewriting: %v.\n", err)
+        }
+    }
+
+    rewritten, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := "# Comment.\nport 7000\nunknown-directive 1\nsave 60 10\ndir tmp\n" + rewriteSignature + "\n" +
+        "notify-keyspace-events \"\"\nrequirepass \"pa\\\"ss word\"\n"
+    if string(rewritten) != expected {
+        t.Errorf("Error rewriting: expected %q, got %q.\n", expected, rewritten)
+    }
+    if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+        t.Errorf("Error rewriting: expected the permissions %v, got %v.\n", os.FileMode(0600), info.Mode().Perm())
+    }
+
+    // The rewritten file reads back as the options.
+    directives, err := Parse(strings.NewReader(string(rewritten)))
+    if err != nil {
+        t.Fatalf("Error parsing: %v.\n", err)
+    }
+    if last := directives[len(directives)-1]; !reflect.DeepEqual(last.Args, []string{`pa"ss word`}) {
+        t.Errorf("Error parsing a quoted argument: expected %q, got %q.\n", `pa"ss word`, last.Args)
+    }
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// rewriteSignature precedes the directives appended to a configuration file by Rewrite.
+const rewriteSignature = "# Generated by CONFIG REWRITE"
+
+// Option is the current value of a setting, written to a configuration file by Rewrite.
+type Option struct {
+    Name string
+    Args []string
+    // Default reports whether the value is the default one: such an option is only written if the file already sets it.
+    Default bool
+}
+
+// Rewrite updates the configuration file at path with options, keeping everything else, e.g. comments, untouched.
+// The first directive of the file setting an option is replaced with its current value and the following ones are dropped,
+// options the file doesn't set yet are appended, unless they have their default value.
+// The file is replaced atomically, a missing file is created.
+func Rewrite(path string, options []Option) error {
+    content, err := os.ReadFile(path)
+    if err != nil && !errors.Is(err, os.ErrNotExist) {
+        return err
+    }
+
+    byName := make(map[string]Option, len(options))
+    for _, option := range options {
+        byName[option.Name] = option
+    }
+    written := make(map[string]bool, len(options))
+    hasSignature := false
+
+    var buf bytes.Buffer
+    scanner := bufio.NewScanner(bytes.NewReader(content))
+    for scanner.Scan() {
+        line := scanner.Text()
+        text := strings.TrimSpace(line)
+        if text == rewriteSignature {
+            hasSignature = true
+        }
+        args, err := SplitArgs(text)
+        if text == "" || text[0] == '#' || err != nil || len(args) == 0 {
+            buf.WriteString(line + "\n")
+            continue
+        }
+
+        name := strings.ToLower(args[0])
+        option, ok := byName[name]
+        switch {
+        case !ok:
+            // Not a setting of the server, e.g. a directive it ignores, kept as it is.
+            buf.WriteString(line + "\n")
+        case !written[name]:
+            written[name] = true
+            buf.WriteString(FormatDirective(option.Name, option.Args...) + "\n")
+        }
+    }
+    if err = scanner.Err(); err != nil {
+        return err
+    }
+
+    appended := false
+    for _, option := range options {
+        if written[option.Name] || option.Default {
+            continue
+        }
+        if !appended && !hasSignature {
+            buf.WriteString(rewriteSignature + "\n")
+        }
+        appended = true
+        buf.WriteString(FormatDirective(option.Name, option.Args...) + "\n")
+    }
+    return WriteFileAtomic(path, buf.Bytes())
+}
+
+// FormatDirective formats a directive as a line of a configuration file, quoting the arguments when needed.
+func FormatDirective(name string, args ...string) string {
+    var sb strings.Builder
+    sb.WriteString(name)
+    for _, arg := range args {
+        sb.WriteByte(' ')
+        sb.WriteString(quote(arg))
+    }
+    return sb.String()
+}
+
+// quote returns arg as is if SplitArgs reads it back unchanged, otherwise in double quotes with the escape sequences of Redis.
+func quote(arg string) string {
+    if arg != "" && !strings.ContainsAny(arg, " \t\n\r\v\f\"'\\") && isPrintable(arg) {
+        return arg
+    }
+
+    var sb strings.Builder
+    sb.WriteByte('"')
+    for i := 0; i < len(arg); i++ {
+        switch c := arg[i]; {
+        case c == '\\' || c == '"':
+            sb.WriteByte('\\')
+            sb.WriteByte(c)
+        case c == '\n':
+            sb.WriteString(`\n`)
+        case c == '\r':
+            sb.WriteString(`\r`)
+        case c == '\t':
+            sb.WriteString(`\t`)
+        case c == '\a':
+            sb.WriteString(`\a`)
+        case c == '\b':
+            sb.WriteString(`\b`)
+        case c < 0x20 || c >= 0x7f:
+            fmt.Fprintf(&sb, `\x%02x`, c)
+        default:
+            sb.WriteByte(c)
+        }
+    }
+    sb.WriteByte('"')
+    return sb.String()
+}
+
+func isPrintable(s string) bool {
+    for i := 0; i < len(s); i++ {
+        if s[i] < 0x20 || s[i] >= 0x7f {
+            return false
+        }
+    }
+    return true
+}
+
+// WriteFileAtomic replaces the file at path with data: the data is written to a temporary file of the same directory,
+// flushed to disk, then renamed over path, so a crash leaves either the previous file or the new one.
+func WriteFileAtomic(path string, data []byte) (err error) {
+    temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+    if err != nil {
+        return err
+    }
+    defer func() {
+        if err != nil {
+            _ = os.Remove(temp.Name())
+        }
+    }()
+
+    // Keep the permissions of the file being replaced.
+    mode := os.FileMode(0644)
+    if info, err := os.Stat(path); err == nil {
+        mode = info.Mode().Perm()
+    }
+    if _, err = temp.Write(data); err == nil {
+        err = temp.Sync()
+    }
+    if err = errors.Join(err, temp.Chmod(mode), temp.Close()); err != nil {
+        return err
+    }
+    return os.Rename(temp.Name(), path)
+}
@@ -1,9 +1,14 @@
 package database
 
+import "time"
+
 type MemDb interface {
     Set(key string, value string)
+    Expire(key string, at time.Time) bool
     Get(key string) (string, error)
     GetAllKeys() []string
+    Keys(pattern string) []string
+    Scan(cursor uint64, count int, pattern string, keyType string) (uint64, []string)
     Exists(key string) bool
     Delete(keys ...string) int
     Increment(key string) (int, error)
@@ -11,5 +16,31 @@ type MemDb interface {
     LeftPush(key string, values ...string) (int, error)
     RightPush(key string, values ...string) (int, error)
     LRange(key string, start, stop int) ([]string, error)
+    Size() int
+    Flush() int
+    Watch(key string) uint64
+    Unwatch(key string)
+    Version(key string) uint64
+    Stats() Stats
+    ResetStats()
+    // WithOrigin returns the database, its keyspace events being reported as caused by the client id.
+    WithOrigin(id uint64) MemDb
+}
+
+// MemStore holds the numbered logical databases selectable by clients.
+type MemStore interface {
+    Db(index int) MemDb
+    Len() int
+    Move(key string, src, dst int) (bool, error)
+    SwapDb(i, j int) error
+    FlushAll() int
+    ActiveExpire() int
+    SetNotifier(notifier Notifier)
+    Snapshot() [][]Entry
     SaveDatabase() error
+    BeginSave() (func() error, error)
+    Dir() string
+    SetDir(dir string) error
+    DBFilename() string
+    SetDBFilename(name string) error
 }
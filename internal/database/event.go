@@ -0,0 +1,36 @@
+package database
+
+// Keyspace event classes, as selected by the notify-keyspace-events setting.
+const (
+    NotifyKeyspace = 1 << iota // K: Keyspace events, published with __keyspace@<db>__ prefix.
+    NotifyKeyevent             // E: Keyevent events, published with __keyevent@<db>__ prefix.
+    NotifyGeneric              // g: Generic commands ( non-type specific ) like DEL, EXPIRE, RENAME, ...
+    NotifyString               // $: String commands.
+    NotifyList                 // l: List commands.
+    NotifySet                  // s: Set commands.
+    NotifyHash                 // h: Hash commands.
+    NotifyZset                 // z: Sorted set commands.
+    NotifyExpired              // x: Expired events ( events generated every time a key expires ).
+    NotifyEvicted              // e: Evicted events ( events generated when a key is evicted for maxmemory ).
+    NotifyStream               // t: Stream commands.
+    NotifyKeyMiss              // m: Key-miss events ( events generated when a key that doesn't exist is accessed ).
+    NotifyModule               // d: Module key type events.
+    NotifyNew                  // n: New key events ( events generated every time a key is created ).
+)
+
+// KeyspaceEvent describes a modification of the keyspace.
+type KeyspaceEvent struct {
+    // Type is the class of the event, one of the Notify* constants.
+    Type int
+    // Event is the name of the event, e.g. "set", "del" or "expired".
+    Event string
+    Key   string
+    // Db is the index of the database holding the key.
+    Db int
+    // Client is the id of the client whose command caused the event, 0 if unknown, see MemDb.WithOrigin.
+    Client uint64
+}
+
+// Notifier receives the keyspace events of a database.
+// It is called while the database is locked, so it must not call back into the database.
+type Notifier func(event KeyspaceEvent)
@@ -0,0 +1,114 @@
+package inMemoryDatabase
+
+import (
+    "hash/maphash"
+    "math/bits"
+)
+
+const (
+    // dictInitialSize is the number of buckets a dict starts with. It must be a power of two.
+    dictInitialSize = 4
+    // dictMinFill is the fill ratio ( in percent ) under which the table is shrunk.
+    dictMinFill = 10
+)
+
+// dict is a chained hash table that indexes every key of a Db.
+// Go maps don't expose their bucket layout, so the keyspace is mirrored here to provide a stable cursor for SCAN.
+// The table size is always a power of two, which lets scan use the reverse binary cursor of Redis's dictScan.
+type dict struct {
+    buckets [][]string
+    used    int
+    seed    maphash.Seed
+}
+
+// newDict creates an empty dict.
+func newDict() *dict {
+    return &dict{
+        buckets: make([][]string, dictInitialSize),
+        seed:    maphash.MakeSeed(),
+    }
+}
+
+// hash returns the hash value of key.
+func (d *dict) hash(key string) uint64 {
+    return maphash.String(d.seed, key)
+}
+
+// add inserts key into the dict. Adding an existing key is a no-op.
+func (d *dict) add(key string) {
+    idx := d.hash(key) & uint64(len(d.buckets)-1)
+    for _, k := range d.buckets[idx] {
+        if k == key {
+            return
+        }
+    }
+    d.buckets[idx] = append(d.buckets[idx], key)
+    d.used++
+
+    // Keep the load factor at most 1.
+    if d.used > len(d.buckets) {
+        d.resize(len(d.buckets) * 2)
+    }
+}
+
+// remove deletes key from the dict. Removing a missing key is a no-op.
+func (d *dict) remove(key string) {
+    idx := d.hash(key) & uint64(len(d.buckets)-1)
+    bucket := d.buckets[idx]
+    for i, k := range bucket {
+        if k == key {
+            bucket[i] = bucket[len(bucket)-1]
+            d.buckets[idx] = bucket[:len(bucket)-1]
+            d.used--
+            break
+        }
+    }
+
+    if len(d.buckets) > dictInitialSize && d.used*100/len(d.buckets) < dictMinFill {
+        d.resize(len(d.buckets) / 2)
+    }
+}
+
+// resize rehashes every key into a table of the given size.
+func (d *dict) resize(size int) {
+    buckets := make([][]string, size)
+    mask := uint64(size - 1)
+    for _, bucket := range d.buckets {
+        for _, k := range bucket {
+            idx := d.hash(k) & mask
+            buckets[idx] = append(buckets[idx], k)
+        }
+    }
+    d.buckets = buckets
+}
+
+// scan visits every key in the bucket pointed by cursor and returns the cursor of the next bucket, 0 when the iteration is over.
+//
+// The cursor is incremented on its reversed bits, so the high bits of the bucket index are the ones that change first.
+// When the table grows from 2^n to 2^(n+1) buckets, bucket i is split into buckets i and i+2^n, which share their n low bits
+// and therefore come right after each other in reversed order; when it shrinks, the buckets merged together have all been
+// visited already or are still ahead of the cursor. This guarantees that every key present during the whole iteration is
+// returned at least once, even if the table is resized between two calls, at the price of possible duplicates.
+func (d *dict) scan(cursor uint64, fn func(key string)) uint64 {
+    mask := uint64(len(d.buckets) - 1)
+    for _, k := range d.buckets[cursor&mask] {
+        fn(k)
+    }
+
+    // Set the unmasked bits so incrementing the reversed cursor operates on the masked bits only.
+    cursor |= ^mask
+    cursor = bits.Reverse64(cursor)
+    cursor++
+    cursor = bits.Reverse64(cursor)
+    return cursor
+}
+
+// len returns the number of keys in the dict.
+func (d *dict) len() int {
+    return d.used
+}
+
+// size returns the number of buckets of the table.
+func (d *dict) size() int {
+    return len(d.buckets)
+}
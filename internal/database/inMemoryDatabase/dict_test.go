@@ -0,0 +1,120 @@
+package inMemoryDatabase
+
+import (
+    "strconv"
+    "testing"
+)
+
+func TestDict_AddRemove(t *testing.T) {
+    d := newDict()
+    for i := 0; i < 100; i++ {
+        d.add(strconv.Itoa(i))
+        d.add(strconv.Itoa(i)) // Adding twice should be a no-op.
+    }
+    if d.len() != 100 {
+        t.Errorf("Error dict length: expected %d, got %d.\n", 100, d.len())
+    }
+    if d.size() < 100 {
+        t.Errorf("Error dict should grow to keep load factor under 1: got %d buckets for %d keys.\n", d.size(), d.len())
+    }
+
+    for i := 0; i < 100; i++ {
+        d.remove(strconv.Itoa(i))
+        d.remove("missing")
+    }
+    if d.len() != 0 {
+        t.Errorf("Error dict length: expected %d, got %d.\n", 0, d.len())
+    }
+    if d.size() != dictInitialSize {
+        t.Errorf("Error dict should shrink back: expected %d buckets, got %d.\n", dictInitialSize, d.size())
+    }
+}
+
+func TestDict_Scan(t *testing.T) {
+    t.Run("Test Scan: Full iteration", func(t *testing.T) {
+        d := newDict()
+        for i := 0; i < 1000; i++ {
+            d.add(strconv.Itoa(i))
+        }
+
+        seen := make(map[string]bool)
+        cursor := uint64(0)
+        for {
+            cursor = d.scan(cursor, func(key string) {
+                seen[key] = true
+            })
+            if cursor == 0 {
+                break
+            }
+        }
+
+        if len(seen) != 1000 {
+            t.Errorf("Error full iteration: expected %d keys, got %d.\n", 1000, len(seen))
+        }
+    })
+
+    t.Run("Test Scan: Resize during iteration", func(t *testing.T) {
+        testCases := []struct {
+            name      string
+            extraKeys int
+            resize    func(d *dict, step int)
+        }{
+            {
+                name:      "grow",
+                extraKeys: 0,
+                resize: func(d *dict, step int) {
+                    // Add new keys during the first steps, which forces the table to grow a few times.
+                    if step >= 40 {
+                        return
+                    }
+                    for i := 0; i < 50; i++ {
+                        d.add("new:" + strconv.Itoa(step) + ":" + strconv.Itoa(i))
+                    }
+                },
+            },
+            {
+                name:      "shrink",
+                extraKeys: 2000,
+                resize: func(d *dict, step int) {
+                    // Remove keys that aren't part of the original set, which forces the table to shrink.
+                    for i := step * 200; i < (step+1)*200 && i < 2000; i++ {
+                        d.remove("tmp:" + strconv.Itoa(i))
+                    }
+                },
+            },
+        }
+
+        for _, tc := range testCases {
+            d := newDict()
+            original := make([]string, 0, 200)
+            for i := 0; i < 200; i++ {
+                key := strconv.Itoa(i)
+                original = append(original, key)
+                d.add(key)
+            }
+            for i := 0; i < tc.extraKeys; i++ {
+                d.add("tmp:" + strconv.Itoa(i))
+            }
+
+            seen := make(map[string]bool)
+            cursor := uint64(0)
+            step := 0
+            for {
+                cursor = d.scan(cursor, func(key string) {
+                    seen[key] = true
+                })
+                if cursor == 0 {
+                    break
+                }
+                tc.resize(d, step)
+                step++
+            }
+
+            for _, key := range original {
+                if !seen[key] {
+                    t.Errorf("Error %s during iteration: key %s was never returned.\n", tc.name, key)
+                }
+            }
+        }
+    })
+}
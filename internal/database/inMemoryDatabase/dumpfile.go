@@ -0,0 +1,133 @@
+package inMemoryDatabase
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "hash/crc64"
+    "io"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+)
+
+// TypeVersion is the first record of a CSV dump: `<Version>,version`. Dumps written by previous versions have none,
+// they are legacy dumps and are loaded only if Config.LoadLegacyCSV is set.
+const TypeVersion = "<Version>"
+
+// csvVersion is the version of the CSV dumps written.
+const csvVersion = "1"
+
+// TypeChecksum is the last record of a CSV dump: `<Checksum>,crc`, the CRC64 of the records before it, in hexadecimal on 16 digits.
+// It is mandatory in versioned dumps, legacy dumps may not have one.
+const TypeChecksum = "<Checksum>"
+
+// checksumRecordLen is the length of the checksum record, line ending included.
+const checksumRecordLen = len(TypeChecksum) + 1 + 16 + 1
+
+var (
+    ErrBadChecksum = errors.New("error dump file checksum mismatch")
+    ErrCorruptDump = errors.New("error dump file is corrupt")
+
+    crcTable = crc64.MakeTable(crc64.ECMA)
+)
+
+// writeFileAtomic writes a dump to path through write, without ever leaving a partial dump behind.
+// The dump is written to a temporary file of the same directory and flushed to disk, then renamed over path,
+// and the directory is flushed so the rename itself is durable. path is left as it was if anything fails.
+func writeFileAtomic(path string, write func(w io.Writer) error) (err error) {
+    dir := filepath.Dir(path)
+    temp := filepath.Join(dir, fmt.Sprintf("temp-%d-%s", os.Getpid(), filepath.Base(path)))
+    file, err := os.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    if err != nil {
+        return err
+    }
+    defer func() {
+        if err != nil {
+            _ = os.Remove(temp)
+        }
+    }()
+
+    if err = write(file); err == nil {
+        err = file.Sync()
+    }
+    if err = errors.Join(err, file.Close()); err != nil {
+        return err
+    }
+    if err = os.Rename(temp, path); err != nil {
+        return err
+    }
+    return syncDir(dir)
+}
+
+// syncDir flushes the entries of a directory to disk, making the files created or renamed in it durable.
+func syncDir(dir string) error {
+    d, err := os.Open(dir)
+    if err != nil {
+        return err
+    }
+    return errors.Join(d.Sync(), d.Close())
+}
+
+// versionRecord returns the first record of a CSV dump.
+func versionRecord() []string {
+    return []string{TypeVersion, csvVersion}
+}
+
+// isVersioned tells whether the CSV dump file starts with the version record. The offset of file is left untouched.
+func isVersioned(file *os.File) (bool, error) {
+    header := []byte(strings.Join(versionRecord(), ",") + "\n")
+    start := make([]byte, len(header))
+    n, err := file.ReadAt(start, 0)
+    if err != nil && !errors.Is(err, io.EOF) {
+        return false, err
+    }
+    return bytes.Equal(start[:n], header), nil
+}
+
+// checksumRecord returns the checksum record of a CSV dump whose records have the CRC64 crc.
+func checksumRecord(crc uint64) []string {
+    return []string{TypeChecksum, fmt.Sprintf("%016x", crc)}
+}
+
+// verifyChecksum checks the CRC64 of the CSV dump file against its checksum record.
+// A missing checksum record is an ErrCorruptDump unless optional, for legacy dumps. The offset of file is left untouched.
+func verifyChecksum(file *os.File, optional bool) error {
+    info, err := file.Stat()
+    if err != nil {
+        return err
+    }
+    size := info.Size() - int64(checksumRecordLen)
+    if size < 0 {
+        if optional {
+            return nil
+        }
+        return fmt.Errorf("%w: missing checksum record", ErrCorruptDump)
+    }
+
+    trailer := make([]byte, checksumRecordLen)
+    if _, err = file.ReadAt(trailer, size); err != nil {
+        return err
+    }
+    prefix := TypeChecksum + ","
+    if string(trailer[:len(prefix)]) != prefix || trailer[len(trailer)-1] != '\n' {
+        if optional {
+            return nil
+        }
+        return fmt.Errorf("%w: missing checksum record", ErrCorruptDump)
+    }
+    expected, err := strconv.ParseUint(string(trailer[len(prefix):len(trailer)-1]), 16, 64)
+    if err != nil {
+        return fmt.Errorf("%w: invalid checksum record %q", ErrBadChecksum, trailer)
+    }
+
+    crc := crc64.New(crcTable)
+    if _, err = io.Copy(crc, io.NewSectionReader(file, 0, size)); err != nil {
+        return err
+    }
+    if crc.Sum64() != expected {
+        return fmt.Errorf("%w: expected %016x, got %016x", ErrBadChecksum, expected, crc.Sum64())
+    }
+    return nil
+}
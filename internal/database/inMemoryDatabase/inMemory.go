@@ -1,23 +1,41 @@
 package inMemoryDatabase
 
 import (
-    "encoding/csv"
+    "MyOwnRedis/internal/database"
+    "MyOwnRedis/internal/glob"
     "errors"
-    "io"
-    "os"
     "strconv"
     "sync"
+    "sync/atomic"
+    "time"
 )
 
 const Nil = "nil"
-const (
-    Tmp      = "tmp/"
-    DumpFile = "tmp/dump.csv"
-)
 
 const (
     TypeString = "<String>"
     TypeList   = "<List>"
+    // TypeSelect marks the start of the records of a database: `<Select>,index`.
+    TypeSelect = "<Select>"
+    // TypeExpireAt follows the record of a key having a time to live: `<ExpireAt>,key,unix-time-milliseconds`.
+    TypeExpireAt = "<ExpireAt>"
+)
+
+// Key type names as reported to clients, e.g. by SCAN TYPE.
+const (
+    KeyTypeString = "string"
+    KeyTypeList   = "list"
+    KeyTypeNone   = "none"
+)
+
+// Active expiration, see activeExpire.
+const (
+    // activeExpireBudget is the time an active expiration pass may take, a quarter of the 100ms between two passes.
+    activeExpireBudget = 25 * time.Millisecond
+    // activeExpireSample is the number of volatile keys sampled per round.
+    activeExpireSample = 20
+    // activeExpireStale is the percentage of expired keys in a sample above which another round is run.
+    activeExpireStale = 25
 )
 
 var (
@@ -26,51 +44,159 @@ var (
     ErrNotList    = errors.New("error fetched value is not a list")
 )
 
-// Db instance.
+// Db instance. A Db is a handle on the data of the database, the handles returned by WithOrigin share it.
 type Db struct {
+    *dbData
+    // origin is the id of the client whose commands run through the handle, reported in keyspace events, 0 if none.
+    origin uint64
+}
+
+// dbData is the data of a Db.
+type dbData struct {
+    // id is the index of the database in its Store, reported in keyspace events.
+    id            int
     stringStorage map[string]string
     listStorage   map[string]*StrNode
+    // expires holds the time at which each volatile key expires.
+    expires map[string]time.Time
+    // keys indexes every key of both storages, providing the cursor used by SCAN.
+    keys *dict
+    // watched holds the modification version of the keys watched by at least one client.
+    watched map[string]*watchedKey
+    // notifier receives the keyspace events of the database, if set.
+    notifier database.Notifier
+    // snapshot is the copy-on-write snapshot being saved, if any. Keys are preserved in it before being modified.
+    snapshot *cowSnapshot
+    // hits, misses and expired are the counters of Stats, updated while holding the lock for reading at least.
+    hits, misses, expired atomic.Int64
+    // avgTTL is the average time to live of the volatile keys, estimated from the samples of ActiveExpire.
+    avgTTL time.Duration
     sync.RWMutex
 }
 
-// New creates a new Db.
-// If there's existing dump.csv, load the data instead.
-func New() *Db {
-    // Create directory 'tmp' and 'tmp/dump.csv' if not exist.
-    if _, err := os.Stat(Tmp); os.IsNotExist(err) {
-        if err = os.Mkdir(Tmp, os.ModeDir|os.ModePerm); err != nil {
-            panic(err)
-        }
-        _, err = os.OpenFile(DumpFile, os.O_CREATE, 0644)
-        if err != nil {
-            panic(err)
-        }
-        return &Db{
-            stringStorage: make(map[string]string),
-            listStorage:   make(map[string]*StrNode),
-        }
-    }
+// watchedKey tracks the modifications of a key watched by WATCH.
+type watchedKey struct {
+    // refs is the number of clients watching the key.
+    refs int
+    // version is incremented every time the key is modified.
+    version uint64
+}
 
-    db, err := loadDatabase()
-    if err != nil {
-        panic(err)
-    }
-    return db
+// NewDb creates a new empty Db.
+func NewDb() *Db {
+    return &Db{dbData: &dbData{
+        stringStorage: make(map[string]string),
+        listStorage:   make(map[string]*StrNode),
+        expires:       make(map[string]time.Time),
+        keys:          newDict(),
+        watched:       make(map[string]*watchedKey),
+    }}
+}
 
+// WithOrigin returns a handle on the database whose keyspace events are reported as caused by the client id.
+func (d *Db) WithOrigin(id uint64) database.MemDb {
+    return &Db{dbData: d.dbData, origin: id}
 }
 
 // Set sets key to hold the string value.
 // If key already holds a value, it is overwritten, regardless of its type.
+// Any previous time to live associated with the key is discarded.
 func (d *Db) Set(key string, value string) {
     d.Lock()
     defer d.Unlock()
+    d.expireIfNeeded(key)
+    d.preserve(key)
+
     // Check if key is in listStorage.
     if _, ok := d.listStorage[key]; ok {
         // Delete the key-value pair if existed.
         delete(d.listStorage, key)
+    } else if _, ok = d.stringStorage[key]; !ok {
+        d.addKey(key)
     }
 
     d.stringStorage[key] = value
+    delete(d.expires, key)
+    d.touch(key)
+    d.notify(database.NotifyString, "set", key)
+}
+
+// Expire sets a timeout on key, after which the key is automatically deleted.
+// A time in the past deletes the key immediately. It returns false if the key doesn't exist.
+func (d *Db) Expire(key string, at time.Time) bool {
+    d.Lock()
+    defer d.Unlock()
+    d.expireIfNeeded(key)
+
+    if d.typeOf(key) == KeyTypeNone {
+        return false
+    }
+    d.preserve(key)
+
+    if !time.Now().Before(at) {
+        d.removeKey(key)
+        d.notify(database.NotifyGeneric, "del", key)
+        return true
+    }
+
+    d.expires[key] = at
+    d.touch(key)
+    d.notify(database.NotifyGeneric, "expire", key)
+    return true
+}
+
+// ActiveExpire deletes keys whose time to live has elapsed and returns the number of keys deleted, see activeExpire.
+// Expired keys are never visible to clients, ActiveExpire reclaims the memory of those nobody accesses anymore.
+func (d *Db) ActiveExpire() int {
+    return d.activeExpire(time.Now().Add(activeExpireBudget))
+}
+
+// activeExpire samples the volatile keys as Redis does, rather than walking all of them: rounds of activeExpireSample keys
+// are run while more than activeExpireStale percent of a sample had expired, until deadline. The lock is released between
+// rounds, so clients are never blocked for long however many keys are volatile.
+func (d *Db) activeExpire(deadline time.Time) int {
+    var expired int
+    for {
+        sampled, stale := d.expireSample()
+        expired += stale
+        if stale*100 <= sampled*activeExpireStale || !time.Now().Before(deadline) {
+            return expired
+        }
+    }
+}
+
+// expireSample deletes the expired keys among activeExpireSample volatile keys and updates the average time to live with
+// the others. It returns the number of keys sampled and deleted.
+func (d *Db) expireSample() (sampled, expired int) {
+    d.Lock()
+    defer d.Unlock()
+
+    var ttl time.Duration
+    now := time.Now()
+    // The iteration order of maps is random, the first keys are a random sample.
+    for key, at := range d.expires {
+        if sampled == activeExpireSample {
+            break
+        }
+        sampled++
+        if d.expireIfNeeded(key) {
+            expired++
+            continue
+        }
+        ttl += at.Sub(now)
+    }
+
+    switch alive := sampled - expired; {
+    case len(d.expires) == 0:
+        d.avgTTL = 0
+    case alive == 0:
+    case d.avgTTL == 0:
+        d.avgTTL = ttl / time.Duration(alive)
+    default:
+        // A moving average, as each sample covers few keys.
+        d.avgTTL = d.avgTTL/50*49 + ttl/time.Duration(alive)/50
+    }
+    return sampled, expired
 }
 
 // Get returns the string value of the key. If the key doesn't exist, "nil" is returned.
@@ -80,6 +206,10 @@ func (d *Db) Get(key string) (string, error) {
     d.RLock()
     defer d.RUnlock()
 
+    if !d.lookup(key) {
+        return Nil, nil
+    }
+
     value, okInStringStorage := d.stringStorage[key]
 
     _, okInListStorage := d.listStorage[key]
@@ -103,24 +233,105 @@ func (d *Db) GetAllKeys() []string {
     defer d.RUnlock()
 
     for k := range d.stringStorage {
-        allKeys = append(allKeys, k)
+        if !d.isExpired(k) {
+            allKeys = append(allKeys, k)
+        }
     }
 
     for k := range d.listStorage {
-        allKeys = append(allKeys, k)
+        if !d.isExpired(k) {
+            allKeys = append(allKeys, k)
+        }
     }
 
     return allKeys
 }
 
+// Keys returns all the keys matching the glob-style pattern.
+func (d *Db) Keys(pattern string) []string {
+    matchedKeys := make([]string, 0)
+
+    d.RLock()
+    defer d.RUnlock()
+
+    allKeys := pattern == "*"
+    for k := range d.stringStorage {
+        if (allKeys || glob.Match(pattern, k, false)) && !d.isExpired(k) {
+            matchedKeys = append(matchedKeys, k)
+        }
+    }
+
+    for k := range d.listStorage {
+        if (allKeys || glob.Match(pattern, k, false)) && !d.isExpired(k) {
+            matchedKeys = append(matchedKeys, k)
+        }
+    }
+
+    return matchedKeys
+}
+
+// Scan incrementally iterates the keyspace, starting from cursor.
+// It returns the cursor to use in the next call, 0 when the iteration is complete, and the keys found in this call.
+// count is a hint of how many keys to visit in one call, pattern filters the keys with a glob-style pattern
+// and keyType only keeps keys holding that type. Empty pattern or keyType disable the corresponding filter.
+// A full iteration, started and finished with cursor 0, returns every key that was present during the whole iteration
+// at least once, even if the keyspace is resized in between; a key may be returned multiple times.
+func (d *Db) Scan(cursor uint64, count int, pattern string, keyType string) (uint64, []string) {
+    if count < 1 {
+        count = 1
+    }
+
+    d.RLock()
+    defer d.RUnlock()
+
+    keys := make([]string, 0, count)
+    // Bound the work done on a sparse table.
+    maxIterations := count * 10
+    for {
+        cursor = d.keys.scan(cursor, func(key string) {
+            keys = append(keys, key)
+        })
+        maxIterations--
+        if cursor == 0 || maxIterations == 0 || len(keys) >= count {
+            break
+        }
+    }
+
+    // Filter the collected keys.
+    filtered := keys[:0]
+    for _, key := range keys {
+        if d.isExpired(key) {
+            continue
+        }
+        if pattern != "" && pattern != "*" && !glob.Match(pattern, key, false) {
+            continue
+        }
+        if keyType != "" && d.typeOf(key) != keyType {
+            continue
+        }
+        filtered = append(filtered, key)
+    }
+
+    return cursor, filtered
+}
+
+// typeOf returns the type name of the value stored at key. Callers must hold the lock.
+func (d *Db) typeOf(key string) string {
+    if _, ok := d.stringStorage[key]; ok {
+        return KeyTypeString
+    }
+    if _, ok := d.listStorage[key]; ok {
+        return KeyTypeList
+    }
+    return KeyTypeNone
+}
+
 // Exists determines whether a key exists.
 func (d *Db) Exists(key string) bool {
     d.RLock()
     defer d.RUnlock()
 
-    _, strOk := d.stringStorage[key]
-    _, listOk := d.listStorage[key]
-    return strOk || listOk
+    return d.lookup(key)
 }
 
 // Delete removes the specified key and returns numbers of the actual deleted key.
@@ -130,16 +341,15 @@ func (d *Db) Delete(keys ...string) int {
     defer d.Unlock()
 
     var deletedKeys int
-    // delete is a no-op if the key doesn't exist in the map.
     for _, key := range keys {
-        _, inStringStorage := d.stringStorage[key]
-        _, inListStorage := d.listStorage[key]
-        if inStringStorage || inListStorage {
+        // A key that has just expired doesn't count as deleted.
+        d.expireIfNeeded(key)
+
+        if d.typeOf(key) != KeyTypeNone {
             deletedKeys++
+            d.removeKey(key)
+            d.notify(database.NotifyGeneric, "del", key)
         }
-
-        delete(d.stringStorage, key)
-        delete(d.listStorage, key)
     }
 
     return deletedKeys
@@ -151,6 +361,8 @@ func (d *Db) Delete(keys ...string) int {
 func (d *Db) Increment(key string) (int, error) {
     d.Lock()
     defer d.Unlock()
+    d.expireIfNeeded(key)
+    d.preserve(key)
 
     // Key exist but in listStorage.
     _, inListStorage := d.listStorage[key]
@@ -161,6 +373,8 @@ func (d *Db) Increment(key string) (int, error) {
 
     if !inStringStorage && !inListStorage {
         d.stringStorage[key] = "1"
+        d.addKey(key)
+        d.touch(key)
         result = 1
     } else if inListStorage && !inStringStorage {
         // Key exist but in wrong storage -> Error value type.
@@ -172,7 +386,9 @@ func (d *Db) Increment(key string) (int, error) {
         }
         result++
         d.stringStorage[key] = strconv.Itoa(result)
+        d.touch(key)
     }
+    d.notify(database.NotifyString, "incrby", key)
     return result, nil
 }
 
@@ -182,6 +398,8 @@ func (d *Db) Increment(key string) (int, error) {
 func (d *Db) Decrement(key string) (int, error) {
     d.Lock()
     defer d.Unlock()
+    d.expireIfNeeded(key)
+    d.preserve(key)
     _, inListStorage := d.listStorage[key]
     value, inStringStorage := d.stringStorage[key]
 
@@ -190,6 +408,8 @@ func (d *Db) Decrement(key string) (int, error) {
 
     if !inStringStorage && !inListStorage {
         d.stringStorage[key] = "-1"
+        d.addKey(key)
+        d.touch(key)
         result = -1
     } else if inListStorage && !inStringStorage {
         return 0, ErrNotInteger
@@ -200,7 +420,9 @@ func (d *Db) Decrement(key string) (int, error) {
         }
         result--
         d.stringStorage[key] = strconv.Itoa(result)
+        d.touch(key)
     }
+    d.notify(database.NotifyString, "incrby", key)
     return result, nil
 }
 
@@ -215,6 +437,10 @@ func (d *Db) LRange(key string, start, stop int) ([]string, error) {
     d.RLock()
     defer d.RUnlock()
 
+    if !d.lookup(key) {
+        return nil, nil
+    }
+
     if _, ok := d.stringStorage[key]; ok {
         // Values having wrong type.
         return nil, ErrNotList
@@ -236,21 +462,26 @@ func (d *Db) LRange(key string, start, stop int) ([]string, error) {
 func (d *Db) LeftPush(key string, values ...string) (int, error) {
     d.Lock()
     defer d.Unlock()
+    d.expireIfNeeded(key)
 
     _, inStringStorage := d.stringStorage[key]
     if inStringStorage {
         return 0, ErrNotList
     }
+    d.preserve(key)
 
     _, inListStorage := d.listStorage[key]
     if !inListStorage {
         d.listStorage[key] = nil
+        d.addKey(key)
     }
 
     // Push the values to the current node.
     newHead := d.listStorage[key].LeftPush(values)
     // Assign newHead as the new corresponding list.
     d.listStorage[key] = newHead
+    d.touch(key)
+    d.notify(database.NotifyList, "lpush", key)
 
     return d.listStorage[key].Len(), nil
 }
@@ -265,115 +496,195 @@ func (d *Db) LeftPush(key string, values ...string) (int, error) {
 func (d *Db) RightPush(key string, values ...string) (int, error) {
     d.Lock()
     defer d.Unlock()
+    d.expireIfNeeded(key)
 
     _, inStringStorage := d.stringStorage[key]
     if inStringStorage {
         return 0, ErrNotList
     }
+    d.preserve(key)
 
     _, inListStorage := d.listStorage[key]
     if !inListStorage {
         d.listStorage[key] = nil
+        d.addKey(key)
     }
     newHead := d.listStorage[key].RightPush(values)
     d.listStorage[key] = newHead
+    d.touch(key)
+    d.notify(database.NotifyList, "rpush", key)
 
     return d.listStorage[key].Len(), nil
 }
 
-// SaveDatabase persists all data to 'tmp/dump.csv'
-func (d *Db) SaveDatabase() error {
-    // Lock the database from writing new data.
+// Size returns the number of keys in the database.
+func (d *Db) Size() int {
     d.RLock()
     defer d.RUnlock()
 
-    // Open a csv file.
-    file, err := os.OpenFile(DumpFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-    if err != nil {
-        return err
-    }
+    return len(d.stringStorage) + len(d.listStorage)
+}
 
-    defer func() {
-        if err = file.Close(); err != nil {
-            panic(err)
-        }
-    }()
+// Flush removes all keys from the database and returns the number of keys removed.
+// The old storages are simply dropped and reclaimed by the garbage collector, so flushing never blocks on the size of the database.
+func (d *Db) Flush() int {
+    d.Lock()
+    defer d.Unlock()
 
-    // Create csv writer.
-    w := csv.NewWriter(file)
+    removed := len(d.stringStorage) + len(d.listStorage)
+    d.touchAll()
+    d.freeze()
+    d.stringStorage = make(map[string]string)
+    d.listStorage = make(map[string]*StrNode)
+    d.expires = make(map[string]time.Time)
+    d.keys = newDict()
+    d.avgTTL = 0
+    return removed
+}
 
-    // Clean underlying buffer in w.
-    defer w.Flush()
+// Watch starts watching key for modifications and returns its current version.
+// Every call must be paired with a call to Unwatch once the client stops watching the key.
+func (d *Db) Watch(key string) uint64 {
+    d.Lock()
+    defer d.Unlock()
 
-    record := make([][]string, 0)
+    w, ok := d.watched[key]
+    if !ok {
+        w = &watchedKey{}
+        d.watched[key] = w
+    }
+    w.refs++
+    return w.version
+}
 
-    // Write the string section.
-    for key, value := range d.stringStorage {
-        record = append(record, []string{TypeString, key, value})
+// Unwatch stops watching key. Modifications of keys nobody watches are not tracked.
+func (d *Db) Unwatch(key string) {
+    d.Lock()
+    defer d.Unlock()
+
+    w, ok := d.watched[key]
+    if !ok {
+        return
     }
+    w.refs--
+    if w.refs <= 0 {
+        delete(d.watched, key)
+    }
+}
 
-    // Write the list section.
-    for key, list := range d.listStorage {
-        curRow := []string{TypeList, key}
-        temp := list
-        for temp != nil {
-            curRow = append(curRow, temp.value)
-            temp = temp.next
-        }
-        record = append(record, curRow)
+// Version returns the modification version of a watched key.
+// Comparing it with the value returned by Watch tells whether the key was modified in between.
+func (d *Db) Version(key string) uint64 {
+    d.RLock()
+    defer d.RUnlock()
+
+    if w, ok := d.watched[key]; ok {
+        return w.version
     }
+    return 0
+}
+
+// Stats returns the statistics of the database.
+func (d *Db) Stats() database.Stats {
+    d.RLock()
+    defer d.RUnlock()
 
-    err = w.WriteAll(record)
-    if err != nil {
-        return err
+    return database.Stats{
+        Keys:           len(d.stringStorage) + len(d.listStorage),
+        Expires:        len(d.expires),
+        AvgTTL:         d.avgTTL,
+        KeyspaceHits:   d.hits.Load(),
+        KeyspaceMisses: d.misses.Load(),
+        ExpiredKeys:    d.expired.Load(),
     }
+}
 
-    return nil
+// ResetStats resets the counters of Stats, e.g. for CONFIG RESETSTAT.
+func (d *Db) ResetStats() {
+    d.hits.Store(0)
+    d.misses.Store(0)
+    d.expired.Store(0)
 }
 
-// loadDatabase loads from 'tmp/dump.csv'
-func loadDatabase() (*Db, error) {
-    db := &Db{
-        stringStorage: make(map[string]string),
-        listStorage:   make(map[string]*StrNode),
+// addKey indexes a key that has just been created. Callers must hold the lock.
+func (d *Db) addKey(key string) {
+    d.keys.add(key)
+    d.notify(database.NotifyNew, "new", key)
+}
+
+// removeKey deletes key and everything associated with it. Callers must hold the lock.
+func (d *Db) removeKey(key string) {
+    d.preserve(key)
+    delete(d.stringStorage, key)
+    delete(d.listStorage, key)
+    delete(d.expires, key)
+    d.keys.remove(key)
+    d.touch(key)
+}
+
+// isExpired reports whether the time to live of key has elapsed. Callers must hold the lock, for reading at least.
+// An expired key must be treated as missing even before it is actually deleted.
+func (d *Db) isExpired(key string) bool {
+    at, ok := d.expires[key]
+    return ok && !time.Now().Before(at)
+}
+
+// expireIfNeeded deletes key if its time to live has elapsed and reports whether it did. Callers must hold the lock.
+func (d *Db) expireIfNeeded(key string) bool {
+    if !d.isExpired(key) {
+        return false
     }
+    d.removeKey(key)
+    d.expired.Add(1)
+    d.notify(database.NotifyExpired, "expired", key)
+    return true
+}
 
-    // Read from dump.csv and store to d.Db
-    file, err := os.OpenFile(DumpFile, os.O_CREATE|os.O_RDONLY, 0644)
-    if err != nil {
-        return nil, err
+// lookup reports whether key exists for a read command, counting a keyspace hit or miss. Callers must hold the lock, for reading at least.
+func (d *Db) lookup(key string) bool {
+    if d.typeOf(key) == KeyTypeNone || d.isExpired(key) {
+        d.misses.Add(1)
+        return false
     }
+    d.hits.Add(1)
+    return true
+}
 
-    defer func() {
-        err = file.Close()
-        if err != nil {
-            panic(err)
-        }
-    }()
+// notify sends a keyspace event to the notifier of the database, if any. Callers must hold the lock.
+func (d *Db) notify(eventType int, event, key string) {
+    if d.notifier != nil {
+        d.notifier(database.KeyspaceEvent{Type: eventType, Event: event, Key: key, Db: d.id, Client: d.origin})
+    }
+}
 
-    // Create csv reader.
-    r := csv.NewReader(file)
+// touch marks key as modified. Callers must hold the lock.
+func (d *Db) touch(key string) {
+    if w, ok := d.watched[key]; ok {
+        w.version++
+    }
+}
 
-    // Disable record length test in the CSV reader.
-    r.FieldsPerRecord = -1
-    var record []string
-    for {
-        record, err = r.Read()
-        if err != nil {
-            if errors.Is(err, io.EOF) {
-                break
-            }
-            return nil, err
+// touchAll marks every existing watched key as modified, e.g. when the whole database is flushed or swapped. Callers must hold the lock.
+func (d *Db) touchAll() {
+    for key, w := range d.watched {
+        if d.typeOf(key) != KeyTypeNone {
+            w.version++
         }
+    }
+}
 
-        if len(record) != 0 {
-            switch record[0] {
-            case TypeString:
-                db.Set(record[1], record[2])
-            case TypeList:
-                _, _ = db.RightPush(record[1], record[2:]...)
-            }
+// entries returns a copy of every key of the database that isn't expired. Callers must hold the lock.
+func (d *Db) entries() []database.Entry {
+    entries := make([]database.Entry, 0, len(d.stringStorage)+len(d.listStorage))
+    for key, value := range d.stringStorage {
+        if !d.isExpired(key) {
+            entries = append(entries, database.Entry{Key: key, Type: database.EntryString, String: value, ExpireAt: d.expires[key]})
+        }
+    }
+    for key, list := range d.listStorage {
+        if !d.isExpired(key) {
+            entries = append(entries, database.Entry{Key: key, Type: database.EntryList, List: list.arr(), ExpireAt: d.expires[key]})
         }
     }
-    return db, nil
+    return entries
 }
@@ -1,13 +1,17 @@
 package inMemoryDatabase
 
 import (
+    "MyOwnRedis/internal/database"
     "errors"
+    "slices"
+    "sort"
     "strconv"
     "testing"
+    "time"
 )
 
 func TestDb_Set(t *testing.T) {
-    db := New()
+    db := NewDb()
     // Rare case, when key already exist but in listStorage.
     kLists := []struct {
         key  string
@@ -61,7 +65,7 @@ func TestDb_Set(t *testing.T) {
 }
 
 func TestDb_Get(t *testing.T) {
-    db := New()
+    db := NewDb()
     t.Run("Test Get: Correct value type", func(t *testing.T) {
         kvs := []struct {
             key   string
@@ -142,7 +146,7 @@ func TestDb_Get(t *testing.T) {
 }
 
 func TestDb_Exists(t *testing.T) {
-    db := New()
+    db := NewDb()
     kvs := []struct {
         key   string
         value string
@@ -210,7 +214,7 @@ func TestDb_Exists(t *testing.T) {
 }
 
 func TestDb_Delete(t *testing.T) {
-    db := New()
+    db := NewDb()
     kvs := []struct {
         key   string
         value string
@@ -292,7 +296,7 @@ func TestDb_Delete(t *testing.T) {
 }
 
 func TestDb_Increment(t *testing.T) {
-    db := New()
+    db := NewDb()
 
     t.Run("Test Increment: Correct input", func(t *testing.T) {
         kvs := []struct {
@@ -381,7 +385,7 @@ func TestDb_Increment(t *testing.T) {
 }
 
 func TestDb_Decrement(t *testing.T) {
-    db := New()
+    db := NewDb()
 
     t.Run("Test Decrement: Correct input", func(t *testing.T) {
         kvs := []struct {
@@ -469,7 +473,7 @@ func TestDb_Decrement(t *testing.T) {
 }
 
 func TestDb_LRange(t *testing.T) {
-    db := New()
+    db := NewDb()
     db.stringStorage["x"] = "1"
 
     // We only test for the incorrect ones here. The correct ones were tested already.
@@ -482,7 +486,7 @@ func TestDb_LRange(t *testing.T) {
 }
 
 func TestDb_LeftPush(t *testing.T) {
-    db := New()
+    db := NewDb()
     t.Run("Test LeftPush: Correct input", func(t *testing.T) {
         testCases := []struct {
             key            string
@@ -570,7 +574,7 @@ func TestDb_LeftPush(t *testing.T) {
 }
 
 func TestDb_RightPush(t *testing.T) {
-    db := New()
+    db := NewDb()
     t.Run("Test RightPush: Correct input", func(t *testing.T) {
         testCases := []struct {
             key            string
@@ -656,3 +660,252 @@ func TestDb_RightPush(t *testing.T) {
         }
     })
 }
+
+func TestDb_Keys(t *testing.T) {
+    db := NewDb()
+    db.Set("user:1:name", "foo")
+    db.Set("user:2:name", "bar")
+    db.Set("user:1:age", "20")
+    _, _ = db.RightPush("user:list", "a")
+
+    testCases := []struct {
+        pattern      string
+        expectedKeys []string
+    }{
+        {pattern: "user:*:name", expectedKeys: []string{"user:1:name", "user:2:name"}},
+        {pattern: "user:1:*", expectedKeys: []string{"user:1:age", "user:1:name"}},
+        {pattern: "user:l?st", expectedKeys: []string{"user:list"}},
+        {pattern: "nothing*", expectedKeys: []string{}},
+    }
+
+    for _, tc := range testCases {
+        keys := db.Keys(tc.pattern)
+        sort.Strings(keys)
+        if !slices.Equal(keys, tc.expectedKeys) {
+            t.Errorf("Error keys matching %s: expected %v, got %v.\n", tc.pattern, tc.expectedKeys, keys)
+        }
+    }
+}
+
+func TestDb_Scan(t *testing.T) {
+    db := NewDb()
+    for i := 0; i < 100; i++ {
+        db.Set("scan:string:"+strconv.Itoa(i), "value")
+        _, _ = db.RightPush("scan:list:"+strconv.Itoa(i), "value")
+    }
+
+    testCases := []struct {
+        pattern       string
+        keyType       string
+        expectedCount int
+    }{
+        {pattern: "scan:*", expectedCount: 200},
+        {pattern: "scan:string:*", expectedCount: 100},
+        {pattern: "scan:*", keyType: KeyTypeList, expectedCount: 100},
+        {pattern: "scan:*:1?", keyType: KeyTypeString, expectedCount: 10},
+        {pattern: "scan:*", keyType: "hash", expectedCount: 0},
+    }
+
+    for _, tc := range testCases {
+        seen := make(map[string]bool)
+        cursor := uint64(0)
+        for {
+            var keys []string
+            cursor, keys = db.Scan(cursor, 7, tc.pattern, tc.keyType)
+            for _, key := range keys {
+                seen[key] = true
+            }
+            if cursor == 0 {
+                break
+            }
+        }
+
+        if len(seen) != tc.expectedCount {
+            t.Errorf("Error scanning with pattern %s and type %s: expected %d keys, got %d.\n", tc.pattern, tc.keyType, tc.expectedCount, len(seen))
+        }
+    }
+}
+
+func TestDb_Flush(t *testing.T) {
+    db := NewDb()
+    db.Set("foo", "bar")
+    _, _ = db.RightPush("list", "a", "b")
+
+    if size := db.Size(); size != 2 {
+        t.Errorf("Error database size: expected %d, got %d.\n", 2, size)
+    }
+    if removed := db.Flush(); removed != 2 {
+        t.Errorf("Error flushing database: expected %d keys removed, got %d.\n", 2, removed)
+    }
+    if size := db.Size(); size != 0 {
+        t.Errorf("Error database size: expected %d, got %d.\n", 0, size)
+    }
+    if _, keys := db.Scan(0, 10, "", ""); len(keys) != 0 {
+        t.Errorf("Error flushed database should not scan any key, got %v.\n", keys)
+    }
+}
+
+func TestDb_Watch(t *testing.T) {
+    db := NewDb()
+    db.Set("foo", "bar")
+
+    testCases := []struct {
+        name     string
+        key      string
+        modify   func()
+        modified bool
+    }{
+        {name: "untouched", key: "foo", modify: func() {}, modified: false},
+        {name: "set", key: "foo", modify: func() { db.Set("foo", "baz") }, modified: true},
+        {name: "other key", key: "foo", modify: func() { db.Set("other", "baz") }, modified: false},
+        {name: "delete", key: "foo", modify: func() { db.Delete("foo") }, modified: true},
+        {name: "delete missing", key: "foo", modify: func() { db.Delete("foo") }, modified: false},
+        {name: "create", key: "counter", modify: func() { _, _ = db.Increment("counter") }, modified: true},
+        {name: "push", key: "list", modify: func() { _, _ = db.RightPush("list", "a") }, modified: true},
+        {name: "flush", key: "list", modify: func() { db.Flush() }, modified: true},
+    }
+
+    for _, tc := range testCases {
+        version := db.Watch(tc.key)
+        tc.modify()
+        if modified := db.Version(tc.key) != version; modified != tc.modified {
+            t.Errorf("Error watching %s ( %s ): expected modified %t, got %t.\n", tc.key, tc.name, tc.modified, modified)
+        }
+        db.Unwatch(tc.key)
+    }
+
+    if len(db.watched) != 0 {
+        t.Errorf("Error unwatched keys should not be tracked anymore, got %d.\n", len(db.watched))
+    }
+}
+
+func TestDb_Expire(t *testing.T) {
+    db := NewDb()
+    db.Set("foo", "bar")
+    db.Set("past", "bar")
+
+    if db.Expire("missing", time.Now().Add(time.Hour)) {
+        t.Errorf("Error expiring a missing key should fail.\n")
+    }
+    if !db.Expire("foo", time.Now().Add(10*time.Millisecond)) {
+        t.Errorf("Error expiring foo should succeed.\n")
+    }
+    if !db.Expire("past", time.Now().Add(-time.Second)) || db.Exists("past") {
+        t.Errorf("Error a time in the past should delete the key immediately.\n")
+    }
+
+    if value, _ := db.Get("foo"); value != "bar" {
+        t.Errorf("Error foo expired too early, got %s.\n", value)
+    }
+    time.Sleep(20 * time.Millisecond)
+    if value, _ := db.Get("foo"); value != Nil {
+        t.Errorf("Error foo should have expired, got %s.\n", value)
+    }
+    if expired := db.ActiveExpire(); expired != 1 {
+        t.Errorf("Error active expire should delete 1 key, got %d.\n", expired)
+    }
+    if db.keys.len() != 0 {
+        t.Errorf("Error expired keys should be removed from the keyspace, got %d.\n", db.keys.len())
+    }
+
+    // A pass samples the volatile keys, passes eventually delete all the expired ones and only them.
+    for i := 0; i < 1000; i++ {
+        db.Set(strconv.Itoa(i), "bar")
+        db.Expire(strconv.Itoa(i), time.Now().Add(10*time.Millisecond))
+    }
+    for i := 0; i < 100; i++ {
+        db.Set("volatile"+strconv.Itoa(i), "bar")
+        db.Expire("volatile"+strconv.Itoa(i), time.Now().Add(time.Hour))
+    }
+    time.Sleep(20 * time.Millisecond)
+    if sampled, _ := db.expireSample(); sampled != activeExpireSample {
+        t.Errorf("Error active expire should sample %d keys, got %d.\n", activeExpireSample, sampled)
+    }
+    for i := 0; i < 10000 && db.Size() > 100; i++ {
+        db.ActiveExpire()
+    }
+    if size, stats := db.Size(), db.Stats(); size != 100 || stats.Expires != 100 || stats.ExpiredKeys != 1001 {
+        t.Errorf("Error active expire should delete the expired keys only, got %d keys ( %+v ).\n", size, stats)
+    }
+    db.Flush()
+
+    // Set discards the time to live of the key.
+    db.Set("foo", "bar")
+    db.Expire("foo", time.Now().Add(10*time.Millisecond))
+    db.Set("foo", "baz")
+    time.Sleep(20 * time.Millisecond)
+    if value, _ := db.Get("foo"); value != "baz" {
+        t.Errorf("Error set should discard the time to live, got %s.\n", value)
+    }
+}
+
+func TestDb_Stats(t *testing.T) {
+    db := NewDb()
+    db.Set("foo", "bar")
+    _, _ = db.RightPush("list", "a")
+    db.Set("volatile", "bar")
+    db.Expire("volatile", time.Now().Add(time.Hour))
+    db.Set("past", "bar")
+    db.Expire("past", time.Now().Add(10*time.Millisecond))
+
+    _, _ = db.Get("foo")
+    _, _ = db.LRange("list", 0, -1)
+    db.Exists("foo")
+    _, _ = db.Get("missing")
+    time.Sleep(20 * time.Millisecond)
+    // An expired key is a miss, and counted as expired once deleted.
+    _, _ = db.Get("past")
+    db.ActiveExpire()
+
+    stats := db.Stats()
+    if stats.Keys != 3 || stats.Expires != 1 || stats.KeyspaceHits != 3 || stats.KeyspaceMisses != 2 || stats.ExpiredKeys != 1 {
+        t.Errorf("Error database stats: got %+v.\n", stats)
+    }
+    if stats.AvgTTL < 59*time.Minute || stats.AvgTTL > time.Hour {
+        t.Errorf("Error average time to live: expected about an hour, got %v.\n", stats.AvgTTL)
+    }
+
+    db.ResetStats()
+    if stats = db.Stats(); stats.KeyspaceHits != 0 || stats.KeyspaceMisses != 0 || stats.ExpiredKeys != 0 || stats.Keys != 3 {
+        t.Errorf("Error resetting the database stats: got %+v.\n", stats)
+    }
+}
+
+func TestDb_Notify(t *testing.T) {
+    db := NewDb()
+    var events []string
+    db.notifier = func(event database.KeyspaceEvent) {
+        events = append(events, event.Event+":"+event.Key)
+    }
+
+    db.Set("foo", "bar")
+    db.Set("foo", "baz")
+    _, _ = db.Increment("counter")
+    _, _ = db.LeftPush("list", "a")
+    _, _ = db.RightPush("list", "b")
+    db.Expire("foo", time.Now().Add(-time.Second))
+    db.Delete("counter")
+    db.Set("temp", "bar")
+    db.Expire("temp", time.Now().Add(time.Millisecond))
+    time.Sleep(5 * time.Millisecond)
+    db.ActiveExpire()
+
+    expected := []string{
+        "new:foo", "set:foo", "set:foo", "new:counter", "incrby:counter", "new:list", "lpush:list", "rpush:list",
+        "del:foo", "del:counter", "new:temp", "set:temp", "expire:temp", "expired:temp",
+    }
+    if !slices.Equal(events, expected) {
+        t.Errorf("Error keyspace events: expected %v, got %v.\n", expected, events)
+    }
+
+    // The events of a handle are reported as caused by its client.
+    var clients []uint64
+    db.notifier = func(event database.KeyspaceEvent) {
+        clients = append(clients, event.Client)
+    }
+    db.WithOrigin(7).Set("foo", "bar")
+    db.Set("foo", "baz")
+    if expected := []uint64{7, 7, 0}; !slices.Equal(clients, expected) {
+        t.Errorf("Error keyspace event clients: expected %v, got %v.\n", expected, clients)
+    }
+}
@@ -0,0 +1,126 @@
+package inMemoryDatabase
+
+import (
+    "MyOwnRedis/internal/database"
+    "time"
+)
+
+// snapshotBatch is the number of keys read from a snapshot at once, under the lock of the database.
+const snapshotBatch = 1024
+
+// cowSnapshot is a copy-on-write snapshot of a Db: a consistent view of the database as it was when the snapshot was taken,
+// readable while the database keeps on being modified.
+// Taking it only lists the keys. A key is copied only when it is about to be modified, before the snapshot is released.
+type cowSnapshot struct {
+    // at is the time the snapshot was taken, keys expired by then aren't part of it.
+    at time.Time
+    // keys lists the keys of the database when the snapshot was taken.
+    keys []string
+    // expires is the number of keys having a time to live.
+    expires int
+    // preserved holds the keys modified since the snapshot was taken, as they were then. A nil entry is a key that didn't exist.
+    preserved map[string]*database.Entry
+    // frozen holds the storages of the database when it was flushed since the snapshot was taken, never modified again.
+    frozen *storages
+}
+
+// storages holds the data of a database.
+type storages struct {
+    stringStorage map[string]string
+    listStorage   map[string]*StrNode
+    expires       map[string]time.Time
+}
+
+// takeSnapshot starts a copy-on-write snapshot of the database. Callers must hold the lock.
+func (d *Db) takeSnapshot() *cowSnapshot {
+    snapshot := &cowSnapshot{
+        at:        time.Now(),
+        keys:      make([]string, 0, len(d.stringStorage)+len(d.listStorage)),
+        preserved: make(map[string]*database.Entry),
+    }
+    for key := range d.stringStorage {
+        snapshot.add(key, d.expires)
+    }
+    for key := range d.listStorage {
+        snapshot.add(key, d.expires)
+    }
+    d.snapshot = snapshot
+    return snapshot
+}
+
+// add lists key in the snapshot, unless it is expired.
+func (s *cowSnapshot) add(key string, expires map[string]time.Time) {
+    at, ok := expires[key]
+    if ok && !s.at.Before(at) {
+        return
+    }
+    if ok {
+        s.expires++
+    }
+    s.keys = append(s.keys, key)
+}
+
+// readSnapshot returns the keys of the snapshot in keys, as they were when it was taken.
+func (d *Db) readSnapshot(keys []string) []database.Entry {
+    d.RLock()
+    defer d.RUnlock()
+
+    snapshot := d.snapshot
+    entries := make([]database.Entry, 0, len(keys))
+    for _, key := range keys {
+        var entry *database.Entry
+        if preserved, ok := snapshot.preserved[key]; ok {
+            entry = preserved
+        } else if snapshot.frozen != nil {
+            entry = snapshot.frozen.entry(key)
+        } else {
+            // The key wasn't modified since the snapshot was taken.
+            entry = d.storages().entry(key)
+        }
+        if entry != nil {
+            entries = append(entries, *entry)
+        }
+    }
+    return entries
+}
+
+// releaseSnapshot ends the snapshot of the database, modifications are no longer tracked.
+func (d *Db) releaseSnapshot() {
+    d.Lock()
+    defer d.Unlock()
+    d.snapshot = nil
+}
+
+// preserve copies key to the snapshot in progress, if any and not done yet, as it is about to be modified. Callers must hold the lock.
+func (d *Db) preserve(key string) {
+    if d.snapshot == nil || d.snapshot.frozen != nil {
+        return
+    }
+    if _, ok := d.snapshot.preserved[key]; !ok {
+        d.snapshot.preserved[key] = d.storages().entry(key)
+    }
+}
+
+// freeze hands the storages of the database over to the snapshot in progress, if any, as they are about to be dropped.
+// Callers must hold the lock.
+func (d *Db) freeze() {
+    if d.snapshot != nil && d.snapshot.frozen == nil {
+        d.snapshot.frozen = d.storages()
+    }
+}
+
+// storages returns the storages of the database. Callers must hold the lock.
+func (d *Db) storages() *storages {
+    return &storages{stringStorage: d.stringStorage, listStorage: d.listStorage, expires: d.expires}
+}
+
+// entry returns a copy of key, nil if it doesn't exist.
+func (s *storages) entry(key string) *database.Entry {
+    if value, ok := s.stringStorage[key]; ok {
+        return &database.Entry{Key: key, Type: database.EntryString, String: value, ExpireAt: s.expires[key]}
+    }
+    if list, ok := s.listStorage[key]; ok {
+        return &database.Entry{Key: key, Type: database.EntryList, List: list.arr(), ExpireAt: s.expires[key]}
+    }
+    return nil
+}
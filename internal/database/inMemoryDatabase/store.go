@@ -0,0 +1,623 @@
+package inMemoryDatabase
+
+import (
+    "MyOwnRedis/internal/database"
+    "MyOwnRedis/internal/rdb"
+    "bufio"
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "hash/crc64"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "slices"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// DefaultDatabases is the number of logical databases a Store holds by default.
+const DefaultDatabases = 16
+
+var (
+    ErrDbIndexOutOfRange   = errors.New("error database index is out of range")
+    ErrTooManyDatabases    = errors.New("error dump file contains more databases than configured")
+    ErrPersistenceDisabled = errors.New("error persistence is disabled")
+    ErrInvalidDBFilename   = errors.New("error dbfilename can't be a path, just a filename")
+)
+
+// Format is the file format the databases are persisted in.
+type Format int
+
+const (
+    // FormatCSV saves the databases to dump.csv by default.
+    FormatCSV Format = iota
+    // FormatRDB saves the databases to dump.rdb by default, the format of Redis.
+    FormatRDB
+)
+
+var ErrInvalidFormat = errors.New("error dump format must be one of csv or rdb")
+
+// ParseFormat parses the name of a dump format.
+func ParseFormat(s string) (Format, error) {
+    switch strings.ToLower(s) {
+    case "csv":
+        return FormatCSV, nil
+    case "rdb":
+        return FormatRDB, nil
+    }
+    return 0, ErrInvalidFormat
+}
+
+// String returns the name of the format, as parsed by ParseFormat.
+func (f Format) String() string {
+    if f == FormatRDB {
+        return "rdb"
+    }
+    return "csv"
+}
+
+// DefaultDBFilename returns the name of the dump file used by default for format.
+func DefaultDBFilename(format Format) string {
+    if format == FormatRDB {
+        return "dump.rdb"
+    }
+    return "dump.csv"
+}
+
+// Config holds the settings of a Store.
+type Config struct {
+    // Databases is the number of logical databases, DefaultDatabases if zero.
+    Databases int
+    // Dir is the directory of the dump file, created if it doesn't exist. The current directory if empty.
+    Dir string
+    // DBFilename is the name of the dump file in Dir, DefaultDBFilename if empty.
+    DBFilename string
+    // Format is the file format of the dump.
+    Format Format
+    // InMemory disables persistence: no dump is loaded, and saving fails with ErrPersistenceDisabled.
+    InMemory bool
+    // LoadLegacyCSV allows loading CSV dumps written by previous versions, which have no version record
+    // and may have no checksum, so they can't be told from truncated dumps. They are refused as corrupt otherwise.
+    LoadLegacyCSV bool
+}
+
+// Store holds the numbered logical databases of the server and persists all of them together.
+type Store struct {
+    dbs    []*Db
+    format Format
+    // dir and dbfilename locate the dump file, they can be changed at runtime, see SetDir and SetDBFilename.
+    dir           string
+    dbfilename    string
+    inMemory      bool
+    loadLegacyCSV bool
+    // saveLock is held while a snapshot is being saved.
+    saveLock sync.Mutex
+    // The store lock guards the dbs slice itself, e.g. against SwapDb, and the location of the dump file,
+    // while each Db guards its own data.
+    sync.RWMutex
+}
+
+// New creates a Store as configured by config, loading the existing dump file if any.
+// An error is returned if the dump can't be loaded, e.g. when it is corrupt,
+// rather than starting with an empty dataset which would overwrite the dump on the next save.
+func New(config Config) (*Store, error) {
+    s, err := NewEmpty(config)
+    if err != nil || s.inMemory {
+        return s, err
+    }
+
+    path := s.path()
+    if s.format == FormatRDB {
+        err = s.loadRDB(path)
+    } else {
+        err = s.loadCSV(path)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("error loading %s: %w", path, err)
+    }
+    return s, nil
+}
+
+// NewEmpty creates a Store as configured by config, ignoring any existing dump file,
+// e.g. when the dataset is loaded from the append only file instead.
+func NewEmpty(config Config) (*Store, error) {
+    if config.Databases == 0 {
+        config.Databases = DefaultDatabases
+    }
+    if config.DBFilename == "" {
+        config.DBFilename = DefaultDBFilename(config.Format)
+    }
+    if filepath.Base(config.DBFilename) != config.DBFilename {
+        return nil, ErrInvalidDBFilename
+    }
+
+    s := newStore(config.Databases)
+    s.format = config.Format
+    s.dir, s.dbfilename, s.inMemory = config.Dir, config.DBFilename, config.InMemory
+    s.loadLegacyCSV = config.LoadLegacyCSV
+    if !s.inMemory && s.dir != "" {
+        if err := os.MkdirAll(s.dir, os.ModeDir|os.ModePerm); err != nil {
+            return nil, err
+        }
+    }
+    return s, nil
+}
+
+// newStore creates a Store of empty databases.
+func newStore(databases int) *Store {
+    s := &Store{dbs: make([]*Db, databases)}
+    for i := range s.dbs {
+        s.dbs[i] = NewDb()
+        s.dbs[i].id = i
+    }
+    return s
+}
+
+// Dir returns the directory of the dump file.
+func (s *Store) Dir() string {
+    s.RLock()
+    defer s.RUnlock()
+    return s.dir
+}
+
+// SetDir changes the directory the next dumps are saved to. It must be an existing directory.
+func (s *Store) SetDir(dir string) error {
+    info, err := os.Stat(dir)
+    if err != nil {
+        return err
+    }
+    if !info.IsDir() {
+        return fmt.Errorf("error %s is not a directory", dir)
+    }
+    s.Lock()
+    defer s.Unlock()
+    s.dir = dir
+    return nil
+}
+
+// DBFilename returns the name of the dump file.
+func (s *Store) DBFilename() string {
+    s.RLock()
+    defer s.RUnlock()
+    return s.dbfilename
+}
+
+// SetDBFilename changes the name of the file the next dumps are saved to.
+func (s *Store) SetDBFilename(name string) error {
+    if name == "" || filepath.Base(name) != name {
+        return ErrInvalidDBFilename
+    }
+    s.Lock()
+    defer s.Unlock()
+    s.dbfilename = name
+    return nil
+}
+
+// path returns the path of the dump file. Callers must hold the lock.
+func (s *Store) path() string {
+    return filepath.Join(s.dir, s.dbfilename)
+}
+
+// Db returns the database at index, nil if index is out of range.
+func (s *Store) Db(index int) database.MemDb {
+    s.RLock()
+    defer s.RUnlock()
+
+    if index < 0 || index >= len(s.dbs) {
+        return nil
+    }
+    return s.dbs[index]
+}
+
+// Len returns the number of databases.
+func (s *Store) Len() int {
+    return len(s.dbs)
+}
+
+// Move moves key from the database src to the database dst.
+// It returns false when the key doesn't exist in src or already exists in dst, in which case nothing is done.
+func (s *Store) Move(key string, src, dst int) (bool, error) {
+    s.RLock()
+    defer s.RUnlock()
+
+    if src < 0 || src >= len(s.dbs) || dst < 0 || dst >= len(s.dbs) {
+        return false, ErrDbIndexOutOfRange
+    }
+    if src == dst {
+        return false, nil
+    }
+
+    // Always lock the lower index first so concurrent moves can't deadlock.
+    from, to := s.dbs[src], s.dbs[dst]
+    if src < dst {
+        from.Lock()
+        to.Lock()
+    } else {
+        to.Lock()
+        from.Lock()
+    }
+    defer from.Unlock()
+    defer to.Unlock()
+
+    from.expireIfNeeded(key)
+    to.expireIfNeeded(key)
+    if to.typeOf(key) != KeyTypeNone {
+        return false, nil
+    }
+    to.preserve(key)
+
+    if value, ok := from.stringStorage[key]; ok {
+        to.stringStorage[key] = value
+    } else if list, ok := from.listStorage[key]; ok {
+        to.listStorage[key] = list
+    } else {
+        return false, nil
+    }
+    // The key keeps its time to live.
+    if at, ok := from.expires[key]; ok {
+        to.expires[key] = at
+    }
+    to.addKey(key)
+    to.touch(key)
+    from.removeKey(key)
+
+    from.notify(database.NotifyGeneric, "move_from", key)
+    to.notify(database.NotifyGeneric, "move_to", key)
+    return true, nil
+}
+
+// SwapDb swaps the databases at index i and j, so clients connected to one database will immediately see the data of the other.
+func (s *Store) SwapDb(i, j int) error {
+    s.Lock()
+    defer s.Unlock()
+
+    if i < 0 || i >= len(s.dbs) || j < 0 || j >= len(s.dbs) {
+        return ErrDbIndexOutOfRange
+    }
+    if i == j {
+        return nil
+    }
+
+    // Clients watching a key existing in either database would now see a different value.
+    a, b := s.dbs[i], s.dbs[j]
+    if i < j {
+        a.Lock()
+        b.Lock()
+    } else {
+        b.Lock()
+        a.Lock()
+    }
+    for _, pair := range [][2]*Db{{a, b}, {b, a}} {
+        db, other := pair[0], pair[1]
+        for key, w := range db.watched {
+            if db.typeOf(key) != KeyTypeNone || other.typeOf(key) != KeyTypeNone {
+                w.version++
+            }
+        }
+    }
+    // The databases keep reporting their new index in keyspace events.
+    a.id, b.id = b.id, a.id
+    a.Unlock()
+    b.Unlock()
+
+    s.dbs[i], s.dbs[j] = s.dbs[j], s.dbs[i]
+    return nil
+}
+
+// FlushAll removes all keys from every database and returns the number of keys removed.
+func (s *Store) FlushAll() int {
+    s.RLock()
+    defer s.RUnlock()
+
+    var removed int
+    for _, db := range s.dbs {
+        removed += db.Flush()
+    }
+    return removed
+}
+
+// ActiveExpire deletes expired keys of every database and returns the number of keys deleted.
+// The databases share the time budget of a pass, see Db.ActiveExpire.
+func (s *Store) ActiveExpire() int {
+    s.RLock()
+    defer s.RUnlock()
+
+    var expired int
+    deadline := time.Now().Add(activeExpireBudget)
+    for _, db := range s.dbs {
+        expired += db.activeExpire(deadline)
+    }
+    return expired
+}
+
+// SetNotifier sets the function receiving the keyspace events of every database.
+func (s *Store) SetNotifier(notifier database.Notifier) {
+    s.RLock()
+    defer s.RUnlock()
+
+    for _, db := range s.dbs {
+        db.Lock()
+        db.notifier = notifier
+        db.Unlock()
+    }
+}
+
+// Snapshot returns a copy of the keys of every database, indexed by database.
+func (s *Store) Snapshot() [][]database.Entry {
+    s.RLock()
+    defer s.RUnlock()
+
+    snapshot := make([][]database.Entry, len(s.dbs))
+    for i, db := range s.dbs {
+        db.RLock()
+        snapshot[i] = db.entries()
+        db.RUnlock()
+    }
+    return snapshot
+}
+
+// SaveDatabase persists the data of all databases to the dump file, in the format of the store.
+// The databases can be modified meanwhile, see BeginSave.
+func (s *Store) SaveDatabase() error {
+    save, err := s.BeginSave()
+    if err != nil {
+        return err
+    }
+    return save()
+}
+
+// BeginSave takes a copy-on-write snapshot of all the databases and returns the function persisting it, e.g. in the background:
+// the databases can be modified meanwhile without affecting the snapshot, and without waiting for it to be written.
+// Only one snapshot is taken at a time, BeginSave waits for the previous one to be persisted. The returned function must be called once.
+// ErrPersistenceDisabled is returned if the store is in memory only.
+func (s *Store) BeginSave() (func() error, error) {
+    if s.inMemory {
+        return nil, ErrPersistenceDisabled
+    }
+    s.saveLock.Lock()
+
+    // Taking the snapshots of every database at once gives a consistent view of the whole store, e.g. of a key being moved.
+    s.RLock()
+    path := s.path()
+    dbs := slices.Clone(s.dbs)
+    for _, db := range dbs {
+        db.Lock()
+    }
+    snapshots := make([]*cowSnapshot, len(dbs))
+    for i, db := range dbs {
+        snapshots[i] = db.takeSnapshot()
+    }
+    for _, db := range dbs {
+        db.Unlock()
+    }
+    s.RUnlock()
+
+    return func() error {
+        defer s.saveLock.Unlock()
+        defer func() {
+            for _, db := range dbs {
+                db.releaseSnapshot()
+            }
+        }()
+
+        if s.format == FormatRDB {
+            return saveRDB(path, dbs, snapshots)
+        }
+        return saveCSV(path, dbs, snapshots)
+    }, nil
+}
+
+// eachSnapshotBatch calls fn with the keys of the snapshots of dbs, in batches, along with the index of their database.
+func eachSnapshotBatch(dbs []*Db, snapshots []*cowSnapshot, fn func(index int, entries []database.Entry) error) error {
+    for i, snapshot := range snapshots {
+        for start := 0; start < len(snapshot.keys); start += snapshotBatch {
+            end := min(start+snapshotBatch, len(snapshot.keys))
+            if err := fn(i, dbs[i].readSnapshot(snapshot.keys[start:end])); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// saveCSV persists the snapshots of dbs to path in CSV.
+// The dump starts with a `<Version>,version` record, the records of each non-empty database are preceded by a `<Select>,index` record,
+// the record of a key having a time to live is followed by an `<ExpireAt>` record, and the dump ends with a `<Checksum>,crc` record.
+func saveCSV(path string, dbs []*Db, snapshots []*cowSnapshot) error {
+    return writeFileAtomic(path, func(file io.Writer) error {
+        crc := crc64.New(crcTable)
+        w := csv.NewWriter(io.MultiWriter(file, crc))
+        if err := w.Write(versionRecord()); err != nil {
+            return err
+        }
+
+        selected := -1
+        err := eachSnapshotBatch(dbs, snapshots, func(index int, entries []database.Entry) error {
+            if index != selected {
+                selected = index
+                if err := w.Write([]string{TypeSelect, strconv.Itoa(index)}); err != nil {
+                    return err
+                }
+            }
+            for _, entry := range entries {
+                record := []string{TypeString, entry.Key, entry.String}
+                if entry.Type == database.EntryList {
+                    record = append([]string{TypeList, entry.Key}, entry.List...)
+                }
+                if err := w.Write(record); err != nil {
+                    return err
+                }
+                if !entry.ExpireAt.IsZero() {
+                    if err := w.Write([]string{TypeExpireAt, entry.Key, strconv.FormatInt(entry.ExpireAt.UnixMilli(), 10)}); err != nil {
+                        return err
+                    }
+                }
+            }
+            return nil
+        })
+        if err != nil {
+            return err
+        }
+
+        // The checksum covers every record but itself.
+        w.Flush()
+        if err = w.Error(); err != nil {
+            return err
+        }
+        w = csv.NewWriter(file)
+        if err = w.Write(checksumRecord(crc.Sum64())); err != nil {
+            return err
+        }
+        w.Flush()
+        return w.Error()
+    })
+}
+
+// loadCSV loads the CSV dump at path into the databases, verifying its checksum first. A missing file is an empty dump.
+// Records that come before any `<Select>` record belong to database 0, which keeps dumps of a single database readable.
+func (s *Store) loadCSV(path string) error {
+    // Read from the dump and store to the databases.
+    file, err := os.Open(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    versioned, err := isVersioned(file)
+    if err != nil {
+        return err
+    }
+    if !versioned && !s.loadLegacyCSV {
+        return fmt.Errorf("%w: missing version record, legacy dumps are loaded only with csv-load-legacy", ErrCorruptDump)
+    }
+    if err = verifyChecksum(file, !versioned); err != nil {
+        return err
+    }
+
+    // Create csv reader.
+    r := csv.NewReader(bufio.NewReader(file))
+
+    // Disable record length test in the CSV reader.
+    r.FieldsPerRecord = -1
+    db := s.dbs[0]
+    for {
+        record, err := r.Read()
+        if errors.Is(err, io.EOF) {
+            return nil
+        }
+        if err != nil {
+            return fmt.Errorf("%w: %v", ErrCorruptDump, err)
+        }
+        line, _ := r.FieldPos(0)
+
+        switch {
+        case record[0] == TypeVersion && line == 1:
+            // Already checked.
+        case record[0] == TypeSelect && len(record) == 2:
+            index, err := strconv.Atoi(record[1])
+            if err != nil {
+                return fmt.Errorf("%w: line %d: invalid database index %q", ErrCorruptDump, line, record[1])
+            }
+            if index < 0 || index >= len(s.dbs) {
+                return fmt.Errorf("%w: database %d, configured %d", ErrTooManyDatabases, index, len(s.dbs))
+            }
+            db = s.dbs[index]
+        case record[0] == TypeString && len(record) == 3:
+            db.Set(record[1], record[2])
+        case record[0] == TypeList && len(record) >= 3:
+            _, _ = db.RightPush(record[1], record[2:]...)
+        case record[0] == TypeExpireAt && len(record) == 3:
+            at, err := strconv.ParseInt(record[2], 10, 64)
+            if err != nil {
+                return fmt.Errorf("%w: line %d: invalid expire time %q", ErrCorruptDump, line, record[2])
+            }
+            // Keys that expired since the dump was written are deleted.
+            if !db.Expire(record[1], time.UnixMilli(at)) {
+                return fmt.Errorf("%w: line %d: expire time of a missing key %q", ErrCorruptDump, line, record[1])
+            }
+        case record[0] == TypeChecksum:
+            // Already verified, it must be the last record.
+            if _, err = r.Read(); !errors.Is(err, io.EOF) {
+                return fmt.Errorf("%w: line %d: checksum record before the end of the dump", ErrCorruptDump, line)
+            }
+            return nil
+        default:
+            return fmt.Errorf("%w: line %d: invalid record %q", ErrCorruptDump, line, record[0])
+        }
+    }
+}
+
+// saveRDB persists the snapshots of dbs to path in RDB.
+func saveRDB(path string, dbs []*Db, snapshots []*cowSnapshot) error {
+    return writeFileAtomic(path, func(file io.Writer) error {
+        e := rdb.NewEncoder(file)
+        selected := -1
+        err := eachSnapshotBatch(dbs, snapshots, func(index int, entries []database.Entry) error {
+            if index != selected {
+                selected = index
+                e.SelectDb(index, len(snapshots[index].keys), snapshots[index].expires)
+            }
+            for _, entry := range entries {
+                e.WriteEntry(entry)
+            }
+            return nil
+        })
+        if err != nil {
+            return err
+        }
+        return e.Close()
+    })
+}
+
+// loadRDB loads the RDB dump at path into the databases, it may have been written by Redis. A missing file is an empty dump.
+func (s *Store) loadRDB(path string) error {
+    file, err := os.Open(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    result, err := rdb.Decode(bufio.NewReader(file), s.restore)
+    if err != nil {
+        return err
+    }
+    if result.Skipped > 0 {
+        log.Printf("RRedis skipped %d keys of unsupported types loading %s", result.Skipped, path)
+    }
+    return nil
+}
+
+// restore adds a key read from a dump to the database at index.
+func (s *Store) restore(index int, entry database.Entry) error {
+    if index < 0 || index >= len(s.dbs) {
+        return fmt.Errorf("%w: database %d, configured %d", ErrTooManyDatabases, index, len(s.dbs))
+    }
+    // Keys that expired since the dump was written are dropped.
+    if !entry.ExpireAt.IsZero() && !entry.ExpireAt.After(time.Now()) {
+        return nil
+    }
+
+    db := s.dbs[index]
+    switch entry.Type {
+    case database.EntryString:
+        db.Set(entry.Key, entry.String)
+    case database.EntryList:
+        if _, err := db.RightPush(entry.Key, entry.List...); err != nil {
+            return err
+        }
+    }
+    if !entry.ExpireAt.IsZero() {
+        db.Expire(entry.Key, entry.ExpireAt)
+    }
+    return nil
+}
@@ -0,0 +1,437 @@
+package inMemoryDatabase
+
+import (
+    "MyOwnRedis/internal/database"
+    "errors"
+    "fmt"
+    "hash/crc64"
+    "io"
+    "os"
+    "path/filepath"
+    "reflect"
+    "slices"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestStore_Db(t *testing.T) {
+    s := newStore(4)
+    if s.Len() != 4 {
+        t.Errorf("Error store length: expected %d, got %d.\n", 4, s.Len())
+    }
+
+    for _, index := range []int{-1, 4} {
+        if db := s.Db(index); db != nil {
+            t.Errorf("Error database %d should be out of range, got %#v.\n", index, db)
+        }
+    }
+
+    s.Db(1).Set("foo", "bar")
+    if s.Db(0).Exists("foo") {
+        t.Errorf("Error databases should be isolated: key foo found in database 0.\n")
+    }
+    if !s.Db(1).Exists("foo") {
+        t.Errorf("Error key foo not found in database 1.\n")
+    }
+}
+
+func TestStore_Move(t *testing.T) {
+    s := newStore(2)
+    s.Db(0).Set("foo", "bar")
+    _, _ = s.Db(0).RightPush("list", "a", "b")
+    s.Db(0).Set("taken", "0")
+    s.Db(1).Set("taken", "1")
+
+    testCases := []struct {
+        key      string
+        src, dst int
+        moved    bool
+        err      error
+    }{
+        {key: "foo", src: 0, dst: 1, moved: true},
+        {key: "list", src: 0, dst: 1, moved: true},
+        {key: "missing", src: 0, dst: 1, moved: false},
+        {key: "taken", src: 0, dst: 1, moved: false},
+        {key: "foo", src: 1, dst: 1, moved: false},
+        {key: "foo", src: 1, dst: 2, moved: false, err: ErrDbIndexOutOfRange},
+    }
+
+    for _, tc := range testCases {
+        moved, err := s.Move(tc.key, tc.src, tc.dst)
+        if !errors.Is(err, tc.err) {
+            t.Errorf("Error moving %s: expected error %v, got %v.\n", tc.key, tc.err, err)
+        }
+        if moved != tc.moved {
+            t.Errorf("Error moving %s from %d to %d: expected %t, got %t.\n", tc.key, tc.src, tc.dst, tc.moved, moved)
+        }
+    }
+
+    if s.Db(0).Exists("foo") || s.Db(0).Exists("list") {
+        t.Errorf("Error moved keys should be removed from the source database.\n")
+    }
+    if value, _ := s.Db(1).Get("foo"); value != "bar" {
+        t.Errorf("Error moved value: expected %s, got %s.\n", "bar", value)
+    }
+    if list, _ := s.Db(1).LRange("list", 0, -1); len(list) != 2 {
+        t.Errorf("Error moved list: expected %d elements, got %v.\n", 2, list)
+    }
+    if value, _ := s.Db(1).Get("taken"); value != "1" {
+        t.Errorf("Error existing key in destination should be kept: expected %s, got %s.\n", "1", value)
+    }
+}
+
+func TestStore_SwapDb(t *testing.T) {
+    s := newStore(2)
+    s.Db(0).Set("foo", "0")
+    s.Db(1).Set("bar", "1")
+
+    if err := s.SwapDb(0, 1); err != nil {
+        t.Errorf("Error swapping databases: %v.\n", err)
+    }
+    if !s.Db(0).Exists("bar") || !s.Db(1).Exists("foo") {
+        t.Errorf("Error swapped databases should exchange their data.\n")
+    }
+    if err := s.SwapDb(0, 2); !errors.Is(err, ErrDbIndexOutOfRange) {
+        t.Errorf("Error incorrect error: expected %v, got %v.\n", ErrDbIndexOutOfRange, err)
+    }
+}
+
+func TestStore_FlushAll(t *testing.T) {
+    s := newStore(3)
+    s.Db(0).Set("foo", "bar")
+    _, _ = s.Db(2).LeftPush("list", "a")
+
+    if removed := s.FlushAll(); removed != 2 {
+        t.Errorf("Error flushing all databases: expected %d keys removed, got %d.\n", 2, removed)
+    }
+    for i := 0; i < s.Len(); i++ {
+        if size := s.Db(i).Size(); size != 0 {
+            t.Errorf("Error database %d should be empty, got %d keys.\n", i, size)
+        }
+    }
+}
+
+func TestStore_Snapshot(t *testing.T) {
+    s := newStore(2)
+    s.Db(0).Set("foo", "bar")
+    at := time.Now().Add(time.Hour)
+    s.Db(0).Expire("foo", at)
+    s.Db(0).Set("gone", "bar")
+    s.Db(0).Expire("gone", time.Now().Add(-time.Second))
+    _, _ = s.Db(1).RightPush("list", "a", "b")
+
+    snapshot := s.Snapshot()
+    // The snapshot is a copy: later writes don't change it.
+    _, _ = s.Db(1).RightPush("list", "c")
+
+    expected := [][]database.Entry{
+        {{Key: "foo", Type: database.EntryString, String: "bar", ExpireAt: at}},
+        {{Key: "list", Type: database.EntryList, List: []string{"a", "b"}}},
+    }
+    if !reflect.DeepEqual(snapshot, expected) {
+        t.Errorf("Error snapshot: expected %+v, got %+v.\n", expected, snapshot)
+    }
+}
+
+// newTestStore creates an empty store as configured by config, saving to a temporary directory.
+func newTestStore(t *testing.T, config Config) *Store {
+    t.Helper()
+    if config.Dir == "" {
+        config.Dir = t.TempDir()
+    }
+    s, err := NewEmpty(config)
+    if err != nil {
+        t.Fatalf("Error creating the store: %v.\n", err)
+    }
+    return s
+}
+
+func TestStore_SaveDatabase(t *testing.T) {
+    s := newTestStore(t, Config{Databases: 4})
+    s.Db(0).Set("foo", "bar")
+    s.Db(3).Set("x", "1")
+    _, _ = s.Db(3).RightPush("list", "a", "b")
+    s.Db(0).Set("temp", "1")
+    _, _ = s.Db(3).RightPush("templist", "a")
+    at := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+    s.Db(0).Expire("temp", at)
+    s.Db(3).Expire("templist", at)
+    if err := s.SaveDatabase(); err != nil {
+        t.Fatalf("Error saving databases: %v.\n", err)
+    }
+
+    loaded, err := New(Config{Databases: 4, Dir: s.Dir()})
+    if err != nil {
+        t.Fatalf("Error loading databases: %v.\n", err)
+    }
+    if expected, got := sortedSnapshot(s), sortedSnapshot(loaded); !reflect.DeepEqual(got, expected) {
+        t.Errorf("Error loaded databases: expected %v, got %v.\n", expected, got)
+    }
+    if value, _ := loaded.Db(0).Get("foo"); value != "bar" {
+        t.Errorf("Error loaded value in database 0: expected %s, got %s.\n", "bar", value)
+    }
+    if value, _ := loaded.Db(3).Get("x"); value != "1" {
+        t.Errorf("Error loaded value in database 3: expected %s, got %s.\n", "1", value)
+    }
+    if list, _ := loaded.Db(3).LRange("list", 0, -1); len(list) != 2 || list[0] != "a" || list[1] != "b" {
+        t.Errorf("Error loaded list in database 3: expected %v, got %v.\n", []string{"a", "b"}, list)
+    }
+    if loaded.Db(1).Size() != 0 || loaded.Db(2).Size() != 0 {
+        t.Errorf("Error databases 1 and 2 should be empty.\n")
+    }
+
+    if _, err = New(Config{Databases: 2, Dir: s.Dir()}); !errors.Is(err, ErrTooManyDatabases) {
+        t.Errorf("Error incorrect error: expected %v, got %v.\n", ErrTooManyDatabases, err)
+    }
+}
+
+func TestStore_LoadCorruptDump(t *testing.T) {
+    s := newTestStore(t, Config{Databases: 1})
+    s.Db(0).Set("foo", "bar")
+    if err := s.SaveDatabase(); err != nil {
+        t.Fatalf("Error saving databases: %v.\n", err)
+    }
+    path := filepath.Join(s.Dir(), DefaultDBFilename(FormatCSV))
+    dump, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if temps, _ := filepath.Glob(filepath.Join(s.Dir(), "temp-*")); len(temps) != 0 {
+        t.Errorf("Error the temporary dump should have been renamed, got %v.\n", temps)
+    }
+
+    checksum := string(dump[strings.Index(string(dump), TypeChecksum):])
+
+    testCases := []struct {
+        name   string
+        dump   string
+        legacy bool
+        err    error
+    }{
+        {name: "valid", dump: string(dump)},
+        {name: "previous version", dump: "<String>,foo,bar\n", legacy: true},
+        {name: "previous version not allowed", dump: "<String>,foo,bar\n", err: ErrCorruptDump},
+        {name: "empty", dump: "", err: ErrCorruptDump},
+        {name: "truncated", dump: string(dump[:len(dump)-len(checksum)]), err: ErrCorruptDump},
+        {name: "truncated previous version", dump: string(dump[:len(dump)-len(checksum)]), legacy: true, err: ErrCorruptDump},
+        {name: "checksum before the end", dump: string(dump) + fmt.Sprintf("%s,%016x\n", TypeChecksum, crc64.Checksum(dump, crcTable)), err: ErrCorruptDump},
+        {name: "bad checksum", dump: strings.Replace(string(dump), "bar", "baz", 1), err: ErrBadChecksum},
+        {name: "truncated record", dump: "<String>,foo\n", legacy: true, err: ErrCorruptDump},
+        {name: "unknown record", dump: "<Hash>,foo,bar\n", legacy: true, err: ErrCorruptDump},
+        {name: "bad quotes", dump: "<String>,\"foo,bar\n", legacy: true, err: ErrCorruptDump},
+        {name: "expired", dump: "<String>,foo,bar\n<String>,old,1\n<ExpireAt>,old,1\n", legacy: true},
+        {name: "invalid expire time", dump: "<String>,foo,bar\n<ExpireAt>,foo,soon\n", legacy: true, err: ErrCorruptDump},
+        {name: "expire time of a missing key", dump: "<ExpireAt>,foo,1\n", legacy: true, err: ErrCorruptDump},
+    }
+    for _, tc := range testCases {
+        t.Run(tc.name, func(t *testing.T) {
+            if err := os.WriteFile(path, []byte(tc.dump), 0644); err != nil {
+                t.Fatal(err)
+            }
+            loaded, err := New(Config{Databases: 1, Dir: s.Dir(), LoadLegacyCSV: tc.legacy})
+            if !errors.Is(err, tc.err) {
+                t.Fatalf("Error loading databases: expected %v, got %v.\n", tc.err, err)
+            }
+            if err == nil {
+                if value, _ := loaded.Db(0).Get("foo"); value != "bar" {
+                    t.Errorf("Error loaded value: expected %s, got %s.\n", "bar", value)
+                }
+                if loaded.Db(0).Exists("old") {
+                    t.Errorf("Error expired keys shouldn't be loaded.\n")
+                }
+            }
+        })
+    }
+}
+
+func TestStore_Config(t *testing.T) {
+    dir := t.TempDir()
+    s := newTestStore(t, Config{Dir: filepath.Join(dir, "data"), DBFilename: "first.rdb", Format: FormatRDB})
+    if s.Len() != DefaultDatabases {
+        t.Errorf("Error store length: expected %d, got %d.\n", DefaultDatabases, s.Len())
+    }
+    s.Db(0).Set("foo", "bar")
+    if err := s.SaveDatabase(); err != nil {
+        t.Fatalf("Error saving databases: %v.\n", err)
+    }
+    if _, err := os.Stat(filepath.Join(dir, "data", "first.rdb")); err != nil {
+        t.Errorf("Error the dump should be saved to the configured path: %v.\n", err)
+    }
+
+    // The next dumps are saved to the new location.
+    if err := s.SetDir(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("Error setting a missing dir: expected %v, got %v.\n", os.ErrNotExist, err)
+    }
+    if err := s.SetDBFilename("../second.rdb"); !errors.Is(err, ErrInvalidDBFilename) {
+        t.Errorf("Error setting a path as dbfilename: expected %v, got %v.\n", ErrInvalidDBFilename, err)
+    }
+    if err := s.SetDir(dir); err != nil {
+        t.Fatalf("Error setting dir: %v.\n", err)
+    }
+    if err := s.SetDBFilename("second.rdb"); err != nil {
+        t.Fatalf("Error setting dbfilename: %v.\n", err)
+    }
+    if err := s.SaveDatabase(); err != nil {
+        t.Fatalf("Error saving databases: %v.\n", err)
+    }
+    loaded, err := New(Config{Dir: dir, DBFilename: "second.rdb", Format: FormatRDB})
+    if err != nil {
+        t.Fatalf("Error loading databases: %v.\n", err)
+    }
+    if value, _ := loaded.Db(0).Get("foo"); value != "bar" {
+        t.Errorf("Error loaded value: expected %s, got %s.\n", "bar", value)
+    }
+
+    // A store in memory only never touches the disk.
+    memory, err := New(Config{Dir: filepath.Join(dir, "memory"), InMemory: true})
+    if err != nil {
+        t.Fatalf("Error creating the store: %v.\n", err)
+    }
+    if err = memory.SaveDatabase(); !errors.Is(err, ErrPersistenceDisabled) {
+        t.Errorf("Error saving in memory: expected %v, got %v.\n", ErrPersistenceDisabled, err)
+    }
+    if _, err = os.Stat(filepath.Join(dir, "memory")); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("Error the dir of a store in memory should not be created, got %v.\n", err)
+    }
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "dump.csv")
+    if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    // A failed save leaves the previous dump untouched.
+    errWrite := errors.New("disk full")
+    err := writeFileAtomic(path, func(w io.Writer) error {
+        _, _ = w.Write([]byte("partial"))
+        return errWrite
+    })
+    if !errors.Is(err, errWrite) {
+        t.Errorf("Error writing: expected %v, got %v.\n", errWrite, err)
+    }
+    if data, _ := os.ReadFile(path); string(data) != "old" {
+        t.Errorf("Error the dump should be untouched: expected %q, got %q.\n", "old", data)
+    }
+    if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+        t.Errorf("Error the temporary dump should have been removed, got %v.\n", entries)
+    }
+
+    if err = writeFileAtomic(path, func(w io.Writer) error {
+        _, err := w.Write([]byte("new"))
+        return err
+    }); err != nil {
+        t.Fatalf("Error writing: %v.\n", err)
+    }
+    if data, _ := os.ReadFile(path); string(data) != "new" {
+        t.Errorf("Error the dump should be replaced: expected %q, got %q.\n", "new", data)
+    }
+}
+
+func TestStore_SaveRDB(t *testing.T) {
+    s := newTestStore(t, Config{Databases: 4, Format: FormatRDB})
+    s.Db(0).Set("foo", "bar")
+    s.Db(0).Set("temp", "1")
+    at := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+    s.Db(0).Expire("temp", at)
+    _, _ = s.Db(3).RightPush("list", "a", "b")
+    if err := s.SaveDatabase(); err != nil {
+        t.Fatalf("Error saving databases: %v.\n", err)
+    }
+
+    loaded, err := New(Config{Databases: 4, Dir: s.Dir(), Format: FormatRDB})
+    if err != nil {
+        t.Fatalf("Error loading databases: %v.\n", err)
+    }
+    if expected, got := sortedSnapshot(s), sortedSnapshot(loaded); !reflect.DeepEqual(got, expected) {
+        t.Errorf("Error loaded databases: expected %v, got %v.\n", expected, got)
+    }
+    if _, err = New(Config{Databases: 2, Dir: s.Dir(), Format: FormatRDB}); !errors.Is(err, ErrTooManyDatabases) {
+        t.Errorf("Error incorrect error: expected %v, got %v.\n", ErrTooManyDatabases, err)
+    }
+}
+
+// sortedSnapshot returns the snapshot of s with the keys of each database sorted, as their order is unspecified.
+func sortedSnapshot(s *Store) [][]database.Entry {
+    snapshot := s.Snapshot()
+    for _, entries := range snapshot {
+        slices.SortFunc(entries, func(a, b database.Entry) int { return strings.Compare(a.Key, b.Key) })
+    }
+    return snapshot
+}
+
+func TestStore_BeginSave(t *testing.T) {
+    s := newTestStore(t, Config{Databases: 3, Format: FormatRDB})
+    s.Db(0).Set("foo", "bar")
+    s.Db(0).Set("deleted", "1")
+    s.Db(0).Set("moved", "1")
+    s.Db(0).Set("temp", "1")
+    s.Db(0).Expire("temp", time.Now().Add(time.Hour))
+    _, _ = s.Db(1).RightPush("list", "a", "b")
+    s.Db(2).Set("flushed", "1")
+    expected := sortedSnapshot(s)
+
+    save, err := s.BeginSave()
+    if err != nil {
+        t.Fatalf("Error beginning the save: %v.\n", err)
+    }
+    // Modifications made while saving don't show in the dump.
+    s.Db(0).Set("foo", "baz")
+    s.Db(0).Delete("deleted")
+    _, _ = s.Db(0).Increment("new")
+    _, _ = s.Move("moved", 0, 1)
+    s.Db(0).Expire("temp", time.Now().Add(-time.Second))
+    _, _ = s.Db(1).RightPush("list", "c")
+    _, _ = s.Db(1).LeftPush("list", "z")
+    s.Db(2).Flush()
+    s.Db(2).Set("flushed", "2")
+    if err := save(); err != nil {
+        t.Fatalf("Error saving databases: %v.\n", err)
+    }
+
+    loaded, err := New(Config{Databases: 3, Dir: s.Dir(), Format: FormatRDB})
+    if err != nil {
+        t.Fatalf("Error loading databases: %v.\n", err)
+    }
+    snapshot := sortedSnapshot(loaded)
+    for _, entries := range append(snapshot, expected...) {
+        for i := range entries {
+            // The dump has a precision of a millisecond.
+            entries[i].ExpireAt = entries[i].ExpireAt.Truncate(time.Millisecond)
+        }
+    }
+    if !reflect.DeepEqual(snapshot, expected) {
+        t.Errorf("Error saved databases: expected %v, got %v.\n", expected, snapshot)
+    }
+
+    // Modifications are no longer tracked once saved.
+    s.Db(0).Set("after", "1")
+    if s.dbs[0].snapshot != nil {
+        t.Errorf("Error the snapshot should have been released.\n")
+    }
+}
+
+func TestStore_WatchAcrossDatabases(t *testing.T) {
+    s := newStore(2)
+    s.Db(1).Set("foo", "bar")
+
+    // The key doesn't exist in database 0 but exists in database 1, swapping makes it appear.
+    db := s.Db(0)
+    version := db.Watch("foo")
+    if err := s.SwapDb(0, 1); err != nil {
+        t.Fatalf("Error swapping databases: %v.\n", err)
+    }
+    if db.Version("foo") == version {
+        t.Errorf("Error swapping databases should modify the watched key.\n")
+    }
+
+    // Moving a key modifies it in both databases.
+    version = db.Watch("foo")
+    if moved, _ := s.Move("foo", 0, 1); !moved {
+        t.Fatalf("Error key foo should be moved.\n")
+    }
+    if db.Version("foo") == version {
+        t.Errorf("Error moving a key should modify the watched key.\n")
+    }
+}
@@ -0,0 +1,22 @@
+package database
+
+import "time"
+
+// EntryType is the type of the value of an Entry.
+type EntryType int
+
+const (
+    EntryString EntryType = iota
+    EntryList
+)
+
+// Entry is a key along with its value, as captured by a snapshot of a database.
+type Entry struct {
+    Key  string
+    Type EntryType
+    // String holds the value of a string key, List the elements of a list key.
+    String string
+    List   []string
+    // ExpireAt is the time the key expires at, zero if it has no time to live.
+    ExpireAt time.Time
+}
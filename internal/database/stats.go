@@ -0,0 +1,17 @@
+package database
+
+import "time"
+
+// Stats are the statistics of a database, reported by INFO.
+type Stats struct {
+    // Keys is the number of keys, Expires the number of keys having a time to live.
+    Keys    int
+    Expires int
+    // AvgTTL is the average time to live of the keys having one, as of the last active expire cycle.
+    AvgTTL time.Duration
+    // KeyspaceHits and KeyspaceMisses count the lookups of read commands that found the key, or didn't.
+    KeyspaceHits   int64
+    KeyspaceMisses int64
+    // ExpiredKeys counts the keys deleted once their time to live elapsed.
+    ExpiredKeys int64
+}
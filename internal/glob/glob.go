@@ -0,0 +1,147 @@
+// Package glob implements the glob-style pattern matching used by Redis commands such as KEYS, SCAN MATCH and PSUBSCRIBE.
+package glob
+
+// Match reports whether str matches the glob-style pattern.
+// It follows the semantics of Redis's stringmatchlen:
+//   - `*` matches any sequence of characters, including the empty one.
+//   - `?` matches exactly one character.
+//   - `[abc]`, `[^abc]` and `[a-z]` match one character from ( or not from ) a set.
+//   - `\x` matches the character x literally.
+//
+// When noCase is true the comparison is case-insensitive for ASCII letters.
+func Match(pattern, str string, noCase bool) bool {
+    skipLongerMatches := false
+    return match([]byte(pattern), []byte(str), noCase, &skipLongerMatches, 0)
+}
+
+// maxNesting limits the recursion caused by consecutive `*` so a malicious pattern can't exhaust the stack.
+const maxNesting = 1000
+
+// match is the recursive worker of Match.
+// skipLongerMatches is set once a `*` failed to match the rest of the string: any longer match attempted
+// by an outer `*` is bound to fail as well, so the search can stop early.
+func match(pattern, str []byte, noCase bool, skipLongerMatches *bool, nesting int) bool {
+    if nesting > maxNesting {
+        return false
+    }
+
+    for len(pattern) > 0 && len(str) > 0 {
+        switch pattern[0] {
+        case '*':
+            // Collapse consecutive stars.
+            for len(pattern) > 1 && pattern[1] == '*' {
+                pattern = pattern[1:]
+            }
+            if len(pattern) == 1 {
+                return true // Trailing star matches everything.
+            }
+            for len(str) > 0 {
+                if match(pattern[1:], str, noCase, skipLongerMatches, nesting+1) {
+                    return true
+                }
+                if *skipLongerMatches {
+                    return false
+                }
+                str = str[1:]
+            }
+            *skipLongerMatches = true
+            return false
+
+        case '?':
+            str = str[1:]
+
+        case '[':
+            pattern = pattern[1:]
+            not := len(pattern) > 0 && pattern[0] == '^'
+            if not {
+                pattern = pattern[1:]
+            }
+
+            matched := false
+            for {
+                if len(pattern) == 0 {
+                    // Unterminated class, treat the end of pattern as the closing bracket.
+                    break
+                }
+                if pattern[0] == '\\' && len(pattern) >= 2 {
+                    pattern = pattern[1:]
+                    if equal(pattern[0], str[0], noCase) {
+                        matched = true
+                    }
+                } else if pattern[0] == ']' {
+                    break
+                } else if len(pattern) >= 3 && pattern[1] == '-' {
+                    start, end := pattern[0], pattern[2]
+                    if start > end {
+                        start, end = end, start
+                    }
+                    c := str[0]
+                    if noCase {
+                        start, end, c = lower(start), lower(end), lower(c)
+                    }
+                    pattern = pattern[2:]
+                    if c >= start && c <= end {
+                        matched = true
+                    }
+                } else if equal(pattern[0], str[0], noCase) {
+                    matched = true
+                }
+                pattern = pattern[1:]
+            }
+            if len(pattern) == 0 {
+                // Keep the pattern pointing at the last character so the advance below ends the pattern.
+                pattern = []byte{']'}
+            }
+
+            if not {
+                matched = !matched
+            }
+            if !matched {
+                return false
+            }
+            str = str[1:]
+
+        case '\\':
+            if len(pattern) >= 2 {
+                pattern = pattern[1:]
+            }
+            if !equal(pattern[0], str[0], noCase) {
+                return false
+            }
+            str = str[1:]
+
+        default:
+            if !equal(pattern[0], str[0], noCase) {
+                return false
+            }
+            str = str[1:]
+        }
+
+        pattern = pattern[1:]
+    }
+
+    if len(str) == 0 {
+        // Only trailing stars may remain in the pattern.
+        for len(pattern) > 0 && pattern[0] == '*' {
+            pattern = pattern[1:]
+        }
+    }
+
+    return len(pattern) == 0 && len(str) == 0
+}
+
+// equal compares two bytes, optionally ignoring ASCII case.
+func equal(a, b byte, noCase bool) bool {
+    if noCase {
+        return lower(a) == lower(b)
+    }
+    return a == b
+}
+
+// lower converts an ASCII upper-case letter to lower case.
+func lower(c byte) byte {
+    if c >= 'A' && c <= 'Z' {
+        return c + ('a' - 'A')
+    }
+    return c
+}
@@ -0,0 +1,54 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+    testCases := []struct {
+        pattern string
+        str     string
+        noCase  bool
+        matched bool
+    }{
+        {pattern: "*", str: "anything", matched: true},
+        {pattern: "*", str: "", matched: true},
+        {pattern: "h?llo", str: "hello", matched: true},
+        {pattern: "h?llo", str: "hllo", matched: false},
+        {pattern: "h*llo", str: "heeeello", matched: true},
+        {pattern: "h*llo", str: "hllo", matched: true},
+        {pattern: "h[ae]llo", str: "hallo", matched: true},
+        {pattern: "h[ae]llo", str: "hillo", matched: false},
+        {pattern: "h[^e]llo", str: "hallo", matched: true},
+        {pattern: "h[^e]llo", str: "hello", matched: false},
+        {pattern: "h[a-b]llo", str: "hbllo", matched: true},
+        {pattern: "h[b-a]llo", str: "hallo", matched: true},
+        {pattern: "h[a-b]llo", str: "hcllo", matched: false},
+        {pattern: "h\\*llo", str: "h*llo", matched: true},
+        {pattern: "h\\*llo", str: "hello", matched: false},
+        {pattern: "h[\\]]llo", str: "h]llo", matched: true},
+        {pattern: "user:*:name", str: "user:1000:name", matched: true},
+        {pattern: "user:*:name", str: "user:1000:age", matched: false},
+        {pattern: "a*b*c", str: "aXXbYYc", matched: true},
+        {pattern: "a*b*c", str: "aXXbYY", matched: false},
+        {pattern: "HELLO", str: "hello", matched: false},
+        {pattern: "HELLO", str: "hello", noCase: true, matched: true},
+        {pattern: "[A-C]x", str: "bx", noCase: true, matched: true},
+        {pattern: "h[ae", str: "ha", matched: true},
+        {pattern: "", str: "", matched: true},
+        {pattern: "", str: "a", matched: false},
+        {pattern: "a**", str: "a", matched: true},
+    }
+
+    for _, tc := range testCases {
+        if got := Match(tc.pattern, tc.str, tc.noCase); got != tc.matched {
+            t.Errorf("Error matching %q against pattern %q: expected %t, got %t.\n", tc.str, tc.pattern, tc.matched, got)
+        }
+    }
+}
+
+func TestMatch_PathologicalPattern(t *testing.T) {
+    pattern := "a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*b"
+    str := "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+    if Match(pattern, str, false) {
+        t.Errorf("Error matching %q against pattern %q: expected false, got true.\n", str, pattern)
+    }
+}
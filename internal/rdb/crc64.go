@@ -0,0 +1,25 @@
+package rdb
+
+import "hash/crc64"
+
+// jonesPolynomial is the polynomial of the CRC64 used by Redis, in the reversed form expected by hash/crc64.
+const jonesPolynomial = 0x95AC9329AC4BC9B5
+
+var jonesTable = crc64.MakeTable(jonesPolynomial)
+
+// checksum is an io.Writer computing the CRC64 of Redis of the data written.
+// Unlike hash/crc64, it neither inverts the initial value nor the result.
+type checksum struct {
+    crc uint64
+}
+
+func (c *checksum) Write(p []byte) (int, error) {
+    // crc64.Update inverts the value before and after the update, the inversions are undone around it.
+    c.crc = ^crc64.Update(^c.crc, jonesTable, p)
+    return len(p), nil
+}
+
+// Sum64 returns the CRC64 of the data written so far.
+func (c *checksum) Sum64() uint64 {
+    return c.crc
+}
@@ -0,0 +1,406 @@
+package rdb
+
+import (
+    "MyOwnRedis/internal/database"
+    "bufio"
+    "encoding/binary"
+    "io"
+    "strconv"
+    "time"
+)
+
+// maxStringLen bounds the length of the strings read, so a corrupt length can't exhaust the memory.
+const maxStringLen = 512 << 20
+
+// Result describes a decoded RDB file.
+type Result struct {
+    // Version is the version of the format of the file.
+    Version int
+    // Aux holds the AUX fields, e.g. redis-ver.
+    Aux map[string]string
+    // Keys is the number of keys decoded.
+    Keys int
+    // Skipped is the number of keys skipped, their type not being supported by the server, e.g. hashes.
+    Skipped int
+}
+
+// Decode reads an RDB file, calling apply with every key along with the index of its database, in order.
+// Lists are decoded from any of their encodings, including the ziplists and listpacks written by recent versions of Redis.
+// Keys of other types are skipped. The checksum is verified, unless it is zero, as written by Redis with rdbchecksum off.
+func Decode(r io.Reader, apply func(db int, entry database.Entry) error) (*Result, error) {
+    d := &decoder{r: bufio.NewReader(r)}
+
+    header, err := d.readFull(9)
+    if err != nil {
+        return nil, err
+    }
+    if string(header[:5]) != "REDIS" {
+        return nil, ErrBadMagic
+    }
+    version, err := strconv.Atoi(string(header[5:]))
+    if err != nil {
+        return nil, ErrBadMagic
+    }
+    if version < 1 || version > MaxVersion {
+        return nil, UnsupportedVersionError(version)
+    }
+
+    result := &Result{Version: version, Aux: make(map[string]string)}
+    db := 0
+    var expireAt time.Time
+    for {
+        op, err := d.readByte()
+        if err != nil {
+            return nil, err
+        }
+
+        switch op {
+        case opEOF:
+            return result, d.verifyChecksum(version)
+
+        case opSelectDb:
+            n, err := d.readLength()
+            if err != nil {
+                return nil, err
+            }
+            db = int(n)
+
+        case opResizeDb:
+            if _, err = d.readLength(); err == nil {
+                _, err = d.readLength()
+            }
+
+        case opAux:
+            var key, value string
+            if key, err = d.readString(); err == nil {
+                value, err = d.readString()
+            }
+            result.Aux[key] = value
+
+        case opExpireTime:
+            var b []byte
+            if b, err = d.readFull(4); err == nil {
+                expireAt = time.Unix(int64(binary.LittleEndian.Uint32(b)), 0)
+            }
+
+        case opExpireTimeMs:
+            var b []byte
+            if b, err = d.readFull(8); err == nil {
+                expireAt = time.UnixMilli(int64(binary.LittleEndian.Uint64(b)))
+            }
+
+        case opIdle:
+            // The eviction metadata of the next key is ignored.
+            _, err = d.readLength()
+
+        case opFreq:
+            _, err = d.readByte()
+
+        case opSlotInfo:
+            for i := 0; i < 3 && err == nil; i++ {
+                _, err = d.readLength()
+            }
+
+        case opFunction2:
+            // Functions aren't supported, their code is skipped.
+            _, err = d.readString()
+
+        case opModuleAux, opFunction:
+            return nil, UnsupportedTypeError(op)
+
+        default:
+            var entry database.Entry
+            var ok bool
+            if entry, ok, err = d.readEntry(op); err != nil {
+                return nil, err
+            }
+            if !ok {
+                result.Skipped++
+            } else {
+                entry.ExpireAt = expireAt
+                if err = apply(db, entry); err != nil {
+                    return nil, err
+                }
+                result.Keys++
+            }
+            // A time to live only applies to the key following it.
+            expireAt = time.Time{}
+        }
+        if err != nil {
+            return nil, err
+        }
+    }
+}
+
+// decoder reads the records of an RDB file, computing the checksum of the data read.
+type decoder struct {
+    r   *bufio.Reader
+    crc checksum
+}
+
+// verifyChecksum reads the checksum following the EOF opcode and compares it to the checksum of the data read.
+func (d *decoder) verifyChecksum(version int) error {
+    // Files older than version 5 have no checksum.
+    if version < 5 {
+        return nil
+    }
+    computed := d.crc.Sum64()
+    b := make([]byte, 8)
+    if _, err := io.ReadFull(d.r, b); err != nil {
+        return unexpectedEOF(err)
+    }
+    if stored := binary.LittleEndian.Uint64(b); stored != 0 && stored != computed {
+        return ErrBadChecksum
+    }
+    return nil
+}
+
+// readEntry reads a key and its value of type typ. ok is false when the type isn't supported by the server, the key being skipped.
+func (d *decoder) readEntry(typ byte) (entry database.Entry, ok bool, err error) {
+    if entry.Key, err = d.readString(); err != nil {
+        return entry, false, err
+    }
+
+    switch typ {
+    case typeString:
+        entry.Type = database.EntryString
+        entry.String, err = d.readString()
+        return entry, err == nil, err
+
+    case typeList, typeListZiplist, typeListQuicklist, typeListQuicklist2:
+        entry.Type = database.EntryList
+        entry.List, err = d.readList(typ)
+        // Empty lists don't exist.
+        return entry, err == nil && len(entry.List) > 0, err
+    }
+    return entry, false, d.skipValue(typ)
+}
+
+// readList reads the elements of a list of any encoding.
+func (d *decoder) readList(typ byte) ([]string, error) {
+    switch typ {
+    case typeListZiplist:
+        blob, err := d.readString()
+        if err != nil {
+            return nil, err
+        }
+        return parseZiplist([]byte(blob))
+    }
+
+    n, err := d.readLength()
+    if err != nil {
+        return nil, err
+    }
+    var list []string
+    for i := uint64(0); i < n; i++ {
+        switch typ {
+        case typeList:
+            element, err := d.readString()
+            if err != nil {
+                return nil, err
+            }
+            list = append(list, element)
+
+        case typeListQuicklist:
+            // Every node is a ziplist.
+            blob, err := d.readString()
+            if err != nil {
+                return nil, err
+            }
+            elements, err := parseZiplist([]byte(blob))
+            if err != nil {
+                return nil, err
+            }
+            list = append(list, elements...)
+
+        case typeListQuicklist2:
+            // Every node is either a single large element, or a listpack of elements.
+            container, err := d.readLength()
+            if err != nil {
+                return nil, err
+            }
+            blob, err := d.readString()
+            if err != nil {
+                return nil, err
+            }
+            const plain, packed = 1, 2
+            switch container {
+            case plain:
+                list = append(list, blob)
+            case packed:
+                elements, err := parseListpack([]byte(blob))
+                if err != nil {
+                    return nil, err
+                }
+                list = append(list, elements...)
+            default:
+                return nil, ErrBadFormat
+            }
+        }
+    }
+    return list, nil
+}
+
+// skipValue reads and drops a value of a type the server doesn't support.
+func (d *decoder) skipValue(typ byte) error {
+    switch typ {
+    case typeHashZipmap, typeSetIntset, typeZsetZiplist, typeHashZiplist, typeHashListpack, typeZsetListpack, typeSetListpack:
+        // The whole value is serialized into a single string.
+        _, err := d.readString()
+        return err
+    case typeSet, typeHash, typeZset, typeZset2:
+    default:
+        return UnsupportedTypeError(typ)
+    }
+
+    n, err := d.readLength()
+    if err != nil {
+        return err
+    }
+    for i := uint64(0); i < n; i++ {
+        if _, err = d.readString(); err != nil {
+            return err
+        }
+        switch typ {
+        case typeHash:
+            _, err = d.readString()
+        case typeZset:
+            // The score is a string, prefixed with its length on a single byte. 253 to 255 are NaN and infinities.
+            var length byte
+            if length, err = d.readByte(); err == nil && length < 253 {
+                _, err = d.readFull(int(length))
+            }
+        case typeZset2:
+            // The score is a binary double.
+            _, err = d.readFull(8)
+        }
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// readLength reads a length. Integer and compressed strings start with a special length, see readString.
+func (d *decoder) readLength() (uint64, error) {
+    n, encoded, err := d.readEncodedLength()
+    if err == nil && encoded {
+        return 0, ErrBadFormat
+    }
+    return n, err
+}
+
+// readEncodedLength reads a length, or the special encoding of a string when encoded is set.
+func (d *decoder) readEncodedLength() (n uint64, encoded bool, err error) {
+    b, err := d.readByte()
+    if err != nil {
+        return 0, false, err
+    }
+
+    switch b >> 6 {
+    case len6Bit:
+        return uint64(b & 0x3f), false, nil
+    case len14Bit:
+        next, err := d.readByte()
+        return uint64(b&0x3f)<<8 | uint64(next), false, err
+    case lenEncoded:
+        return uint64(b & 0x3f), true, nil
+    }
+
+    switch b {
+    case len32Bit:
+        buf, err := d.readFull(4)
+        if err != nil {
+            return 0, false, err
+        }
+        return uint64(binary.BigEndian.Uint32(buf)), false, nil
+    case len64Bit:
+        buf, err := d.readFull(8)
+        if err != nil {
+            return 0, false, err
+        }
+        return binary.BigEndian.Uint64(buf), false, nil
+    }
+    return 0, false, ErrBadFormat
+}
+
+// readString reads a string, either prefixed with its length, an integer or compressed.
+func (d *decoder) readString() (string, error) {
+    n, encoded, err := d.readEncodedLength()
+    if err != nil {
+        return "", err
+    }
+    if !encoded {
+        if n > maxStringLen {
+            return "", ErrBadFormat
+        }
+        b, err := d.readFull(int(n))
+        return string(b), err
+    }
+
+    switch n {
+    case encInt8:
+        b, err := d.readFull(1)
+        if err != nil {
+            return "", err
+        }
+        return strconv.Itoa(int(int8(b[0]))), nil
+    case encInt16:
+        b, err := d.readFull(2)
+        if err != nil {
+            return "", err
+        }
+        return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b)))), nil
+    case encInt32:
+        b, err := d.readFull(4)
+        if err != nil {
+            return "", err
+        }
+        return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b)))), nil
+    case encLZF:
+        compressedLen, err := d.readLength()
+        if err != nil {
+            return "", err
+        }
+        length, err := d.readLength()
+        if err != nil {
+            return "", err
+        }
+        if compressedLen > maxStringLen || length > maxStringLen {
+            return "", ErrBadFormat
+        }
+        compressed, err := d.readFull(int(compressedLen))
+        if err != nil {
+            return "", err
+        }
+        b, err := lzfDecompress(compressed, int(length))
+        return string(b), err
+    }
+    return "", ErrBadFormat
+}
+
+func (d *decoder) readByte() (byte, error) {
+    b, err := d.r.ReadByte()
+    if err != nil {
+        return 0, unexpectedEOF(err)
+    }
+    _, _ = d.crc.Write([]byte{b})
+    return b, nil
+}
+
+func (d *decoder) readFull(n int) ([]byte, error) {
+    b := make([]byte, n)
+    if _, err := io.ReadFull(d.r, b); err != nil {
+        return nil, unexpectedEOF(err)
+    }
+    _, _ = d.crc.Write(b)
+    return b, nil
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF: a file must end with the EOF opcode.
+func unexpectedEOF(err error) error {
+    if err == io.EOF {
+        return io.ErrUnexpectedEOF
+    }
+    return err
+}
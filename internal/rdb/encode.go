@@ -0,0 +1,181 @@
+package rdb
+
+import (
+    "MyOwnRedis/internal/database"
+    "bufio"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "math"
+    "strconv"
+    "time"
+)
+
+// minCompressLen is the length from which strings are compressed, shorter ones are left as is.
+const minCompressLen = 20
+
+// Encode writes a snapshot of the databases, indexed by database, to w in the RDB format.
+func Encode(w io.Writer, snapshot [][]database.Entry) error {
+    e := NewEncoder(w)
+    for db, entries := range snapshot {
+        if len(entries) == 0 {
+            continue
+        }
+        var expires int
+        for _, entry := range entries {
+            if !entry.ExpireAt.IsZero() {
+                expires++
+            }
+        }
+        e.SelectDb(db, len(entries), expires)
+        for _, entry := range entries {
+            e.WriteEntry(entry)
+        }
+    }
+    return e.Close()
+}
+
+// Encoder writes an RDB file record by record, so a snapshot can be written without holding all of it in memory.
+// The first error is kept and every later write is skipped, it is returned by Close.
+type Encoder struct {
+    // dst is the destination of the file, w buffers the writes to dst while computing their checksum.
+    dst io.Writer
+    w   *bufio.Writer
+    crc checksum
+    buf [9]byte
+    err error
+}
+
+// NewEncoder starts writing an RDB file to w, with the header and the AUX fields.
+func NewEncoder(w io.Writer) *Encoder {
+    e := &Encoder{dst: w}
+    e.w = bufio.NewWriter(io.MultiWriter(w, &e.crc))
+
+    e.writeRaw([]byte(fmt.Sprintf("REDIS%04d", Version)))
+    e.writeAux("redis-ver", redisVersion)
+    e.writeAux("redis-bits", strconv.Itoa(strconv.IntSize))
+    e.writeAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+    return e
+}
+
+// SelectDb starts writing the keys of the database db, holding size keys among which expires have a time to live.
+// The sizes are hints letting the loader size its tables upfront.
+func (e *Encoder) SelectDb(db, size, expires int) {
+    e.writeByte(opSelectDb)
+    e.writeLength(uint64(db))
+    e.writeByte(opResizeDb)
+    e.writeLength(uint64(size))
+    e.writeLength(uint64(expires))
+}
+
+// Close ends the file with the EOF opcode and the checksum, and returns the first error that occurred writing it.
+func (e *Encoder) Close() error {
+    e.writeByte(opEOF)
+    if e.err != nil {
+        return e.err
+    }
+    if err := e.w.Flush(); err != nil {
+        return err
+    }
+    // The checksum covers everything before it.
+    return binary.Write(e.dst, binary.LittleEndian, e.crc.Sum64())
+}
+
+// WriteEntry writes a key of the selected database, along with its time to live.
+func (e *Encoder) WriteEntry(entry database.Entry) {
+    if !entry.ExpireAt.IsZero() {
+        e.writeByte(opExpireTimeMs)
+        binary.LittleEndian.PutUint64(e.buf[:8], uint64(entry.ExpireAt.UnixMilli()))
+        e.writeRaw(e.buf[:8])
+    }
+
+    switch entry.Type {
+    case database.EntryString:
+        e.writeByte(typeString)
+        e.writeString(entry.Key)
+        e.writeString(entry.String)
+    case database.EntryList:
+        e.writeByte(typeList)
+        e.writeString(entry.Key)
+        e.writeLength(uint64(len(entry.List)))
+        for _, element := range entry.List {
+            e.writeString(element)
+        }
+    }
+}
+
+// writeAux writes an AUX field, describing the file or the server that wrote it.
+func (e *Encoder) writeAux(key, value string) {
+    e.writeByte(opAux)
+    e.writeString(key)
+    e.writeString(value)
+}
+
+// writeLength writes a length in the smallest of the 6, 14, 32 or 64 bits encodings.
+func (e *Encoder) writeLength(n uint64) {
+    switch {
+    case n < 1<<6:
+        e.writeByte(byte(n))
+    case n < 1<<14:
+        e.writeRaw([]byte{byte(len14Bit<<6 | n>>8), byte(n)})
+    case n <= math.MaxUint32:
+        e.buf[0] = len32Bit
+        binary.BigEndian.PutUint32(e.buf[1:5], uint32(n))
+        e.writeRaw(e.buf[:5])
+    default:
+        e.buf[0] = len64Bit
+        binary.BigEndian.PutUint64(e.buf[1:9], n)
+        e.writeRaw(e.buf[:9])
+    }
+}
+
+// writeString writes a string, encoded as an integer when it is the canonical form of one,
+// or compressed when that makes it shorter.
+func (e *Encoder) writeString(s string) {
+    if len(s) <= 11 {
+        if v, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(v, 10) == s {
+            e.writeInt(int32(v))
+            return
+        }
+    }
+    if len(s) > minCompressLen {
+        // Compressing must save a few bytes to be worth the extra lengths.
+        if compressed := lzfCompress([]byte(s), len(s)-4); compressed != nil {
+            e.writeByte(lenEncoded<<6 | encLZF)
+            e.writeLength(uint64(len(compressed)))
+            e.writeLength(uint64(len(s)))
+            e.writeRaw(compressed)
+            return
+        }
+    }
+    e.writeLength(uint64(len(s)))
+    e.writeRaw([]byte(s))
+}
+
+// writeInt writes an integer string in the smallest of the 8, 16 or 32 bits encodings.
+func (e *Encoder) writeInt(v int32) {
+    switch {
+    case v >= math.MinInt8 && v <= math.MaxInt8:
+        e.writeRaw([]byte{lenEncoded<<6 | encInt8, byte(v)})
+    case v >= math.MinInt16 && v <= math.MaxInt16:
+        e.buf[0] = lenEncoded<<6 | encInt16
+        binary.LittleEndian.PutUint16(e.buf[1:3], uint16(v))
+        e.writeRaw(e.buf[:3])
+    default:
+        e.buf[0] = lenEncoded<<6 | encInt32
+        binary.LittleEndian.PutUint32(e.buf[1:5], uint32(v))
+        e.writeRaw(e.buf[:5])
+    }
+}
+
+func (e *Encoder) writeByte(b byte) {
+    if e.err == nil {
+        e.err = e.w.WriteByte(b)
+    }
+}
+
+func (e *Encoder) writeRaw(b []byte) {
+    if e.err == nil {
+        _, e.err = e.w.Write(b)
+    }
+}
@@ -0,0 +1,125 @@
+package rdb
+
+// LZF compression, compatible with liblzf as used by Redis to compress strings.
+//
+// The compressed data is a sequence of chunks starting with a control byte. A control byte below 32 is followed by
+// a literal run of control+1 bytes. Otherwise the chunk is a back reference: the 3 most significant bits are the
+// length of the match minus 2, 7 meaning the length is continued in the next byte, and the 5 least significant bits
+// are the high bits of the offset of the match minus 1, followed by its low byte.
+
+const (
+    lzfHashLog    = 14
+    lzfMaxLiteral = 1 << 5
+    lzfMaxOffset  = 1 << 13
+    lzfMaxMatch   = 1<<8 + 1<<3
+)
+
+// lzfCompress compresses in, returning nil unless the compressed data is shorter than maxLen bytes.
+func lzfCompress(in []byte, maxLen int) []byte {
+    if len(in) < 4 {
+        return nil
+    }
+
+    var table [1 << lzfHashLog]int
+    out := make([]byte, 0, maxLen)
+    // literal is the start of the pending literal run.
+    literal := 0
+    flushLiterals := func(end int) {
+        for literal < end {
+            n := min(end-literal, lzfMaxLiteral)
+            out = append(out, byte(n-1))
+            out = append(out, in[literal:literal+n]...)
+            literal += n
+        }
+    }
+
+    ip := 0
+    for ip+2 < len(in) {
+        h := lzfHash(in[ip:])
+        // The table holds positions plus one, so zero means no position.
+        ref := table[h] - 1
+        table[h] = ip + 1
+
+        offset := ip - ref - 1
+        if ref < 0 || offset >= lzfMaxOffset || in[ref] != in[ip] || in[ref+1] != in[ip+1] || in[ref+2] != in[ip+2] {
+            ip++
+            continue
+        }
+
+        length := 3
+        for maxLength := min(len(in)-ip, lzfMaxMatch); length < maxLength && in[ref+length] == in[ip+length]; {
+            length++
+        }
+        flushLiterals(ip)
+        if n := length - 2; n < 7 {
+            out = append(out, byte(n<<5|offset>>8))
+        } else {
+            out = append(out, byte(7<<5|offset>>8), byte(n-7))
+        }
+        out = append(out, byte(offset))
+        if len(out) >= maxLen {
+            return nil
+        }
+
+        ip += length
+        literal = ip
+    }
+    flushLiterals(len(in))
+
+    if len(out) >= maxLen {
+        return nil
+    }
+    return out
+}
+
+// lzfHash hashes the 3 bytes starting b.
+func lzfHash(b []byte) int {
+    v := uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+    return int((v * 2654435761) >> (32 - lzfHashLog))
+}
+
+// lzfDecompress decompresses in, expecting exactly outLen bytes.
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+    out := make([]byte, 0, outLen)
+    for ip := 0; ip < len(in); {
+        ctrl := int(in[ip])
+        ip++
+
+        if ctrl < lzfMaxLiteral {
+            n := ctrl + 1
+            if ip+n > len(in) || len(out)+n > outLen {
+                return nil, ErrBadFormat
+            }
+            out = append(out, in[ip:ip+n]...)
+            ip += n
+            continue
+        }
+
+        length := ctrl >> 5
+        if length == 7 {
+            if ip >= len(in) {
+                return nil, ErrBadFormat
+            }
+            length += int(in[ip])
+            ip++
+        }
+        if ip >= len(in) {
+            return nil, ErrBadFormat
+        }
+        ref := len(out) - (ctrl&0x1f)<<8 - int(in[ip]) - 1
+        ip++
+        length += 2
+        if ref < 0 || len(out)+length > outLen {
+            return nil, ErrBadFormat
+        }
+        // The match may overlap the bytes it produces, it is copied byte by byte.
+        for i := 0; i < length; i++ {
+            out = append(out, out[ref+i])
+        }
+    }
+
+    if len(out) != outLen {
+        return nil, ErrBadFormat
+    }
+    return out, nil
+}
@@ -0,0 +1,94 @@
+// Package rdb implements the RDB file format of Redis: a compact binary snapshot of the dataset, so dump.rdb files
+// can be exchanged with Redis and its tooling.
+//
+// A file starts with the `REDIS` magic string and a 4 digits version, followed by AUX fields, then the keys of
+// each database, introduced by SELECTDB, and ends with the EOF opcode and the CRC64 checksum of everything before it.
+package rdb
+
+import (
+    "errors"
+    "fmt"
+)
+
+// Version is the version of the files written. Files up to MaxVersion can be read.
+const (
+    Version    = 9
+    MaxVersion = 12
+)
+
+// redisVersion is reported in the redis-ver AUX field, same as the server.
+const redisVersion = "7.2.0"
+
+// Opcodes introducing the records of the file, other than keys.
+const (
+    opSlotInfo     = 0xF4
+    opFunction2    = 0xF5
+    opFunction     = 0xF6
+    opModuleAux    = 0xF7
+    opIdle         = 0xF8
+    opFreq         = 0xF9
+    opAux          = 0xFA
+    opResizeDb     = 0xFB
+    opExpireTimeMs = 0xFC
+    opExpireTime   = 0xFD
+    opSelectDb     = 0xFE
+    opEOF          = 0xFF
+)
+
+// Types of the values, written before the key.
+const (
+    typeString         = 0
+    typeList           = 1
+    typeSet            = 2
+    typeZset           = 3
+    typeHash           = 4
+    typeZset2          = 5
+    typeHashZipmap     = 9
+    typeListZiplist    = 10
+    typeSetIntset      = 11
+    typeZsetZiplist    = 12
+    typeHashZiplist    = 13
+    typeListQuicklist  = 14
+    typeHashListpack   = 16
+    typeZsetListpack   = 17
+    typeListQuicklist2 = 18
+    typeSetListpack    = 20
+)
+
+// Length encodings, from the two most significant bits of the first byte of a length.
+const (
+    len6Bit  = 0
+    len14Bit = 1
+    len32Bit = 0x80
+    len64Bit = 0x81
+    // lenEncoded marks a string encoded as an integer or compressed, rather than prefixed with its length.
+    lenEncoded = 3
+)
+
+// Special string encodings, following lenEncoded.
+const (
+    encInt8  = 0
+    encInt16 = 1
+    encInt32 = 2
+    encLZF   = 3
+)
+
+var (
+    ErrBadMagic    = errors.New("error wrong signature trying to load DB from file")
+    ErrBadFormat   = errors.New("error bad file format reading the RDB file")
+    ErrBadChecksum = errors.New("error wrong RDB checksum")
+)
+
+// UnsupportedVersionError is returned when reading a file written by a newer version of Redis.
+type UnsupportedVersionError int
+
+func (e UnsupportedVersionError) Error() string {
+    return fmt.Sprintf("error can't handle RDB format version %d", int(e))
+}
+
+// UnsupportedTypeError is returned when reading a value whose type can't be skipped, e.g. a module value.
+type UnsupportedTypeError byte
+
+func (e UnsupportedTypeError) Error() string {
+    return fmt.Sprintf("error unsupported object type %d in the RDB file", byte(e))
+}
@@ -0,0 +1,243 @@
+package rdb
+
+import (
+    "MyOwnRedis/internal/database"
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "io"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestChecksum(t *testing.T) {
+    // The check value of the CRC64 of Redis, from its crc64.c.
+    var crc checksum
+    _, _ = crc.Write([]byte("123456789"))
+    if crc.Sum64() != 0xe9c6d914c4b8d9ca {
+        t.Errorf("Error checksum: expected %x, got %x.\n", uint64(0xe9c6d914c4b8d9ca), crc.Sum64())
+    }
+}
+
+func TestLZF(t *testing.T) {
+    // A literal `a` followed by a back reference of 9 bytes at offset 0, as liblzf compresses 10 `a`.
+    decompressed, err := lzfDecompress([]byte{0x00, 'a', 0xE0, 0x00, 0x00}, 10)
+    if err != nil || string(decompressed) != strings.Repeat("a", 10) {
+        t.Errorf("Error decompressing: expected %q, got %q ( %v ).\n", strings.Repeat("a", 10), decompressed, err)
+    }
+    if _, err = lzfDecompress([]byte{0x00, 'a', 0xE0, 0x00, 0x01}, 10); !errors.Is(err, ErrBadFormat) {
+        t.Errorf("Error decompressing a reference before the start: expected %v, got %v.\n", ErrBadFormat, err)
+    }
+
+    testCases := []string{
+        strings.Repeat("a", 1000),
+        strings.Repeat("hello world ", 50),
+        strings.Repeat("0123456789abcdefghijklmnopqrstuvwxyz", 300),
+    }
+    for _, tc := range testCases {
+        compressed := lzfCompress([]byte(tc), len(tc)-4)
+        if compressed == nil {
+            t.Errorf("Error %q should be compressible.\n", tc[:20])
+            continue
+        }
+        decompressed, err := lzfDecompress(compressed, len(tc))
+        if err != nil || string(decompressed) != tc {
+            t.Errorf("Error decompressing %q: got %q ( %v ).\n", tc[:20], decompressed, err)
+        }
+    }
+    if compressed := lzfCompress([]byte("abcdefghijklmnopqrstuvwxyz"), 22); compressed != nil {
+        t.Errorf("Error incompressible data should not be compressed, got %v.\n", compressed)
+    }
+}
+
+func TestEncodeDecode(t *testing.T) {
+    at := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+    snapshot := [][]database.Entry{
+        {
+            {Key: "foo", Type: database.EntryString, String: "bar"},
+            {Key: "int8", Type: database.EntryString, String: "-12"},
+            {Key: "int16", Type: database.EntryString, String: "1000"},
+            {Key: "int32", Type: database.EntryString, String: "-100000"},
+            {Key: "not canonical", Type: database.EntryString, String: "007"},
+            {Key: "large", Type: database.EntryString, String: "12345678901234567890"},
+            {Key: "compressed", Type: database.EntryString, String: strings.Repeat("redis ", 100)},
+            {Key: "empty", Type: database.EntryString, String: ""},
+            {Key: "binary", Type: database.EntryString, String: "\x00\xff\r\n"},
+            {Key: "temp", Type: database.EntryString, String: "bar", ExpireAt: at},
+        },
+        nil,
+        {
+            {Key: "list", Type: database.EntryList, List: []string{"a", "1", strings.Repeat("b", 100)}},
+            {Key: strings.Repeat("k", 20000), Type: database.EntryList, List: []string{"a"}, ExpireAt: at},
+        },
+    }
+
+    var buf bytes.Buffer
+    if err := Encode(&buf, snapshot); err != nil {
+        t.Fatalf("Error encoding: %v.\n", err)
+    }
+    if !bytes.HasPrefix(buf.Bytes(), []byte("REDIS0009")) {
+        t.Errorf("Error encoding: expected the header REDIS0009, got %q.\n", buf.Bytes()[:9])
+    }
+
+    decoded := make([][]database.Entry, 3)
+    result, err := Decode(&buf, func(db int, entry database.Entry) error {
+        decoded[db] = append(decoded[db], entry)
+        return nil
+    })
+    if err != nil {
+        t.Fatalf("Error decoding: %v.\n", err)
+    }
+    if !reflect.DeepEqual(decoded, snapshot) {
+        t.Errorf("Error decoding: expected %v, got %v.\n", snapshot, decoded)
+    }
+    if result.Keys != 12 || result.Skipped != 0 || result.Aux["redis-ver"] != redisVersion || result.Version != Version {
+        t.Errorf("Error decoding: unexpected result %+v.\n", result)
+    }
+}
+
+// redisDump returns a file as written by Redis 7, with lists encoded as quicklists of listpacks and keys of types the server doesn't support.
+func redisDump(t *testing.T) []byte {
+    t.Helper()
+    var buf bytes.Buffer
+    e := &Encoder{w: bufio.NewWriter(&buf)}
+    e.writeRaw([]byte("REDIS0011"))
+    e.writeAux("redis-ver", "7.2.4")
+    e.writeAux("aof-base", "0")
+    e.writeByte(opFunction2)
+    e.writeString("#!lua name=lib\nredis.register_function('f', function() return 1 end)")
+
+    e.writeByte(opSelectDb)
+    e.writeLength(0)
+    e.writeByte(opResizeDb)
+    e.writeLength(5)
+    e.writeLength(1)
+
+    e.writeByte(typeString)
+    e.writeString("counter")
+    e.writeString("42")
+
+    e.writeByte(opExpireTime)
+    e.writeRaw(binary.LittleEndian.AppendUint32(nil, uint32(time.Now().Add(time.Hour).Unix())))
+    e.writeByte(opIdle)
+    e.writeLength(10)
+    e.writeByte(typeString)
+    e.writeString("temp")
+    e.writeString("bar")
+
+    // A listpack of 5, "ab", -3 and 1000, then a plain node.
+    listpack := []byte{0, 0, 0, 0, 4, 0, 0x05, 0x01, 0x82, 'a', 'b', 0x03, 0xDF, 0xFD, 0x02, 0xF1, 0xE8, 0x03, 0x03, 0xFF}
+    e.writeByte(typeListQuicklist2)
+    e.writeString("list")
+    e.writeLength(2)
+    e.writeLength(2)
+    e.writeString(string(listpack))
+    e.writeLength(1)
+    e.writeString("plain")
+
+    e.writeByte(typeHashListpack)
+    e.writeString("hash")
+    e.writeString(string([]byte{0, 0, 0, 0, 2, 0, 0x81, 'f', 0x02, 0x01, 0x01, 0xFF}))
+    e.writeByte(typeSet)
+    e.writeString("set")
+    e.writeLength(2)
+    e.writeString("a")
+    e.writeString("b")
+    e.writeByte(typeZset2)
+    e.writeString("zset")
+    e.writeLength(1)
+    e.writeString("a")
+    e.writeRaw(make([]byte, 8))
+
+    // A Redis 6 list: a quicklist of ziplists of "xyz", 300 and 2.
+    ziplist := []byte{0, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0x00, 0x03, 'x', 'y', 'z', 0x05, 0xC0, 0x2C, 0x01, 0x04, 0xF3, 0xFF}
+    e.writeByte(opSelectDb)
+    e.writeLength(1)
+    e.writeByte(opFreq)
+    e.writeByte(3)
+    e.writeByte(typeListQuicklist)
+    e.writeString("old")
+    e.writeLength(1)
+    e.writeString(string(ziplist))
+    e.writeByte(opEOF)
+    if err := e.w.Flush(); err != nil {
+        t.Fatal(err)
+    }
+
+    var crc checksum
+    _, _ = crc.Write(buf.Bytes())
+    return binary.LittleEndian.AppendUint64(buf.Bytes(), crc.Sum64())
+}
+
+func TestDecode_Redis(t *testing.T) {
+    type key struct {
+        db    int
+        entry database.Entry
+    }
+    var keys []key
+    result, err := Decode(bytes.NewReader(redisDump(t)), func(db int, entry database.Entry) error {
+        keys = append(keys, key{db, entry})
+        return nil
+    })
+    if err != nil {
+        t.Fatalf("Error decoding: %v.\n", err)
+    }
+
+    expected := []key{
+        {0, database.Entry{Key: "counter", Type: database.EntryString, String: "42"}},
+        {0, database.Entry{Key: "temp", Type: database.EntryString, String: "bar"}},
+        {0, database.Entry{Key: "list", Type: database.EntryList, List: []string{"5", "ab", "-3", "1000", "plain"}}},
+        {1, database.Entry{Key: "old", Type: database.EntryList, List: []string{"xyz", "300", "2"}}},
+    }
+    for i := range keys {
+        // Only the presence of the time to live is compared.
+        if keys[i].entry.Key == "temp" {
+            if keys[i].entry.ExpireAt.IsZero() {
+                t.Errorf("Error decoding: temp should have a time to live.\n")
+            }
+            keys[i].entry.ExpireAt = time.Time{}
+        } else if !keys[i].entry.ExpireAt.IsZero() {
+            t.Errorf("Error decoding: %s should not have a time to live.\n", keys[i].entry.Key)
+        }
+    }
+    if !reflect.DeepEqual(keys, expected) {
+        t.Errorf("Error decoding: expected %v, got %v.\n", expected, keys)
+    }
+    if result.Keys != 4 || result.Skipped != 3 || result.Aux["redis-ver"] != "7.2.4" {
+        t.Errorf("Error decoding: unexpected result %+v.\n", result)
+    }
+}
+
+func TestDecode_Errors(t *testing.T) {
+    dump := redisDump(t)
+    corrupt := bytes.Clone(dump)
+    corrupt[bytes.Index(corrupt, []byte("xyz"))+1] = 'Y'
+    noChecksum := bytes.Clone(dump)
+    copy(noChecksum[len(noChecksum)-8:], make([]byte, 8))
+
+    testCases := []struct {
+        name string
+        data []byte
+        err  error
+    }{
+        {name: "no checksum", data: noChecksum},
+        {name: "bad checksum", data: corrupt, err: ErrBadChecksum},
+        {name: "truncated", data: dump[:len(dump)-12], err: io.ErrUnexpectedEOF},
+        {name: "empty", data: nil, err: io.ErrUnexpectedEOF},
+        {name: "bad magic", data: []byte("REDIX0009\xff"), err: ErrBadMagic},
+        {name: "newer version", data: []byte("REDIS0099\xff"), err: UnsupportedVersionError(99)},
+        {name: "module", data: []byte("REDIS0009\x07\x01k"), err: UnsupportedTypeError(7)},
+    }
+
+    for _, tc := range testCases {
+        t.Run(tc.name, func(t *testing.T) {
+            _, err := Decode(bytes.NewReader(tc.data), func(int, database.Entry) error { return nil })
+            if !errors.Is(err, tc.err) {
+                t.Errorf("Error decoding: expected %v, got %v.\n", tc.err, err)
+            }
+        })
+    }
+}
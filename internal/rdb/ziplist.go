@@ -0,0 +1,195 @@
+package rdb
+
+import (
+    "encoding/binary"
+    "strconv"
+)
+
+// parseZiplist returns the elements of a ziplist, the compact list encoding of Redis before 7.0.
+//
+// A ziplist is made of a header, the total size and the offset of the last entry on 4 bytes and the number of entries
+// on 2 bytes, followed by the entries and a 0xFF end marker. Each entry is the length of the previous entry,
+// on 1 byte or 0xFE and 4 bytes, followed by the encoding of the element and the element itself.
+func parseZiplist(b []byte) ([]string, error) {
+    const headerSize = 10
+    if len(b) < headerSize+1 {
+        return nil, ErrBadFormat
+    }
+
+    var elements []string
+    p := headerSize
+    for {
+        if p >= len(b) {
+            return nil, ErrBadFormat
+        }
+        if b[p] == 0xFF {
+            return elements, nil
+        }
+
+        // Skip the length of the previous entry.
+        if b[p] == 0xFE {
+            p += 5
+        } else {
+            p++
+        }
+        if p >= len(b) {
+            return nil, ErrBadFormat
+        }
+
+        enc := b[p]
+        var element string
+        var n int
+        ok := true
+        switch {
+        case enc>>6 == 0:
+            element, n, ok = ziplistBytes(b, p+1, int(enc&0x3f))
+        case enc>>6 == 1:
+            if p+1 >= len(b) {
+                return nil, ErrBadFormat
+            }
+            element, n, ok = ziplistBytes(b, p+2, int(enc&0x3f)<<8|int(b[p+1]))
+            n++
+        case enc == 0x80:
+            if p+5 > len(b) {
+                return nil, ErrBadFormat
+            }
+            element, n, ok = ziplistBytes(b, p+5, int(binary.BigEndian.Uint32(b[p+1:p+5])))
+            n += 4
+        case enc == 0xC0:
+            element, n, ok = ziplistInt(b, p+1, 2)
+        case enc == 0xD0:
+            element, n, ok = ziplistInt(b, p+1, 4)
+        case enc == 0xE0:
+            element, n, ok = ziplistInt(b, p+1, 8)
+        case enc == 0xF0:
+            element, n, ok = ziplistInt(b, p+1, 3)
+        case enc == 0xFE:
+            element, n, ok = ziplistInt(b, p+1, 1)
+        case enc >= 0xF1 && enc <= 0xFD:
+            // Small integers from 0 to 12 are held by the encoding itself.
+            element, n = strconv.Itoa(int(enc&0x0f)-1), 0
+        default:
+            return nil, ErrBadFormat
+        }
+        if !ok {
+            return nil, ErrBadFormat
+        }
+        elements = append(elements, element)
+        p += 1 + n
+    }
+}
+
+// parseListpack returns the elements of a listpack, the compact list encoding of Redis since 7.0.
+//
+// A listpack is made of a header, the total size on 4 bytes and the number of elements on 2 bytes,
+// followed by the entries and a 0xFF end marker. Each entry is the encoding of the element, the element itself,
+// then the length of the entry so far, on 1 to 5 bytes, for traversing the listpack backwards.
+func parseListpack(b []byte) ([]string, error) {
+    const headerSize = 6
+    if len(b) < headerSize+1 {
+        return nil, ErrBadFormat
+    }
+
+    var elements []string
+    p := headerSize
+    for {
+        if p >= len(b) {
+            return nil, ErrBadFormat
+        }
+        enc := b[p]
+        if enc == 0xFF {
+            return elements, nil
+        }
+
+        var element string
+        // n is the length of the entry, without its back length.
+        var n int
+        ok := true
+        switch {
+        case enc&0x80 == 0:
+            element, n = strconv.Itoa(int(enc)), 1
+        case enc&0xC0 == 0x80:
+            element, n, ok = ziplistBytes(b, p+1, int(enc&0x3f))
+            n++
+        case enc&0xE0 == 0xC0:
+            if p+1 >= len(b) {
+                return nil, ErrBadFormat
+            }
+            // A 13 bits signed integer.
+            v := int(enc&0x1f)<<8 | int(b[p+1])
+            if v >= 1<<12 {
+                v -= 1 << 13
+            }
+            element, n = strconv.Itoa(v), 2
+        case enc&0xF0 == 0xE0:
+            if p+1 >= len(b) {
+                return nil, ErrBadFormat
+            }
+            element, n, ok = ziplistBytes(b, p+2, int(enc&0x0f)<<8|int(b[p+1]))
+            n += 2
+        case enc == 0xF0:
+            if p+5 > len(b) {
+                return nil, ErrBadFormat
+            }
+            element, n, ok = ziplistBytes(b, p+5, int(binary.LittleEndian.Uint32(b[p+1:p+5])))
+            n += 5
+        case enc == 0xF1:
+            element, n, ok = ziplistInt(b, p+1, 2)
+            n++
+        case enc == 0xF2:
+            element, n, ok = ziplistInt(b, p+1, 3)
+            n++
+        case enc == 0xF3:
+            element, n, ok = ziplistInt(b, p+1, 4)
+            n++
+        case enc == 0xF4:
+            element, n, ok = ziplistInt(b, p+1, 8)
+            n++
+        default:
+            return nil, ErrBadFormat
+        }
+        if !ok {
+            return nil, ErrBadFormat
+        }
+        elements = append(elements, element)
+        p += n + listpackBackLen(n)
+    }
+}
+
+// listpackBackLen returns the number of bytes taken by the back length of a listpack entry of n bytes:
+// 7 bits of the length per byte, with the same bounds as Redis.
+func listpackBackLen(n int) int {
+    switch {
+    case n <= 127:
+        return 1
+    case n < 16383:
+        return 2
+    case n < 2097151:
+        return 3
+    case n < 268435455:
+        return 4
+    }
+    return 5
+}
+
+// ziplistBytes returns the length bytes starting at p, and length. ok is false when b is too short.
+func ziplistBytes(b []byte, p, length int) (s string, n int, ok bool) {
+    if length < 0 || p+length > len(b) {
+        return "", 0, false
+    }
+    return string(b[p : p+length]), length, true
+}
+
+// ziplistInt returns the little endian signed integer of size bytes starting at p, and size. ok is false when b is too short.
+func ziplistInt(b []byte, p, size int) (s string, n int, ok bool) {
+    if p+size > len(b) {
+        return "", 0, false
+    }
+    var v uint64
+    for i := size - 1; i >= 0; i-- {
+        v = v<<8 | uint64(b[p+i])
+    }
+    // Sign extend from size bytes.
+    shift := 64 - 8*size
+    return strconv.FormatInt(int64(v<<shift)>>shift, 10), size, true
+}
@@ -15,10 +15,22 @@ const (
     Integers      = ":"
     BulkStrings   = "$"
     Arrays        = "*"
+    // Maps and Push are RESP3 types, only sent to clients that switched protocol with HELLO 3.
+    Maps = "%"
+    Push = ">"
 )
 
 var ErrInvalidCommand = errors.New("error invalid command")
 
+var (
+    // NullBulkStrings is the RESP encoding of a null bulk string, e.g. a missing value.
+    NullBulkStrings = []byte("$-1\r\n")
+    // NullArray is the RESP encoding of a null array, e.g. returned by EXEC when the transaction is aborted by WATCH.
+    NullArray = []byte("*-1\r\n")
+    // Null is the RESP3 encoding of a null value, of any type.
+    Null = []byte("_\r\n")
+)
+
 const (
     FIX      = "fix"
     OPTIONAL = "optional"
@@ -29,33 +41,61 @@ var cmdTable = map[string]struct {
     cmdType      string
     expectedArgs int
 }{
-    "null":    {},
-    "command": {cmdType: FIX, expectedArgs: 1}, // expected to follow by docs, but for now it doesn't matter.
-    "ping":    {cmdType: FIX, expectedArgs: 0},
-    "scan":    {cmdType: FIX, expectedArgs: 0},
-    "get":     {cmdType: FIX, expectedArgs: 1},
-    "exists":  {cmdType: FIX, expectedArgs: 1},
-    "incr":    {cmdType: FIX, expectedArgs: 1},
-    "decr":    {cmdType: FIX, expectedArgs: 1},
-    "lrange":  {cmdType: FIX, expectedArgs: 3},
-    "save":    {cmdType: OPTIONAL, expectedArgs: -1}, // save or save <seconds> <changes>
-    "set":     {cmdType: OPTIONAL, expectedArgs: -1}, // SET x 1 or SET x 1 ex 10
-    "echo":    {cmdType: MULTIPLE, expectedArgs: -1},
-    "del":     {cmdType: MULTIPLE, expectedArgs: -1},
-    "lpush":   {cmdType: MULTIPLE, expectedArgs: -1},
-    "rpush":   {cmdType: MULTIPLE, expectedArgs: -1},
+    "null":         {},
+    "command":      {cmdType: FIX, expectedArgs: 1}, // expected to follow by docs, but for now it doesn't matter.
+    "ping":         {cmdType: FIX, expectedArgs: 0},
+    "keys":         {cmdType: FIX, expectedArgs: 1},
+    "get":          {cmdType: FIX, expectedArgs: 1},
+    "exists":       {cmdType: FIX, expectedArgs: 1},
+    "incr":         {cmdType: FIX, expectedArgs: 1},
+    "decr":         {cmdType: FIX, expectedArgs: 1},
+    "lrange":       {cmdType: FIX, expectedArgs: 3},
+    "select":       {cmdType: FIX, expectedArgs: 1},
+    "move":         {cmdType: FIX, expectedArgs: 2},
+    "pexpireat":    {cmdType: FIX, expectedArgs: 2},
+    "swapdb":       {cmdType: FIX, expectedArgs: 2},
+    "dbsize":       {cmdType: FIX, expectedArgs: 0},
+    "multi":        {cmdType: FIX, expectedArgs: 0},
+    "exec":         {cmdType: FIX, expectedArgs: 0},
+    "discard":      {cmdType: FIX, expectedArgs: 0},
+    "unwatch":      {cmdType: FIX, expectedArgs: 0},
+    "publish":      {cmdType: FIX, expectedArgs: 2},
+    "spublish":     {cmdType: FIX, expectedArgs: 2},
+    "bgrewriteaof": {cmdType: FIX, expectedArgs: 0},
+    "lastsave":     {cmdType: FIX, expectedArgs: 0},
+    "shutdown":     {cmdType: OPTIONAL, expectedArgs: -1}, // SHUTDOWN [NOSAVE | SAVE] [NOW] [FORCE] [ABORT]
+    "save":         {cmdType: FIX, expectedArgs: 0},
+    "bgsave":       {cmdType: OPTIONAL, expectedArgs: -1}, // BGSAVE [SCHEDULE]
+    "set":          {cmdType: OPTIONAL, expectedArgs: -1}, // SET x 1 or SET x 1 ex 10
+    "flushdb":      {cmdType: OPTIONAL, expectedArgs: -1}, // FLUSHDB [ASYNC | SYNC]
+    "flushall":     {cmdType: OPTIONAL, expectedArgs: -1}, // FLUSHALL [ASYNC | SYNC]
+    "unsubscribe":  {cmdType: OPTIONAL, expectedArgs: -1}, // UNSUBSCRIBE [channel [channel ...]]
+    "hello":        {cmdType: OPTIONAL, expectedArgs: -1}, // HELLO [protover [AUTH username password] [SETNAME clientname]]
+    "info":         {cmdType: OPTIONAL, expectedArgs: -1}, // INFO [section [section ...]]
+    "punsubscribe": {cmdType: OPTIONAL, expectedArgs: -1}, // PUNSUBSCRIBE [pattern [pattern ...]]
+    "sunsubscribe": {cmdType: OPTIONAL, expectedArgs: -1}, // SUNSUBSCRIBE [shardchannel [shardchannel ...]]
+    "echo":         {cmdType: MULTIPLE, expectedArgs: -1},
+    "scan":         {cmdType: MULTIPLE, expectedArgs: -1}, // SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+    "del":          {cmdType: MULTIPLE, expectedArgs: -1},
+    "watch":        {cmdType: MULTIPLE, expectedArgs: -1},
+    "subscribe":    {cmdType: MULTIPLE, expectedArgs: -1},
+    "psubscribe":   {cmdType: MULTIPLE, expectedArgs: -1},
+    "ssubscribe":   {cmdType: MULTIPLE, expectedArgs: -1},
+    "pubsub":       {cmdType: MULTIPLE, expectedArgs: -1}, // PUBSUB subcommand [argument [argument ...]]
+    "config":       {cmdType: MULTIPLE, expectedArgs: -1}, // CONFIG GET parameter | SET parameter value
+    "client":       {cmdType: MULTIPLE, expectedArgs: -1}, // CLIENT subcommand [argument ...]
+    "auth":         {cmdType: MULTIPLE, expectedArgs: -1}, // AUTH [username] password
+    "acl":          {cmdType: MULTIPLE, expectedArgs: -1}, // ACL subcommand [argument ...]
+    "lpush":        {cmdType: MULTIPLE, expectedArgs: -1},
+    "rpush":        {cmdType: MULTIPLE, expectedArgs: -1},
 }
 
 // RObj struct.
 type RObj struct {
-    Type        string
-    Command     string
-    Content     []string
-    TimeToLive  time.Duration
-    SaveOptions struct {
-        CheckKeys  int
-        CheckCycle time.Duration
-    }
+    Type       string
+    Command    string
+    Content    []string
+    TimeToLive time.Duration
 }
 
 // New deserializes the client request and creates a RObj.
@@ -171,7 +211,7 @@ func Deserialize(req []byte) (*RObj, error) {
                     if err != nil {
                         return nil, err
                     }
-                    switch cmd {
+                    switch robj.Command {
                     case "set":
                         if len(content) == 2 {
                             robj.Content = content
@@ -195,43 +235,38 @@ func Deserialize(req []byte) (*RObj, error) {
                                 now := time.Now().Unix()
                                 robj.TimeToLive = time.Duration(int64(timeArg)-now) * time.Second
                             case "pxat":
-                                now := time.Now().Unix()
+                                now := time.Now().UnixMilli()
                                 robj.TimeToLive = time.Duration(int64(timeArg)-now) * time.Millisecond
                             default:
                                 return nil, ErrInvalidCommand
                             }
+                            if robj.TimeToLive == 0 && (optionalCmd == "exat" || optionalCmd == "pxat") {
+                                // Expiring this very second or millisecond, e.g. replaying the AOF: a zero time to live would mean none.
+                                robj.TimeToLive = -time.Nanosecond
+                            }
                         } else {
                             return nil, ErrInvalidCommand
                         }
-                    case "save":
-                        if len(content) == 0 {
-                            // Do nothing.
-                            robj.Content = content
-
-                        } else if len(content) == 2 {
-                            robj.Content = content
-
-                            saveCheckCycle, err := strconv.Atoi(content[0])
-                            if err != nil {
-                                return nil, err
-                            }
-
-                            checkKeys, err := strconv.Atoi(content[1])
-                            if err != nil {
-                                return nil, err
-                            }
-
-                            robj.SaveOptions = struct {
-                                CheckKeys  int
-                                CheckCycle time.Duration
-                            }{
-                                CheckKeys:  checkKeys,
-                                CheckCycle: time.Duration(saveCheckCycle) * time.Second, // Redis only enables save options using seconds.
-                            }
-
-                        } else {
+                    case "flushdb", "flushall":
+                        if len(content) > 1 {
                             return nil, ErrInvalidCommand
                         }
+                        robj.Content = content
+                    case "unsubscribe", "punsubscribe", "sunsubscribe":
+                        // Without arguments, the client is unsubscribed from everything.
+                        robj.Content = content
+                    case "hello":
+                        // HELLO [protover [AUTH username password] [SETNAME clientname]]
+                        robj.Content = content
+                    case "info":
+                        // INFO [section [section ...]]
+                        robj.Content = content
+                    case "bgsave":
+                        // BGSAVE [SCHEDULE]
+                        robj.Content = content
+                    case "shutdown":
+                        // SHUTDOWN [NOSAVE | SAVE] [NOW] [FORCE] [ABORT]
+                        robj.Content = content
                     default:
                         return nil, ErrInvalidCommand
                     }
@@ -315,6 +350,33 @@ func parseMessage(input []byte) (string, []byte, error) {
     }
 }
 
+// SerializeArray wraps already serialized elements into a RESP array, allowing nested arrays and mixed element types.
+func SerializeArray(elements ...[]byte) []byte {
+    re := []byte(fmt.Sprintf("%s%d\r\n", Arrays, len(elements)))
+    for _, ele := range elements {
+        re = append(re, ele...)
+    }
+    return re
+}
+
+// SerializePush wraps already serialized elements into a RESP3 push, data sent by the server out of band, e.g. an invalidation message.
+func SerializePush(elements ...[]byte) []byte {
+    re := []byte(fmt.Sprintf("%s%d\r\n", Push, len(elements)))
+    for _, ele := range elements {
+        re = append(re, ele...)
+    }
+    return re
+}
+
+// SerializeMap wraps already serialized keys and values, alternated, into a RESP3 map.
+func SerializeMap(pairs ...[]byte) []byte {
+    re := []byte(fmt.Sprintf("%s%d\r\n", Maps, len(pairs)/2))
+    for _, ele := range pairs {
+        re = append(re, ele...)
+    }
+    return re
+}
+
 func Serialize(responseType string, data ...string) []byte {
     var re []byte
     switch responseType {
@@ -1,6 +1,7 @@
 package redisObject
 
 import (
+    "MyOwnRedis/internal/acl"
     "bytes"
     "errors"
     "testing"
@@ -42,6 +43,51 @@ func Test_Serialize(t *testing.T) {
     }
 }
 
+func Test_SerializeArray(t *testing.T) {
+    testCases := []struct {
+        elements [][]byte
+        result   []byte
+    }{
+        {
+            elements: [][]byte{},
+            result:   []byte("*0\r\n"),
+        },
+        {
+            elements: [][]byte{
+                Serialize(BulkStrings, "0"),
+                Serialize(Arrays, "foo", "bar"),
+            },
+            result: []byte("*2\r\n$1\r\n0\r\n*2\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"),
+        },
+        {
+            elements: [][]byte{
+                Serialize(BulkStrings, "message"),
+                Serialize(Integers, "1"),
+            },
+            result: []byte("*2\r\n$7\r\nmessage\r\n:1\r\n"),
+        },
+    }
+
+    for _, tc := range testCases {
+        re := SerializeArray(tc.elements...)
+        if !bytes.Equal(re, tc.result) {
+            t.Errorf("error serializing array: expected %q, got %q.\n", tc.result, re)
+        }
+    }
+}
+
+func Test_SerializePushAndMap(t *testing.T) {
+    push := SerializePush(Serialize(BulkStrings, "invalidate"), Serialize(Arrays, "foo"))
+    if expected := []byte(">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n"); !bytes.Equal(push, expected) {
+        t.Errorf("error serializing push: expected %q, got %q.\n", expected, push)
+    }
+
+    m := SerializeMap(Serialize(BulkStrings, "proto"), Serialize(Integers, "3"), Serialize(BulkStrings, "modules"), Serialize(Arrays))
+    if expected := []byte("%2\r\n$5\r\nproto\r\n:3\r\n$7\r\nmodules\r\n*0\r\n"); !bytes.Equal(m, expected) {
+        t.Errorf("error serializing map: expected %q, got %q.\n", expected, m)
+    }
+}
+
 func Test_Deserialize(t *testing.T) {
     t.Run("Test Deserialize Invalid commands", func(t *testing.T) {
         testCases := []struct {
@@ -56,6 +102,10 @@ func Test_Deserialize(t *testing.T) {
                 input: []byte("*1\r\n$3\r\nPNG\r\n"), // Arrays.
                 err:   ErrInvalidCommand,
             },
+            {
+                input: []byte("*3\r\n$4\r\nsave\r\n$3\r\n900\r\n$1\r\n1\r\n"), // Arrays: SAVE 900 1, save points are configured with CONFIG SET save.
+                err:   ErrInvalidCommand,
+            },
         }
 
         for _, tc := range testCases {
@@ -153,12 +203,20 @@ func Test_Deserialize(t *testing.T) {
                 },
             },
             {
-                input:  []byte("*4\r\n$4\r\nsave\r\n"), // Arrays: SAVE.
-                result: &RObj{Type: Arrays, Command: "save", Content: []string{}},
+                input:  []byte("*2\r\n$4\r\nscan\r\n$1\r\n0\r\n"), // Arrays: SCAN 0.
+                result: &RObj{Type: Arrays, Command: "scan", Content: []string{"0"}},
             },
             {
-                input:  []byte("*4\r\n$4\r\nsave\r\n$3\r\n900\r\n$1\r\n1\r\n"), // Arrays: SAVE 900 1
-                result: &RObj{Type: Arrays, Command: "save", Content: []string{"900", "1"}},
+                input:  []byte("*6\r\n$4\r\nSCAN\r\n$2\r\n12\r\n$5\r\nMATCH\r\n$5\r\nuser*\r\n$5\r\nCOUNT\r\n$3\r\n100\r\n"), // Arrays: SCAN with options.
+                result: &RObj{Type: Arrays, Command: "scan", Content: []string{"12", "MATCH", "user*", "COUNT", "100"}},
+            },
+            {
+                input:  []byte("*2\r\n$4\r\nkeys\r\n$1\r\n*\r\n"), // Arrays: KEYS *.
+                result: &RObj{Type: Arrays, Command: "keys", Content: []string{"*"}},
+            },
+            {
+                input:  []byte("*1\r\n$4\r\nsave\r\n"), // Arrays: SAVE.
+                result: &RObj{Type: Arrays, Command: "save", Content: []string{}},
             },
         }
 
@@ -258,3 +316,12 @@ func Test_parseMessage(t *testing.T) {
         }
     }
 }
+
+func Test_cmdTableACL(t *testing.T) {
+    // Commands missing from the ACL commands table are denied to every user.
+    for name := range cmdTable {
+        if name != NULL && !acl.CommandExists(name) {
+            t.Errorf("Error command %s has no ACL entry.\n", name)
+        }
+    }
+}
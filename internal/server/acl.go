@@ -0,0 +1,271 @@
+package server
+
+import (
+    "MyOwnRedis/internal/acl"
+    "MyOwnRedis/internal/redisObject"
+    "errors"
+    "fmt"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// checkPermission returns the error replied to a client not allowed to run a command: either not authenticated yet,
+// or its user lacks the permission to run the command, or to access one of its keys or channels. Denials are logged to ACL LOG.
+// nil is returned if the command is allowed.
+func (r *RedisServer) checkPermission(c *client, robj *redisObject.RObj) []byte {
+    if !c.authenticated {
+        if acl.NoAuth(robj.Command) {
+            return nil
+        }
+        return redisObject.Serialize(redisObject.SimpleErrors, "NOAUTH Authentication required.")
+    }
+
+    err := r.acl.Check(c.user, robj.Command, robj.Content)
+    if err == nil {
+        return nil
+    }
+    var denied *acl.PermissionError
+    if !errors.As(err, &denied) {
+        // The user was removed, its clients are being disconnected.
+        denied = &acl.PermissionError{Reason: acl.ReasonCommand, Object: commandName(robj)}
+    }
+    r.logDenial(c, denied.Reason, denied.Object, c.user)
+
+    switch denied.Reason {
+    case acl.ReasonKey:
+        return redisObject.Serialize(redisObject.SimpleErrors, "NOPERM No permissions to access a key")
+    case acl.ReasonChannel:
+        return redisObject.Serialize(redisObject.SimpleErrors, "NOPERM No permissions to access a channel")
+    }
+    return redisObject.Serialize(redisObject.SimpleErrors, "NOPERM User "+c.user+" has no permissions to run the '"+denied.Object+"' command")
+}
+
+// logDenial adds a denied command or authentication of a client to ACL LOG.
+func (r *RedisServer) logDenial(c *client, reason acl.Reason, object, username string) {
+    context := "toplevel"
+    if c.multi {
+        context = "multi"
+    }
+    r.aclLog.Add(reason, context, object, username, r.clientInfoLine(c), time.Now())
+}
+
+// authenticate authenticates the client as username, if password is right and the user enabled.
+func (r *RedisServer) authenticate(c *client, username, password string) bool {
+    if !r.acl.Authenticate(username, password) {
+        r.logDenial(c, acl.ReasonAuth, "AUTH", username)
+        return false
+    }
+    c.user, c.authenticated = username, true
+    c.infoLock.Lock()
+    c.info.user = username
+    c.infoLock.Unlock()
+    return true
+}
+
+// auth handles `AUTH [username] password`, the default user being authenticated without username.
+func (r *RedisServer) auth(c *client, args []string) []byte {
+    var username, password string
+    switch len(args) {
+    case 1:
+        if r.acl.NoPass(acl.DefaultUser) {
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+        }
+        username, password = acl.DefaultUser, args[0]
+    case 2:
+        username, password = args[0], args[1]
+    default:
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+    }
+
+    if !r.authenticate(c, username, password) {
+        return redisObject.Serialize(redisObject.SimpleErrors, "WRONGPASS invalid username-password pair or user is disabled.")
+    }
+    return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+}
+
+// setRequirePass sets the password of the default user, or lets it authenticate without password if password is empty.
+func (r *RedisServer) setRequirePass(password string) error {
+    rules := []string{"nopass"}
+    if password != "" {
+        rules = []string{"resetpass", ">" + password}
+    }
+    if err := r.acl.SetUser(acl.DefaultUser, rules...); err != nil {
+        return err
+    }
+    r.Lock()
+    defer r.Unlock()
+    r.requirePass = password
+    return nil
+}
+
+// disconnectRemovedUsers disconnects the clients authenticated as a user which doesn't exist anymore.
+func (r *RedisServer) disconnectRemovedUsers() {
+    r.clientsLock.RLock()
+    defer r.clientsLock.RUnlock()
+    for _, c := range r.clients {
+        if !r.acl.Exists(c.userName()) {
+            c.kill()
+        }
+    }
+}
+
+// aclCommand handles `ACL CAT [category] | WHOAMI | SETUSER username [rule ...] | GETUSER username | DELUSER username [username ...] |
+// LIST | USERS | LOG [count | RESET] | DRYRUN username command [arg ...] | LOAD | SAVE`.
+func (r *RedisServer) aclCommand(c *client, args []string) []byte {
+    subcommand := strings.ToLower(args[0])
+    switch {
+    case subcommand == "cat" && len(args) <= 2:
+        if len(args) == 1 {
+            return redisObject.Serialize(redisObject.Arrays, acl.Categories()...)
+        }
+        names, ok := acl.CategoryCommands(args[1])
+        if !ok {
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR Unknown category '"+args[1]+"'")
+        }
+        return redisObject.Serialize(redisObject.Arrays, names...)
+
+    case subcommand == "whoami" && len(args) == 1:
+        return redisObject.Serialize(redisObject.BulkStrings, c.user)
+
+    case subcommand == "setuser" && len(args) >= 2:
+        if err := r.acl.SetUser(args[1], args[2:]...); err != nil {
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR "+err.Error())
+        }
+        return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+
+    case subcommand == "getuser" && len(args) == 2:
+        return r.aclGetUser(c, args[1])
+
+    case subcommand == "deluser" && len(args) >= 2:
+        n, err := r.acl.DelUser(args[1:]...)
+        if err != nil {
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR The 'default' user cannot be removed")
+        }
+        r.disconnectRemovedUsers()
+        return redisObject.Serialize(redisObject.Integers, strconv.Itoa(n))
+
+    case subcommand == "list" && len(args) == 1:
+        return redisObject.Serialize(redisObject.Arrays, r.acl.List()...)
+
+    case subcommand == "users" && len(args) == 1:
+        return redisObject.Serialize(redisObject.Arrays, r.acl.Users()...)
+
+    case subcommand == "log" && len(args) <= 2:
+        return r.aclLogCommand(c, args[1:])
+
+    case subcommand == "dryrun" && len(args) >= 3:
+        return r.aclDryRun(args[1], strings.ToLower(args[2]), args[3:])
+
+    case subcommand == "load" && len(args) == 1, subcommand == "save" && len(args) == 1:
+        r.RLock()
+        path := r.aclFile
+        r.RUnlock()
+        if path == "" {
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command.")
+        }
+        if subcommand == "save" {
+            if err := r.acl.Save(path); err != nil {
+                log.Printf("RRedis error saving the ACL file: %v", err)
+                return redisObject.Serialize(redisObject.SimpleErrors, "ERR There was an error trying to save the ACLs. Please check the server logs for more information")
+            }
+            return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+        }
+        if err := r.acl.Load(path); err != nil {
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR "+err.Error())
+        }
+        r.disconnectRemovedUsers()
+        return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+    }
+    return redisObject.Serialize(redisObject.SimpleErrors, "ERR unknown subcommand or wrong number of arguments for '"+args[0]+"'. Try ACL HELP.")
+}
+
+// aclGetUser handles `ACL GETUSER username`.
+func (r *RedisServer) aclGetUser(c *client, username string) []byte {
+    info, ok := r.acl.GetUser(username)
+    if !ok {
+        return redisObject.NullBulkStrings
+    }
+    return c.encodeMap(
+        redisObject.Serialize(redisObject.BulkStrings, "flags"),
+        redisObject.Serialize(redisObject.Arrays, info.Flags...),
+        redisObject.Serialize(redisObject.BulkStrings, "passwords"),
+        redisObject.Serialize(redisObject.Arrays, info.Passwords...),
+        redisObject.Serialize(redisObject.BulkStrings, "commands"),
+        redisObject.Serialize(redisObject.BulkStrings, info.Commands),
+        redisObject.Serialize(redisObject.BulkStrings, "keys"),
+        redisObject.Serialize(redisObject.BulkStrings, info.Keys),
+        redisObject.Serialize(redisObject.BulkStrings, "channels"),
+        redisObject.Serialize(redisObject.BulkStrings, info.Channels),
+        redisObject.Serialize(redisObject.BulkStrings, "selectors"),
+        redisObject.SerializeArray(),
+    )
+}
+
+// aclLogCommand handles `ACL LOG [count | RESET]`, replying with the most recent denials first.
+func (r *RedisServer) aclLogCommand(c *client, args []string) []byte {
+    count := 10
+    if len(args) == 1 {
+        if strings.ToLower(args[0]) == "reset" {
+            r.aclLog.Reset()
+            return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+        }
+        n, err := strconv.Atoi(args[0])
+        if err != nil || n < 0 {
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR value is out of range, must be positive")
+        }
+        count = n
+    }
+
+    now := time.Now()
+    entries := r.aclLog.Entries(count)
+    replies := make([][]byte, 0, len(entries))
+    for _, e := range entries {
+        replies = append(replies, c.encodeMap(
+            redisObject.Serialize(redisObject.BulkStrings, "count"),
+            redisObject.Serialize(redisObject.Integers, strconv.Itoa(e.Count)),
+            redisObject.Serialize(redisObject.BulkStrings, "reason"),
+            redisObject.Serialize(redisObject.BulkStrings, string(e.Reason)),
+            redisObject.Serialize(redisObject.BulkStrings, "context"),
+            redisObject.Serialize(redisObject.BulkStrings, e.Context),
+            redisObject.Serialize(redisObject.BulkStrings, "object"),
+            redisObject.Serialize(redisObject.BulkStrings, e.Object),
+            redisObject.Serialize(redisObject.BulkStrings, "username"),
+            redisObject.Serialize(redisObject.BulkStrings, e.Username),
+            redisObject.Serialize(redisObject.BulkStrings, "age-seconds"),
+            redisObject.Serialize(redisObject.BulkStrings, fmt.Sprintf("%.3f", now.Sub(e.Created).Seconds())),
+            redisObject.Serialize(redisObject.BulkStrings, "client-info"),
+            redisObject.Serialize(redisObject.BulkStrings, e.ClientInfo),
+            redisObject.Serialize(redisObject.BulkStrings, "entry-id"),
+            redisObject.Serialize(redisObject.Integers, strconv.FormatInt(e.EntryID, 10)),
+            redisObject.Serialize(redisObject.BulkStrings, "timestamp-created"),
+            redisObject.Serialize(redisObject.Integers, strconv.FormatInt(e.Created.UnixMilli(), 10)),
+            redisObject.Serialize(redisObject.BulkStrings, "timestamp-last-updated"),
+            redisObject.Serialize(redisObject.Integers, strconv.FormatInt(e.Updated.UnixMilli(), 10)),
+        ))
+    }
+    return redisObject.SerializeArray(replies...)
+}
+
+// aclDryRun handles `ACL DRYRUN username command [arg ...]`, telling whether the user may run the command, without running it.
+func (r *RedisServer) aclDryRun(username, command string, args []string) []byte {
+    if !r.acl.Exists(username) {
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR User '"+username+"' not found")
+    }
+    if !acl.CommandExists(command) {
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR Command '"+command+"' not found")
+    }
+
+    err := r.acl.Check(username, command, args)
+    var denied *acl.PermissionError
+    switch {
+    case err == nil:
+        return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+    case !errors.As(err, &denied):
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR "+err.Error())
+    case denied.Reason == acl.ReasonCommand:
+        return redisObject.Serialize(redisObject.BulkStrings, "User "+username+" has no permissions to run the '"+denied.Object+"' command")
+    }
+    return redisObject.Serialize(redisObject.BulkStrings, "User "+username+" has no permissions to access the '"+denied.Object+"' "+string(denied.Reason))
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+    "MyOwnRedis/internal/aof"
+    "MyOwnRedis/internal/database"
+    "MyOwnRedis/internal/redisObject"
+    "errors"
+    "fmt"
+    "log"
+    "strconv"
+    "time"
+)
+
+// defaultAOFConfig is the configuration of the AOF unless configured otherwise, in the directory of the store.
+var defaultAOFConfig = aof.Config{DirName: "appendonlydir", Filename: "appendonly.aof", Fsync: aof.FsyncEverySec, LoadTruncated: true}
+
+// EnableAOF turns the append only file on: the dataset is loaded from the AOF when the server starts,
+// before it accepts connections, and every write command is appended to it.
+func (r *RedisServer) EnableAOF(config aof.Config) {
+    r.aofEnabled = true
+    r.aofConfig = config
+}
+
+// SetAOFAutoRewrite sets when the AOF is rewritten automatically: once it grew by percentage since the last rewrite,
+// and is at least minSize bytes. A percentage of 0 disables automatic rewrites.
+func (r *RedisServer) SetAOFAutoRewrite(percentage int, minSize int64) {
+    r.Lock()
+    defer r.Unlock()
+    r.aofRewritePercentage = percentage
+    r.aofRewriteMinSize = minSize
+}
+
+// openAOF loads the dataset from the AOF, then opens it to append the write commands.
+func (r *RedisServer) openAOF() error {
+    start := time.Now()
+    a, err := aof.Open(r.aofConfig)
+    if err != nil {
+        return err
+    }
+    // Commands are replayed as if they were sent by a client, whose replies are dropped.
+    c := newClient(0, nil)
+    err = a.Load(func(args []string) error {
+        robj, err := redisObject.Deserialize(redisObject.Serialize(redisObject.Arrays, args...))
+        if err != nil {
+            return fmt.Errorf("error unknown command '%s' reading the append only file", args[0])
+        }
+        r.execute(c, robj)
+        return nil
+    })
+    if err != nil {
+        return errors.Join(err, a.Close())
+    }
+    // The replayed commands are already persisted, they aren't changes since the last save.
+    r.dirty.Store(0)
+    log.Printf("RRedis DB loaded from append only file: %.3f seconds", time.Since(start).Seconds())
+
+    r.aof = a
+    go r.aofRewriteCron()
+    return nil
+}
+
+// bgRewriteAOF handles `BGREWRITEAOF`, compacting the AOF in the background.
+func (r *RedisServer) bgRewriteAOF() []byte {
+    if r.aof == nil {
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR Append only file is disabled")
+    }
+    err := r.startAOFRewrite()
+    switch {
+    case errors.Is(err, aof.ErrRewriteInProgress):
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR Background append only file rewriting already in progress")
+    case errors.Is(err, errBgsaveInProgress):
+        // The rewrite starts once the background save is over.
+        r.Lock()
+        r.aofRewriteScheduled = true
+        r.Unlock()
+        return redisObject.Serialize(redisObject.SimpleStrings, "Background append only file rewriting scheduled")
+    case err != nil:
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR "+err.Error())
+    }
+    return redisObject.Serialize(redisObject.SimpleStrings, "Background append only file rewriting started")
+}
+
+// startAOFRewrite starts rewriting the AOF from a snapshot of the dataset, written to the new base file by a background goroutine.
+// Callers must hold the command lock, so no command is executed between the snapshot and the switch to a new incr file.
+// A single background job runs at a time, errBgsaveInProgress is returned while a background save is in progress.
+func (r *RedisServer) startAOFRewrite() error {
+    start := time.Now()
+    r.Lock()
+    if r.closed.Load() {
+        r.Unlock()
+        return ErrServerClosed
+    }
+    if !r.bgsaveStart.IsZero() {
+        r.Unlock()
+        return errBgsaveInProgress
+    }
+    if !r.aofRewriteStart.IsZero() {
+        r.Unlock()
+        return aof.ErrRewriteInProgress
+    }
+    r.aofRewriteStart = start
+    r.aofRewriteScheduled = false
+    r.Unlock()
+
+    // Write commands hold the write lock until they are logged, the dataset and the AOF are in sync once it is acquired.
+    r.writeLock.Lock()
+    snapshot := r.store.Snapshot()
+    rw, err := r.aof.StartRewrite()
+    r.writeLock.Unlock()
+    if err != nil {
+        r.Lock()
+        r.aofRewriteStart = time.Time{}
+        r.Unlock()
+        return err
+    }
+    log.Println("RRedis background append only file rewriting started")
+
+    go func() {
+        err := rw.Finish(func(w *aof.Writer) error {
+            return writeDataset(w, snapshot)
+        })
+        r.Lock()
+        r.aofRewriteStart = time.Time{}
+        r.aofLastRewriteDuration = time.Since(start)
+        r.aofLastRewriteErr = err
+        if err == nil {
+            r.aofRewrites++
+        }
+        r.Unlock()
+        if err != nil {
+            log.Printf("RRedis background append only file rewriting failed: %v", err)
+            return
+        }
+        log.Println("RRedis background AOF rewrite finished successfully")
+    }()
+    return nil
+}
+
+// aofRewriteBatch is the maximum number of elements of a list written by a single RPUSH when rewriting the AOF.
+const aofRewriteBatch = 64
+
+// writeDataset writes the commands rebuilding a snapshot of the dataset, one database after the other.
+func writeDataset(w *aof.Writer, snapshot [][]database.Entry) error {
+    for db, entries := range snapshot {
+        if len(entries) == 0 {
+            continue
+        }
+        if err := w.Command("select", strconv.Itoa(db)); err != nil {
+            return err
+        }
+        for _, entry := range entries {
+            if err := writeEntry(w, entry); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// writeEntry writes the commands rebuilding a key.
+func writeEntry(w *aof.Writer, entry database.Entry) error {
+    switch entry.Type {
+    case database.EntryString:
+        args := []string{"set", entry.Key, entry.String}
+        if !entry.ExpireAt.IsZero() {
+            args = append(args, "pxat", strconv.FormatInt(entry.ExpireAt.UnixMilli(), 10))
+        }
+        return w.Command(args...)
+    case database.EntryList:
+        // Long lists are split, so a single command never gets too large.
+        for list := entry.List; len(list) > 0; {
+            n := min(len(list), aofRewriteBatch)
+            if err := w.Command(append([]string{"rpush", entry.Key}, list[:n]...)...); err != nil {
+                return err
+            }
+            list = list[n:]
+        }
+        if !entry.ExpireAt.IsZero() {
+            return w.Command("pexpireat", entry.Key, strconv.FormatInt(entry.ExpireAt.UnixMilli(), 10))
+        }
+    }
+    return nil
+}
+
+// aofRewriteCron rewrites the AOF once it grew enough since the last rewrite, see SetAOFAutoRewrite, until the server is closed.
+func (r *RedisServer) aofRewriteCron() {
+    ticker := time.NewTicker(100 * time.Millisecond)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-r.done:
+            return
+        case <-ticker.C:
+            r.RLock()
+            percentage, minSize := r.aofRewritePercentage, r.aofRewriteMinSize
+            r.RUnlock()
+            if !r.aof.RewriteNeeded(percentage, minSize) {
+                continue
+            }
+            log.Println("RRedis starting automatic rewriting of AOF")
+            r.commandLock.RLock()
+            err := r.startAOFRewrite()
+            r.commandLock.RUnlock()
+            if err != nil && !errors.Is(err, aof.ErrRewriteInProgress) && !errors.Is(err, errBgsaveInProgress) {
+                log.Printf("RRedis automatic rewriting of AOF failed: %v", err)
+            }
+        }
+    }
+}
+
+// call executes a command and appends it to the AOF, if enabled and the command modified the dataset.
+func (r *RedisServer) call(c *client, robj *redisObject.RObj) []byte {
+    if r.closed.Load() {
+        // Waited for the command lock while the server was shutting down, the dataset is already saved.
+        r.rejectCall(commandName(robj))
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR Server is shutting down")
+    }
+    db := c.db
+    start := time.Now()
+    response := r.execute(c, robj)
+    r.recordCall(commandName(robj), start, response)
+    if r.aof != nil && isWriteCommand(robj.Command) && !isError(response) {
+        r.propagate(db, aofArgs(robj)...)
+    }
+    return response
+}
+
+// propagate appends a command executed against the database db to the AOF.
+func (r *RedisServer) propagate(db int, args ...string) {
+    if err := r.aof.Append(db, args...); err != nil {
+        log.Printf("RRedis error writing to the AOF: %v", err)
+    }
+}
+
+// isWriteCommand reports whether a command may modify the dataset.
+func isWriteCommand(command string) bool {
+    switch command {
+    case "set", "del", "incr", "decr", "lpush", "rpush", "move", "pexpireat", "swapdb", "flushdb", "flushall":
+        return true
+    }
+    return false
+}
+
+// isError reports whether a response is an error.
+func isError(response []byte) bool {
+    return len(response) > 0 && response[0] == redisObject.SimpleErrors[0]
+}
+
+// aofArgs returns the arguments of robj as appended to the AOF.
+// A relative time to live is converted to an absolute one, so keys don't live longer when the AOF is replayed later.
+func aofArgs(robj *redisObject.RObj) []string {
+    args := append([]string{robj.Command}, robj.Content...)
+    if robj.Command == "set" && robj.TimeToLive != 0 {
+        at := time.Now().Add(robj.TimeToLive).UnixMilli()
+        args = append(args[:3], "pxat", strconv.FormatInt(at, 10))
+    }
+    return args
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+    "MyOwnRedis/internal/aof"
+    "MyOwnRedis/internal/database/inMemoryDatabase"
+    "MyOwnRedis/internal/redisObject"
+    "errors"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var (
+    errBgsaveInProgress  = errors.New("background save already in progress")
+    ErrInvalidSavePoints = errors.New("save points must be pairs of positive seconds and changes")
+)
+
+// savePoint triggers a background save once changes keys were modified within period.
+type savePoint struct {
+    period  time.Duration
+    changes int
+}
+
+// defaultSavePoints are the save points of the server unless configured otherwise: `save "900 1 300 100"`.
+var defaultSavePoints = []savePoint{{900 * time.Second, 1}, {300 * time.Second, 100}}
+
+// parseSavePoints parses the value of save: pairs of seconds and changes, e.g. `900 1 300 100`. An empty value disables saves.
+func parseSavePoints(s string) ([]savePoint, error) {
+    fields := strings.Fields(s)
+    if len(fields)%2 != 0 {
+        return nil, ErrInvalidSavePoints
+    }
+    points := make([]savePoint, 0, len(fields)/2)
+    for i := 0; i < len(fields); i += 2 {
+        seconds, err := strconv.Atoi(fields[i])
+        if err != nil || seconds < 1 {
+            return nil, ErrInvalidSavePoints
+        }
+        changes, err := strconv.Atoi(fields[i+1])
+        if err != nil || changes < 0 {
+            return nil, ErrInvalidSavePoints
+        }
+        points = append(points, savePoint{period: time.Duration(seconds) * time.Second, changes: changes})
+    }
+    return points, nil
+}
+
+// formatSavePoints formats save points as the value of save.
+func formatSavePoints(points []savePoint) string {
+    fields := make([]string, 0, 2*len(points))
+    for _, point := range points {
+        fields = append(fields, strconv.Itoa(int(point.period.Seconds())), strconv.Itoa(point.changes))
+    }
+    return strings.Join(fields, " ")
+}
+
+// bgsave handles `BGSAVE [SCHEDULE]`.
+// With SCHEDULE, a save requested while the AOF is being rewritten starts once the rewrite is over instead of failing.
+func (r *RedisServer) bgsave(args []string) []byte {
+    schedule := false
+    if len(args) > 1 || len(args) == 1 && !strings.EqualFold(args[0], "schedule") {
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+    } else if len(args) == 1 {
+        schedule = true
+    }
+
+    err := r.startBgsave()
+    switch {
+    case errors.Is(err, aof.ErrRewriteInProgress) && schedule:
+        r.Lock()
+        r.bgsaveScheduled = true
+        r.Unlock()
+        return redisObject.Serialize(redisObject.SimpleStrings, "Background saving scheduled")
+    case errors.Is(err, aof.ErrRewriteInProgress):
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR Another child process is active (AOF?): can't BGSAVE right now. "+
+            "Use BGSAVE SCHEDULE in order to schedule a BGSAVE whenever possible.")
+    case err != nil:
+        return saveErrorReply(err)
+    }
+    return redisObject.Serialize(redisObject.SimpleStrings, "Background saving started")
+}
+
+// saveErrorReply returns the reply to SAVE or BGSAVE failing with err.
+func saveErrorReply(err error) []byte {
+    switch {
+    case errors.Is(err, errBgsaveInProgress):
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR Background save already in progress")
+    case errors.Is(err, inMemoryDatabase.ErrPersistenceDisabled):
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR Persistence is disabled, the server runs in memory only")
+    }
+    return redisObject.Serialize(redisObject.SimpleErrors, "ERR "+err.Error())
+}
+
+// startBgsave saves the databases in the background, from a copy-on-write snapshot so commands keep on being served meanwhile.
+// Callers must hold the command lock, so the snapshot isn't taken in the middle of a transaction.
+// A single background job runs at a time, aof.ErrRewriteInProgress is returned while the AOF is being rewritten.
+func (r *RedisServer) startBgsave() error {
+    start := time.Now()
+    r.Lock()
+    if r.closed.Load() {
+        r.Unlock()
+        return ErrServerClosed
+    }
+    if !r.bgsaveStart.IsZero() {
+        r.Unlock()
+        return errBgsaveInProgress
+    }
+    if !r.aofRewriteStart.IsZero() {
+        r.Unlock()
+        return aof.ErrRewriteInProgress
+    }
+    r.bgsaveStart = start
+    r.lastBgsaveTry = start
+    r.bgsaveScheduled = false
+    dirty := r.dirty.Load()
+    r.Unlock()
+
+    save, err := r.store.BeginSave()
+    if err != nil {
+        r.Lock()
+        r.bgsaveStart = time.Time{}
+        r.lastBgsaveErr = err
+        r.Unlock()
+        return err
+    }
+    log.Println("RRedis background saving started")
+    go func() {
+        err := save()
+        r.Lock()
+        r.bgsaveStart = time.Time{}
+        r.lastBgsaveDuration = time.Since(start)
+        r.lastBgsaveErr = err
+        if err == nil {
+            r.lastSave = start
+            r.lastSaveDirty = dirty
+            r.rdbSaves++
+        }
+        r.Unlock()
+
+        if err != nil {
+            log.Printf("RRedis background saving failed: %v", err)
+            return
+        }
+        log.Println("RRedis background saving terminated with success")
+    }()
+    return nil
+}
+
+// saveDatabase handles `SAVE`, saving the databases before replying.
+func (r *RedisServer) saveDatabase() error {
+    r.RLock()
+    inProgress := !r.bgsaveStart.IsZero()
+    dirty := r.dirty.Load()
+    r.RUnlock()
+    if inProgress {
+        return errBgsaveInProgress
+    }
+
+    start := time.Now()
+    if err := r.store.SaveDatabase(); err != nil {
+        return err
+    }
+    r.Lock()
+    r.lastSave = start
+    r.lastSaveDirty = dirty
+    r.rdbSaves++
+    r.Unlock()
+    return nil
+}
+
+// bgsaveRetryDelay is the delay before a save point triggers another background save after a failed one.
+const bgsaveRetryDelay = 5 * time.Second
+
+// saveCron starts a background save if a save point is reached: at least as many keys changed as the save point requires,
+// and its period elapsed, since the last successful save.
+func (r *RedisServer) saveCron(now time.Time) {
+    r.RLock()
+    // Nothing can be saved with persistence disabled, the save points don't apply.
+    if r.storeConfig.InMemory {
+        r.RUnlock()
+        return
+    }
+    changes := r.dirty.Load() - r.lastSaveDirty
+    reached := false
+    for _, point := range r.savePoints {
+        if changes >= int64(point.changes) && now.Sub(r.lastSave) >= point.period {
+            reached = true
+            break
+        }
+    }
+    // Don't retry a failed save right away, e.g. on a full disk.
+    retry := r.lastBgsaveErr == nil || now.Sub(r.lastBgsaveTry) >= bgsaveRetryDelay
+    r.RUnlock()
+    if !reached || !retry {
+        return
+    }
+
+    r.commandLock.RLock()
+    err := r.startBgsave()
+    r.commandLock.RUnlock()
+    if err != nil && !errors.Is(err, inMemoryDatabase.ErrPersistenceDisabled) && !errors.Is(err, errBgsaveInProgress) && !errors.Is(err, aof.ErrRewriteInProgress) {
+        log.Printf("RRedis background saving failed: %v", err)
+    }
+}
+
+// nextSaveIn returns the number of seconds before a save point triggers a background save, given the changes
+// since the last save, -1 if no save point has enough changes yet.
+func nextSaveIn(points []savePoint, changes int64, lastSave time.Time) int {
+    next := -1
+    for _, point := range points {
+        if changes < int64(point.changes) {
+            continue
+        }
+        in := max(int(time.Until(lastSave.Add(point.period)).Seconds()), 0)
+        if next == -1 || in < next {
+            next = in
+        }
+    }
+    return next
+}
+
+// backgroundJobsCron starts the background saves of the save points, and the background save or AOF rewrite
+// scheduled while another job was running, until the server is closed.
+func (r *RedisServer) backgroundJobsCron() {
+    ticker := time.NewTicker(100 * time.Millisecond)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-r.done:
+            return
+        case now := <-ticker.C:
+            r.RLock()
+            bgsave, rewrite := r.bgsaveScheduled, r.aofRewriteScheduled && r.aof != nil
+            idle := r.bgsaveStart.IsZero() && r.aofRewriteStart.IsZero()
+            r.RUnlock()
+            if !idle {
+                continue
+            }
+            if !bgsave && !rewrite {
+                r.saveCron(now)
+                continue
+            }
+
+            var err error
+            r.commandLock.RLock()
+            if bgsave {
+                err = r.startBgsave()
+            } else if rewrite {
+                err = r.startAOFRewrite()
+            }
+            r.commandLock.RUnlock()
+            if err != nil && !errors.Is(err, errBgsaveInProgress) && !errors.Is(err, aof.ErrRewriteInProgress) {
+                log.Printf("RRedis scheduled background job failed: %v", err)
+            }
+        }
+    }
+}
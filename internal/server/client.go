@@ -0,0 +1,551 @@
+package server
+
+import (
+    "MyOwnRedis/internal/acl"
+    "MyOwnRedis/internal/database"
+    "MyOwnRedis/internal/redisObject"
+    "crypto/tls"
+    "errors"
+    "log"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// redisVersion is the version of Redis the server is compatible with, as reported to clients.
+const redisVersion = "7.2.0"
+
+// client holds the state of a single client connection.
+type client struct {
+    // id is the unique id of the client, never reused.
+    id   uint64
+    conn net.Conn
+    // resp is the protocol version of the connection, 2 unless switched with HELLO.
+    // It is only changed by the client itself, under outLock.
+    resp int
+    // db is the index of the database selected with SELECT.
+    db int
+    // user is the user the client is authenticated as, once authenticated is set, see AUTH.
+    user          string
+    authenticated bool
+
+    // multi is set between MULTI and EXEC or DISCARD, while commands are queued instead of executed.
+    multi bool
+    // multiError is set when a command failed to be queued, which aborts the transaction on EXEC.
+    multiError bool
+    queue      []*redisObject.RObj
+    // watched are the keys watched with WATCH, along with their version at the time.
+    watched []watchedKey
+
+    // channels, patterns and shardChannels are the subscriptions of the client, guarded by the pubSub lock.
+    channels      map[string]struct{}
+    patterns      map[string]struct{}
+    shardChannels map[string]struct{}
+
+    // tracking holds the options of CLIENT TRACKING, nil if tracking is off.
+    // It is only changed by the client itself, under the tracking lock.
+    tracking *trackingOptions
+    // caching is set by CLIENT CACHING for the next command, or the next transaction.
+    caching bool
+
+    // out buffers the replies and pushed messages waiting to be written to the connection by writeLoop,
+    // so neither the server nor a publisher ever blocks on a slow peer.
+    out     []byte
+    outLock sync.Mutex
+    // outReady signals writeLoop that out isn't empty.
+    outReady chan struct{}
+    // outputLimit returns the output buffer limit of a class of clients, nil if the output isn't limited.
+    outputLimit func(class clientClass) outputBufferLimit
+    // softLimitReached is the time out exceeded the soft limit, zero if it is below.
+    // outDropped is set once the output buffer limit is exceeded. Both are guarded by outLock.
+    softLimitReached time.Time
+    outDropped       bool
+    // netOutput counts the bytes written to every client, nil if they aren't counted.
+    netOutput *atomic.Int64
+    // created is the time the client connected.
+    created time.Time
+    // info is the state of the client reported by CLIENT LIST, see recordCommand.
+    info     clientInfo
+    infoLock sync.Mutex
+    // killed is set by CLIENT KILL.
+    killed atomic.Bool
+    // replyOff, replySkip and replySkipNext are set by CLIENT REPLY, see reply.
+    replyOff, replySkip, replySkipNext bool
+
+    // done is closed when the connection is closed, flushed once writeLoop wrote the remaining output and returned.
+    done      chan struct{}
+    flushed   chan struct{}
+    closeOnce sync.Once
+}
+
+// watchedKey is a key watched by a client.
+type watchedKey struct {
+    db      database.MemDb
+    key     string
+    version uint64
+}
+
+// newClient creates the state of a new connection, with database 0 selected.
+func newClient(id uint64, conn net.Conn) *client {
+    now := time.Now()
+    return &client{
+        id:            id,
+        created:       now,
+        info:          clientInfo{lastInteraction: now, multi: -1, user: acl.DefaultUser},
+        user:          acl.DefaultUser,
+        conn:          conn,
+        resp:          2,
+        channels:      make(map[string]struct{}),
+        patterns:      make(map[string]struct{}),
+        shardChannels: make(map[string]struct{}),
+        outReady:      make(chan struct{}, 1),
+        done:          make(chan struct{}),
+        flushed:       make(chan struct{}),
+    }
+}
+
+// write queues data to be written to the connection. It never blocks.
+func (c *client) write(data []byte) {
+    if len(data) == 0 {
+        return
+    }
+
+    var limit outputBufferLimit
+    if c.outputLimit != nil {
+        limit = c.outputLimit(c.class())
+    }
+    c.outLock.Lock()
+    if c.outDropped {
+        c.outLock.Unlock()
+        return
+    }
+    c.out = append(c.out, data...)
+    size := int64(len(c.out))
+    if c.overLimit(size, limit, time.Now()) {
+        // The output is dropped, nothing is written to the connection anymore.
+        c.out, c.outDropped = nil, true
+        c.outLock.Unlock()
+        c.closeForOutputLimit(size)
+        return
+    }
+    c.outLock.Unlock()
+
+    // Wake writeLoop up, unless it is already signaled.
+    select {
+    case c.outReady <- struct{}{}:
+    default:
+    }
+}
+
+// pushMessage is a message pushed to clients out of band, e.g. a published message, serialized for both protocols:
+// as an array for RESP2 clients and as a push for RESP3 clients.
+type pushMessage struct {
+    resp2, resp3 []byte
+}
+
+// newPushMessage serializes a message made of bulk strings pushed to clients.
+func newPushMessage(elements ...string) pushMessage {
+    bulks := make([][]byte, 0, len(elements))
+    for _, ele := range elements {
+        bulks = append(bulks, redisObject.Serialize(redisObject.BulkStrings, ele))
+    }
+    return pushMessage{resp2: redisObject.SerializeArray(bulks...), resp3: redisObject.SerializePush(bulks...)}
+}
+
+// writePush queues a message pushed out of band in the protocol of the client. It never blocks.
+// A message without RESP2 encoding can't be received by RESP2 clients and is dropped for them.
+func (c *client) writePush(m pushMessage) {
+    c.outLock.Lock()
+    data := m.resp2
+    if c.resp == 3 {
+        data = m.resp3
+    }
+    c.outLock.Unlock()
+    c.write(data)
+}
+
+// encodePush serializes a push replied to the client itself, e.g. a subscription confirmation.
+func (c *client) encodePush(elements ...[]byte) []byte {
+    if c.resp == 3 {
+        return redisObject.SerializePush(elements...)
+    }
+    return redisObject.SerializeArray(elements...)
+}
+
+// encodeMap serializes a map replied to the client, flattened into an array for RESP2 clients.
+func (c *client) encodeMap(pairs ...[]byte) []byte {
+    if c.resp == 3 {
+        return redisObject.SerializeMap(pairs...)
+    }
+    return redisObject.SerializeArray(pairs...)
+}
+
+// setProtocol switches the protocol of the connection.
+func (c *client) setProtocol(resp int) {
+    c.outLock.Lock()
+    c.resp = resp
+    c.outLock.Unlock()
+}
+
+// flushTimeout bounds the time spent writing the remaining output of a closed client,
+// writeTimeout the time spent writing the output of a connected one: a peer not reading for that long is disconnected.
+const (
+    flushTimeout = time.Second
+    writeTimeout = 30 * time.Second
+)
+
+// writeLoop writes the buffered output to the connection until the client is closed, then writes what remains.
+// It closes the connection if a write fails, which also terminates the read loop.
+func (c *client) writeLoop() {
+    defer close(c.flushed)
+    for {
+        select {
+        case <-c.done:
+            c.outLock.Lock()
+            data := c.out
+            c.out = nil
+            c.outLock.Unlock()
+            if len(data) > 0 {
+                // The peer may not read anymore.
+                _ = c.conn.SetWriteDeadline(time.Now().Add(flushTimeout))
+                n, _ := c.conn.Write(data)
+                c.countOutput(n)
+            }
+            return
+        case <-c.outReady:
+            c.outLock.Lock()
+            data := c.out
+            c.out = nil
+            c.outLock.Unlock()
+
+            // A peer not reading anymore would block writeLoop forever.
+            _ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+            n, err := c.conn.Write(data)
+            c.countOutput(n)
+            if err != nil {
+                _ = c.conn.Close()
+                return
+            }
+        }
+    }
+}
+
+// countOutput counts n bytes written to the client, see INFO stats.
+func (c *client) countOutput(n int) {
+    if c.netOutput != nil {
+        c.netOutput.Add(int64(n))
+    }
+}
+
+// subscriptions returns the number of channels and patterns the client is subscribed to.
+func (c *client) subscriptions() int {
+    return len(c.channels) + len(c.patterns)
+}
+
+// subscribed reports whether the client is in the subscribed mode, having at least one subscription of any kind.
+func (c *client) subscribed() bool {
+    return c.subscriptions()+len(c.shardChannels) > 0
+}
+
+// queueCommand queues a command of a transaction.
+func (c *client) queueCommand(robj *redisObject.RObj) []byte {
+    c.queue = append(c.queue, robj)
+    return redisObject.Serialize(redisObject.SimpleStrings, "QUEUED")
+}
+
+// flagTransactionError marks the current transaction, if any, as failed.
+func (c *client) flagTransactionError() {
+    if c.multi {
+        c.multiError = true
+    }
+}
+
+// resetTransaction leaves the transaction state and drops the queued commands.
+func (c *client) resetTransaction() {
+    c.multi = false
+    c.multiError = false
+    c.queue = nil
+}
+
+// unwatchAll stops watching every key watched by the client.
+func (c *client) unwatchAll() {
+    for _, w := range c.watched {
+        w.db.Unwatch(w.key)
+    }
+    c.watched = nil
+}
+
+// close releases the resources held by the client once the connection is closed.
+func (c *client) close() {
+    c.unwatchAll()
+    c.closeOnce.Do(func() {
+        close(c.done)
+    })
+}
+
+// ErrMaxClients is returned by registerClient when maxclients clients are already connected.
+var ErrMaxClients = errors.New("error max number of clients reached")
+
+// registerClient creates the state of a new connection and registers it, so it can be found by id, and counts it in conns.
+// ErrMaxClients is returned when maxclients clients are already connected, and ErrServerClosed once the server is shut down,
+// the connection must then be closed.
+func (r *RedisServer) registerClient(conn net.Conn) (*client, error) {
+    r.RLock()
+    maxClients := r.maxClients
+    r.RUnlock()
+
+    c := newClient(r.nextClientID.Add(1), conn)
+    c.outputLimit = r.outputBufferLimit
+    c.netOutput = &r.stats.netOutput
+    // Unless the default user requires a password, clients are authenticated as the default user when they connect.
+    c.authenticated = r.acl.NoPass(acl.DefaultUser)
+
+    r.clientsLock.Lock()
+    defer r.clientsLock.Unlock()
+    // shutdown disconnects the registered clients under this lock once closed is set: a client registered meanwhile is
+    // disconnected by it, and none is registered afterwards, nor counted while conns is being waited for.
+    if r.closed.Load() {
+        return nil, ErrServerClosed
+    }
+    // Checked along with the insert, as the listeners accept connections concurrently.
+    if len(r.clients) >= maxClients {
+        return nil, ErrMaxClients
+    }
+    r.clients[c.id] = c
+    r.conns.Add(1)
+    return c, nil
+}
+
+// acceptClient registers the client of a new connection, unless maxclients clients are already connected:
+// the connection is then closed with an error. It is closed as well once the server is shut down.
+func (r *RedisServer) acceptClient(conn net.Conn) (*client, bool) {
+    c, err := r.registerClient(conn)
+    if errors.Is(err, ErrMaxClients) {
+        r.stats.rejectedConnections.Add(1)
+        _ = conn.SetWriteDeadline(time.Now().Add(flushTimeout))
+        _, _ = conn.Write(redisObject.Serialize(redisObject.SimpleErrors, "ERR max number of clients reached"))
+    }
+    if err != nil {
+        _ = conn.Close()
+        return nil, false
+    }
+    r.stats.connections.Add(1)
+    r.setKeepAlive(conn)
+    return c, true
+}
+
+// setKeepAlive sets the TCP keepalive of a new connection as configured by tcp-keepalive, so dead peers are detected.
+func (r *RedisServer) setKeepAlive(conn net.Conn) {
+    if tlsConn, ok := conn.(*tls.Conn); ok {
+        conn = tlsConn.NetConn()
+    }
+    tc, ok := conn.(*net.TCPConn)
+    if !ok {
+        return
+    }
+    r.RLock()
+    period := r.tcpKeepAlive
+    r.RUnlock()
+    if period == 0 {
+        _ = tc.SetKeepAlive(false)
+        return
+    }
+    _ = tc.SetKeepAlive(true)
+    _ = tc.SetKeepAlivePeriod(period)
+}
+
+// readDeadline returns the deadline of the next request of a client, before which it is disconnected as idle.
+// Subscribed clients only receive messages and are never idle, the zero time is returned for them or when timeout is 0.
+func (r *RedisServer) readDeadline(c *client) time.Time {
+    r.RLock()
+    timeout := r.idleTimeout
+    r.RUnlock()
+    if timeout == 0 || c.subscribed() {
+        return time.Time{}
+    }
+    c.infoLock.Lock()
+    defer c.infoLock.Unlock()
+    return c.info.lastInteraction.Add(timeout)
+}
+
+// idle reports whether a client has been idle for longer than timeout.
+func (r *RedisServer) idle(c *client) bool {
+    deadline := r.readDeadline(c)
+    if deadline.IsZero() || time.Now().Before(deadline) {
+        return false
+    }
+    log.Printf("RRedis closing idle client id=%d addr=%s", c.id, c.addr())
+    return true
+}
+
+// refreshReadDeadlines wakes the clients waiting for a request up, so their read deadline is computed again.
+func (r *RedisServer) refreshReadDeadlines() {
+    r.clientsLock.RLock()
+    defer r.clientsLock.RUnlock()
+    for _, c := range r.clients {
+        if c.conn != nil {
+            _ = c.conn.SetReadDeadline(time.Now())
+        }
+    }
+}
+
+// isTimeout reports whether err is a timeout, e.g. a read deadline reached.
+func isTimeout(err error) bool {
+    var ne net.Error
+    return errors.As(err, &ne) && ne.Timeout()
+}
+
+// unregisterClient removes a closed client from the registry.
+func (r *RedisServer) unregisterClient(c *client) {
+    r.clientsLock.Lock()
+    defer r.clientsLock.Unlock()
+    delete(r.clients, c.id)
+}
+
+// lookupClient returns the connected client with the given id, nil if there's none.
+func (r *RedisServer) lookupClient(id uint64) *client {
+    r.clientsLock.RLock()
+    defer r.clientsLock.RUnlock()
+    return r.clients[id]
+}
+
+// hello handles `HELLO [protover [AUTH username password] [SETNAME clientname]]`, authenticating the client and switching the protocol
+// of the connection, then replies with the properties of the server.
+func (r *RedisServer) hello(c *client, args []string) []byte {
+    protover := 0
+    if len(args) > 0 {
+        var err error
+        protover, err = strconv.Atoi(args[0])
+        if err != nil {
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR Protocol version is not an integer or out of range")
+        }
+        if protover != 2 && protover != 3 {
+            return redisObject.Serialize(redisObject.SimpleErrors, "NOPROTO unsupported protocol version")
+        }
+    }
+
+    var credentials []string
+    name, setName := "", false
+    for i := 1; i < len(args); i++ {
+        switch option := strings.ToLower(args[i]); {
+        case option == "auth" && i+2 < len(args):
+            credentials = args[i+1 : i+3]
+            i += 2
+        case option == "setname" && i+1 < len(args):
+            name, setName = args[i+1], true
+            i++
+        default:
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR Syntax error in HELLO option '"+args[i]+"'")
+        }
+    }
+
+    if credentials == nil && !c.authenticated {
+        return redisObject.Serialize(redisObject.SimpleErrors, "NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+    }
+    if credentials != nil && !r.authenticate(c, credentials[0], credentials[1]) {
+        return redisObject.Serialize(redisObject.SimpleErrors, "WRONGPASS invalid username-password pair or user is disabled.")
+    }
+    if setName {
+        if response := c.setName(name); isError(response) {
+            return response
+        }
+    }
+    if protover != 0 {
+        c.setProtocol(protover)
+    }
+
+    return c.encodeMap(
+        redisObject.Serialize(redisObject.BulkStrings, "server"),
+        redisObject.Serialize(redisObject.BulkStrings, "redis"),
+        redisObject.Serialize(redisObject.BulkStrings, "version"),
+        redisObject.Serialize(redisObject.BulkStrings, redisVersion),
+        redisObject.Serialize(redisObject.BulkStrings, "proto"),
+        redisObject.Serialize(redisObject.Integers, strconv.Itoa(c.resp)),
+        redisObject.Serialize(redisObject.BulkStrings, "id"),
+        redisObject.Serialize(redisObject.Integers, strconv.FormatUint(c.id, 10)),
+        redisObject.Serialize(redisObject.BulkStrings, "mode"),
+        redisObject.Serialize(redisObject.BulkStrings, "standalone"),
+        redisObject.Serialize(redisObject.BulkStrings, "role"),
+        redisObject.Serialize(redisObject.BulkStrings, "master"),
+        redisObject.Serialize(redisObject.BulkStrings, "modules"),
+        redisObject.SerializeArray(),
+    )
+}
+
+// clientCommand handles `CLIENT ID | INFO | LIST ... | SETNAME name | GETNAME | KILL ... | PAUSE timeout [WRITE|ALL] | UNPAUSE |
+// NO-EVICT ON|OFF | REPLY ON|OFF|SKIP | GETREDIR | TRACKING ... | TRACKINGINFO | CACHING YES|NO`.
+func (r *RedisServer) clientCommand(c *client, args []string) []byte {
+    subcommand := strings.ToLower(args[0])
+    switch {
+    case subcommand == "id" && len(args) == 1:
+        return redisObject.Serialize(redisObject.Integers, strconv.FormatUint(c.id, 10))
+
+    case subcommand == "info" && len(args) == 1:
+        return redisObject.Serialize(redisObject.BulkStrings, r.clientInfoLine(c)+"\n")
+
+    case subcommand == "list":
+        return r.clientList(args[1:])
+
+    case subcommand == "setname" && len(args) == 2:
+        return c.setName(args[1])
+
+    case subcommand == "getname" && len(args) == 1:
+        return c.getName()
+
+    case subcommand == "kill" && len(args) >= 2:
+        return r.clientKill(c, args[1:])
+
+    case subcommand == "pause" && (len(args) == 2 || len(args) == 3):
+        return r.clientPause(args[1:])
+
+    case subcommand == "unpause" && len(args) == 1:
+        return r.clientUnpause()
+
+    case subcommand == "no-evict" && len(args) == 2:
+        // There is no eviction, the flag is only reported by CLIENT LIST.
+        noEvict, err := parseOnOff(args[1])
+        if err != nil {
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+        }
+        c.infoLock.Lock()
+        c.info.noEvict = noEvict
+        c.infoLock.Unlock()
+        return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+
+    case subcommand == "reply" && len(args) == 2:
+        // Nothing is replied to OFF and SKIP.
+        switch strings.ToLower(args[1]) {
+        case "on":
+            c.replyOff, c.replySkip, c.replySkipNext = false, false, false
+            return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+        case "off":
+            c.replyOff = true
+            return nil
+        case "skip":
+            c.replySkipNext = true
+            return nil
+        }
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+
+    case subcommand == "getredir" && len(args) == 1:
+        redirect := "-1"
+        if c.tracking != nil {
+            redirect = strconv.FormatUint(c.tracking.redirect, 10)
+        }
+        return redisObject.Serialize(redisObject.Integers, redirect)
+
+    case subcommand == "tracking" && len(args) >= 2:
+        return r.clientTracking(c, args[1:])
+
+    case subcommand == "trackinginfo" && len(args) == 1:
+        return r.trackingInfo(c)
+
+    case subcommand == "caching" && len(args) == 2:
+        return clientCaching(c, args[1])
+    }
+
+    return redisObject.Serialize(redisObject.SimpleErrors, "ERR unknown subcommand or wrong number of arguments for '"+args[0]+"'. Try CLIENT HELP.")
+}
@@ -0,0 +1,456 @@
+package server
+
+import (
+    "MyOwnRedis/internal/acl"
+    "MyOwnRedis/internal/redisObject"
+    "cmp"
+    "fmt"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// clientInfo is the state of a client reported by CLIENT LIST, guarded by the info lock of the client.
+type clientInfo struct {
+    name string
+    // lastCommand and lastInteraction are the name and the time of the last command, see recordCommand.
+    lastCommand     string
+    lastInteraction time.Time
+    // db and multi are copied from the client after every command, so other clients can read them.
+    db int
+    // multi is the number of commands queued in a transaction, -1 outside of transactions.
+    multi   int
+    noEvict bool
+    // pubsub is set while the client is subscribed, see clientClass.
+    pubsub bool
+    // user is the user the client is authenticated as.
+    user string
+}
+
+// clientPause is the state of CLIENT PAUSE.
+type clientPause struct {
+    end time.Time
+    // writeOnly only pauses the write commands.
+    writeOnly bool
+    // unpaused is closed by CLIENT UNPAUSE.
+    unpaused chan struct{}
+}
+
+// recordCommand records the command being executed by the client, as reported by CLIENT LIST.
+func (c *client) recordCommand(name string) {
+    c.infoLock.Lock()
+    c.info.lastCommand = name
+    c.info.lastInteraction = time.Now()
+    c.infoLock.Unlock()
+    c.syncInfo()
+}
+
+// syncInfo copies the state of the client changed by its commands, e.g. the database selected, to its info.
+func (c *client) syncInfo() {
+    multi := -1
+    if c.multi {
+        multi = len(c.queue)
+    }
+    pubsub := c.subscribed()
+
+    c.infoLock.Lock()
+    defer c.infoLock.Unlock()
+    c.info.db = c.db
+    c.info.multi = multi
+    c.info.pubsub = pubsub
+}
+
+// kill closes the connection of the client, once the reply being executed, if any, is written.
+func (c *client) kill() {
+    c.killed.Store(true)
+    if c.conn != nil {
+        // Unblocks the read loop, which closes the connection.
+        _ = c.conn.SetReadDeadline(time.Now())
+    }
+}
+
+// reply queues the response to a command, unless replies are turned off with CLIENT REPLY.
+func (c *client) reply(response []byte) {
+    switch {
+    case c.replySkip:
+        c.replySkip = false
+    case !c.replyOff:
+        c.write(response)
+    }
+    // CLIENT REPLY SKIP skips the reply to the command following it.
+    if c.replySkipNext {
+        c.replySkipNext = false
+        c.replySkip = true
+    }
+}
+
+// commandName returns the name of a command as reported by CLIENT LIST, with its subcommand if any, e.g. `client|list`.
+func commandName(robj *redisObject.RObj) string {
+    return acl.CommandName(robj.Command, robj.Content)
+}
+
+// clientType returns the type of a client, as filtered by CLIENT LIST and CLIENT KILL: pubsub or normal.
+func (r *RedisServer) clientType(c *client) string {
+    r.pubsub.RLock()
+    defer r.pubsub.RUnlock()
+    if c.subscribed() {
+        return "pubsub"
+    }
+    return "normal"
+}
+
+// clientInfoLine formats the state of a client as a line of CLIENT LIST, without the line ending.
+func (r *RedisServer) clientInfoLine(c *client) string {
+    c.infoLock.Lock()
+    info := c.info
+    c.infoLock.Unlock()
+
+    r.pubsub.RLock()
+    sub, psub, ssub := len(c.channels), len(c.patterns), len(c.shardChannels)
+    r.pubsub.RUnlock()
+
+    r.tracking.Lock()
+    tracking := c.tracking
+    redirect := int64(-1)
+    if tracking != nil && tracking.redirect != 0 {
+        redirect = int64(tracking.redirect)
+    }
+    r.tracking.Unlock()
+
+    c.outLock.Lock()
+    resp, omem := c.resp, len(c.out)
+    c.outLock.Unlock()
+
+    var flags string
+    if sub+psub+ssub > 0 {
+        flags += "P"
+    }
+    if info.multi >= 0 {
+        flags += "x"
+    }
+    if tracking != nil {
+        flags += "t"
+    }
+    if info.noEvict {
+        flags += "e"
+    }
+    if c.killed.Load() {
+        flags += "A"
+    }
+    if c.unixSocket() {
+        flags += "U"
+    }
+    if flags == "" {
+        flags = "N"
+    }
+
+    now := time.Now()
+    return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d ssub=%d multi=%d omem=%d cmd=%s user=%s redir=%d resp=%d",
+        c.id, c.addr(), c.localAddr(), info.name, int(now.Sub(c.created).Seconds()), int(now.Sub(info.lastInteraction).Seconds()),
+        flags, info.db, sub, psub, ssub, info.multi, omem, info.lastCommand, info.user, redirect, resp)
+}
+
+// addr returns the address of the peer of the client, empty if it isn't connected to a network, e.g. while loading the AOF.
+// The peers of a unix socket being unnamed, the address of the socket is returned for them, as with Redis.
+func (c *client) addr() string {
+    if c.conn == nil {
+        return ""
+    }
+    if c.unixSocket() {
+        return c.localAddr()
+    }
+    return c.conn.RemoteAddr().String()
+}
+
+// localAddr returns the local address of the connection of the client: the path of the socket, followed by :0, for a unix socket.
+func (c *client) localAddr() string {
+    if c.conn == nil {
+        return ""
+    }
+    if c.unixSocket() {
+        return c.conn.LocalAddr().String() + ":0"
+    }
+    return c.conn.LocalAddr().String()
+}
+
+// unixSocket tells whether the client is connected to the unix socket.
+func (c *client) unixSocket() bool {
+    return c.conn != nil && c.conn.LocalAddr().Network() == Unix
+}
+
+// connectedClients returns the connected clients, sorted by id.
+func (r *RedisServer) connectedClients() []*client {
+    r.clientsLock.RLock()
+    clients := make([]*client, 0, len(r.clients))
+    for _, c := range r.clients {
+        clients = append(clients, c)
+    }
+    r.clientsLock.RUnlock()
+
+    slices.SortFunc(clients, func(a, b *client) int {
+        return cmp.Compare(a.id, b.id)
+    })
+    return clients
+}
+
+// clientList handles `CLIENT LIST [TYPE normal|master|replica|pubsub] [ID client-id [client-id ...]]`.
+func (r *RedisServer) clientList(args []string) []byte {
+    var clientType string
+    var ids []uint64
+    for i := 0; i < len(args); i++ {
+        switch option := strings.ToLower(args[i]); {
+        case option == "type" && i+1 < len(args):
+            i++
+            clientType = strings.ToLower(args[i])
+            if !validClientType(clientType) {
+                return redisObject.Serialize(redisObject.SimpleErrors, "ERR Unknown client type '"+args[i]+"'")
+            }
+        case option == "id" && i+1 < len(args):
+            for i++; i < len(args); i++ {
+                id, err := strconv.ParseUint(args[i], 10, 64)
+                if err != nil || id == 0 {
+                    return redisObject.Serialize(redisObject.SimpleErrors, "ERR Invalid client ID")
+                }
+                ids = append(ids, id)
+            }
+        default:
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+        }
+    }
+
+    var sb strings.Builder
+    for _, c := range r.connectedClients() {
+        if clientType != "" && r.clientType(c) != clientType || ids != nil && !slices.Contains(ids, c.id) {
+            continue
+        }
+        sb.WriteString(r.clientInfoLine(c) + "\n")
+    }
+    return redisObject.Serialize(redisObject.BulkStrings, sb.String())
+}
+
+// validClientType reports whether t is a client type of CLIENT LIST and CLIENT KILL. The server has no replication,
+// no client is ever of the types master and replica.
+func validClientType(t string) bool {
+    switch t {
+    case "normal", "master", "replica", "slave", "pubsub":
+        return true
+    }
+    return false
+}
+
+// setName handles `CLIENT SETNAME connection-name`. An empty name removes the name of the connection.
+func (c *client) setName(name string) []byte {
+    for i := 0; i < len(name); i++ {
+        if name[i] < '!' || name[i] > '~' {
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR Client names cannot contain spaces, newlines or special characters.")
+        }
+    }
+    c.infoLock.Lock()
+    c.info.name = name
+    c.infoLock.Unlock()
+    return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+}
+
+// getName handles `CLIENT GETNAME`.
+func (c *client) getName() []byte {
+    c.infoLock.Lock()
+    name := c.info.name
+    c.infoLock.Unlock()
+    if name == "" {
+        return redisObject.NullBulkStrings
+    }
+    return redisObject.Serialize(redisObject.BulkStrings, name)
+}
+
+// killFilter selects the clients killed by CLIENT KILL.
+type killFilter struct {
+    id          uint64
+    addr, laddr string
+    user        string
+    clientType  string
+    // skipMe spares the client calling CLIENT KILL, the default.
+    skipMe bool
+}
+
+// matches reports whether c is selected by the filter, self being the client calling CLIENT KILL.
+func (r *RedisServer) matches(f killFilter, c, self *client) bool {
+    switch {
+    case f.skipMe && c == self:
+        return false
+    case f.id != 0 && c.id != f.id:
+        return false
+    case f.addr != "" && c.addr() != f.addr:
+        return false
+    case f.laddr != "" && c.localAddr() != f.laddr:
+        return false
+    case f.user != "" && c.userName() != f.user:
+        return false
+    case f.clientType != "" && r.clientType(c) != f.clientType:
+        return false
+    }
+    return true
+}
+
+// clientKill handles `CLIENT KILL ip:port` and `CLIENT KILL [ID client-id] [ADDR ip:port] [LADDR ip:port] [USER username]
+// [TYPE normal|master|replica|pubsub] [SKIPME yes|no]`. The old form replies OK, the new one the number of clients killed.
+func (r *RedisServer) clientKill(self *client, args []string) []byte {
+    if len(args) == 1 {
+        for _, c := range r.connectedClients() {
+            if c.addr() == args[0] {
+                c.kill()
+                return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+            }
+        }
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR No such client")
+    }
+    if len(args)%2 != 0 {
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+    }
+
+    filter := killFilter{skipMe: true}
+    for i := 0; i < len(args); i += 2 {
+        value := args[i+1]
+        switch strings.ToLower(args[i]) {
+        case "id":
+            id, err := strconv.ParseUint(value, 10, 64)
+            if err != nil || id == 0 {
+                return redisObject.Serialize(redisObject.SimpleErrors, "ERR client-id should be greater than 0")
+            }
+            filter.id = id
+        case "addr":
+            filter.addr = value
+        case "laddr":
+            filter.laddr = value
+        case "user":
+            filter.user = value
+        case "type":
+            filter.clientType = strings.ToLower(value)
+            if !validClientType(filter.clientType) {
+                return redisObject.Serialize(redisObject.SimpleErrors, "ERR Unknown client type '"+value+"'")
+            }
+        case "skipme":
+            skipMe, err := parseYesNo(value)
+            if err != nil {
+                return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+            }
+            filter.skipMe = skipMe
+        default:
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+        }
+    }
+
+    killed := 0
+    for _, c := range r.connectedClients() {
+        if r.matches(filter, c, self) {
+            c.kill()
+            killed++
+        }
+    }
+    return redisObject.Serialize(redisObject.Integers, strconv.Itoa(killed))
+}
+
+// clientPause handles `CLIENT PAUSE timeout [WRITE | ALL]`: the commands of every client, or only the write commands,
+// wait until timeout milliseconds elapsed or CLIENT UNPAUSE is called. Expired keys aren't evicted meanwhile.
+func (r *RedisServer) clientPause(args []string) []byte {
+    timeout, err := strconv.ParseInt(args[0], 10, 64)
+    if err != nil || timeout < 0 {
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR timeout is not an integer or out of range")
+    }
+    writeOnly := false
+    if len(args) == 2 {
+        switch strings.ToLower(args[1]) {
+        case "write":
+            writeOnly = true
+        case "all":
+        default:
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+        }
+    }
+
+    end := time.Now().Add(time.Duration(timeout) * time.Millisecond)
+    r.Lock()
+    defer r.Unlock()
+    if p := r.pause; p != nil && time.Now().Before(p.end) {
+        // The pause lasting the longest and pausing the most commands wins, as with Redis.
+        end = maxTime(end, p.end)
+        writeOnly = writeOnly && p.writeOnly
+        close(p.unpaused)
+    }
+    r.pause = &clientPause{end: end, writeOnly: writeOnly, unpaused: make(chan struct{})}
+    return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+}
+
+// clientUnpause handles `CLIENT UNPAUSE`, resuming the commands paused by CLIENT PAUSE.
+func (r *RedisServer) clientUnpause() []byte {
+    r.Lock()
+    defer r.Unlock()
+    if r.pause != nil {
+        close(r.pause.unpaused)
+        r.pause = nil
+    }
+    return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+}
+
+// paused reports whether the clients are paused, at least their write commands.
+func (r *RedisServer) paused() bool {
+    r.RLock()
+    defer r.RUnlock()
+    return r.pause != nil && time.Now().Before(r.pause.end)
+}
+
+// waitPause waits while the command of c is paused by CLIENT PAUSE.
+// CLIENT commands are never paused, so CLIENT UNPAUSE can be called, nor is SHUTDOWN.
+func (r *RedisServer) waitPause(c *client, robj *redisObject.RObj) {
+    if robj.Command == "client" || robj.Command == "shutdown" {
+        return
+    }
+    write := isWriteCommand(robj.Command) || robj.Command == "exec" && slices.ContainsFunc(c.queue, func(queued *redisObject.RObj) bool {
+        return isWriteCommand(queued.Command)
+    })
+
+    for {
+        r.RLock()
+        p := r.pause
+        r.RUnlock()
+        if p == nil || !time.Now().Before(p.end) || p.writeOnly && !write {
+            return
+        }
+
+        timer := time.NewTimer(time.Until(p.end))
+        select {
+        case <-timer.C:
+        case <-p.unpaused:
+            timer.Stop()
+        case <-r.done:
+            timer.Stop()
+            return
+        }
+    }
+}
+
+// maxTime returns the latest of a and b.
+func maxTime(a, b time.Time) time.Time {
+    if a.After(b) {
+        return a
+    }
+    return b
+}
+
+// parseOnOff parses the ON|OFF argument of a CLIENT subcommand.
+func parseOnOff(s string) (bool, error) {
+    switch strings.ToLower(s) {
+    case "on":
+        return true, nil
+    case "off":
+        return false, nil
+    }
+    return false, ErrInvalidBool
+}
+
+// userName returns the user the client is authenticated as.
+func (c *client) userName() string {
+    c.infoLock.Lock()
+    defer c.infoLock.Unlock()
+    return c.info.user
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+    "MyOwnRedis/internal/acl"
+    "fmt"
+    "math"
+    "math/bits"
+    "slices"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// latencyPercentiles are the percentiles of the latency of each command reported by INFO latencystats.
+var latencyPercentiles = []float64{50, 99, 99.9}
+
+// histogramPrecision is the number of bits of a duration kept by its bucket in a histogram: durations are counted with
+// a relative error of at most 1/2^histogramPrecision, about 3%, whatever their magnitude.
+const histogramPrecision = 5
+
+// histogramBuckets is the number of buckets of a histogram: durations below 2^histogramPrecision nanoseconds have their own
+// bucket, the others share 2^histogramPrecision buckets per power of two.
+const histogramBuckets = (64 - histogramPrecision + 1) << histogramPrecision
+
+// histogram counts durations in buckets of bounded relative width, as HdrHistogram does, so percentiles are computed
+// with a bounded error and in constant memory. It is safe for concurrent use.
+type histogram struct {
+    counts [histogramBuckets]atomic.Int64
+}
+
+// bucket returns the index of the bucket counting the duration of n nanoseconds.
+func bucket(n uint64) int {
+    if n < 1<<histogramPrecision {
+        return int(n)
+    }
+    shift := bits.Len64(n) - 1 - histogramPrecision
+    mantissa := int(n>>shift) & (1<<histogramPrecision - 1)
+    return (shift+1)<<histogramPrecision + mantissa
+}
+
+// bucketMax returns the highest duration, in nanoseconds, counted by the bucket i.
+func bucketMax(i int) uint64 {
+    if i < 1<<histogramPrecision {
+        return uint64(i)
+    }
+    shift := i>>histogramPrecision - 1
+    lowest := uint64(1<<histogramPrecision+i&(1<<histogramPrecision-1)) << shift
+    return lowest + 1<<shift - 1
+}
+
+// add counts a duration.
+func (h *histogram) add(d time.Duration) {
+    h.counts[bucket(uint64(max(d, 0)))].Add(1)
+}
+
+// total returns the number of durations counted.
+func (h *histogram) total() int64 {
+    var total int64
+    for i := range h.counts {
+        total += h.counts[i].Load()
+    }
+    return total
+}
+
+// percentile returns the duration below which p percent of the durations counted are, 0 if none was.
+func (h *histogram) percentile(p float64) time.Duration {
+    total := h.total()
+    if total == 0 {
+        return 0
+    }
+    target := max(int64(math.Ceil(p/100*float64(total))), 1)
+    var seen int64
+    for i := range h.counts {
+        if seen += h.counts[i].Load(); seen >= target {
+            return time.Duration(min(bucketMax(i), math.MaxInt64))
+        }
+    }
+    return math.MaxInt64
+}
+
+// commandStat holds the statistics of a command, see INFO commandstats and latencystats.
+type commandStat struct {
+    // calls counts the executions, failed those replying with an error and rejected the calls refused before
+    // being executed, e.g. for lack of permission.
+    calls, failed, rejected atomic.Int64
+    // duration is the total execution time of the calls.
+    duration atomic.Int64
+    // latency is allocated on the first duration counted, see histogram.
+    latency atomic.Pointer[histogram]
+}
+
+// histogram returns the latency histogram of the command, allocating it if needed.
+func (s *commandStat) histogram() *histogram {
+    if h := s.latency.Load(); h != nil {
+        return h
+    }
+    s.latency.CompareAndSwap(nil, &histogram{})
+    return s.latency.Load()
+}
+
+// commandStats holds the statistics of the commands, by name, created on their first call. Subcommands are named
+// `command|subcommand`. Once created, a statistic is looked up without locking, so recording a call is cheap.
+// Only the commands of acl.CommandName are recorded, so clients can't create statistics at will.
+type commandStats struct {
+    stats sync.Map
+}
+
+// get returns the statistics of a command, creating them on its first call, nil if the command doesn't exist.
+func (s *commandStats) get(name string) *commandStat {
+    if stat, ok := s.stats.Load(name); ok {
+        return stat.(*commandStat)
+    }
+    if !acl.CommandExists(name) {
+        return nil
+    }
+    stat, _ := s.stats.LoadOrStore(name, &commandStat{})
+    return stat.(*commandStat)
+}
+
+// reset drops every statistic, for CONFIG RESETSTAT.
+func (s *commandStats) reset() {
+    s.stats.Range(func(name, _ any) bool {
+        s.stats.Delete(name)
+        return true
+    })
+}
+
+// namedStat is the statistics of a command, along with its name.
+type namedStat struct {
+    name string
+    *commandStat
+}
+
+// sorted returns the statistics of every command, sorted by name.
+func (s *commandStats) sorted() []namedStat {
+    var stats []namedStat
+    s.stats.Range(func(name, stat any) bool {
+        stats = append(stats, namedStat{name.(string), stat.(*commandStat)})
+        return true
+    })
+    slices.SortFunc(stats, func(a, b namedStat) int {
+        return strings.Compare(a.name, b.name)
+    })
+    return stats
+}
+
+// recordCall records a call of a command that started at start and replied with response.
+func (r *RedisServer) recordCall(name string, start time.Time, response []byte) {
+    d := time.Since(start)
+    stat := r.commandStats.get(name)
+    if stat == nil {
+        return
+    }
+    stat.calls.Add(1)
+    stat.duration.Add(int64(d))
+    if isError(response) {
+        stat.failed.Add(1)
+    }
+    if r.latencyTracking.Load() {
+        stat.histogram().add(d)
+    }
+}
+
+// rejectCall records a call of a command refused before being executed.
+func (r *RedisServer) rejectCall(name string) {
+    if stat := r.commandStats.get(name); stat != nil {
+        stat.rejected.Add(1)
+    }
+}
+
+// commandStatsInfo returns the fields of the commandstats section, one per command called.
+func (r *RedisServer) commandStatsInfo() [][2]string {
+    var fields [][2]string
+    for _, stat := range r.commandStats.sorted() {
+        calls, duration := stat.calls.Load(), time.Duration(stat.duration.Load())
+        var perCall float64
+        if calls > 0 {
+            perCall = float64(duration) / float64(time.Microsecond) / float64(calls)
+        }
+        fields = append(fields, [2]string{"cmdstat_" + stat.name, fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+            calls, duration.Microseconds(), perCall, stat.rejected.Load(), stat.failed.Load())})
+    }
+    return fields
+}
+
+// latencyStatsInfo returns the fields of the latencystats section, the latency percentiles of each command executed
+// while latency-tracking was enabled.
+func (r *RedisServer) latencyStatsInfo() [][2]string {
+    var fields [][2]string
+    for _, stat := range r.commandStats.sorted() {
+        latency := stat.latency.Load()
+        if latency == nil {
+            continue
+        }
+        values := make([]string, 0, len(latencyPercentiles))
+        for _, p := range latencyPercentiles {
+            values = append(values, fmt.Sprintf("p%v=%.3f", p, float64(latency.percentile(p))/float64(time.Microsecond)))
+        }
+        fields = append(fields, [2]string{"latency_percentiles_usec_" + stat.name, strings.Join(values, ",")})
+    }
+    return fields
+}
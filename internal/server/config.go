@@ -0,0 +1,858 @@
+package server
+
+import (
+    "MyOwnRedis/internal/acl"
+    "MyOwnRedis/internal/aof"
+    "MyOwnRedis/internal/config"
+    "MyOwnRedis/internal/database/inMemoryDatabase"
+    "MyOwnRedis/internal/glob"
+    "MyOwnRedis/internal/redisObject"
+    "errors"
+    "fmt"
+    "net"
+    "os"
+    "path/filepath"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Defaults of the settings of the server and its store, see NewFromConfig.
+const (
+    DefaultBind       = "localhost"
+    DefaultPort       = 6379
+    DefaultDir        = "tmp"
+    DefaultMaxClients = 10000
+    // DefaultTCPKeepAlive is the default of tcp-keepalive, in seconds.
+    DefaultTCPKeepAlive = 300
+)
+
+var (
+    ErrInvalidConfigValue    = errors.New("argument must be a non negative integer")
+    ErrInvalidAppendFilename = errors.New("appendfilename can't be a path, just a filename")
+    ErrAOFEnabled            = errors.New("can't be changed while the append only file is enabled")
+    ErrInvalidPort           = errors.New("port must be between 0 and 65535")
+    ErrInvalidBool           = errors.New("argument must be 'yes' or 'no'")
+    ErrInvalidPermissions    = errors.New("argument must be octal permissions, e.g. 700")
+)
+
+// ParseMemory parses a number of bytes, optionally with a unit, e.g. 64mb. Units are case insensitive:
+// k, kb, m, mb, g and gb, where k is 1000 and kb 1024.
+func ParseMemory(s string) (int64, error) {
+    units := []struct {
+        suffix string
+        size   int64
+    }{
+        {"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+        {"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+    }
+    s = strings.ToLower(s)
+    unit := int64(1)
+    for _, u := range units {
+        if strings.HasSuffix(s, u.suffix) {
+            s, unit = strings.TrimSuffix(s, u.suffix), u.size
+            break
+        }
+    }
+    n, err := strconv.ParseInt(s, 10, 64)
+    if err != nil || n < 0 {
+        return 0, ErrInvalidConfigValue
+    }
+    return n * unit, nil
+}
+
+// configParameter is a setting readable with CONFIG GET, and writable at runtime with CONFIG SET unless it is immutable.
+type configParameter struct {
+    get func() string
+    // set applies a value, from the configuration file or CONFIG SET. It is nil for the settings of the store,
+    // applied when the store is created.
+    set func(value string) error
+    // immutable settings are only read from the configuration file, when the server starts.
+    immutable bool
+    // def is the default value, as returned by get.
+    def string
+    // reloadTLS settings reload the TLS certificates once CONFIG SET set them, see reloadTLS.
+    reloadTLS bool
+}
+
+// configParameters returns the settings of the server exposed to CONFIG, by name.
+func (r *RedisServer) configParameters() map[string]configParameter {
+    return map[string]configParameter{
+        "bind": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                host, _, _ := net.SplitHostPort(r.addr)
+                return host
+            },
+            set: func(value string) error {
+                r.Lock()
+                defer r.Unlock()
+                _, port, _ := net.SplitHostPort(r.addr)
+                r.addr = net.JoinHostPort(value, port)
+                return nil
+            },
+            immutable: true,
+            def:       DefaultBind,
+        },
+        "port": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                _, port, _ := net.SplitHostPort(r.addr)
+                return port
+            },
+            set: func(value string) error {
+                port, err := strconv.Atoi(value)
+                if err != nil || port < 0 || port > 65535 {
+                    return ErrInvalidPort
+                }
+                r.Lock()
+                defer r.Unlock()
+                host, _, _ := net.SplitHostPort(r.addr)
+                r.addr = net.JoinHostPort(host, strconv.Itoa(port))
+                return nil
+            },
+            immutable: true,
+            def:       strconv.Itoa(DefaultPort),
+        },
+        "unixsocket": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return r.unixSocket
+            },
+            set: func(value string) error {
+                r.Lock()
+                defer r.Unlock()
+                r.unixSocket = value
+                return nil
+            },
+            immutable: true,
+            def:       "",
+        },
+        "unixsocketperm": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return strconv.FormatUint(uint64(r.unixSocketPerm), 8)
+            },
+            set: func(value string) error {
+                perm, err := strconv.ParseUint(value, 8, 32)
+                if err != nil || perm > 0777 {
+                    return ErrInvalidPermissions
+                }
+                r.Lock()
+                defer r.Unlock()
+                r.unixSocketPerm = os.FileMode(perm)
+                return nil
+            },
+            immutable: true,
+            def:       "0",
+        },
+        "databases": {
+            get: func() string {
+                return strconv.Itoa(r.store.Len())
+            },
+            immutable: true,
+            def:       strconv.Itoa(inMemoryDatabase.DefaultDatabases),
+        },
+        "dbformat": {
+            get: func() string {
+                return r.storeConfig.Format.String()
+            },
+            immutable: true,
+            def:       inMemoryDatabase.FormatCSV.String(),
+        },
+        "in-memory": {
+            get: func() string {
+                return yesNo(r.storeConfig.InMemory)
+            },
+            immutable: true,
+            def:       yesNo(false),
+        },
+        "csv-load-legacy": {
+            get: func() string {
+                return yesNo(r.storeConfig.LoadLegacyCSV)
+            },
+            immutable: true,
+            def:       yesNo(false),
+        },
+        "dir": {
+            get: r.store.Dir,
+            set: func(value string) error {
+                if err := r.store.SetDir(value); err != nil {
+                    return err
+                }
+                r.Lock()
+                defer r.Unlock()
+                // An open AOF keeps its directory.
+                if r.aof == nil {
+                    r.aofConfig.Dir = value
+                }
+                return nil
+            },
+            def: DefaultDir,
+        },
+        "dbfilename": {
+            get: r.store.DBFilename,
+            set: r.store.SetDBFilename,
+            def: inMemoryDatabase.DefaultDBFilename(r.storeConfig.Format),
+        },
+        "save": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return formatSavePoints(r.savePoints)
+            },
+            set: func(value string) error {
+                points, err := parseSavePoints(value)
+                if err != nil {
+                    return err
+                }
+                r.Lock()
+                defer r.Unlock()
+                r.savePoints = points
+                return nil
+            },
+            def: formatSavePoints(defaultSavePoints),
+        },
+        "appendonly": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return yesNo(r.aofEnabled)
+            },
+            set: func(value string) error {
+                enabled, err := parseYesNo(value)
+                if err != nil {
+                    return err
+                }
+                r.Lock()
+                defer r.Unlock()
+                r.aofEnabled = enabled
+                return nil
+            },
+            immutable: true,
+            def:       yesNo(false),
+        },
+        "appendfilename": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return r.aofConfig.Filename
+            },
+            set: func(value string) error {
+                if value == "" || filepath.Base(value) != value {
+                    return ErrInvalidAppendFilename
+                }
+                r.Lock()
+                defer r.Unlock()
+                if r.aof != nil {
+                    return ErrAOFEnabled
+                }
+                r.aofConfig.Filename = value
+                return nil
+            },
+            def: defaultAOFConfig.Filename,
+        },
+        "appenddirname": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return r.aofConfig.DirName
+            },
+            set: func(value string) error {
+                if value == "" || filepath.Base(value) != value {
+                    return fmt.Errorf("appenddirname can't be a path, just a name")
+                }
+                r.Lock()
+                defer r.Unlock()
+                r.aofConfig.DirName = value
+                return nil
+            },
+            immutable: true,
+            def:       defaultAOFConfig.DirName,
+        },
+        "appendfsync": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return r.aofConfig.Fsync.String()
+            },
+            set: func(value string) error {
+                policy, err := aof.ParseFsyncPolicy(value)
+                if err != nil {
+                    return err
+                }
+                r.Lock()
+                defer r.Unlock()
+                r.aofConfig.Fsync = policy
+                if r.aof != nil {
+                    r.aof.SetFsyncPolicy(policy)
+                }
+                return nil
+            },
+            def: defaultAOFConfig.Fsync.String(),
+        },
+        "aof-load-truncated": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return yesNo(r.aofConfig.LoadTruncated)
+            },
+            set: func(value string) error {
+                loadTruncated, err := parseYesNo(value)
+                if err != nil {
+                    return err
+                }
+                r.Lock()
+                defer r.Unlock()
+                r.aofConfig.LoadTruncated = loadTruncated
+                return nil
+            },
+            immutable: true,
+            def:       yesNo(defaultAOFConfig.LoadTruncated),
+        },
+        "auto-aof-rewrite-percentage": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return strconv.Itoa(r.aofRewritePercentage)
+            },
+            set: func(value string) error {
+                percentage, err := strconv.Atoi(value)
+                if err != nil || percentage < 0 {
+                    return ErrInvalidConfigValue
+                }
+                r.Lock()
+                defer r.Unlock()
+                r.aofRewritePercentage = percentage
+                return nil
+            },
+            def: "100",
+        },
+        "auto-aof-rewrite-min-size": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return strconv.FormatInt(r.aofRewriteMinSize, 10)
+            },
+            set: func(value string) error {
+                size, err := ParseMemory(value)
+                if err != nil {
+                    return err
+                }
+                r.Lock()
+                defer r.Unlock()
+                r.aofRewriteMinSize = size
+                return nil
+            },
+            def: strconv.Itoa(64 << 20),
+        },
+        "notify-keyspace-events": {
+            get: func() string {
+                return notifyFlagsString(int(r.notifyFlags.Load()))
+            },
+            set: r.SetNotifyKeyspaceEvents,
+            def: "",
+        },
+        "maxclients": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return strconv.Itoa(r.maxClients)
+            },
+            set: func(value string) error {
+                maxClients, err := strconv.Atoi(value)
+                if err != nil || maxClients < 1 {
+                    return fmt.Errorf("maxclients must be a positive integer")
+                }
+                r.Lock()
+                defer r.Unlock()
+                r.maxClients = maxClients
+                return nil
+            },
+            def: strconv.Itoa(DefaultMaxClients),
+        },
+        "timeout": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return strconv.Itoa(int(r.idleTimeout.Seconds()))
+            },
+            set: func(value string) error {
+                seconds, err := strconv.Atoi(value)
+                if err != nil || seconds < 0 {
+                    return ErrInvalidConfigValue
+                }
+                r.Lock()
+                r.idleTimeout = time.Duration(seconds) * time.Second
+                r.Unlock()
+                // The clients waiting for a request apply the new timeout right away.
+                r.refreshReadDeadlines()
+                return nil
+            },
+            def: "0",
+        },
+        "tcp-keepalive": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return strconv.Itoa(int(r.tcpKeepAlive.Seconds()))
+            },
+            set: func(value string) error {
+                seconds, err := strconv.Atoi(value)
+                if err != nil || seconds < 0 {
+                    return ErrInvalidConfigValue
+                }
+                r.Lock()
+                defer r.Unlock()
+                r.tcpKeepAlive = time.Duration(seconds) * time.Second
+                return nil
+            },
+            def: strconv.Itoa(DefaultTCPKeepAlive),
+        },
+        "requirepass": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return r.requirePass
+            },
+            set: r.setRequirePass,
+            def: "",
+        },
+        "aclfile": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return r.aclFile
+            },
+            set: func(value string) error {
+                r.Lock()
+                defer r.Unlock()
+                r.aclFile = value
+                return nil
+            },
+            immutable: true,
+            def:       "",
+        },
+        "acllog-max-len": {
+            get: func() string {
+                return strconv.Itoa(r.aclLog.MaxLen())
+            },
+            set: func(value string) error {
+                maxLen, err := strconv.Atoi(value)
+                if err != nil || maxLen < 0 {
+                    return ErrInvalidConfigValue
+                }
+                r.aclLog.SetMaxLen(maxLen)
+                return nil
+            },
+            def: strconv.Itoa(acl.DefaultLogMaxLen),
+        },
+        "tls-port": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return strconv.Itoa(r.tls.port)
+            },
+            set: func(value string) error {
+                port, err := strconv.Atoi(value)
+                if err != nil || port < 0 || port > 65535 {
+                    return ErrInvalidPort
+                }
+                r.Lock()
+                defer r.Unlock()
+                r.tls.port = port
+                return nil
+            },
+            immutable: true,
+            def:       "0",
+        },
+        "tls-cert-file": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return r.tls.certFile
+            },
+            set: func(value string) error {
+                r.Lock()
+                defer r.Unlock()
+                r.tls.certFile = value
+                return nil
+            },
+            reloadTLS: true,
+            def:       "",
+        },
+        "tls-key-file": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return r.tls.keyFile
+            },
+            set: func(value string) error {
+                r.Lock()
+                defer r.Unlock()
+                r.tls.keyFile = value
+                return nil
+            },
+            reloadTLS: true,
+            def:       "",
+        },
+        "tls-ca-cert-file": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return r.tls.caCertFile
+            },
+            set: func(value string) error {
+                r.Lock()
+                defer r.Unlock()
+                r.tls.caCertFile = value
+                return nil
+            },
+            reloadTLS: true,
+            def:       "",
+        },
+        "tls-auth-clients": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return r.tls.authClients
+            },
+            set: func(value string) error {
+                authClients, err := parseAuthClients(value)
+                if err != nil {
+                    return err
+                }
+                r.Lock()
+                defer r.Unlock()
+                r.tls.authClients = authClients
+                return nil
+            },
+            reloadTLS: true,
+            def:       "yes",
+        },
+        "client-output-buffer-limit": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return formatOutputBufferLimits(r.outputLimits)
+            },
+            set: func(value string) error {
+                r.Lock()
+                defer r.Unlock()
+                limits, err := parseOutputBufferLimits(value, r.outputLimits)
+                if err != nil {
+                    return err
+                }
+                r.outputLimits = limits
+                return nil
+            },
+            def: formatOutputBufferLimits(defaultOutputBufferLimits),
+        },
+        "latency-tracking": {
+            get: func() string {
+                return yesNo(r.latencyTracking.Load())
+            },
+            set: func(value string) error {
+                enabled, err := parseYesNo(value)
+                if err != nil {
+                    return err
+                }
+                r.latencyTracking.Store(enabled)
+                return nil
+            },
+            def: yesNo(true),
+        },
+        "shutdown-timeout": {
+            get: func() string {
+                r.RLock()
+                defer r.RUnlock()
+                return strconv.Itoa(int(r.shutdownTimeout.Seconds()))
+            },
+            set: func(value string) error {
+                seconds, err := strconv.Atoi(value)
+                if err != nil || seconds < 0 {
+                    return ErrInvalidConfigValue
+                }
+                r.Lock()
+                defer r.Unlock()
+                r.shutdownTimeout = time.Duration(seconds) * time.Second
+                return nil
+            },
+            def: strconv.Itoa(int(DefaultShutdownTimeout.Seconds())),
+        },
+    }
+}
+
+// NewFromConfig creates a server, and the store it serves, as configured by file. The settings missing from file have their default value.
+func NewFromConfig(file *config.File) (*RedisServer, error) {
+    // The settings of the store are read first: the dump is loaded when the store is created.
+    storeConfig := inMemoryDatabase.Config{Dir: DefaultDir}
+    appendOnly := false
+    lookups := []struct {
+        name  string
+        parse func(value string) error
+    }{
+        {"databases", func(value string) (err error) {
+            storeConfig.Databases, err = strconv.Atoi(value)
+            if err != nil || storeConfig.Databases < 1 {
+                return fmt.Errorf("databases must be a positive integer")
+            }
+            return nil
+        }},
+        {"dbformat", func(value string) (err error) {
+            storeConfig.Format, err = inMemoryDatabase.ParseFormat(value)
+            return err
+        }},
+        {"in-memory", func(value string) (err error) {
+            storeConfig.InMemory, err = parseYesNo(value)
+            return err
+        }},
+        {"csv-load-legacy", func(value string) (err error) {
+            storeConfig.LoadLegacyCSV, err = parseYesNo(value)
+            return err
+        }},
+        {"dir", func(value string) error {
+            storeConfig.Dir = value
+            return nil
+        }},
+        {"dbfilename", func(value string) error {
+            storeConfig.DBFilename = value
+            return nil
+        }},
+        {"appendonly", func(value string) (err error) {
+            appendOnly, err = parseYesNo(value)
+            return err
+        }},
+    }
+    for _, lookup := range lookups {
+        args, ok := file.Lookup(lookup.name)
+        if !ok {
+            continue
+        }
+        if len(args) != 1 {
+            return nil, fmt.Errorf("error wrong number of arguments for %s", lookup.name)
+        }
+        if err := lookup.parse(args[0]); err != nil {
+            return nil, fmt.Errorf("error invalid %s: %w", lookup.name, err)
+        }
+    }
+    if appendOnly && storeConfig.InMemory {
+        return nil, errors.New("error appendonly can't be enabled in memory only")
+    }
+
+    var store *inMemoryDatabase.Store
+    var err error
+    if appendOnly {
+        // The AOF holds the whole dataset, the dump file isn't loaded.
+        store, err = inMemoryDatabase.NewEmpty(storeConfig)
+    } else {
+        store, err = inMemoryDatabase.New(storeConfig)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    r := New(net.JoinHostPort(DefaultBind, strconv.Itoa(DefaultPort)), store)
+    r.storeConfig = storeConfig
+    r.configFile = file.Path
+    if err = r.Configure(file.Directives); err != nil {
+        return nil, err
+    }
+    // The users of the ACL file replace the default user configured by requirepass.
+    if r.aclFile != "" {
+        if err = r.acl.Load(r.aclFile); err != nil {
+            return nil, err
+        }
+    }
+    return r, nil
+}
+
+// Configure applies the directives of a configuration file, before the server runs.
+func (r *RedisServer) Configure(directives []config.Directive) error {
+    params := r.configParameters()
+    var savePoints []string
+    for _, directive := range directives {
+        param, ok := params[directive.Name]
+        if !ok {
+            return fmt.Errorf("error bad directive %s", directive.Name)
+        }
+        if directive.Name == "save" {
+            // Save points add up, `save ""` removes them.
+            if len(directive.Args) == 1 && directive.Args[0] == "" {
+                savePoints = []string{}
+            } else {
+                savePoints = append(savePoints, directive.Args...)
+            }
+            continue
+        }
+        value := strings.Join(directive.Args, " ")
+        if len(directive.Args) != 1 && directive.Name != "client-output-buffer-limit" {
+            return fmt.Errorf("error wrong number of arguments for %s", directive.Name)
+        }
+        if param.set == nil {
+            // Applied when the store was created.
+            continue
+        }
+        if err := param.set(value); err != nil {
+            return fmt.Errorf("error invalid %s: %w", directive.Name, err)
+        }
+    }
+
+    if savePoints != nil {
+        if err := params["save"].set(strings.Join(savePoints, " ")); err != nil {
+            return fmt.Errorf("error invalid save: %w", err)
+        }
+    }
+    return nil
+}
+
+// configCommand handles `CONFIG GET parameter [parameter ...] | SET parameter value [parameter value ...] | REWRITE | RESETSTAT`.
+func (r *RedisServer) configCommand(args []string) []byte {
+    subcommand := strings.ToLower(args[0])
+    switch {
+    case subcommand == "get" && len(args) >= 2:
+        return r.configGet(args[1:])
+    case subcommand == "set" && len(args) >= 3 && len(args)%2 == 1:
+        return r.configSet(args[1:])
+    case subcommand == "rewrite" && len(args) == 1:
+        if err := r.configRewrite(); err != nil {
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR "+err.Error())
+        }
+        return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+    case subcommand == "resetstat" && len(args) == 1:
+        r.resetStats()
+        return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+    }
+
+    return redisObject.Serialize(redisObject.SimpleErrors, "ERR unknown subcommand or wrong number of arguments for '"+args[0]+"'. Try CONFIG HELP.")
+}
+
+// configGet replies the name and value of the parameters matching any of the glob-style patterns, sorted by name.
+func (r *RedisServer) configGet(patterns []string) []byte {
+    params := r.configParameters()
+    var names []string
+    for name := range params {
+        for _, pattern := range patterns {
+            if glob.Match(pattern, name, true) {
+                names = append(names, name)
+                break
+            }
+        }
+    }
+    slices.Sort(names)
+
+    reply := make([]string, 0, 2*len(names))
+    for _, name := range names {
+        reply = append(reply, name, params[name].get())
+    }
+    return redisObject.Serialize(redisObject.Arrays, reply...)
+}
+
+// configSet sets the parameters of pairs of name and value, all or none: if a value is rejected,
+// the parameters already set are restored.
+func (r *RedisServer) configSet(pairs []string) []byte {
+    params := r.configParameters()
+    seen := make(map[string]bool)
+    for i := 0; i < len(pairs); i += 2 {
+        name := strings.ToLower(pairs[i])
+        param, ok := params[name]
+        switch {
+        case !ok:
+            return redisObject.Serialize(redisObject.SimpleErrors, fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", pairs[i]))
+        case param.immutable:
+            return configSetError(pairs[i], errors.New("can't set immutable config"))
+        case seen[name]:
+            return configSetError(pairs[i], errors.New("duplicate parameter"))
+        }
+        seen[name] = true
+    }
+
+    previous := make([]string, 0, len(pairs)/2)
+    // restore sets back the parameters set so far.
+    restore := func() {
+        for j := len(previous) - 1; j >= 0; j-- {
+            _ = params[strings.ToLower(pairs[2*j])].set(previous[j])
+        }
+    }
+    // The TLS certificates are reloaded once, with every TLS setting set.
+    reloadTLS := ""
+    for i := 0; i < len(pairs); i += 2 {
+        param := params[strings.ToLower(pairs[i])]
+        value := param.get()
+        if err := param.set(pairs[i+1]); err != nil {
+            restore()
+            return configSetError(pairs[i], err)
+        }
+        previous = append(previous, value)
+        if param.reloadTLS && reloadTLS == "" {
+            reloadTLS = pairs[i]
+        }
+    }
+    if reloadTLS != "" {
+        if err := r.reloadTLS(); err != nil {
+            restore()
+            return configSetError(reloadTLS, err)
+        }
+    }
+    return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+}
+
+// configSetError returns the reply to CONFIG SET failing on the parameter name.
+func configSetError(name string, err error) []byte {
+    return redisObject.Serialize(redisObject.SimpleErrors, fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", name, err))
+}
+
+// configRewrite writes the current configuration to the configuration file the server was started with.
+func (r *RedisServer) configRewrite() error {
+    r.RLock()
+    path := r.configFile
+    r.RUnlock()
+    if path == "" {
+        return errors.New("The server is running without a config file")
+    }
+
+    params := r.configParameters()
+    names := make([]string, 0, len(params))
+    for name := range params {
+        names = append(names, name)
+    }
+    slices.Sort(names)
+
+    options := make([]config.Option, 0, len(names))
+    for _, name := range names {
+        value := params[name].get()
+        args := []string{value}
+        if (name == "save" || name == "client-output-buffer-limit") && value != "" {
+            args = strings.Fields(value)
+        }
+        options = append(options, config.Option{Name: name, Args: args, Default: value == params[name].def})
+    }
+    if err := config.Rewrite(path, options); err != nil {
+        return fmt.Errorf("Rewriting config file: %v", err)
+    }
+    return nil
+}
+
+// yesNo formats a boolean setting.
+func yesNo(b bool) string {
+    if b {
+        return "yes"
+    }
+    return "no"
+}
+
+// parseYesNo parses a boolean setting.
+func parseYesNo(s string) (bool, error) {
+    switch strings.ToLower(s) {
+    case "yes":
+        return true, nil
+    case "no":
+        return false, nil
+    }
+    return false, ErrInvalidBool
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+    "MyOwnRedis/internal/redisObject"
+    "crypto/rand"
+    "encoding/hex"
+    "fmt"
+    "net"
+    "os"
+    "runtime"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// infoSection is a section of the INFO reply, made of `field:value` lines.
+type infoSection struct {
+    name   string
+    fields func() [][2]string
+    // all sections are left out of the default sections, they are returned by INFO all and everything, or when selected.
+    all bool
+}
+
+// infoSections returns the sections of the INFO reply, in order.
+func (r *RedisServer) infoSections() []infoSection {
+    return []infoSection{
+        {name: "server", fields: r.serverInfo},
+        {name: "clients", fields: r.clientsInfo},
+        {name: "memory", fields: r.memoryInfo},
+        {name: "persistence", fields: r.persistenceInfo},
+        {name: "stats", fields: r.statsInfo},
+        {name: "commandstats", fields: r.commandStatsInfo, all: true},
+        {name: "latencystats", fields: r.latencyStatsInfo, all: true},
+        {name: "keyspace", fields: r.keyspaceInfo},
+    }
+}
+
+// info handles `INFO [section [section ...]]`. Without sections, or with default, the default sections are returned,
+// with all or everything every section is.
+func (r *RedisServer) info(args []string) []byte {
+    defaults, all := len(args) == 0, false
+    selected := make(map[string]bool)
+    for _, arg := range args {
+        section := strings.ToLower(arg)
+        switch section {
+        case "default":
+            defaults = true
+        case "all", "everything":
+            all = true
+        }
+        selected[section] = true
+    }
+
+    var sb strings.Builder
+    for _, section := range r.infoSections() {
+        if !all && !selected[section.name] && !(defaults && !section.all) {
+            continue
+        }
+        if sb.Len() > 0 {
+            sb.WriteString("\r\n")
+        }
+        sb.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+        for _, field := range section.fields() {
+            sb.WriteString(field[0] + ":" + field[1] + "\r\n")
+        }
+    }
+    return redisObject.Serialize(redisObject.BulkStrings, sb.String())
+}
+
+// newRunID returns a random identifier of 40 hexadecimal characters, telling the runs of the server apart.
+func newRunID() string {
+    b := make([]byte, 20)
+    _, _ = rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+// serverInfo returns the fields of the server section, with a listenerN field per listener.
+func (r *RedisServer) serverInfo() [][2]string {
+    r.RLock()
+    addr, configFile, listeners := r.addr, r.configFile, r.listeners
+    r.RUnlock()
+    _, port, _ := net.SplitHostPort(addr)
+    executable, _ := os.Executable()
+    now := time.Now()
+    uptime := now.Sub(r.started)
+
+    fields := [][2]string{
+        {"redis_version", redisVersion},
+        {"redis_mode", "standalone"},
+        {"os", runtime.GOOS + " " + runtime.GOARCH},
+        {"arch_bits", strconv.Itoa(strconv.IntSize)},
+        {"go_version", runtime.Version()},
+        {"process_id", strconv.Itoa(os.Getpid())},
+        {"run_id", r.runID},
+        {"tcp_port", port},
+        {"server_time_usec", fmt.Sprint(now.UnixMicro())},
+        {"uptime_in_seconds", fmt.Sprint(int(uptime.Seconds()))},
+        {"uptime_in_days", fmt.Sprint(int(uptime.Hours() / 24))},
+        {"hz", fmt.Sprint(int(time.Second / statsSampleInterval))},
+        {"executable", executable},
+        {"config_file", configFile},
+    }
+    for i, l := range listeners {
+        value := "name=" + l.name
+        if host, port, err := net.SplitHostPort(l.Addr().String()); err == nil {
+            value += ",bind=" + host + ",port=" + port
+        } else {
+            value += ",bind=" + l.Addr().String()
+        }
+        fields = append(fields, [2]string{fmt.Sprintf("listener%d", i), value})
+    }
+    return fields
+}
+
+// clientsInfo returns the fields of the clients section.
+func (r *RedisServer) clientsInfo() [][2]string {
+    r.RLock()
+    maxClients := r.maxClients
+    r.RUnlock()
+    clients := r.connectedClients()
+
+    var pubsubClients, maxOutput int
+    r.pubsub.RLock()
+    for _, c := range clients {
+        if c.subscribed() {
+            pubsubClients++
+        }
+    }
+    r.pubsub.RUnlock()
+    for _, c := range clients {
+        c.outLock.Lock()
+        maxOutput = max(maxOutput, len(c.out))
+        c.outLock.Unlock()
+    }
+    r.tracking.Lock()
+    trackingClients := len(r.tracking.clients)
+    r.tracking.Unlock()
+
+    return [][2]string{
+        {"connected_clients", fmt.Sprint(len(clients))},
+        {"maxclients", fmt.Sprint(maxClients)},
+        {"client_recent_max_output_buffer", fmt.Sprint(maxOutput)},
+        {"blocked_clients", "0"},
+        {"tracking_clients", fmt.Sprint(trackingClients)},
+        {"pubsub_clients", fmt.Sprint(pubsubClients)},
+    }
+}
+
+// memoryInfo returns the fields of the memory section. The memory used is the heap allocated by the Go runtime,
+// the RSS the memory it obtained from the OS.
+func (r *RedisServer) memoryInfo() [][2]string {
+    m := r.stats.sampleMemory()
+    peak := r.stats.peakMemory.Load()
+    return [][2]string{
+        {"used_memory", fmt.Sprint(m.HeapAlloc)},
+        {"used_memory_human", bytesToHuman(m.HeapAlloc)},
+        {"used_memory_rss", fmt.Sprint(m.Sys)},
+        {"used_memory_rss_human", bytesToHuman(m.Sys)},
+        {"used_memory_peak", fmt.Sprint(peak)},
+        {"used_memory_peak_human", bytesToHuman(peak)},
+        {"maxmemory", "0"},
+        {"maxmemory_human", "0B"},
+        {"maxmemory_policy", "noeviction"},
+        {"mem_allocator", "go"},
+    }
+}
+
+// bytesToHuman formats a number of bytes the way Redis does, e.g. 1.50M.
+func bytesToHuman(n uint64) string {
+    if n < 1024 {
+        return fmt.Sprintf("%dB", n)
+    }
+    const units = "KMGT"
+    size, unit := float64(n)/1024, 0
+    for size >= 1024 && unit < len(units)-1 {
+        size /= 1024
+        unit++
+    }
+    return fmt.Sprintf("%.2f%c", size, units[unit])
+}
+
+// statsInfo returns the fields of the stats section, the keyspace counters adding up those of every database.
+func (r *RedisServer) statsInfo() [][2]string {
+    var hits, misses, expired int64
+    for i := 0; i < r.store.Len(); i++ {
+        stats := r.store.Db(i).Stats()
+        hits, misses, expired = hits+stats.KeyspaceHits, misses+stats.KeyspaceMisses, expired+stats.ExpiredKeys
+    }
+    r.pubsub.RLock()
+    channels, patterns := len(r.pubsub.channels), len(r.pubsub.patterns)
+    var shardChannels int
+    for _, slot := range r.pubsub.shardChannels {
+        shardChannels += len(slot)
+    }
+    r.pubsub.RUnlock()
+    ops, inputKbps, outputKbps := r.stats.rates()
+
+    return [][2]string{
+        {"total_connections_received", fmt.Sprint(r.stats.connections.Load())},
+        {"total_commands_processed", fmt.Sprint(r.stats.commands.Load())},
+        {"instantaneous_ops_per_sec", fmt.Sprint(int64(ops))},
+        {"total_net_input_bytes", fmt.Sprint(r.stats.netInput.Load())},
+        {"total_net_output_bytes", fmt.Sprint(r.stats.netOutput.Load())},
+        {"instantaneous_input_kbps", fmt.Sprintf("%.2f", inputKbps)},
+        {"instantaneous_output_kbps", fmt.Sprintf("%.2f", outputKbps)},
+        {"rejected_connections", fmt.Sprint(r.stats.rejectedConnections.Load())},
+        {"expired_keys", fmt.Sprint(expired)},
+        {"evicted_keys", "0"},
+        {"keyspace_hits", fmt.Sprint(hits)},
+        {"keyspace_misses", fmt.Sprint(misses)},
+        {"pubsub_channels", fmt.Sprint(channels)},
+        {"pubsub_patterns", fmt.Sprint(patterns)},
+        {"pubsubshard_channels", fmt.Sprint(shardChannels)},
+    }
+}
+
+// keyspaceInfo returns the fields of the keyspace section, one per database holding keys.
+func (r *RedisServer) keyspaceInfo() [][2]string {
+    var fields [][2]string
+    for i := 0; i < r.store.Len(); i++ {
+        stats := r.store.Db(i).Stats()
+        if stats.Keys == 0 {
+            continue
+        }
+        fields = append(fields, [2]string{fmt.Sprintf("db%d", i),
+            fmt.Sprintf("keys=%d,expires=%d,avg_ttl=%d", stats.Keys, stats.Expires, stats.AvgTTL.Milliseconds())})
+    }
+    return fields
+}
+
+// persistenceInfo returns the fields of the persistence section.
+func (r *RedisServer) persistenceInfo() [][2]string {
+    r.RLock()
+    changes, lastSave, saves := r.dirty.Load()-r.lastSaveDirty, r.lastSave, r.rdbSaves
+    savePoints := formatSavePoints(r.savePoints)
+    nextSave := nextSaveIn(r.savePoints, changes, lastSave)
+    if r.storeConfig.InMemory {
+        nextSave = -1
+    }
+    bgsaveStart, lastBgsave, lastBgsaveErr := r.bgsaveStart, r.lastBgsaveDuration, r.lastBgsaveErr
+    rewriteStart, lastRewrite, lastRewriteErr, rewrites := r.aofRewriteStart, r.aofLastRewriteDuration, r.aofLastRewriteErr, r.aofRewrites
+    rewriteScheduled := r.aofRewriteScheduled
+    r.RUnlock()
+
+    fields := [][2]string{
+        {"rdb_changes_since_last_save", fmt.Sprint(changes)},
+        {"rdb_bgsave_in_progress", boolInfo(!bgsaveStart.IsZero())},
+        {"rdb_last_save_time", fmt.Sprint(lastSave.Unix())},
+        {"rdb_last_bgsave_status", statusInfo(lastBgsaveErr)},
+        {"rdb_last_bgsave_time_sec", fmt.Sprint(durationInfo(lastBgsave))},
+        {"rdb_current_bgsave_time_sec", fmt.Sprint(elapsedInfo(bgsaveStart))},
+        {"rdb_saves", fmt.Sprint(saves)},
+        {"rdb_save_points", savePoints},
+        {"rdb_next_save_in_sec", fmt.Sprint(nextSave)},
+        {"aof_enabled", boolInfo(r.aof != nil)},
+        {"aof_rewrite_in_progress", boolInfo(!rewriteStart.IsZero())},
+        {"aof_rewrite_scheduled", boolInfo(rewriteScheduled)},
+        {"aof_last_rewrite_time_sec", fmt.Sprint(durationInfo(lastRewrite))},
+        {"aof_current_rewrite_time_sec", fmt.Sprint(elapsedInfo(rewriteStart))},
+        {"aof_last_bgrewrite_status", statusInfo(lastRewriteErr)},
+        {"aof_rewrites", fmt.Sprint(rewrites)},
+    }
+    if r.aof == nil {
+        return append(fields, [2]string{"aof_last_write_status", "ok"})
+    }
+
+    status := r.aof.Status()
+    return append(fields,
+        [2]string{"aof_last_write_status", statusInfo(status.LastWriteErr)},
+        [2]string{"aof_current_size", fmt.Sprint(status.Size)},
+        [2]string{"aof_base_size", fmt.Sprint(status.BaseSize)},
+    )
+}
+
+// durationInfo formats the duration of the last run of a background job in seconds, -1 if it never ran.
+func durationInfo(d time.Duration) int {
+    if d <= 0 {
+        return -1
+    }
+    return int(d.Seconds())
+}
+
+// elapsedInfo formats the time elapsed since a background job in progress started in seconds, -1 if none.
+func elapsedInfo(start time.Time) int {
+    if start.IsZero() {
+        return -1
+    }
+    return int(time.Since(start).Seconds())
+}
+
+// resetStats resets the statistics reported by INFO, for CONFIG RESETSTAT.
+func (r *RedisServer) resetStats() {
+    r.stats.reset()
+    r.commandStats.reset()
+    for i := 0; i < r.store.Len(); i++ {
+        r.store.Db(i).ResetStats()
+    }
+    r.Lock()
+    defer r.Unlock()
+    r.rdbSaves = 0
+    r.aofRewrites = 0
+}
+
+// boolInfo formats a flag of the INFO reply.
+func boolInfo(b bool) string {
+    if b {
+        return "1"
+    }
+    return "0"
+}
+
+// statusInfo formats the outcome of an operation in the INFO reply.
+func statusInfo(err error) string {
+    if err != nil {
+        return "err"
+    }
+    return "ok"
+}
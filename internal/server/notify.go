@@ -0,0 +1,115 @@
+package server
+
+import (
+    "MyOwnRedis/internal/database"
+    "errors"
+    "fmt"
+    "strings"
+)
+
+// notifyAll is the set of classes enabled by the `A` alias of notify-keyspace-events.
+const notifyAll = database.NotifyGeneric | database.NotifyString | database.NotifyList | database.NotifySet |
+    database.NotifyHash | database.NotifyZset | database.NotifyExpired | database.NotifyEvicted |
+    database.NotifyStream | database.NotifyModule
+
+// notifyClasses maps the characters of notify-keyspace-events to the classes they enable, `A` excluded.
+var notifyClasses = []struct {
+    flag      byte
+    eventType int
+}{
+    {'g', database.NotifyGeneric},
+    {'$', database.NotifyString},
+    {'l', database.NotifyList},
+    {'s', database.NotifySet},
+    {'h', database.NotifyHash},
+    {'z', database.NotifyZset},
+    {'x', database.NotifyExpired},
+    {'e', database.NotifyEvicted},
+    {'t', database.NotifyStream},
+    {'d', database.NotifyModule},
+    {'K', database.NotifyKeyspace},
+    {'E', database.NotifyKeyevent},
+    {'m', database.NotifyKeyMiss},
+    {'n', database.NotifyNew},
+}
+
+var ErrInvalidNotifyFlags = errors.New("invalid event class character, use 'Ag$lshzxeKEtmdn'")
+
+// parseNotifyFlags converts a notify-keyspace-events string, e.g. "KEA" or "Kx", to the classes it enables.
+func parseNotifyFlags(s string) (int, error) {
+    var flags int
+    for i := 0; i < len(s); i++ {
+        if s[i] == 'A' {
+            flags |= notifyAll
+            continue
+        }
+
+        found := false
+        for _, class := range notifyClasses {
+            if class.flag == s[i] {
+                flags |= class.eventType
+                found = true
+                break
+            }
+        }
+        if !found {
+            return 0, ErrInvalidNotifyFlags
+        }
+    }
+    return flags, nil
+}
+
+// notifyFlagsString converts the enabled classes back to their notify-keyspace-events string.
+func notifyFlagsString(flags int) string {
+    var sb strings.Builder
+    if flags&notifyAll == notifyAll {
+        sb.WriteByte('A')
+    }
+    for _, class := range notifyClasses {
+        if flags&notifyAll == notifyAll && class.eventType&notifyAll != 0 {
+            // Already covered by `A`.
+            continue
+        }
+        if flags&class.eventType != 0 {
+            sb.WriteByte(class.flag)
+        }
+    }
+    return sb.String()
+}
+
+// SetNotifyKeyspaceEvents sets the classes of keyspace events published to clients, using Redis's notify-keyspace-events syntax.
+// Events are only published if at least `K` or `E` is set along with the classes of interest; an empty string disables notifications.
+func (r *RedisServer) SetNotifyKeyspaceEvents(s string) error {
+    flags, err := parseNotifyFlags(s)
+    if err != nil {
+        return err
+    }
+    r.notifyFlags.Store(int64(flags))
+    return nil
+}
+
+// keyspaceChanged is notified of every modification of the databases.
+// It invalidates the modified key for client side caching and publishes the keyspace event.
+func (r *RedisServer) keyspaceChanged(event database.KeyspaceEvent) {
+    // A new key is always followed by the event of the command creating it, invalidating it once is enough.
+    if event.Type != database.NotifyNew {
+        r.invalidateKey(event.Key, event.Client)
+    }
+    r.notifyKeyspaceEvent(event)
+}
+
+// notifyKeyspaceEvent publishes a keyspace event from the databases to the channels
+// `__keyspace@<db>__:<key>` ( with the event as message ) and `__keyevent@<db>__:<event>` ( with the key as message ).
+func (r *RedisServer) notifyKeyspaceEvent(event database.KeyspaceEvent) {
+    flags := int(r.notifyFlags.Load())
+    if flags&event.Type == 0 {
+        return
+    }
+
+    if flags&database.NotifyKeyspace != 0 {
+        r.pubsub.publish(fmt.Sprintf("__keyspace@%d__:%s", event.Db, event.Key), event.Event)
+    }
+    if flags&database.NotifyKeyevent != 0 {
+        r.pubsub.publish(fmt.Sprintf("__keyevent@%d__:%s", event.Db, event.Event), event.Key)
+    }
+}
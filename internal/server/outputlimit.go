@@ -0,0 +1,126 @@
+package server
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// clientClass is the class of a client whose output buffer limit applies, see client-output-buffer-limit.
+type clientClass int
+
+const (
+    classNormal clientClass = iota
+    // classReplica is accepted for compatibility, no client is a replica yet.
+    classReplica
+    classPubSub
+    numClientClasses
+)
+
+var clientClassNames = [numClientClasses]string{"normal", "replica", "pubsub"}
+
+// ErrInvalidOutputLimit is returned for a malformed client-output-buffer-limit.
+var ErrInvalidOutputLimit = errors.New("client-output-buffer-limit must be groups of class, hard limit, soft limit and soft seconds")
+
+// outputBufferLimit limits the output buffered for a client: the client is disconnected as soon as the hard limit is reached,
+// or once it stayed above the soft limit for softSeconds. A limit of 0 doesn't apply.
+type outputBufferLimit struct {
+    hard, soft  int64
+    softSeconds time.Duration
+}
+
+// defaultOutputBufferLimits are the default of client-output-buffer-limit, the same as Redis.
+var defaultOutputBufferLimits = [numClientClasses]outputBufferLimit{
+    classNormal:  {},
+    classReplica: {hard: 256 << 20, soft: 64 << 20, softSeconds: 60 * time.Second},
+    classPubSub:  {hard: 32 << 20, soft: 8 << 20, softSeconds: 60 * time.Second},
+}
+
+// parseOutputBufferLimits parses `class hard soft seconds [class hard soft seconds ...]` on top of limits,
+// where class is normal, replica ( or slave ) or pubsub, and the limits are memory sizes, e.g. 32mb.
+func parseOutputBufferLimits(s string, limits [numClientClasses]outputBufferLimit) ([numClientClasses]outputBufferLimit, error) {
+    fields := strings.Fields(s)
+    if len(fields) == 0 || len(fields)%4 != 0 {
+        return limits, ErrInvalidOutputLimit
+    }
+    for ; len(fields) > 0; fields = fields[4:] {
+        var class clientClass
+        switch strings.ToLower(fields[0]) {
+        case "normal":
+            class = classNormal
+        case "replica", "slave":
+            class = classReplica
+        case "pubsub":
+            class = classPubSub
+        default:
+            return limits, ErrInvalidOutputLimit
+        }
+        hard, err := ParseMemory(fields[1])
+        if err != nil {
+            return limits, ErrInvalidOutputLimit
+        }
+        soft, err := ParseMemory(fields[2])
+        if err != nil {
+            return limits, ErrInvalidOutputLimit
+        }
+        seconds, err := strconv.Atoi(fields[3])
+        if err != nil || seconds < 0 {
+            return limits, ErrInvalidOutputLimit
+        }
+        limits[class] = outputBufferLimit{hard: hard, soft: soft, softSeconds: time.Duration(seconds) * time.Second}
+    }
+    return limits, nil
+}
+
+// formatOutputBufferLimits formats limits as read by parseOutputBufferLimits.
+func formatOutputBufferLimits(limits [numClientClasses]outputBufferLimit) string {
+    groups := make([]string, 0, len(limits))
+    for class, limit := range limits {
+        groups = append(groups, fmt.Sprintf("%s %d %d %d", clientClassNames[class], limit.hard, limit.soft, int(limit.softSeconds.Seconds())))
+    }
+    return strings.Join(groups, " ")
+}
+
+// outputBufferLimit returns the output buffer limit of a class of clients.
+func (r *RedisServer) outputBufferLimit(class clientClass) outputBufferLimit {
+    r.RLock()
+    defer r.RUnlock()
+    return r.outputLimits[class]
+}
+
+// class returns the class of the client: subscribed clients are pubsub ones.
+func (c *client) class() clientClass {
+    c.infoLock.Lock()
+    defer c.infoLock.Unlock()
+    if c.info.pubsub {
+        return classPubSub
+    }
+    return classNormal
+}
+
+// overLimit reports whether size bytes of output exceed the limit, which starts the soft limit timer if it isn't yet.
+// Callers must hold the out lock.
+func (c *client) overLimit(size int64, limit outputBufferLimit, now time.Time) bool {
+    if limit.hard > 0 && size >= limit.hard {
+        return true
+    }
+    if limit.soft == 0 || size < limit.soft {
+        c.softLimitReached = time.Time{}
+        return false
+    }
+    if c.softLimitReached.IsZero() {
+        c.softLimitReached = now
+    }
+    return now.Sub(c.softLimitReached) > limit.softSeconds
+}
+
+// closeForOutputLimit drops the output of a client exceeding its output buffer limit and closes its connection right away.
+func (c *client) closeForOutputLimit(size int64) {
+    c.killed.Store(true)
+    log.Printf("RRedis client id=%d addr=%s closed for overcoming of output buffer limits ( omem=%d )", c.id, c.addr(), size)
+    // Both the read loop and writeLoop fail once the connection is closed.
+    _ = c.conn.Close()
+}
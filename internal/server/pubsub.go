@@ -0,0 +1,374 @@
+package server
+
+import (
+    "MyOwnRedis/internal/glob"
+    "MyOwnRedis/internal/redisObject"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// pubSub is the hub dispatching published messages to the subscribed clients.
+type pubSub struct {
+    // channels maps each channel to its subscribers.
+    channels map[string]map[*client]struct{}
+    // patterns maps each glob-style pattern to its subscribers.
+    patterns map[string]map[*client]struct{}
+    // shardChannels maps each hash slot to its shard channels and their subscribers.
+    // Shard channels are assigned to slots like keys, the way a cluster distributes them over its nodes.
+    shardChannels map[int]map[string]map[*client]struct{}
+    sync.RWMutex
+}
+
+// newPubSub creates an empty pubSub hub.
+func newPubSub() *pubSub {
+    return &pubSub{
+        channels:      make(map[string]map[*client]struct{}),
+        patterns:      make(map[string]map[*client]struct{}),
+        shardChannels: make(map[int]map[string]map[*client]struct{}),
+    }
+}
+
+// subscribe subscribes c to the channels and returns one confirmation per channel.
+func (p *pubSub) subscribe(c *client, channels ...string) []byte {
+    p.Lock()
+    defer p.Unlock()
+
+    var re []byte
+    for _, channel := range channels {
+        addSubscriber(p.channels, channel, c)
+        c.channels[channel] = struct{}{}
+        re = append(re, subscriptionReply(c, "subscribe", channel, c.subscriptions())...)
+    }
+    return re
+}
+
+// psubscribe subscribes c to the patterns and returns one confirmation per pattern.
+func (p *pubSub) psubscribe(c *client, patterns ...string) []byte {
+    p.Lock()
+    defer p.Unlock()
+
+    var re []byte
+    for _, pattern := range patterns {
+        addSubscriber(p.patterns, pattern, c)
+        c.patterns[pattern] = struct{}{}
+        re = append(re, subscriptionReply(c, "psubscribe", pattern, c.subscriptions())...)
+    }
+    return re
+}
+
+// unsubscribe unsubscribes c from the channels, or from every channel if none is given.
+func (p *pubSub) unsubscribe(c *client, channels ...string) []byte {
+    p.Lock()
+    defer p.Unlock()
+
+    return unsubscribeFrom(c, "unsubscribe", c.channels, channels, func(channel string) {
+        removeSubscriber(p.channels, channel, c)
+    }, c.subscriptions)
+}
+
+// punsubscribe unsubscribes c from the patterns, or from every pattern if none is given.
+func (p *pubSub) punsubscribe(c *client, patterns ...string) []byte {
+    p.Lock()
+    defer p.Unlock()
+
+    return unsubscribeFrom(c, "punsubscribe", c.patterns, patterns, func(pattern string) {
+        removeSubscriber(p.patterns, pattern, c)
+    }, c.subscriptions)
+}
+
+// ssubscribe subscribes c to the shard channels and returns one confirmation per channel.
+// All the channels must belong to the same hash slot.
+func (p *pubSub) ssubscribe(c *client, channels ...string) []byte {
+    if !sameSlot(channels) {
+        return redisObject.Serialize(redisObject.SimpleErrors, "CROSSSLOT Keys in request don't hash to the same slot")
+    }
+
+    p.Lock()
+    defer p.Unlock()
+
+    var re []byte
+    for _, channel := range channels {
+        slot := keyHashSlot(channel)
+        if _, ok := p.shardChannels[slot]; !ok {
+            p.shardChannels[slot] = make(map[string]map[*client]struct{})
+        }
+        addSubscriber(p.shardChannels[slot], channel, c)
+        c.shardChannels[channel] = struct{}{}
+        re = append(re, subscriptionReply(c, "ssubscribe", channel, len(c.shardChannels))...)
+    }
+    return re
+}
+
+// sunsubscribe unsubscribes c from the shard channels, or from every shard channel if none is given.
+func (p *pubSub) sunsubscribe(c *client, channels ...string) []byte {
+    if !sameSlot(channels) {
+        return redisObject.Serialize(redisObject.SimpleErrors, "CROSSSLOT Keys in request don't hash to the same slot")
+    }
+
+    p.Lock()
+    defer p.Unlock()
+
+    // Shard channels are counted separately from channels and patterns.
+    return unsubscribeFrom(c, "sunsubscribe", c.shardChannels, channels, func(channel string) {
+        p.removeShardSubscriber(channel, c)
+    }, func() int {
+        return len(c.shardChannels)
+    })
+}
+
+// removeShardSubscriber removes c from the subscribers of the shard channel, dropping the slot once it has no channel left. Callers must hold the lock.
+func (p *pubSub) removeShardSubscriber(channel string, c *client) {
+    slot := keyHashSlot(channel)
+    if channels, ok := p.shardChannels[slot]; ok {
+        removeSubscriber(channels, channel, c)
+        if len(channels) == 0 {
+            delete(p.shardChannels, slot)
+        }
+    }
+}
+
+// unsubscribeFrom removes the subscriptions of c to targets, or all its subscriptions of that kind if targets is empty.
+// subscribed holds the subscriptions of c of that kind, remove drops c from the subscribers of a target in the hub
+// and count returns the number of subscriptions reported in the confirmations.
+// Callers must hold the lock.
+func unsubscribeFrom(c *client, kind string, subscribed map[string]struct{}, targets []string, remove func(target string), count func() int) []byte {
+    if len(targets) == 0 {
+        if len(subscribed) == 0 {
+            // Nothing to unsubscribe from, still confirm with a null channel.
+            return c.encodePush(
+                redisObject.Serialize(redisObject.BulkStrings, kind),
+                redisObject.NullBulkStrings,
+                redisObject.Serialize(redisObject.Integers, strconv.Itoa(count())),
+            )
+        }
+        for target := range subscribed {
+            targets = append(targets, target)
+        }
+        sort.Strings(targets)
+    }
+
+    var re []byte
+    for _, target := range targets {
+        remove(target)
+        delete(subscribed, target)
+        re = append(re, subscriptionReply(c, kind, target, count())...)
+    }
+    return re
+}
+
+// unsubscribeAll removes every subscription of c without replying, e.g. when its connection is closed.
+func (p *pubSub) unsubscribeAll(c *client) {
+    p.Lock()
+    defer p.Unlock()
+
+    for channel := range c.channels {
+        removeSubscriber(p.channels, channel, c)
+    }
+    for pattern := range c.patterns {
+        removeSubscriber(p.patterns, pattern, c)
+    }
+    for channel := range c.shardChannels {
+        p.removeShardSubscriber(channel, c)
+    }
+    c.channels = make(map[string]struct{})
+    c.patterns = make(map[string]struct{})
+    c.shardChannels = make(map[string]struct{})
+}
+
+// publish posts message to channel and returns the number of clients that received it.
+// Messages are queued in the output buffer of each subscriber, so a slow subscriber never blocks the publisher.
+func (p *pubSub) publish(channel, message string) int {
+    p.RLock()
+    defer p.RUnlock()
+
+    var receivers int
+    if subscribers, ok := p.channels[channel]; ok {
+        msg := newPushMessage("message", channel, message)
+        for c := range subscribers {
+            c.writePush(msg)
+            receivers++
+        }
+    }
+
+    for pattern, subscribers := range p.patterns {
+        if !glob.Match(pattern, channel, false) {
+            continue
+        }
+        msg := newPushMessage("pmessage", pattern, channel, message)
+        for c := range subscribers {
+            c.writePush(msg)
+            receivers++
+        }
+    }
+    return receivers
+}
+
+// spublish posts message to the shard channel and returns the number of clients that received it.
+// Pattern subscriptions never match shard channels.
+func (p *pubSub) spublish(channel, message string) int {
+    p.RLock()
+    defer p.RUnlock()
+
+    subscribers := p.shardChannels[keyHashSlot(channel)][channel]
+    msg := newPushMessage("smessage", channel, message)
+    for c := range subscribers {
+        c.writePush(msg)
+    }
+    return len(subscribers)
+}
+
+// activeShardChannels returns the shard channels having at least one subscriber and matching pattern, all of them if pattern is empty.
+func (p *pubSub) activeShardChannels(pattern string) []string {
+    p.RLock()
+    defer p.RUnlock()
+
+    channels := make([]string, 0)
+    for _, slotChannels := range p.shardChannels {
+        for channel := range slotChannels {
+            if pattern == "" || glob.Match(pattern, channel, false) {
+                channels = append(channels, channel)
+            }
+        }
+    }
+    sort.Strings(channels)
+    return channels
+}
+
+// shardNumSub returns the number of subscribers of the shard channel.
+func (p *pubSub) shardNumSub(channel string) int {
+    p.RLock()
+    defer p.RUnlock()
+
+    return len(p.shardChannels[keyHashSlot(channel)][channel])
+}
+
+// activeChannels returns the channels having at least one subscriber and matching pattern, all of them if pattern is empty.
+func (p *pubSub) activeChannels(pattern string) []string {
+    p.RLock()
+    defer p.RUnlock()
+
+    channels := make([]string, 0)
+    for channel := range p.channels {
+        if pattern == "" || glob.Match(pattern, channel, false) {
+            channels = append(channels, channel)
+        }
+    }
+    sort.Strings(channels)
+    return channels
+}
+
+// numSub returns the number of subscribers of channel.
+func (p *pubSub) numSub(channel string) int {
+    p.RLock()
+    defer p.RUnlock()
+
+    return len(p.channels[channel])
+}
+
+// subscribedTo reports whether c is subscribed to channel.
+func (p *pubSub) subscribedTo(c *client, channel string) bool {
+    p.RLock()
+    defer p.RUnlock()
+
+    _, ok := p.channels[channel][c]
+    return ok
+}
+
+// numPat returns the number of unique patterns subscribed to.
+func (p *pubSub) numPat() int {
+    p.RLock()
+    defer p.RUnlock()
+
+    return len(p.patterns)
+}
+
+// addSubscriber adds c to the subscribers of target.
+func addSubscriber(subscribers map[string]map[*client]struct{}, target string, c *client) {
+    if _, ok := subscribers[target]; !ok {
+        subscribers[target] = make(map[*client]struct{})
+    }
+    subscribers[target][c] = struct{}{}
+}
+
+// removeSubscriber removes c from the subscribers of target, dropping target once nobody is subscribed to it.
+func removeSubscriber(subscribers map[string]map[*client]struct{}, target string, c *client) {
+    delete(subscribers[target], c)
+    if len(subscribers[target]) == 0 {
+        delete(subscribers, target)
+    }
+}
+
+// sameSlot reports whether all the channels hash to the same slot.
+func sameSlot(channels []string) bool {
+    for _, channel := range channels[min(1, len(channels)):] {
+        if keyHashSlot(channel) != keyHashSlot(channels[0]) {
+            return false
+        }
+    }
+    return true
+}
+
+// subscriptionReply is the confirmation of a (un)subscription: the kind of operation, its target and the number of subscriptions left.
+func subscriptionReply(c *client, kind, target string, count int) []byte {
+    return c.encodePush(
+        redisObject.Serialize(redisObject.BulkStrings, kind),
+        redisObject.Serialize(redisObject.BulkStrings, target),
+        redisObject.Serialize(redisObject.Integers, strconv.Itoa(count)),
+    )
+}
+
+// pubSubCommand handles `PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT | SHARDCHANNELS [pattern] | SHARDNUMSUB [channel ...]`.
+func (r *RedisServer) pubSubCommand(args []string) []byte {
+    subcommand := strings.ToLower(args[0])
+    switch {
+    case subcommand == "channels" && len(args) <= 2:
+        var pattern string
+        if len(args) == 2 {
+            pattern = args[1]
+        }
+        return redisObject.Serialize(redisObject.Arrays, r.pubsub.activeChannels(pattern)...)
+
+    case subcommand == "numsub":
+        elements := make([][]byte, 0, 2*(len(args)-1))
+        for _, channel := range args[1:] {
+            elements = append(elements,
+                redisObject.Serialize(redisObject.BulkStrings, channel),
+                redisObject.Serialize(redisObject.Integers, strconv.Itoa(r.pubsub.numSub(channel))),
+            )
+        }
+        return redisObject.SerializeArray(elements...)
+
+    case subcommand == "numpat" && len(args) == 1:
+        return redisObject.Serialize(redisObject.Integers, strconv.Itoa(r.pubsub.numPat()))
+
+    case subcommand == "shardchannels" && len(args) <= 2:
+        var pattern string
+        if len(args) == 2 {
+            pattern = args[1]
+        }
+        return redisObject.Serialize(redisObject.Arrays, r.pubsub.activeShardChannels(pattern)...)
+
+    case subcommand == "shardnumsub":
+        elements := make([][]byte, 0, 2*(len(args)-1))
+        for _, channel := range args[1:] {
+            elements = append(elements,
+                redisObject.Serialize(redisObject.BulkStrings, channel),
+                redisObject.Serialize(redisObject.Integers, strconv.Itoa(r.pubsub.shardNumSub(channel))),
+            )
+        }
+        return redisObject.SerializeArray(elements...)
+    }
+
+    return redisObject.Serialize(redisObject.SimpleErrors, "ERR unknown subcommand or wrong number of arguments for '"+args[0]+"'. Try PUBSUB HELP.")
+}
+
+// allowedInSubscribedMode reports whether a command can be used by a client in the subscribed mode.
+func allowedInSubscribedMode(command string) bool {
+    switch command {
+    case "subscribe", "psubscribe", "ssubscribe", "unsubscribe", "punsubscribe", "sunsubscribe", "ping", "quit", "reset":
+        return true
+    }
+    return false
+}
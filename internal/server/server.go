@@ -1,325 +1,786 @@
 package server
 
 import (
+    "MyOwnRedis/internal/acl"
+    "MyOwnRedis/internal/aof"
     "MyOwnRedis/internal/database"
     "MyOwnRedis/internal/database/inMemoryDatabase"
     "MyOwnRedis/internal/redisObject"
-    "context"
+    "crypto/tls"
+    "errors"
     "fmt"
+    "io/fs"
     "log"
     "net"
+    "os"
+    "slices"
     "strconv"
+    "strings"
     "sync"
+    "sync/atomic"
     "time"
 )
 
-const TCP = "tcp"
+// Networks of the listeners, see listen.
+const (
+    TCP  = "tcp"
+    Unix = "unix"
+)
 
 type RedisServer struct {
     addr string
-    // Passing `net.Listener` by value is idiomatic and aligns with the general practice in Go of passing interface by value.
-    l            net.Listener
-    db           database.MemDb
-    keysChanged  int
-    done         chan struct{}
-    saveRoutines map[time.Duration]struct {
-        timeCreated time.Time
-        done        chan struct{}
-    }
+    // configFile is the path of the configuration file the server was started with, rewritten by CONFIG REWRITE.
+    configFile string
+    // storeConfig is the configuration the store was created with, see NewFromConfig.
+    storeConfig inMemoryDatabase.Config
+    // listeners accept the plaintext, TLS and unix socket connections, see listen.
+    listeners []listener
+    store     database.MemStore
+    pubsub    *pubSub
+    // tracking is the invalidation table of client side caching.
+    tracking *tracking
+    // clients holds the connected clients by id.
+    clients      map[uint64]*client
+    clientsLock  sync.RWMutex
+    nextClientID atomic.Uint64
+    // aof logs the write commands when the append only file is enabled, see EnableAOF.
+    aof        *aof.AOF
+    aofEnabled bool
+    aofConfig  aof.Config
+    // aofRewritePercentage and aofRewriteMinSize tell when the AOF is rewritten automatically, see SetAOFAutoRewrite.
+    aofRewritePercentage int
+    aofRewriteMinSize    int64
+    // aofRewriteStart is the start time of the rewrite in progress, zero if none.
+    aofRewriteStart        time.Time
+    aofLastRewriteDuration time.Duration
+    aofLastRewriteErr      error
+    aofRewrites            int
+    // aofRewriteScheduled and bgsaveScheduled are set when a job is requested while the other one runs, see backgroundJobsCron.
+    aofRewriteScheduled bool
+    bgsaveScheduled     bool
+    // bgsaveStart is the start time of the background save in progress, zero if none.
+    bgsaveStart        time.Time
+    lastBgsaveDuration time.Duration
+    lastBgsaveErr      error
+    rdbSaves           int
+    // savePoints trigger background saves, see saveCron.
+    savePoints []savePoint
+    // lastSave is the start time of the last successful save, lastSaveDirty the value of dirty then.
+    lastSave      time.Time
+    lastSaveDirty int64
+    // lastBgsaveTry is the start time of the last background save, successful or not.
+    lastBgsaveTry time.Time
+    // writeLock serializes the write commands while the AOF is enabled, so they are logged in the order they are applied.
+    writeLock sync.Mutex
+    // notifyFlags holds the classes of keyspace events published, see SetNotifyKeyspaceEvents.
+    notifyFlags atomic.Int64
+    // dirty counts the keys changed since the server started, the changes since the last save are dirty - lastSaveDirty.
+    dirty atomic.Int64
+    // commandLock is held for reading while a command executes and for writing while a transaction executes,
+    // so no other client can interleave with the commands of a transaction.
+    commandLock sync.RWMutex
+    // done is closed when the server shuts down, releasing every background goroutine at once.
+    done chan struct{}
+    // ready is closed once the server listens, see Ready, stopped once it is shut down, see Done.
+    ready   chan struct{}
+    stopped chan struct{}
+    // maxClients is the maximum number of connected clients.
+    maxClients int
+    // idleTimeout closes the clients idle for that long, 0 never does. tcpKeepAlive is the keepalive period of new connections.
+    idleTimeout  time.Duration
+    tcpKeepAlive time.Duration
+    // acl holds the users, aclLog the recent denials. aclFile is the ACL file the users are loaded from, if any.
+    acl     *acl.ACL
+    aclLog  *acl.Log
+    aclFile string
+    // requirePass is the password of the default user, see requirepass.
+    requirePass string
+    // unixSocket is the path of the unix socket the server listens on, if any, created with the permissions unixSocketPerm.
+    unixSocket     string
+    unixSocketPerm os.FileMode
+    // tls holds the settings of the TLS listener, tlsConfig the configuration of the new TLS connections, see reloadTLS.
+    tls       tlsSettings
+    tlsConfig atomic.Pointer[tls.Config]
+    // outputLimits are the output buffer limits of each class of clients, see client-output-buffer-limit.
+    outputLimits [numClientClasses]outputBufferLimit
+    // pause is set by CLIENT PAUSE, nil if the clients aren't paused.
+    pause *clientPause
+    // closed is set once the server is shut down: commands aren't executed anymore.
+    closed atomic.Bool
+    // started is the time the server was created, runID identifies this run of the server, see INFO server.
+    started time.Time
+    runID   string
+    // stats holds the counters reported by INFO stats, commandStats the statistics of each command.
+    stats        serverStats
+    commandStats commandStats
+    // latencyTracking enables the latency histograms of the commands, see latency-tracking.
+    latencyTracking atomic.Bool
+    // conns counts the connections being served.
+    conns sync.WaitGroup
+    // shutdownAbort is closed by SHUTDOWN ABORT, non-nil while a shutdown is in progress.
+    shutdownAbort   chan struct{}
+    shutdownTimeout time.Duration
     sync.RWMutex
 }
 
-// New creates a new RedisServer.
-func New(addr string, db database.MemDb) *RedisServer {
-    return &RedisServer{
-        addr: addr,
-        db:   db,
-        done: make(chan struct{}),
-        saveRoutines: make(map[time.Duration]struct {
-            timeCreated time.Time
-            done        chan struct{}
-        }),
+// New creates a new RedisServer serving the databases of store.
+func New(addr string, store database.MemStore) *RedisServer {
+    r := &RedisServer{
+        addr:     addr,
+        started:  time.Now(),
+        runID:    newRunID(),
+        store:    store,
+        pubsub:   newPubSub(),
+        tracking: newTracking(),
+        acl:      acl.New(),
+        aclLog:   acl.NewLog(acl.DefaultLogMaxLen),
+        clients:  make(map[uint64]*client),
+        done:     make(chan struct{}),
+        ready:    make(chan struct{}),
+        stopped:  make(chan struct{}),
+        // Same defaults as auto-aof-rewrite-percentage and auto-aof-rewrite-min-size.
+        aofRewritePercentage: 100,
+        aofRewriteMinSize:    64 << 20,
+        aofConfig:            defaultAOFConfig,
+        savePoints:           slices.Clone(defaultSavePoints),
+        lastSave:             time.Now(),
+        shutdownTimeout:      DefaultShutdownTimeout,
+        maxClients:           DefaultMaxClients,
+        tcpKeepAlive:         DefaultTCPKeepAlive * time.Second,
+        outputLimits:         defaultOutputBufferLimits,
+        tls:                  tlsSettings{authClients: "yes"},
     }
+    r.aofConfig.Dir = store.Dir()
+    r.latencyTracking.Store(true)
+    store.SetNotifier(r.keyspaceChanged)
+    return r
+}
+
+// Ready returns a channel closed once the server listens and accepts connections, see Run.
+func (r *RedisServer) Ready() <-chan struct{} {
+    return r.ready
 }
 
 // Run sets up a Redis server that can handle multiple client connections concurrently and respond to client requests asynchronously.
 // Each connection is handled in a separate goroutine, allowing the server to remain responsive to new connections and handle them efficiently.
+// Run returns net.ErrClosed once the server is shut down.
 func (r *RedisServer) Run() error {
-    go r.save(900*time.Second, 1)
-    go r.save(300*time.Second, 100)
+    go r.activeExpireCycle()
+    go r.backgroundJobsCron()
+    go r.statsCron()
 
     var err error
-    // Create a socket that can accept incoming connections.
-    r.l, err = net.Listen(TCP, r.addr)
+    // The dataset must be loaded before any client can connect.
+    if r.aofEnabled {
+        if err = r.openAOF(); err != nil {
+            return err
+        }
+    }
+
+    // Create the sockets that can accept incoming connections.
+    listeners, err := r.listen()
     if err != nil {
         return err
     }
+    r.Lock()
+    if r.closed.Load() {
+        // Shut down while loading the dataset.
+        r.Unlock()
+        for _, l := range listeners {
+            _ = l.Close()
+        }
+        return net.ErrClosed
+    }
+    r.listeners = listeners
+    r.Unlock()
+    close(r.ready)
+
+    var wg sync.WaitGroup
+    for _, l := range listeners {
+        wg.Add(1)
+        go func(l listener) {
+            defer wg.Done()
+            r.serve(l)
+        }(l)
+    }
+    wg.Wait()
+    return net.ErrClosed
+}
+
+// listener accepts the connections of one kind: tcp, tls or unix.
+type listener struct {
+    // Passing `net.Listener` by value is idiomatic and aligns with the general practice in Go of passing interface by value.
+    net.Listener
+    name string
+}
+
+// listen creates the listeners of the server, sharing the same databases: plaintext TCP on port, TLS on tls-port and
+// a unix socket on unixsocket. The plaintext port is only disabled by setting it to 0 along with another listener.
+func (r *RedisServer) listen() (listeners []listener, err error) {
+    r.RLock()
+    addr, tlsPort, unixSocket, unixSocketPerm := r.addr, r.tls.port, r.unixSocket, r.unixSocketPerm
+    r.RUnlock()
+    host, port, _ := net.SplitHostPort(addr)
+
+    defer func() {
+        if err != nil {
+            for _, l := range listeners {
+                _ = l.Close()
+            }
+        }
+    }()
+    if port != "0" || tlsPort == 0 && unixSocket == "" {
+        l, err := net.Listen(TCP, addr)
+        if err != nil {
+            return listeners, err
+        }
+        listeners = append(listeners, listener{l, TCP})
+    }
+    if tlsPort != 0 {
+        if err = r.reloadTLS(); err != nil {
+            return listeners, err
+        }
+        l, err := net.Listen(TCP, net.JoinHostPort(host, strconv.Itoa(tlsPort)))
+        if err != nil {
+            return listeners, err
+        }
+        // The configuration is looked up by every handshake, so reloaded certificates apply to the next connections.
+        listeners = append(listeners, listener{tls.NewListener(l, &tls.Config{GetConfigForClient: r.getTLSConfig}), "tls"})
+    }
+    if unixSocket != "" {
+        l, err := listenUnix(unixSocket, unixSocketPerm)
+        if err != nil {
+            return listeners, err
+        }
+        listeners = append(listeners, listener{l, Unix})
+    }
+    return listeners, nil
+}
+
+// listenUnix listens on a unix socket, replacing the file left by a previous run, and sets its permissions unless perm is 0.
+// The file is removed once the listener is closed.
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+    if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+        return nil, err
+    }
+    l, err := net.Listen(Unix, path)
+    if err != nil {
+        return nil, err
+    }
+    if perm != 0 {
+        if err = os.Chmod(path, perm); err != nil {
+            _ = l.Close()
+            return nil, err
+        }
+    }
+    return l, nil
+}
 
-    log.Println("RRedis listening on port 6379...")
-    // Forever loop ran here is necessary to continuously accept incoming connections.
+// serve accepts the connections of a listener until it is closed on shutdown.
+func (r *RedisServer) serve(l net.Listener) {
+    log.Printf("RRedis listening on %s...", l.Addr())
+    // Forever loop ran here is necessary to continuously accept incoming connections, until the listener is closed on shutdown.
+    var delay time.Duration
     for {
-        var conn net.Conn
         // Waiting for incoming connections.
-        conn, err = r.l.Accept()
-        if err != nil || conn == nil {
-            // If anything happens when waiting for connections, process towards the next loop.
-            // i.e. Error Accepting or Empty connection ( meaning that there's no incoming connection )
+        conn, err := l.Accept()
+        if errors.Is(err, net.ErrClosed) {
+            return
+        }
+        if err != nil {
+            // e.g. too many open files: retry after a delay doubling up to a second, rather than spinning.
+            delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+            log.Printf("RRedis accept error: %v, retrying in %v", err, delay)
+            select {
+            case <-time.After(delay):
+            case <-r.done:
+            }
             continue
         }
+        delay = 0
 
+        c, ok := r.acceptClient(conn)
+        if !ok {
+            continue
+        }
         // If receive a connection, spawn the connection dealing process with a goroutine.
         // Then go on to the next loop.
-        go func(conn net.Conn) {
-            // Close the connection after we're done dealing with the connection.
-            defer func() {
-                err := conn.Close()
-                if err != nil {
-                    fmt.Println(err)
-                }
-            }()
-            // Read data from the connection.
-            // For loop here is to enable sequential network reads on the same connection,
-            // and will break
-            for {
-                req := make([]byte, 1024)
-                n, err := conn.Read(req)
-                if err != nil {
-
-                    break
-                }
-
-                // Trim empty bytes.
-                req = req[:n]
-
-                // Handle request.
-                var response []byte
-                response = r.handleRequest(req)
-                if err != nil {
-                    break
-                }
-
-                // Write response to the connection (Responding to client).
-                if _, err = conn.Write(response); err != nil {
-                    break
-                }
+        go r.serveClient(c, conn)
+    }
+}
+
+// serveClient reads the requests of a client and queues the replies, until the connection is closed.
+func (r *RedisServer) serveClient(c *client, conn net.Conn) {
+    defer r.conns.Done()
+    // Replies and pushed messages are written by a dedicated goroutine, so the server can write to the client at any time.
+    go c.writeLoop()
+    // Close the connection after we're done dealing with the connection.
+    defer func() {
+        r.unregisterClient(c)
+        r.disableTracking(c)
+        r.pubsub.unsubscribeAll(c)
+        c.close()
+        // The replies already queued are written before the connection is closed.
+        <-c.flushed
+        err := conn.Close()
+        if err != nil && !errors.Is(err, net.ErrClosed) {
+            fmt.Println(err)
+        }
+    }()
+    if err := handshake(conn); err != nil {
+        log.Printf("RRedis error accepting a TLS connection from %s: %v", c.addr(), err)
+        return
+    }
+    // Read data from the connection.
+    // For loop here is to enable sequential network reads on the same connection,
+    // and will break
+    for {
+        _ = conn.SetReadDeadline(r.readDeadline(c))
+        // Killed meanwhile, the deadline set by kill was overridden.
+        if c.killed.Load() {
+            break
+        }
+        req := make([]byte, 1024)
+        n, err := conn.Read(req)
+        if err != nil {
+            // The deadline may have been reached as the client subscribed or the timeout changed meanwhile.
+            if isTimeout(err) && !c.killed.Load() && !r.idle(c) {
+                continue
             }
-        }(conn)
+            break
+        }
+
+        // Trim empty bytes.
+        req = req[:n]
+        r.stats.netInput.Add(int64(n))
+
+        // Handle request and queue the response to the connection (Responding to client).
+        response := r.handleRequest(c, req)
+        c.reply(response)
+    }
+}
+
+// handleRequest decodes a request and either executes it or, inside a transaction, queues it.
+func (r *RedisServer) handleRequest(c *client, request []byte) []byte {
+    robj, err := redisObject.Deserialize(request)
+    if err != nil {
+        // A command that can't be queued makes the whole transaction fail.
+        c.flagTransactionError()
+        return redisObject.Serialize(redisObject.SimpleErrors, "Unknown or disabled command")
+    }
+
+    c.recordCommand(commandName(robj))
+    r.stats.commands.Add(1)
+    defer c.syncInfo()
+    if response := r.checkPermission(c, robj); response != nil {
+        r.rejectCall(commandName(robj))
+        c.flagTransactionError()
+        return response
+    }
+    if !c.multi || robj.Command == "exec" {
+        r.waitPause(c, robj)
+    }
+
+    // CLIENT CACHING only applies to the next command, or to the next transaction as a whole.
+    defer func() {
+        if !c.multi && !isClientCaching(robj) {
+            c.caching = false
+        }
+    }()
+
+    // A subscribed RESP2 client can only manage its subscriptions, RESP3 clients tell messages and replies apart.
+    if c.resp == 2 && c.subscribed() && !allowedInSubscribedMode(robj.Command) {
+        r.rejectCall(commandName(robj))
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR Can't execute '"+robj.Command+"': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context")
+    }
+
+    // Transaction commands control the queue itself and are never queued.
+    // SHUTDOWN waits for the in-flight commands, it must not hold the command lock itself.
+    switch robj.Command {
+    case "shutdown", "multi", "exec", "discard", "watch":
+        start := time.Now()
+        response := r.controlCommand(c, robj)
+        r.recordCall(robj.Command, start, response)
+        return response
+    }
+
+    if c.multi {
+        return c.queueCommand(robj)
+    }
+
+    r.commandLock.RLock()
+    defer r.commandLock.RUnlock()
+    if r.aof != nil && isWriteCommand(robj.Command) {
+        r.writeLock.Lock()
+        defer r.writeLock.Unlock()
     }
+    return r.call(c, robj)
 }
 
-// Close closes the listener.
-// Any blocked `Accept` operations will be unblocked and return errors.
-func (r *RedisServer) Close(ctx context.Context) error {
-    fmt.Println("")
-    log.Println("Shutting down RRedis...")
-    <-ctx.Done()
-    r.done <- struct{}{}
-    return r.l.Close()
+// controlCommand runs a command handled outside of the command lock: a transaction command or SHUTDOWN.
+func (r *RedisServer) controlCommand(c *client, robj *redisObject.RObj) []byte {
+    switch robj.Command {
+    case "shutdown":
+        return r.shutdownCommand(robj.Content)
+    case "multi":
+        return r.multi(c)
+    case "exec":
+        return r.exec(c)
+    case "discard":
+        return r.discard(c)
+    }
+    return r.watch(c, robj.Content)
 }
 
-func (r *RedisServer) handleRequest(request []byte) []byte {
+// execute runs a single command against the selected database and returns its response.
+func (r *RedisServer) execute(c *client, robj *redisObject.RObj) []byte {
     var response []byte
+    // The keyspace events of the command are reported as caused by c, so c isn't sent their invalidations with NOLOOP.
+    db := r.store.Db(c.db).WithOrigin(c.id)
+    defer r.trackReadKeys(c, robj)()
+
+    switch robj.Command {
+    case "ping":
+        if c.resp == 2 && c.subscribed() {
+            // In the subscribed mode the reply has the same shape as a message.
+            response = redisObject.Serialize(redisObject.Arrays, "pong", "")
+            return response
+        }
+        response = redisObject.Serialize(redisObject.SimpleStrings, "PONG")
 
-    robj, err := redisObject.Deserialize(request)
-    if err != nil {
-        response = redisObject.Serialize(redisObject.SimpleErrors, "Unknown or disabled command")
-    } else {
-        switch robj.Command {
-        case "ping":
-            response = redisObject.Serialize(redisObject.SimpleStrings, "PONG")
-
-        case "echo":
-            response = redisObject.Serialize(redisObject.SimpleStrings, robj.Content...)
-
-        case "scan":
-            allKeys := r.db.GetAllKeys()
-            response = redisObject.Serialize(redisObject.Arrays, allKeys...)
-
-        case "set":
-            // Any SET operation will be successful and previous value is discarded.
-            // The command should always return '+OK\r\n'.
-            r.db.Set(robj.Content[0], robj.Content[1])
-
-            r.RLock()
-            r.keysChanged++
-            r.RUnlock()
-
-            response = redisObject.Serialize(redisObject.SimpleStrings, "OK")
-
-            // Expire robj that has time to live.
-            if robj.TimeToLive != 0 {
-                // Launch a goroutine that waits to expire the object.
-                go r.expireRObj(robj)
-            }
+    case "echo":
+        response = redisObject.Serialize(redisObject.SimpleStrings, robj.Content...)
 
-        case "get":
-            value, err := r.db.Get(robj.Content[0])
-            if err != nil {
-                // The error here can only be clients trying to get from the lrange database.
-                response = redisObject.Serialize(redisObject.SimpleErrors, "WRONGTYPE Operation against a key holding the wrong kind of value")
-            } else {
-                // Check for nil values.
-                if value == inMemoryDatabase.Nil {
-                    response = redisObject.Serialize(redisObject.BulkStrings, "-1")
-                    return response
-                }
-                response = redisObject.Serialize(redisObject.SimpleStrings, value)
-                // Do we have to check whether the value is an integer?
-            }
+    case "scan":
+        response = r.scan(db, robj.Content)
 
-        case "del":
-            keysDeleted := r.db.Delete(robj.Content...)
-            r.RLock()
-            r.keysChanged += keysDeleted
-            r.RUnlock()
-            // Integer response.
-            response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(keysDeleted))
-
-        case "exists":
-            // Integer response. 1 for found key, 0 otherwise.
-            if r.db.Exists(robj.Content[0]) {
-                response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(1))
+    case "keys":
+        keys := db.Keys(robj.Content[0])
+        response = redisObject.Serialize(redisObject.Arrays, keys...)
+
+    case "set":
+        // Any SET operation will be successful and previous value is discarded.
+        // The command should always return '+OK\r\n'.
+        db.Set(robj.Content[0], robj.Content[1])
+
+        r.dirty.Add(1)
+
+        response = redisObject.Serialize(redisObject.SimpleStrings, "OK")
+
+        // Expire robj that has time to live.
+        if robj.TimeToLive != 0 {
+            db.Expire(robj.Content[0], time.Now().Add(robj.TimeToLive))
+        }
+
+    case "get":
+        value, err := db.Get(robj.Content[0])
+        if err != nil {
+            // The error here can only be clients trying to get from the lrange database.
+            response = redisObject.Serialize(redisObject.SimpleErrors, "WRONGTYPE Operation against a key holding the wrong kind of value")
+        } else {
+            // Check for nil values.
+            if value == inMemoryDatabase.Nil {
+                response = redisObject.Serialize(redisObject.BulkStrings, "-1")
                 return response
             }
+            response = redisObject.Serialize(redisObject.SimpleStrings, value)
+            // Do we have to check whether the value is an integer?
+        }
+
+    case "del":
+        keysDeleted := db.Delete(robj.Content...)
+        r.dirty.Add(int64(keysDeleted))
+        // Integer response.
+        response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(keysDeleted))
+
+    case "exists":
+        // Integer response. 1 for found key, 0 otherwise.
+        if db.Exists(robj.Content[0]) {
+            response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(1))
+            return response
+        }
+        response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(0))
+
+    case "pexpireat":
+        at, err := strconv.ParseInt(robj.Content[1], 10, 64)
+        if err != nil {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "ERR value is not an integer or out of range")
+            return response
+        }
+        if !db.Expire(robj.Content[0], time.UnixMilli(at)) {
             response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(0))
+            return response
+        }
+        r.dirty.Add(1)
+        response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(1))
+
+    case "incr":
+        value, err := db.Increment(robj.Content[0])
+        if err != nil {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "WRONGTYPE Operation against a key holding the wrong kind of value")
+            return response
+        }
+        r.dirty.Add(1)
+        response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(value))
+
+    case "decr":
+        value, err := db.Decrement(robj.Content[0])
+        if err != nil {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "WRONGTYPE Operation against a key holding the wrong kind of value")
+            return response
+        }
+        r.dirty.Add(1)
+        response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(value))
 
-        case "incr":
-            value, err := r.db.Increment(robj.Content[0])
-            if err != nil {
-                response = redisObject.Serialize(redisObject.SimpleErrors, "WRONGTYPE Operation against a key holding the wrong kind of value")
-                return response
-            }
-            r.RLock()
-            r.keysChanged++
-            r.RUnlock()
-            response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(value))
+    case "save":
+        if err := r.saveDatabase(); err != nil {
+            return saveErrorReply(err)
+        }
+        response = redisObject.Serialize(redisObject.SimpleStrings, "OK")
 
-        case "decr":
-            value, err := r.db.Decrement(robj.Content[0])
-            if err != nil {
-                response = redisObject.Serialize(redisObject.SimpleErrors, "WRONGTYPE Operation against a key holding the wrong kind of value")
-                return response
-            }
-            r.RLock()
-            r.keysChanged++
-            r.RUnlock()
-            response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(value))
-
-        case "save":
-            if err := r.db.SaveDatabase(); err != nil {
-                panic(err)
-            }
-            if len(robj.Content) != 0 {
-                // Setup save options
-                go r.save(robj.SaveOptions.CheckCycle, robj.SaveOptions.CheckKeys)
-            }
-            response = redisObject.Serialize(redisObject.SimpleStrings, "OK")
+    case "lpush":
+        valuesPushed, err := db.LeftPush(robj.Content[0], robj.Content[1:]...)
+        if err != nil {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "WRONGTYPE Operation against a key holding the wrong kind of value")
+            return response
+        }
+        r.dirty.Add(1)
 
-        case "lpush":
-            valuesPushed, err := r.db.LeftPush(robj.Content[0], robj.Content[1:]...)
-            if err != nil {
-                response = redisObject.Serialize(redisObject.SimpleErrors, "WRONGTYPE Operation against a key holding the wrong kind of value")
-                return response
-            }
-            r.RLock()
-            r.keysChanged++
-            r.RUnlock()
+        response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(valuesPushed))
 
-            response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(valuesPushed))
+    case "rpush":
+        valuesPushed, err := db.RightPush(robj.Content[0], robj.Content[1:]...)
+        if err != nil {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "WRONGTYPE Operation against a key holding the wrong kind of value")
+            return response
+        }
+        r.dirty.Add(1)
+        response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(valuesPushed))
+
+    case "lrange":
+        // Need to type check the start and end.
+        start, err := strconv.Atoi(robj.Content[1])
+        if err != nil {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "ERR value is not an integer or out of range")
+            return response
+        }
 
-        case "rpush":
-            valuesPushed, err := r.db.RightPush(robj.Content[0], robj.Content[1:]...)
-            if err != nil {
-                response = redisObject.Serialize(redisObject.SimpleErrors, "WRONGTYPE Operation against a key holding the wrong kind of value")
-                return response
-            }
-            r.RLock()
-            r.keysChanged++
-            r.RUnlock()
-            response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(valuesPushed))
-
-        case "lrange":
-            // Need to type check the start and end.
-            start, err := strconv.Atoi(robj.Content[1])
-            if err != nil {
-                response = redisObject.Serialize(redisObject.SimpleErrors, "ERR value is not an integer or out of range")
-                return response
-            }
+        end, err := strconv.Atoi(robj.Content[2])
+        if err != nil {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "ERR value is not an integer or out of range")
+            return response
+        }
 
-            end, err := strconv.Atoi(robj.Content[2])
-            if err != nil {
-                response = redisObject.Serialize(redisObject.SimpleErrors, "ERR value is not an integer or out of range")
-                return response
-            }
+        var result []string
+        result, err = db.LRange(robj.Content[0], start, end)
+        if err != nil {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "WRONGTYPE Operation against a key holding the wrong kind of value")
+            return response
+        }
+        response = redisObject.Serialize(redisObject.Arrays, result...)
 
-            var result []string
-            result, err = r.db.LRange(robj.Content[0], start, end)
-            if err != nil {
-                response = redisObject.Serialize(redisObject.SimpleErrors, "WRONGTYPE Operation against a key holding the wrong kind of value")
+    case "select":
+        index, err := strconv.Atoi(robj.Content[0])
+        if err != nil {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "ERR value is not an integer or out of range")
+            return response
+        }
+        if index < 0 || index >= r.store.Len() {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "ERR DB index is out of range")
+            return response
+        }
+        c.db = index
+        response = redisObject.Serialize(redisObject.SimpleStrings, "OK")
+
+    case "move":
+        index, err := strconv.Atoi(robj.Content[1])
+        if err != nil {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "ERR value is not an integer or out of range")
+            return response
+        }
+        if index == c.db {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "ERR source and destination objects are the same")
+            return response
+        }
+        moved, err := r.store.Move(robj.Content[0], c.db, index)
+        if err != nil {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "ERR DB index is out of range")
+            return response
+        }
+        if !moved {
+            response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(0))
+            return response
+        }
+        r.dirty.Add(1)
+        response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(1))
+
+    case "swapdb":
+        first, err := strconv.Atoi(robj.Content[0])
+        if err != nil {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "ERR invalid first DB index")
+            return response
+        }
+        second, err := strconv.Atoi(robj.Content[1])
+        if err != nil {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "ERR invalid second DB index")
+            return response
+        }
+        if err = r.store.SwapDb(first, second); err != nil {
+            response = redisObject.Serialize(redisObject.SimpleErrors, "ERR DB index is out of range")
+            return response
+        }
+        r.dirty.Add(1)
+        response = redisObject.Serialize(redisObject.SimpleStrings, "OK")
+
+    case "dbsize":
+        response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(db.Size()))
+
+    case "flushdb", "flushall":
+        // Flushing only drops references to the old data, SYNC and ASYNC behave the same.
+        if len(robj.Content) == 1 {
+            if mode := strings.ToLower(robj.Content[0]); mode != "async" && mode != "sync" {
+                response = redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
                 return response
             }
-            response = redisObject.Serialize(redisObject.Arrays, result...)
+        }
 
-        case "command": // This is for starting up, which will never show on the client side.
-            response = redisObject.Serialize(redisObject.SimpleStrings, "Hello, Edward's Redis.")
+        var keysDeleted int
+        if robj.Command == "flushdb" {
+            keysDeleted = db.Flush()
+        } else {
+            keysDeleted = r.store.FlushAll()
         }
+        r.invalidateAll()
+        r.dirty.Add(int64(keysDeleted))
+        response = redisObject.Serialize(redisObject.SimpleStrings, "OK")
+
+    case "unwatch":
+        c.unwatchAll()
+        response = redisObject.Serialize(redisObject.SimpleStrings, "OK")
+
+    case "subscribe":
+        response = r.pubsub.subscribe(c, robj.Content...)
+
+    case "psubscribe":
+        response = r.pubsub.psubscribe(c, robj.Content...)
+
+    case "unsubscribe":
+        response = r.pubsub.unsubscribe(c, robj.Content...)
+
+    case "punsubscribe":
+        response = r.pubsub.punsubscribe(c, robj.Content...)
+
+    case "publish":
+        receivers := r.pubsub.publish(robj.Content[0], robj.Content[1])
+        response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(receivers))
+
+    case "ssubscribe":
+        response = r.pubsub.ssubscribe(c, robj.Content...)
+
+    case "sunsubscribe":
+        response = r.pubsub.sunsubscribe(c, robj.Content...)
+
+    case "spublish":
+        receivers := r.pubsub.spublish(robj.Content[0], robj.Content[1])
+        response = redisObject.Serialize(redisObject.Integers, strconv.Itoa(receivers))
+
+    case "pubsub":
+        response = r.pubSubCommand(robj.Content)
+
+    case "config":
+        response = r.configCommand(robj.Content)
+
+    case "bgsave":
+        response = r.bgsave(robj.Content)
+
+    case "lastsave":
+        r.RLock()
+        response = redisObject.Serialize(redisObject.Integers, strconv.FormatInt(r.lastSave.Unix(), 10))
+        r.RUnlock()
+
+    case "bgrewriteaof":
+        response = r.bgRewriteAOF()
+
+    case "info":
+        response = r.info(robj.Content)
+
+    case "hello":
+        response = r.hello(c, robj.Content)
+
+    case "auth":
+        response = r.auth(c, robj.Content)
+
+    case "acl":
+        response = r.aclCommand(c, robj.Content)
+
+    case "client":
+        response = r.clientCommand(c, robj.Content)
+
+    case "command": // This is for starting up, which will never show on the client side.
+        response = redisObject.Serialize(redisObject.SimpleStrings, "Hello, Edward's Redis.")
     }
     return response
 }
 
-// expireRObj expires a Redis object after reaches time to live.
-func (r *RedisServer) expireRObj(robj *redisObject.RObj) {
-    // Blocking process.
-    select {
-    // Choose time.After over time.NewTimer for light-weight purposes.
-    case <-time.After(robj.TimeToLive):
-        // Will delete when receive from time.After(), unblock process.
-        r.db.Delete(robj.Content[0])
+// scan handles `SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]`.
+// The reply is a two elements array: the cursor for the next call and the array of keys found.
+func (r *RedisServer) scan(db database.MemDb, args []string) []byte {
+    cursor, err := strconv.ParseUint(args[0], 10, 64)
+    if err != nil {
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR invalid cursor")
     }
-}
 
-// save method is responsible for managing periodic saving operations for the Redis server.
-// It monitors the number of active save routines and limits them to five.
-// If there are already five save routines running, it replaces the earliest one with a new one.
-func (r *RedisServer) save(checkCycle time.Duration, checkKeys int) {
-    r.Lock()
-    if len(r.saveRoutines) >= 5 {
-        // Collect keys to delete
-        var earliestKey time.Duration
-        earliestTime := time.Now()
-        for k, t := range r.saveRoutines {
-            if t.timeCreated.Before(earliestTime) {
-                earliestTime = t.timeCreated
-                earliestKey = k
+    count := 10
+    var pattern, keyType string
+    // Options come in pairs after the cursor.
+    for i := 1; i < len(args); i += 2 {
+        if i+1 >= len(args) {
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+        }
+        switch strings.ToLower(args[i]) {
+        case "match":
+            pattern = args[i+1]
+        case "count":
+            count, err = strconv.Atoi(args[i+1])
+            if err != nil {
+                return redisObject.Serialize(redisObject.SimpleErrors, "ERR value is not an integer or out of range")
+            }
+            if count < 1 {
+                return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
             }
+        case "type":
+            keyType = strings.ToLower(args[i+1])
+        default:
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
         }
-        r.saveRoutines[earliestKey].done <- struct{}{}
-        delete(r.saveRoutines, earliestKey)
     }
-    now := time.Now()
-    saveChan := make(chan struct{})
-    r.saveRoutines[checkCycle] = struct {
-        timeCreated time.Time
-        done        chan struct{}
-    }{timeCreated: now, done: saveChan}
-    r.Unlock()
 
-    ticker := time.NewTicker(checkCycle)
-    initialKey := r.keysChanged
+    next, keys := db.Scan(cursor, count, pattern, keyType)
+    return redisObject.SerializeArray(
+        redisObject.Serialize(redisObject.BulkStrings, strconv.FormatUint(next, 10)),
+        redisObject.Serialize(redisObject.Arrays, keys...),
+    )
+}
+
+// activeExpireCycle periodically deletes the keys whose time to live has elapsed, until the server is closed.
+// Expired keys are already invisible to clients, this reclaims the memory of keys nobody accesses anymore.
+func (r *RedisServer) activeExpireCycle() {
+    ticker := time.NewTicker(100 * time.Millisecond)
+    defer ticker.Stop()
 
     for {
         select {
-        case <-r.done: // Release current goroutine.
-            return
-        case <-saveChan:
+        case <-r.done:
             return
         case <-ticker.C:
-            r.Lock()
-            if r.keysChanged-initialKey >= checkKeys {
-                _ = r.db.SaveDatabase()
-                initialKey = r.keysChanged
+            // Expiring is a write, paused by CLIENT PAUSE.
+            if r.paused() {
+                continue
             }
-            r.Unlock()
+            // Expiring is a write like any other, it must not interleave with a transaction.
+            r.commandLock.RLock()
+            r.store.ActiveExpire()
+            r.commandLock.RUnlock()
         }
     }
 }
@@ -1,34 +1,66 @@
 package server
 
 import (
+    "MyOwnRedis/internal/aof"
+    "MyOwnRedis/internal/config"
     "MyOwnRedis/internal/database/inMemoryDatabase"
+    "MyOwnRedis/internal/redisObject"
     "bytes"
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
     "errors"
+    "fmt"
     "io"
+    "math"
+    "math/big"
     "net"
+    "os"
+    "path/filepath"
+    "slices"
+    "strconv"
+    "strings"
+    "sync"
     "testing"
+    "time"
 )
 
 const TestAddr = "localhost:6380"
 
+// newTestStore creates an empty store as configured by config, saving to a temporary directory.
+func newTestStore(t *testing.T, config inMemoryDatabase.Config) *inMemoryDatabase.Store {
+    t.Helper()
+    config.Dir = t.TempDir()
+    store, err := inMemoryDatabase.NewEmpty(config)
+    if err != nil {
+        t.Fatalf("error creating the store: %v\n", err)
+    }
+    return store
+}
+
 func TestRedisServer_Run(t *testing.T) {
-    db := inMemoryDatabase.New()
-    rs := New(TestAddr, db)
+    store := newTestStore(t, inMemoryDatabase.Config{})
+    rs := New(TestAddr, store)
     go func() {
         err := rs.Run()
         if err != nil {
             panic(err)
         }
         defer func() {
-            if err = rs.Close(); err != nil {
+            if err = rs.Close(context.Background()); err != nil {
                 panic(err)
             }
         }()
     }()
 
-    clientConn, err := net.Dial(TCP, TestAddr)
+    clientConn, err := dial(TestAddr)
     if err != nil {
-        t.Errorf("error cannot connect to server: %#v\n", err)
+        t.Fatalf("error cannot connect to server: %#v\n", err)
     }
 
     testCases := []struct {
@@ -43,6 +75,54 @@ func TestRedisServer_Run(t *testing.T) {
             request:  []byte("*3\r\n$4\r\necho\r\n$5\r\nhello\r\n$5\r\nworld\r\n"),
             response: []byte("+hello world\r\n"),
         },
+        {
+            request:  []byte("*3\r\n$3\r\nset\r\n$13\r\nscan-test-key\r\n$1\r\n1\r\n"),
+            response: []byte("+OK\r\n"),
+        },
+        {
+            request:  []byte("*6\r\n$4\r\nscan\r\n$1\r\n0\r\n$5\r\nmatch\r\n$13\r\nscan-test-key\r\n$5\r\ncount\r\n$4\r\n1000\r\n"),
+            response: []byte("*2\r\n$1\r\n0\r\n*1\r\n$13\r\nscan-test-key\r\n"),
+        },
+        {
+            request:  []byte("*2\r\n$4\r\nscan\r\n$3\r\nabc\r\n"),
+            response: []byte("-ERR invalid cursor\r\n"),
+        },
+        {
+            request:  []byte("*2\r\n$4\r\nkeys\r\n$13\r\nscan-test-ke?\r\n"),
+            response: []byte("*1\r\n$13\r\nscan-test-key\r\n"),
+        },
+        {
+            request:  []byte("*2\r\n$6\r\nselect\r\n$2\r\n15\r\n"),
+            response: []byte("+OK\r\n"),
+        },
+        {
+            request:  []byte("*1\r\n$7\r\nflushdb\r\n"),
+            response: []byte("+OK\r\n"),
+        },
+        {
+            request:  []byte("*3\r\n$3\r\nset\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"),
+            response: []byte("+OK\r\n"),
+        },
+        {
+            request:  []byte("*1\r\n$6\r\ndbsize\r\n"),
+            response: []byte(":1\r\n"),
+        },
+        {
+            request:  []byte("*3\r\n$4\r\nmove\r\n$3\r\nfoo\r\n$2\r\n15\r\n"),
+            response: []byte("-ERR source and destination objects are the same\r\n"),
+        },
+        {
+            request:  []byte("*2\r\n$7\r\nFLUSHDB\r\n$5\r\nASYNC\r\n"),
+            response: []byte("+OK\r\n"),
+        },
+        {
+            request:  []byte("*1\r\n$6\r\ndbsize\r\n"),
+            response: []byte(":0\r\n"),
+        },
+        {
+            request:  []byte("*2\r\n$6\r\nselect\r\n$2\r\n16\r\n"),
+            response: []byte("-ERR DB index is out of range\r\n"),
+        },
     }
 
     for _, tc := range testCases {
@@ -67,3 +147,1741 @@ func TestRedisServer_Run(t *testing.T) {
         panic(err)
     }
 }
+
+// startTestServer starts a server on a free port of 127.0.0.1, configured with directives, and returns it along with its address.
+// The server is shut down at the end of the test.
+func startTestServer(t *testing.T, directives ...config.Directive) (*RedisServer, string) {
+    t.Helper()
+    // The free port is picked here: listening on port 0 disables the TCP listener along with a unix socket.
+    l, err := net.Listen(TCP, "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    addr := l.Addr().String()
+    _ = l.Close()
+
+    rs := New(addr, newTestStore(t, inMemoryDatabase.Config{}))
+    if err = rs.Configure(directives); err != nil {
+        t.Fatalf("error configuring the server: %v\n", err)
+    }
+    go func() {
+        _ = rs.Run()
+    }()
+    t.Cleanup(func() {
+        _ = rs.Close(context.Background())
+    })
+    <-rs.Ready()
+    return rs, addr
+}
+
+// command returns a command as sent by the clients.
+func command(args ...string) []byte {
+    return redisObject.Serialize(redisObject.Arrays, args...)
+}
+
+// dial connects to addr, retrying for a while since the server starts listening asynchronously.
+func dial(addr string) (net.Conn, error) {
+    var conn net.Conn
+    var err error
+    for i := 0; i < 50; i++ {
+        conn, err = net.Dial(TCP, addr)
+        if err == nil {
+            return conn, nil
+        }
+        time.Sleep(20 * time.Millisecond)
+    }
+    return nil, err
+}
+
+func TestRedisServer_Transaction(t *testing.T) {
+    const addr = "localhost:6381"
+    store := newTestStore(t, inMemoryDatabase.Config{})
+    rs := New(addr, store)
+    go func() {
+        _ = rs.Run()
+    }()
+
+    clientConn, err := dial(addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer clientConn.Close()
+    otherConn, err := dial(addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer otherConn.Close()
+
+    testCases := []struct {
+        conn     net.Conn
+        request  []byte
+        response []byte
+    }{
+        // Queue and execute commands.
+        {conn: clientConn, request: []byte("*2\r\n$6\r\nselect\r\n$1\r\n9\r\n"), response: []byte("+OK\r\n")},
+        {conn: clientConn, request: []byte("*1\r\n$7\r\nflushdb\r\n"), response: []byte("+OK\r\n")},
+        {conn: clientConn, request: []byte("*1\r\n$5\r\nmulti\r\n"), response: []byte("+OK\r\n")},
+        {conn: clientConn, request: []byte("*1\r\n$5\r\nmulti\r\n"), response: []byte("-ERR MULTI calls can not be nested\r\n")},
+        {conn: clientConn, request: []byte("*2\r\n$4\r\nincr\r\n$7\r\nbalance\r\n"), response: []byte("+QUEUED\r\n")},
+        {conn: clientConn, request: []byte("*2\r\n$4\r\nincr\r\n$7\r\nbalance\r\n"), response: []byte("+QUEUED\r\n")},
+        {conn: clientConn, request: []byte("*1\r\n$4\r\nexec\r\n"), response: []byte("*2\r\n:1\r\n:2\r\n")},
+        {conn: clientConn, request: []byte("*1\r\n$4\r\nexec\r\n"), response: []byte("-ERR EXEC without MULTI\r\n")},
+        // Discard the queued commands.
+        {conn: clientConn, request: []byte("*1\r\n$5\r\nmulti\r\n"), response: []byte("+OK\r\n")},
+        {conn: clientConn, request: []byte("*2\r\n$4\r\nincr\r\n$7\r\nbalance\r\n"), response: []byte("+QUEUED\r\n")},
+        {conn: clientConn, request: []byte("*1\r\n$7\r\ndiscard\r\n"), response: []byte("+OK\r\n")},
+        {conn: clientConn, request: []byte("*2\r\n$3\r\nget\r\n$7\r\nbalance\r\n"), response: []byte("+2\r\n")},
+        // A command failing to be queued aborts the transaction.
+        {conn: clientConn, request: []byte("*1\r\n$5\r\nmulti\r\n"), response: []byte("+OK\r\n")},
+        {conn: clientConn, request: []byte("*1\r\n$4\r\nincr\r\n"), response: []byte("-Unknown or disabled command\r\n")},
+        {conn: clientConn, request: []byte("*1\r\n$4\r\nexec\r\n"), response: []byte("-EXECABORT Transaction discarded because of previous errors.\r\n")},
+        // A watched key modified by another client aborts the transaction.
+        {conn: clientConn, request: []byte("*2\r\n$5\r\nwatch\r\n$7\r\nbalance\r\n"), response: []byte("+OK\r\n")},
+        {conn: otherConn, request: []byte("*2\r\n$6\r\nselect\r\n$1\r\n9\r\n"), response: []byte("+OK\r\n")},
+        {conn: otherConn, request: []byte("*2\r\n$4\r\nincr\r\n$7\r\nbalance\r\n"), response: []byte(":3\r\n")},
+        {conn: clientConn, request: []byte("*1\r\n$5\r\nmulti\r\n"), response: []byte("+OK\r\n")},
+        {conn: clientConn, request: []byte("*2\r\n$4\r\nincr\r\n$7\r\nbalance\r\n"), response: []byte("+QUEUED\r\n")},
+        {conn: clientConn, request: []byte("*1\r\n$4\r\nexec\r\n"), response: []byte("*-1\r\n")},
+        // EXEC unwatches the keys, the next transaction succeeds.
+        {conn: clientConn, request: []byte("*1\r\n$5\r\nmulti\r\n"), response: []byte("+OK\r\n")},
+        {conn: clientConn, request: []byte("*2\r\n$4\r\nincr\r\n$7\r\nbalance\r\n"), response: []byte("+QUEUED\r\n")},
+        {conn: clientConn, request: []byte("*1\r\n$4\r\nexec\r\n"), response: []byte("*1\r\n:4\r\n")},
+    }
+
+    for _, tc := range testCases {
+        expectResponse(t, tc.conn, tc.request, tc.response)
+    }
+}
+
+func TestRedisServer_PubSub(t *testing.T) {
+    const addr = "localhost:6382"
+    store := newTestStore(t, inMemoryDatabase.Config{})
+    rs := New(addr, store)
+    go func() {
+        _ = rs.Run()
+    }()
+
+    subscriber, err := dial(addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer subscriber.Close()
+    publisher, err := dial(addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer publisher.Close()
+
+    testCases := []struct {
+        conn     net.Conn
+        request  []byte
+        response []byte
+        // pushed is the message the subscriber should receive after the request.
+        pushed []byte
+    }{
+        {
+            conn:     subscriber,
+            request:  []byte("*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n$6\r\nsports\r\n"),
+            response: []byte("*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$6\r\nsports\r\n:2\r\n"),
+        },
+        {
+            conn:     subscriber,
+            request:  []byte("*2\r\n$10\r\npsubscribe\r\n$6\r\nnews.*\r\n"),
+            response: []byte("*3\r\n$10\r\npsubscribe\r\n$6\r\nnews.*\r\n:3\r\n"),
+        },
+        {
+            conn:     subscriber,
+            request:  []byte("*2\r\n$3\r\nget\r\n$3\r\nfoo\r\n"),
+            response: []byte("-ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n"),
+        },
+        {
+            conn:     subscriber,
+            request:  []byte("*1\r\n$4\r\nping\r\n"),
+            response: []byte("*2\r\n$4\r\npong\r\n$0\r\n\r\n"),
+        },
+        {
+            conn:     publisher,
+            request:  []byte("*3\r\n$7\r\npublish\r\n$4\r\nnews\r\n$5\r\nhello\r\n"),
+            response: []byte(":1\r\n"),
+            pushed:   []byte("*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n"),
+        },
+        {
+            conn:     publisher,
+            request:  []byte("*3\r\n$7\r\npublish\r\n$10\r\nnews.tech1\r\n$2\r\nhi\r\n"),
+            response: []byte(":1\r\n"),
+            pushed:   []byte("*4\r\n$8\r\npmessage\r\n$6\r\nnews.*\r\n$10\r\nnews.tech1\r\n$2\r\nhi\r\n"),
+        },
+        {
+            conn:     publisher,
+            request:  []byte("*3\r\n$7\r\npublish\r\n$7\r\nweather\r\n$2\r\nhi\r\n"),
+            response: []byte(":0\r\n"),
+        },
+        {
+            conn:     publisher,
+            request:  []byte("*2\r\n$6\r\npubsub\r\n$8\r\nchannels\r\n"),
+            response: []byte("*2\r\n$4\r\nnews\r\n$6\r\nsports\r\n"),
+        },
+        {
+            conn:     publisher,
+            request:  []byte("*4\r\n$6\r\npubsub\r\n$6\r\nnumsub\r\n$4\r\nnews\r\n$7\r\nweather\r\n"),
+            response: []byte("*4\r\n$4\r\nnews\r\n:1\r\n$7\r\nweather\r\n:0\r\n"),
+        },
+        {
+            conn:     publisher,
+            request:  []byte("*2\r\n$6\r\npubsub\r\n$6\r\nnumpat\r\n"),
+            response: []byte(":1\r\n"),
+        },
+        {
+            conn:     subscriber,
+            request:  []byte("*1\r\n$11\r\nunsubscribe\r\n"),
+            response: []byte("*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:2\r\n*3\r\n$11\r\nunsubscribe\r\n$6\r\nsports\r\n:1\r\n"),
+        },
+        {
+            conn:     subscriber,
+            request:  []byte("*2\r\n$12\r\npunsubscribe\r\n$6\r\nnews.*\r\n"),
+            response: []byte("*3\r\n$12\r\npunsubscribe\r\n$6\r\nnews.*\r\n:0\r\n"),
+        },
+        {
+            conn:     subscriber,
+            request:  []byte("*1\r\n$11\r\nunsubscribe\r\n"),
+            response: []byte("*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n"),
+        },
+        {
+            // Out of the subscribed mode, every command is allowed again.
+            conn:     subscriber,
+            request:  []byte("*1\r\n$4\r\nping\r\n"),
+            response: []byte("+PONG\r\n"),
+        },
+    }
+
+    for _, tc := range testCases {
+        expectResponse(t, tc.conn, tc.request, tc.response)
+        if tc.pushed != nil {
+            expectResponse(t, subscriber, nil, tc.pushed)
+        }
+    }
+}
+
+// expectResponse writes request to conn, if any, and checks the next bytes read from conn are the expected response.
+func expectResponse(t *testing.T, conn net.Conn, request []byte, response []byte) {
+    t.Helper()
+
+    if request != nil {
+        if _, err := conn.Write(request); err != nil {
+            t.Fatalf("error writing request: %#v.\n", err)
+        }
+    }
+
+    resp := make([]byte, len(response))
+    _ = conn.SetReadDeadline(time.Now().Add(time.Second))
+    defer conn.SetReadDeadline(time.Time{})
+    if _, err := io.ReadFull(conn, resp); err != nil {
+        t.Fatalf("error reading response to %q: %#v.\n", request, err)
+    }
+    if !bytes.Equal(resp, response) {
+        t.Errorf("error response to %q didn't match, expected %q, got %q.\n", request, response, resp)
+    }
+}
+
+func TestRedisServer_ShardedPubSub(t *testing.T) {
+    const addr = "localhost:6383"
+    store := newTestStore(t, inMemoryDatabase.Config{})
+    rs := New(addr, store)
+    go func() {
+        _ = rs.Run()
+    }()
+
+    subscriber, err := dial(addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer subscriber.Close()
+    publisher, err := dial(addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer publisher.Close()
+
+    testCases := []struct {
+        conn     net.Conn
+        request  []byte
+        response []byte
+        pushed   []byte
+    }{
+        {
+            conn:     subscriber,
+            request:  []byte("*3\r\n$10\r\nssubscribe\r\n$5\r\norder\r\n$6\r\nbasket\r\n"),
+            response: []byte("-CROSSSLOT Keys in request don't hash to the same slot\r\n"),
+        },
+        {
+            conn:     subscriber,
+            request:  []byte("*3\r\n$10\r\nssubscribe\r\n$12\r\n{user1}.cart\r\n$14\r\n{user1}.orders\r\n"),
+            response: []byte("*3\r\n$10\r\nssubscribe\r\n$12\r\n{user1}.cart\r\n:1\r\n*3\r\n$10\r\nssubscribe\r\n$14\r\n{user1}.orders\r\n:2\r\n"),
+        },
+        {
+            conn:     subscriber,
+            request:  []byte("*2\r\n$3\r\nget\r\n$3\r\nfoo\r\n"),
+            response: []byte("-ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n"),
+        },
+        {
+            conn:     publisher,
+            request:  []byte("*3\r\n$8\r\nspublish\r\n$12\r\n{user1}.cart\r\n$4\r\nitem\r\n"),
+            response: []byte(":1\r\n"),
+            pushed:   []byte("*3\r\n$8\r\nsmessage\r\n$12\r\n{user1}.cart\r\n$4\r\nitem\r\n"),
+        },
+        {
+            // Regular channels and shard channels are separate namespaces.
+            conn:     publisher,
+            request:  []byte("*3\r\n$7\r\npublish\r\n$12\r\n{user1}.cart\r\n$4\r\nitem\r\n"),
+            response: []byte(":0\r\n"),
+        },
+        {
+            conn:     publisher,
+            request:  []byte("*3\r\n$6\r\npubsub\r\n$13\r\nshardchannels\r\n$10\r\n{user1}.c*\r\n"),
+            response: []byte("*1\r\n$12\r\n{user1}.cart\r\n"),
+        },
+        {
+            conn:     publisher,
+            request:  []byte("*4\r\n$6\r\npubsub\r\n$11\r\nshardnumsub\r\n$14\r\n{user1}.orders\r\n$5\r\nother\r\n"),
+            response: []byte("*4\r\n$14\r\n{user1}.orders\r\n:1\r\n$5\r\nother\r\n:0\r\n"),
+        },
+        {
+            conn:     subscriber,
+            request:  []byte("*1\r\n$12\r\nsunsubscribe\r\n"),
+            response: []byte("*3\r\n$12\r\nsunsubscribe\r\n$12\r\n{user1}.cart\r\n:1\r\n*3\r\n$12\r\nsunsubscribe\r\n$14\r\n{user1}.orders\r\n:0\r\n"),
+        },
+        {
+            conn:     publisher,
+            request:  []byte("*2\r\n$6\r\npubsub\r\n$13\r\nshardchannels\r\n"),
+            response: []byte("*0\r\n"),
+        },
+    }
+
+    for _, tc := range testCases {
+        expectResponse(t, tc.conn, tc.request, tc.response)
+        if tc.pushed != nil {
+            expectResponse(t, subscriber, nil, tc.pushed)
+        }
+    }
+}
+
+func TestRedisServer_KeyspaceNotifications(t *testing.T) {
+    const addr = "localhost:6384"
+    store := newTestStore(t, inMemoryDatabase.Config{})
+    rs := New(addr, store)
+    go func() {
+        _ = rs.Run()
+    }()
+
+    subscriber, err := dial(addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer subscriber.Close()
+    conn, err := dial(addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer conn.Close()
+
+    testCases := []struct {
+        conn     net.Conn
+        request  []byte
+        response []byte
+        pushed   []byte
+    }{
+        {
+            conn:     conn,
+            request:  []byte("*4\r\n$6\r\nconfig\r\n$3\r\nset\r\n$22\r\nnotify-keyspace-events\r\n$3\r\nKEQ\r\n"),
+            response: []byte("-ERR CONFIG SET failed (possibly related to argument 'notify-keyspace-events') - invalid event class character, use 'Ag$lshzxeKEtmdn'\r\n"),
+        },
+        {
+            conn:     conn,
+            request:  []byte("*4\r\n$6\r\nconfig\r\n$3\r\nset\r\n$22\r\nnotify-keyspace-events\r\n$4\r\nKE$x\r\n"),
+            response: []byte("+OK\r\n"),
+        },
+        {
+            conn:     conn,
+            request:  []byte("*3\r\n$6\r\nconfig\r\n$3\r\nget\r\n$22\r\nnotify-keyspace-events\r\n"),
+            response: []byte("*2\r\n$22\r\nnotify-keyspace-events\r\n$4\r\n$xKE\r\n"),
+        },
+        {
+            conn:     subscriber,
+            request:  []byte("*3\r\n$9\r\nsubscribe\r\n$18\r\n__keyspace@0__:foo\r\n$22\r\n__keyevent@0__:expired\r\n"),
+            response: []byte("*3\r\n$9\r\nsubscribe\r\n$18\r\n__keyspace@0__:foo\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$22\r\n__keyevent@0__:expired\r\n:2\r\n"),
+        },
+        {
+            conn:     conn,
+            request:  []byte("*3\r\n$3\r\nset\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"),
+            response: []byte("+OK\r\n"),
+            pushed:   []byte("*3\r\n$7\r\nmessage\r\n$18\r\n__keyspace@0__:foo\r\n$3\r\nset\r\n"),
+        },
+        {
+            // Generic events are not enabled.
+            conn:     conn,
+            request:  []byte("*2\r\n$3\r\ndel\r\n$3\r\nfoo\r\n"),
+            response: []byte(":1\r\n"),
+        },
+        {
+            // Expired keys are notified by the active expire cycle even if nobody accesses them.
+            conn:     conn,
+            request:  []byte("*5\r\n$3\r\nset\r\n$3\r\ntmp\r\n$3\r\nbar\r\n$2\r\npx\r\n$2\r\n10\r\n"),
+            response: []byte("+OK\r\n"),
+            pushed:   []byte("*3\r\n$7\r\nmessage\r\n$22\r\n__keyevent@0__:expired\r\n$3\r\ntmp\r\n"),
+        },
+    }
+
+    for _, tc := range testCases {
+        expectResponse(t, tc.conn, tc.request, tc.response)
+        if tc.pushed != nil {
+            expectResponse(t, subscriber, nil, tc.pushed)
+        }
+    }
+}
+
+func TestRedisServer_ClientTracking(t *testing.T) {
+    const addr = "localhost:6385"
+    store := newTestStore(t, inMemoryDatabase.Config{})
+    rs := New(addr, store)
+    go func() {
+        _ = rs.Run()
+    }()
+
+    // Connections are given ids in order, each one is used before the next one connects.
+    conns := make([]net.Conn, 3)
+    for i := range conns {
+        conn, err := dial(addr)
+        if err != nil {
+            t.Fatalf("error cannot connect to server: %#v\n", err)
+        }
+        defer conn.Close()
+        conns[i] = conn
+        expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "ping"), []byte("+PONG\r\n"))
+    }
+    resp3, redirected, other := conns[0], conns[1], conns[2]
+
+    testCases := []struct {
+        name     string
+        conn     net.Conn
+        request  []string
+        response string
+        pushedTo net.Conn
+        pushed   string
+    }{
+        {
+            name:     "switch to RESP3",
+            conn:     resp3,
+            request:  []string{"hello", "3"},
+            response: "%7\r\n$6\r\nserver\r\n$5\r\nredis\r\n$7\r\nversion\r\n$5\r\n7.2.0\r\n$5\r\nproto\r\n:3\r\n$2\r\nid\r\n:1\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n",
+        },
+        {name: "unsupported protocol", conn: other, request: []string{"hello", "4"}, response: "-NOPROTO unsupported protocol version\r\n"},
+        {name: "client id", conn: other, request: []string{"client", "id"}, response: ":3\r\n"},
+        {name: "default mode", conn: resp3, request: []string{"client", "tracking", "on"}, response: "+OK\r\n"},
+        {name: "read tracked key", conn: resp3, request: []string{"get", "foo"}, response: "$2\r\n-1\r\n"},
+        {
+            name:     "invalidate tracked key",
+            conn:     other,
+            request:  []string{"set", "foo", "bar"},
+            response: "+OK\r\n",
+            pushedTo: resp3,
+            pushed:   ">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n",
+        },
+        {name: "invalidated once until read again", conn: other, request: []string{"set", "foo", "baz"}, response: "+OK\r\n"},
+        {
+            name:     "switch to bcast",
+            conn:     resp3,
+            request:  []string{"client", "tracking", "on", "bcast"},
+            response: "-ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.\r\n",
+        },
+        {name: "tracking off", conn: resp3, request: []string{"client", "tracking", "off"}, response: "+OK\r\n"},
+        {
+            name:     "prefix without bcast",
+            conn:     resp3,
+            request:  []string{"client", "tracking", "on", "prefix", "user:"},
+            response: "-ERR PREFIX option requires BCAST mode to be enabled\r\n",
+        },
+        {
+            name:     "overlapping prefixes",
+            conn:     resp3,
+            request:  []string{"client", "tracking", "on", "bcast", "prefix", "user:", "prefix", "us"},
+            response: "-ERR Prefix 'user:' overlaps with another provided prefix 'us'. Prefixes for a single client must not overlap.\r\n",
+        },
+        {name: "bcast mode", conn: resp3, request: []string{"client", "tracking", "on", "bcast", "prefix", "user:"}, response: "+OK\r\n"},
+        {
+            name:     "broadcast unread key",
+            conn:     other,
+            request:  []string{"set", "user:1", "x"},
+            response: "+OK\r\n",
+            pushedTo: resp3,
+            pushed:   ">2\r\n$10\r\ninvalidate\r\n*1\r\n$6\r\nuser:1\r\n",
+        },
+        {name: "key without prefix", conn: other, request: []string{"set", "product:1", "x"}, response: "+OK\r\n"},
+        {
+            name:     "subscribe to invalidations",
+            conn:     redirected,
+            request:  []string{"subscribe", "__redis__:invalidate"},
+            response: "*3\r\n$9\r\nsubscribe\r\n$20\r\n__redis__:invalidate\r\n:1\r\n",
+        },
+        {
+            name:     "redirect to missing client",
+            conn:     other,
+            request:  []string{"client", "tracking", "on", "redirect", "42"},
+            response: "-ERR The client ID you want redirect to does not exist\r\n",
+        },
+        {name: "redirect", conn: other, request: []string{"client", "tracking", "on", "redirect", "2", "optin", "noloop"}, response: "+OK\r\n"},
+        {name: "getredir", conn: other, request: []string{"client", "getredir"}, response: ":2\r\n"},
+        {
+            name:     "caching no in optin",
+            conn:     other,
+            request:  []string{"client", "caching", "no"},
+            response: "-ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.\r\n",
+        },
+        {name: "not opted in", conn: other, request: []string{"get", "a"}, response: "$2\r\n-1\r\n"},
+        {name: "opt in", conn: other, request: []string{"client", "caching", "yes"}, response: "+OK\r\n"},
+        {name: "opted in", conn: other, request: []string{"get", "b"}, response: "$2\r\n-1\r\n"},
+        {name: "untracked key", conn: resp3, request: []string{"set", "a", "1"}, response: "+OK\r\n"},
+        {
+            name:     "redirected invalidation",
+            conn:     resp3,
+            request:  []string{"set", "b", "1"},
+            response: "+OK\r\n",
+            pushedTo: redirected,
+            pushed:   "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$1\r\nb\r\n",
+        },
+        {name: "read again", conn: other, request: []string{"client", "caching", "yes"}, response: "+OK\r\n"},
+        {name: "read again", conn: other, request: []string{"get", "b"}, response: "+1\r\n"},
+        {name: "noloop", conn: other, request: []string{"set", "b", "2"}, response: "+OK\r\n"},
+        {
+            name:     "trackinginfo",
+            conn:     other,
+            request:  []string{"client", "trackinginfo"},
+            response: "*6\r\n$5\r\nflags\r\n*3\r\n$2\r\non\r\n$5\r\noptin\r\n$6\r\nnoloop\r\n$8\r\nredirect\r\n:2\r\n$8\r\nprefixes\r\n*0\r\n",
+        },
+        {
+            name:     "flush invalidates everything",
+            conn:     other,
+            request:  []string{"flushall"},
+            response: "+OK\r\n",
+            pushedTo: resp3,
+            pushed:   ">2\r\n$10\r\ninvalidate\r\n_\r\n",
+        },
+    }
+
+    for _, tc := range testCases {
+        t.Run(tc.name, func(t *testing.T) {
+            expectResponse(t, tc.conn, redisObject.Serialize(redisObject.Arrays, tc.request...), []byte(tc.response))
+            if tc.pushedTo != nil {
+                expectResponse(t, tc.pushedTo, nil, []byte(tc.pushed))
+            }
+        })
+    }
+    // The flush is redirected as well.
+    expectResponse(t, redirected, nil, []byte("*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n$-1\r\n"))
+}
+
+func TestRedisServer_trackReadKeys(t *testing.T) {
+    rs := New(TestAddr, newTestStore(t, inMemoryDatabase.Config{}))
+    c := newClient(1, nil)
+    c.tracking = &trackingOptions{}
+    rs.tracking.clients[c.id] = c
+    tracked := func() bool {
+        rs.tracking.Lock()
+        defer rs.tracking.Unlock()
+        _, ok := rs.tracking.keys["foo"][c.id]
+        return ok
+    }
+
+    // A write concurrent with the read is invalidated, and the key stays tracked for the value read after it.
+    done := rs.trackReadKeys(c, redisObject.New(redisObject.Arrays, []string{"foo"}, "get"))
+    if !tracked() {
+        t.Errorf("error the key should be tracked before being read.\n")
+    }
+    rs.invalidateKey("foo", 0)
+    if !tracked() {
+        t.Errorf("error the key should stay tracked while being read.\n")
+    }
+    done()
+    rs.invalidateKey("foo", 0)
+    if tracked() || len(rs.tracking.reading) != 0 {
+        t.Errorf("error the key should be invalidated once read.\n")
+    }
+}
+
+func TestRedisServer_AOF(t *testing.T) {
+    config := aof.Config{Dir: t.TempDir(), DirName: "appendonlydir", Filename: "appendonly.aof", Fsync: aof.FsyncAlways}
+
+    // The first server logs the writes, the second one replays them on startup.
+    writes := []struct {
+        request  []string
+        response string
+    }{
+        {request: []string{"set", "foo", "bar"}, response: "+OK\r\n"},
+        {request: []string{"set", "temp", "bar", "px", "100000"}, response: "+OK\r\n"},
+        {request: []string{"set", "expired", "bar", "px", "1"}, response: "+OK\r\n"},
+        {request: []string{"incr", "counter"}, response: ":1\r\n"},
+        {request: []string{"incr", "counter"}, response: ":2\r\n"},
+        {request: []string{"rpush", "list", "a", "b"}, response: ":2\r\n"},
+        {request: []string{"select", "2"}, response: "+OK\r\n"},
+        {request: []string{"set", "foo", "db2"}, response: "+OK\r\n"},
+        {request: []string{"multi"}, response: "+OK\r\n"},
+        {request: []string{"incr", "counter"}, response: "+QUEUED\r\n"},
+        {request: []string{"exec"}, response: "*1\r\n:1\r\n"},
+        {request: []string{"incr", "foo"}, response: "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+    }
+    reads := []struct {
+        request  []string
+        response string
+    }{
+        {request: []string{"get", "foo"}, response: "+bar\r\n"},
+        {request: []string{"exists", "temp"}, response: ":1\r\n"},
+        {request: []string{"exists", "expired"}, response: ":0\r\n"},
+        {request: []string{"get", "counter"}, response: "+2\r\n"},
+        {request: []string{"lrange", "list", "0", "-1"}, response: "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+        {request: []string{"select", "2"}, response: "+OK\r\n"},
+        {request: []string{"get", "foo"}, response: "+db2\r\n"},
+        {request: []string{"get", "counter"}, response: "+1\r\n"},
+    }
+
+    for i, addr := range []string{"localhost:6386", "localhost:6387"} {
+        rs := New(addr, newTestStore(t, inMemoryDatabase.Config{InMemory: true}))
+        rs.EnableAOF(config)
+        go func() {
+            _ = rs.Run()
+        }()
+
+        conn, err := dial(addr)
+        if err != nil {
+            t.Fatalf("error cannot connect to server: %#v\n", err)
+        }
+        if i == 0 {
+            for _, w := range writes {
+                expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, w.request...), []byte(w.response))
+            }
+        } else {
+            if changes := rs.dirty.Load(); changes != 0 {
+                t.Errorf("error the replayed commands shouldn't count as changes since the last save, got %d.\n", changes)
+            }
+            for _, r := range reads {
+                expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, r.request...), []byte(r.response))
+            }
+        }
+        _ = conn.Close()
+
+        if err = rs.Close(context.Background()); err != nil {
+            t.Fatalf("error closing the server: %v\n", err)
+        }
+    }
+
+    // Relative times to live are logged as absolute ones.
+    content, err := os.ReadFile(filepath.Join(config.Dir, config.DirName, "appendonly.aof.1.incr.aof"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Contains(content, []byte("$4\r\npxat\r\n")) || bytes.Contains(content, []byte("$2\r\npx\r\n")) {
+        t.Errorf("error times to live should be logged as absolute times, got %q.\n", content)
+    }
+}
+
+func TestRedisServer_BGREWRITEAOF(t *testing.T) {
+    config := aof.Config{Dir: t.TempDir(), DirName: "appendonlydir", Filename: "appendonly.aof", Fsync: aof.FsyncAlways}
+
+    expireAt := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+
+    // The first server rewrites its AOF, the second one loads the rewritten AOF on startup.
+    for i, addr := range []string{"localhost:6388", "localhost:6389"} {
+        rs := New(addr, newTestStore(t, inMemoryDatabase.Config{InMemory: true}))
+        rs.EnableAOF(config)
+        go func() {
+            _ = rs.Run()
+        }()
+
+        conn, err := dial(addr)
+        if err != nil {
+            t.Fatalf("error cannot connect to server: %#v\n", err)
+        }
+        if i == 0 {
+            for n := 1; n <= 100; n++ {
+                expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "incr", "counter"), []byte(fmt.Sprintf(":%d\r\n", n)))
+            }
+            expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "rpush", "list", "a", "b"), []byte(":2\r\n"))
+            expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "pexpireat", "list", expireAt), []byte(":1\r\n"))
+            expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "pexpireat", "missing", expireAt), []byte(":0\r\n"))
+            expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "set", "temp", "bar", "px", "100000"), []byte("+OK\r\n"))
+            expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "bgrewriteaof"), []byte("+Background append only file rewriting started\r\n"))
+            for rs.aof.Status().Rewriting {
+                time.Sleep(10 * time.Millisecond)
+            }
+            // Writes following the rewrite are logged to the new incr file.
+            expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "select", "1"), []byte("+OK\r\n"))
+            expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "set", "foo", "db1"), []byte("+OK\r\n"))
+
+            status := rs.aof.Status()
+            if status.Size >= int64(100*len("*2\r\n$4\r\nincr\r\n$7\r\ncounter\r\n")) {
+                t.Errorf("error the rewritten AOF should be compacted, got %d bytes.\n", status.Size)
+            }
+            info := string(rs.info([]string{"persistence"}))
+            if !strings.Contains(info, "aof_rewrites:1\r\n") || !strings.Contains(info, "aof_last_bgrewrite_status:ok\r\n") {
+                t.Errorf("error INFO should report the rewrite, got %q.\n", info)
+            }
+        } else {
+            expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "get", "counter"), []byte("+100\r\n"))
+            expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "lrange", "list", "0", "-1"), []byte("*2\r\n$1\r\na\r\n$1\r\nb\r\n"))
+            expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "exists", "temp"), []byte(":1\r\n"))
+            // The time to live of every type is rewritten.
+            for _, entry := range rs.store.Snapshot()[0] {
+                if entry.Key == "list" && strconv.FormatInt(entry.ExpireAt.UnixMilli(), 10) != expireAt {
+                    t.Errorf("error expected the list to expire at %s, got %v.\n", expireAt, entry.ExpireAt)
+                }
+            }
+            expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "select", "1"), []byte("+OK\r\n"))
+            expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "get", "foo"), []byte("+db1\r\n"))
+        }
+        _ = conn.Close()
+
+        if err = rs.Close(context.Background()); err != nil {
+            t.Fatalf("error closing the server: %v\n", err)
+        }
+    }
+
+    manifest, err := os.ReadFile(filepath.Join(config.Dir, config.DirName, "appendonly.aof.manifest"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := "file appendonly.aof.1.base.aof seq 1 type b\nfile appendonly.aof.2.incr.aof seq 2 type i\n"
+    if string(manifest) != expected {
+        t.Errorf("error expected the manifest %q, got %q.\n", expected, manifest)
+    }
+}
+
+func Test_keyHashSlot(t *testing.T) {
+    testCases := []struct {
+        key  string
+        slot int
+    }{
+        {key: "123456789", slot: 0x31C3},
+        {key: "foo", slot: 12182},
+        {key: "{foo}.bar", slot: 12182},
+        {key: "bar{foo}", slot: 12182},
+        {key: "{}foo", slot: keyHashSlot("{}foo")},
+        {key: "{user1000}.following", slot: keyHashSlot("{user1000}.followers")},
+    }
+
+    for _, tc := range testCases {
+        if slot := keyHashSlot(tc.key); slot != tc.slot {
+            t.Errorf("error hash slot of %s: expected %d, got %d.\n", tc.key, tc.slot, slot)
+        }
+    }
+
+    if keyHashSlot("{}foo") == keyHashSlot("") {
+        t.Errorf("error empty hash tags should be ignored.\n")
+    }
+}
+
+func TestRedisServer_BGSAVE(t *testing.T) {
+    const addr = "localhost:6390"
+    rs := New(addr, newTestStore(t, inMemoryDatabase.Config{Format: inMemoryDatabase.FormatRDB}))
+    go func() {
+        _ = rs.Run()
+    }()
+    defer func() {
+        _ = rs.Close(context.Background())
+    }()
+
+    conn, err := dial(addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer conn.Close()
+
+    waitBgsave := func() {
+        t.Helper()
+        for i := 0; strings.Contains(string(rs.info([]string{"persistence"})), "rdb_bgsave_in_progress:1\r\n"); i++ {
+            if i == 100 {
+                t.Fatalf("error the background save should be over.\n")
+            }
+            time.Sleep(10 * time.Millisecond)
+        }
+    }
+
+    start := time.Now().Unix()
+    expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "set", "foo", "bar"), []byte("+OK\r\n"))
+    expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "bgsave", "now"), []byte("-ERR syntax error\r\n"))
+    expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "bgsave"), []byte("+Background saving started\r\n"))
+    waitBgsave()
+    rs.RLock()
+    lastSave := rs.lastSave.Unix()
+    rs.RUnlock()
+    if lastSave < start {
+        t.Errorf("error LASTSAVE should be the start of the background save, got %d before %d.\n", lastSave, start)
+    }
+    expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "lastsave"), []byte(fmt.Sprintf(":%d\r\n", lastSave)))
+    info := string(rs.info([]string{"persistence"}))
+    for _, field := range []string{"rdb_changes_since_last_save:0", "rdb_saves:1", "rdb_last_bgsave_status:ok", "rdb_current_bgsave_time_sec:-1"} {
+        if !strings.Contains(info, field+"\r\n") {
+            t.Errorf("error INFO should report %s, got %q.\n", field, info)
+        }
+    }
+
+    // While the AOF is being rewritten, a background save only starts once the rewrite is over, if scheduled.
+    rs.Lock()
+    rs.aofRewriteStart = time.Now()
+    rs.Unlock()
+    expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "bgsave"),
+        []byte("-ERR Another child process is active (AOF?): can't BGSAVE right now. Use BGSAVE SCHEDULE in order to schedule a BGSAVE whenever possible.\r\n"))
+    expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "bgsave", "schedule"), []byte("+Background saving scheduled\r\n"))
+    rs.Lock()
+    rs.aofRewriteStart = time.Time{}
+    rs.Unlock()
+    for i := 0; !strings.Contains(string(rs.info([]string{"persistence"})), "rdb_saves:2\r\n"); i++ {
+        if i == 100 {
+            t.Fatalf("error the scheduled background save should have run.\n")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestRedisServer_ConfigPersistence(t *testing.T) {
+    rs := New(TestAddr, newTestStore(t, inMemoryDatabase.Config{}))
+    dir := t.TempDir()
+
+    testCases := []struct {
+        args     []string
+        response []byte
+    }{
+        {[]string{"set", "dir", filepath.Join(dir, "missing")}, nil},
+        {[]string{"set", "dir", dir}, []byte("+OK\r\n")},
+        {[]string{"get", "dir"}, redisObject.Serialize(redisObject.Arrays, "dir", dir)},
+        {[]string{"set", "dbfilename", "../dump.csv"}, nil},
+        {[]string{"set", "dbfilename", "backup.csv"}, []byte("+OK\r\n")},
+        {[]string{"get", "dbfilename"}, redisObject.Serialize(redisObject.Arrays, "dbfilename", "backup.csv")},
+        {[]string{"set", "appendfilename", "backup.aof"}, []byte("+OK\r\n")},
+        {[]string{"get", "appendfilename"}, redisObject.Serialize(redisObject.Arrays, "appendfilename", "backup.aof")},
+    }
+    for _, tc := range testCases {
+        response := rs.configCommand(tc.args)
+        if tc.response == nil && !isError(response) || tc.response != nil && !bytes.Equal(response, tc.response) {
+            t.Errorf("error CONFIG %v: expected %q, got %q.\n", tc.args, tc.response, response)
+        }
+    }
+
+    if err := rs.saveDatabase(); err != nil {
+        t.Fatalf("error saving: %v\n", err)
+    }
+    if _, err := os.Stat(filepath.Join(dir, "backup.csv")); err != nil {
+        t.Errorf("error the dump should be saved to the configured path: %v\n", err)
+    }
+
+    // Nothing is saved in memory only.
+    rs = New(TestAddr, newTestStore(t, inMemoryDatabase.Config{InMemory: true}))
+    for _, command := range []string{"save", "bgsave"} {
+        robj, _ := redisObject.Deserialize(redisObject.Serialize(redisObject.Arrays, command))
+        expected := []byte("-ERR Persistence is disabled, the server runs in memory only\r\n")
+        if response := rs.execute(newClient(0, nil), robj); !bytes.Equal(response, expected) {
+            t.Errorf("error %s: expected %q, got %q.\n", command, expected, response)
+        }
+    }
+}
+
+func TestRedisServer_Config(t *testing.T) {
+    rs := New(TestAddr, newTestStore(t, inMemoryDatabase.Config{}))
+    rs.configFile = filepath.Join(t.TempDir(), "redis.conf")
+
+    testCases := []struct {
+        args     []string
+        response []byte
+    }{
+        {[]string{"get", "append*", "PORT"}, redisObject.Serialize(redisObject.Arrays,
+            "appenddirname", "appendonlydir", "appendfilename", "appendonly.aof", "appendfsync", "everysec", "appendonly", "no", "port", "6380")},
+        {[]string{"get", "missing"}, redisObject.Serialize(redisObject.Arrays)},
+        {[]string{"set", "appendfsync", "always", "auto-aof-rewrite-min-size", "1kb"}, []byte("+OK\r\n")},
+        {[]string{"get", "appendfsync", "auto-aof-rewrite-min-size"}, redisObject.Serialize(redisObject.Arrays,
+            "appendfsync", "always", "auto-aof-rewrite-min-size", "1024")},
+        // All or nothing: appendfsync is restored.
+        {[]string{"set", "appendfsync", "no", "auto-aof-rewrite-percentage", "-1"}, nil},
+        {[]string{"get", "appendfsync"}, redisObject.Serialize(redisObject.Arrays, "appendfsync", "always")},
+        {[]string{"set", "port", "7000"}, []byte("-ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config\r\n")},
+        {[]string{"set", "appendfsync", "no", "appendfsync", "no"}, nil},
+        {[]string{"set", "missing", "1"}, nil},
+        {[]string{"set", "appendfsync"}, nil},
+        {[]string{"rewrite"}, []byte("+OK\r\n")},
+        {[]string{"resetstat"}, []byte("+OK\r\n")},
+    }
+    for _, tc := range testCases {
+        response := rs.configCommand(tc.args)
+        if tc.response == nil && !isError(response) || tc.response != nil && !bytes.Equal(response, tc.response) {
+            t.Errorf("error CONFIG %v: expected %q, got %q.\n", tc.args, tc.response, response)
+        }
+    }
+
+    // Only the settings changed from their default are written.
+    content, err := os.ReadFile(rs.configFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, directive := range []string{"appendfsync always\n", "auto-aof-rewrite-min-size 1024\n", "port 6380\n"} {
+        if !strings.Contains(string(content), directive) {
+            t.Errorf("error CONFIG REWRITE: expected %q in %q.\n", directive, content)
+        }
+    }
+    if strings.Contains(string(content), "appendonly no") {
+        t.Errorf("error CONFIG REWRITE: the default appendonly shouldn't be written, got %q.\n", content)
+    }
+
+    rs.configFile = ""
+    if response := rs.configCommand([]string{"rewrite"}); !isError(response) {
+        t.Errorf("error CONFIG REWRITE without a config file: expected an error, got %q.\n", response)
+    }
+}
+
+func TestNewFromConfig(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "redis.conf")
+    content := "port 7000\ndatabases 4\ndbformat rdb\nsave 60 10\nsave 30 100\nappendfsync no\n"
+    if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    file, err := config.Load([]string{path, "--port", "7001", "--dir", dir})
+    if err != nil {
+        t.Fatal(err)
+    }
+    rs, err := NewFromConfig(file)
+    if err != nil {
+        t.Fatalf("error creating the server: %v\n", err)
+    }
+    expected := redisObject.Serialize(redisObject.Arrays, "databases", "4", "dbfilename", "dump.rdb", "dir", dir, "port", "7001", "save", "60 10 30 100")
+    if response := rs.configCommand([]string{"get", "port", "dir", "databases", "save", "dbfilename"}); !bytes.Equal(response, expected) {
+        t.Errorf("error CONFIG GET: expected %q, got %q.\n", expected, response)
+    }
+    if rs.addr != "localhost:7001" || rs.configFile != path {
+        t.Errorf("error expected the address localhost:7001 and the file %s, got %s and %s.\n", path, rs.addr, rs.configFile)
+    }
+
+    for _, args := range [][]string{{"--unknown", "1"}, {"--appendonly", "--in-memory"}, {"--save", "60"}, {"--port", "1", "2"}} {
+        file, err := config.Load(append(args, "--dir", dir))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err = NewFromConfig(file); err == nil {
+            t.Errorf("error creating a server with %v: expected an error.\n", args)
+        }
+    }
+}
+
+func TestRedisServer_SavePoints(t *testing.T) {
+    rs := New(TestAddr, newTestStore(t, inMemoryDatabase.Config{}))
+    expectInfo := func(fields ...string) {
+        t.Helper()
+        info := string(rs.info([]string{"persistence"}))
+        for _, field := range fields {
+            if !strings.Contains(info, field+"\r\n") {
+                t.Errorf("error INFO: expected %q in %q.\n", field, info)
+            }
+        }
+    }
+
+    for _, value := range []string{"60", "60 -1", "0 1", "a 1"} {
+        if response := rs.configCommand([]string{"set", "save", value}); !isError(response) {
+            t.Errorf("error CONFIG SET save %q: expected an error, got %q.\n", value, response)
+        }
+    }
+    if response := rs.configCommand([]string{"set", "save", "3600 1 1 2"}); !bytes.Equal(response, []byte("+OK\r\n")) {
+        t.Fatalf("error CONFIG SET save: got %q.\n", response)
+    }
+    expectInfo("rdb_save_points:3600 1 1 2", "rdb_next_save_in_sec:-1")
+
+    // A single change reaches the save point of an hour only.
+    rs.Lock()
+    rs.lastSave = time.Now().Add(-2 * time.Second)
+    rs.Unlock()
+    rs.dirty.Add(1)
+    rs.saveCron(time.Now())
+    expectInfo("rdb_changes_since_last_save:1", "rdb_bgsave_in_progress:0", "rdb_next_save_in_sec:3597")
+
+    rs.dirty.Add(1)
+    expectInfo("rdb_next_save_in_sec:0")
+    rs.saveCron(time.Now())
+    for i := 0; !strings.Contains(string(rs.info([]string{"persistence"})), "rdb_saves:1\r\n"); i++ {
+        if i == 100 {
+            t.Fatalf("error the save point should have triggered a background save.\n")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    expectInfo("rdb_changes_since_last_save:0", "rdb_last_bgsave_status:ok")
+
+    // Without save points, nothing is saved.
+    rs.configCommand([]string{"set", "save", ""})
+    rs.dirty.Add(10)
+    rs.saveCron(time.Now().Add(time.Hour))
+    expectInfo("rdb_save_points:", "rdb_bgsave_in_progress:0", "rdb_saves:1")
+
+    // With persistence disabled, the save points never trigger a background save.
+    file, err := config.Load([]string{"--in-memory", "--dir", t.TempDir()})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if rs, err = NewFromConfig(file); err != nil {
+        t.Fatalf("error creating the server: %v\n", err)
+    }
+    rs.dirty.Add(10)
+    rs.saveCron(time.Now().Add(time.Hour))
+    expectInfo("rdb_next_save_in_sec:-1", "rdb_bgsave_in_progress:0", "rdb_last_bgsave_status:ok")
+}
+
+func TestRedisServer_Shutdown(t *testing.T) {
+    const addr = "localhost:6391"
+    rs := New(addr, newTestStore(t, inMemoryDatabase.Config{}))
+    go func() {
+        _ = rs.Run()
+    }()
+
+    conn, err := dial(addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer conn.Close()
+    other, err := dial(addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer other.Close()
+
+    expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "set", "key", "value"), []byte("+OK\r\n"))
+    expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "shutdown", "nosave", "save"), []byte("-ERR syntax error\r\n"))
+    expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "shutdown", "abort"), []byte("-ERR No shutdown in progress.\r\n"))
+
+    // A shutdown waiting for a background job can be aborted, the server keeps on running.
+    rs.Lock()
+    rs.bgsaveStart = time.Now()
+    rs.Unlock()
+    aborted := make(chan error)
+    go func() {
+        aborted <- rs.Shutdown(context.Background(), ShutdownOptions{})
+    }()
+    for i := 0; ; i++ {
+        rs.RLock()
+        inProgress := rs.shutdownAbort != nil
+        rs.RUnlock()
+        if inProgress {
+            break
+        } else if i == 100 {
+            t.Fatalf("error the shutdown should be in progress.\n")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    expectResponse(t, other, redisObject.Serialize(redisObject.Arrays, "shutdown", "abort"), []byte("+OK\r\n"))
+    if err = <-aborted; !errors.Is(err, ErrShutdownAborted) {
+        t.Errorf("error shutting down: expected %v, got %v.\n", ErrShutdownAborted, err)
+    }
+    rs.Lock()
+    rs.bgsaveStart = time.Time{}
+    rs.Unlock()
+    expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "ping"), []byte("+PONG\r\n"))
+
+    // The shutdown is aborted if the final save fails, unless forced.
+    dir := rs.store.Dir()
+    if err = os.RemoveAll(dir); err != nil {
+        t.Fatal(err)
+    }
+    expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "shutdown"), []byte("-ERR Errors trying to SHUTDOWN. Check logs.\r\n"))
+    if err = os.Mkdir(dir, 0755); err != nil {
+        t.Fatal(err)
+    }
+
+    // Nothing is replied, the connections are closed once the databases are saved.
+    if _, err = conn.Write(redisObject.Serialize(redisObject.Arrays, "shutdown")); err != nil {
+        t.Fatal(err)
+    }
+    for _, c := range []net.Conn{conn, other} {
+        _ = c.SetReadDeadline(time.Now().Add(time.Second))
+        if n, err := c.Read(make([]byte, 64)); err != io.EOF {
+            t.Errorf("error the connection should be closed, read %d bytes ( %v ).\n", n, err)
+        }
+    }
+    select {
+    case <-rs.Done():
+    case <-time.After(time.Second):
+        t.Fatalf("error the server should be shut down.\n")
+    }
+    if _, err = os.Stat(filepath.Join(dir, "dump.csv")); err != nil {
+        t.Errorf("error the databases should be saved on shutdown: %v\n", err)
+    }
+    if err = rs.Shutdown(context.Background(), ShutdownOptions{}); !errors.Is(err, ErrShutdownInProgress) {
+        t.Errorf("error shutting down twice: expected %v, got %v.\n", ErrShutdownInProgress, err)
+    }
+
+    // A connection accepted while shutting down is closed at once, it isn't waited for.
+    server, peer := net.Pipe()
+    defer peer.Close()
+    if _, ok := rs.acceptClient(server); ok {
+        t.Errorf("error no client should be accepted once the server is shut down.\n")
+    }
+    if n, err := peer.Read(make([]byte, 1)); err != io.EOF {
+        t.Errorf("error the connection should be closed, read %d bytes ( %v ).\n", n, err)
+    }
+}
+
+func TestRedisServer_Accept(t *testing.T) {
+    const addr = "localhost:6392"
+    rs := New(addr, newTestStore(t, inMemoryDatabase.Config{}))
+    if response := rs.configCommand([]string{"set", "maxclients", "1"}); !bytes.Equal(response, []byte("+OK\r\n")) {
+        t.Fatalf("error CONFIG SET maxclients: got %q.\n", response)
+    }
+    stopped := make(chan error)
+    go func() {
+        stopped <- rs.Run()
+    }()
+    select {
+    case <-rs.Ready():
+    case <-time.After(time.Second):
+        t.Fatalf("error the server should be ready.\n")
+    }
+
+    conn, err := net.Dial(TCP, addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer conn.Close()
+    expectResponse(t, conn, redisObject.Serialize(redisObject.Arrays, "ping"), []byte("+PONG\r\n"))
+
+    // Beyond maxclients, connections are closed with an error.
+    rejected, err := net.Dial(TCP, addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer rejected.Close()
+    expectResponse(t, rejected, nil, []byte("-ERR max number of clients reached\r\n"))
+    if _, err = rejected.Read(make([]byte, 1)); err != io.EOF {
+        t.Errorf("error the connection should be closed, got %v.\n", err)
+    }
+
+    // The listeners accept concurrently, maxclients holds all the same.
+    rs.configCommand([]string{"set", "maxclients", "10"})
+    registered := make(chan *client, 50)
+    var wg sync.WaitGroup
+    for i := 0; i < 50; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            server, peer := net.Pipe()
+            defer peer.Close()
+            if c, err := rs.registerClient(server); err == nil {
+                registered <- c
+            }
+        }()
+    }
+    wg.Wait()
+    close(registered)
+    if n := len(registered); n != 9 {
+        t.Errorf("error registering clients concurrently: expected %d, got %d.\n", 9, n)
+    }
+    for c := range registered {
+        rs.unregisterClient(c)
+        rs.conns.Done()
+    }
+
+    if err = rs.Close(context.Background()); err != nil {
+        t.Fatalf("error closing the server: %v\n", err)
+    }
+    select {
+    case err = <-stopped:
+        if !errors.Is(err, net.ErrClosed) {
+            t.Errorf("error Run: expected %v, got %v.\n", net.ErrClosed, err)
+        }
+    case <-time.After(time.Second):
+        t.Fatalf("error Run should return once the server is closed.\n")
+    }
+}
+
+// readBulkString reads a bulk string reply from conn.
+func readBulkString(t *testing.T, conn net.Conn) string {
+    t.Helper()
+    _ = conn.SetReadDeadline(time.Now().Add(time.Second))
+    defer conn.SetReadDeadline(time.Time{})
+
+    var header []byte
+    for b := make([]byte, 1); !bytes.HasSuffix(header, []byte("\r\n")); header = append(header, b[0]) {
+        if _, err := io.ReadFull(conn, b); err != nil {
+            t.Fatalf("error reading bulk string: %v.\n", err)
+        }
+    }
+    var n int
+    if _, err := fmt.Sscanf(string(header), "$%d\r\n", &n); err != nil {
+        t.Fatalf("error expected a bulk string, got %q.\n", header)
+    }
+    data := make([]byte, n+2)
+    if _, err := io.ReadFull(conn, data); err != nil {
+        t.Fatalf("error reading bulk string: %v.\n", err)
+    }
+    return string(data[:n])
+}
+
+func TestRedisServer_Client(t *testing.T) {
+    _, addr := startTestServer(t)
+
+    conn, err := net.Dial(TCP, addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer conn.Close()
+    other, err := net.Dial(TCP, addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer other.Close()
+
+    expectResponse(t, conn, command("client", "getname"), []byte("$-1\r\n"))
+    expectResponse(t, conn, command("client", "setname", "bad name"), []byte("-ERR Client names cannot contain spaces, newlines or special characters.\r\n"))
+    expectResponse(t, conn, command("client", "setname", "worker"), []byte("+OK\r\n"))
+    expectResponse(t, conn, command("client", "getname"), []byte("$6\r\nworker\r\n"))
+    expectResponse(t, conn, command("client", "no-evict", "on"), []byte("+OK\r\n"))
+    expectResponse(t, other, command("select", "2"), []byte("+OK\r\n"))
+    expectResponse(t, other, command("subscribe", "news"), []byte("*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n"))
+
+    if _, err = conn.Write(command("client", "info")); err != nil {
+        t.Fatal(err)
+    }
+    info := readBulkString(t, conn)
+    for _, field := range []string{"name=worker", "flags=e", "db=0", "cmd=client|info", "addr=" + conn.LocalAddr().String(), "laddr=" + conn.RemoteAddr().String()} {
+        if !strings.Contains(info, " "+field+" ") {
+            t.Errorf("error CLIENT INFO: expected %q in %q.\n", field, info)
+        }
+    }
+
+    if _, err = conn.Write(command("client", "list")); err != nil {
+        t.Fatal(err)
+    }
+    lines := strings.Split(strings.TrimSuffix(readBulkString(t, conn), "\n"), "\n")
+    if len(lines) != 2 || !strings.Contains(lines[1], " flags=P db=2 sub=1 ") || !strings.Contains(lines[1], " cmd=subscribe ") {
+        t.Errorf("error CLIENT LIST: expected the subscriber in db 2 second, got %q.\n", lines)
+    }
+    if _, err = conn.Write(command("client", "list", "type", "pubsub")); err != nil {
+        t.Fatal(err)
+    }
+    if list := readBulkString(t, conn); strings.Count(list, "\n") != 1 || !strings.Contains(list, "sub=1") {
+        t.Errorf("error CLIENT LIST TYPE pubsub: expected the subscriber only, got %q.\n", list)
+    }
+    expectResponse(t, conn, command("client", "list", "type", "unknown"), []byte("-ERR Unknown client type 'unknown'\r\n"))
+
+    // Replies can be turned off, or skipped for a single command.
+    noReply := func(args ...string) {
+        expectResponse(t, conn, command(args...), nil)
+        // Requests are read one at a time, the next one mustn't be sent along.
+        time.Sleep(20 * time.Millisecond)
+    }
+    noReply("client", "reply", "off")
+    noReply("ping")
+    noReply("client", "reply", "skip")
+    expectResponse(t, conn, command("client", "reply", "on"), []byte("+OK\r\n"))
+    noReply("client", "reply", "skip")
+    noReply("ping")
+    expectResponse(t, conn, command("ping"), []byte("+PONG\r\n"))
+
+    // Only the write commands are paused.
+    expectResponse(t, conn, command("client", "pause", "100", "write"), []byte("+OK\r\n"))
+    expectResponse(t, conn, command("exists", "key"), []byte(":0\r\n"))
+    start := time.Now()
+    expectResponse(t, conn, command("set", "key", "value"), []byte("+OK\r\n"))
+    if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+        t.Errorf("error SET should be paused, it took %v.\n", elapsed)
+    }
+    expectResponse(t, conn, command("client", "pause", "10000"), []byte("+OK\r\n"))
+    expectResponse(t, conn, command("client", "unpause"), []byte("+OK\r\n"))
+    expectResponse(t, conn, command("ping"), []byte("+PONG\r\n"))
+
+    expectResponse(t, conn, command("client", "kill", "127.0.0.1:1"), []byte("-ERR No such client\r\n"))
+    expectResponse(t, conn, command("client", "kill", "type", "pubsub", "user", "default"), []byte(":1\r\n"))
+    if _, err = other.Read(make([]byte, 1)); err != io.EOF {
+        t.Errorf("error the killed connection should be closed, got %v.\n", err)
+    }
+    // Unless SKIPME no, the caller isn't killed.
+    expectResponse(t, conn, command("client", "kill", "addr", conn.LocalAddr().String()), []byte(":0\r\n"))
+    expectResponse(t, conn, command("client", "kill", "addr", conn.LocalAddr().String(), "skipme", "no"), []byte(":1\r\n"))
+    if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+        t.Errorf("error the killed connection should be closed, got %v.\n", err)
+    }
+}
+
+func TestRedisServer_Timeout(t *testing.T) {
+    _, addr := startTestServer(t)
+
+    idle, err := net.Dial(TCP, addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer idle.Close()
+    subscriber, err := net.Dial(TCP, addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer subscriber.Close()
+    expectResponse(t, subscriber, redisObject.Serialize(redisObject.Arrays, "subscribe", "news"),
+        []byte("*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n"))
+
+    expectResponse(t, idle, redisObject.Serialize(redisObject.Arrays, "config", "get", "tcp-keepalive"),
+        []byte("*2\r\n$13\r\ntcp-keepalive\r\n$3\r\n300\r\n"))
+    // The new timeout applies to the clients already waiting for a request.
+    expectResponse(t, idle, redisObject.Serialize(redisObject.Arrays, "config", "set", "timeout", "1"), []byte("+OK\r\n"))
+    start := time.Now()
+    _ = idle.SetReadDeadline(time.Now().Add(3 * time.Second))
+    if _, err = idle.Read(make([]byte, 1)); err != io.EOF {
+        t.Fatalf("error the idle client should be disconnected, got %v.\n", err)
+    }
+    if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+        t.Errorf("error the idle client was disconnected after %v only.\n", elapsed)
+    }
+
+    // Subscribed clients are never idle.
+    expectResponse(t, subscriber, redisObject.Serialize(redisObject.Arrays, "ping"),
+        []byte("*2\r\n$4\r\npong\r\n$0\r\n\r\n"))
+}
+
+func TestRedisServer_OutputBufferLimit(t *testing.T) {
+    rs := New(TestAddr, newTestStore(t, inMemoryDatabase.Config{}))
+    if response := rs.configCommand([]string{"set", "client-output-buffer-limit", "normal 100 50 1 slave 1mb 1mb 0"}); !bytes.Equal(response, []byte("+OK\r\n")) {
+        t.Fatalf("error CONFIG SET client-output-buffer-limit: got %q.\n", response)
+    }
+    expected := redisObject.Serialize(redisObject.Arrays, "client-output-buffer-limit", "normal 100 50 1 replica 1048576 1048576 0 pubsub 33554432 8388608 60")
+    if response := rs.configCommand([]string{"get", "client-output-buffer-limit"}); !bytes.Equal(response, expected) {
+        t.Errorf("error CONFIG GET client-output-buffer-limit: expected %q, got %q.\n", expected, response)
+    }
+    for _, value := range []string{"normal 100 50", "master 0 0 0", "pubsub -1 0 0"} {
+        if response := rs.configCommand([]string{"set", "client-output-buffer-limit", value}); !isError(response) {
+            t.Errorf("error CONFIG SET client-output-buffer-limit %q: expected an error, got %q.\n", value, response)
+        }
+    }
+
+    // The soft limit is only exceeded once the output stayed above it for the soft seconds.
+    c := newClient(1, nil)
+    limit := rs.outputBufferLimit(classNormal)
+    now := time.Now()
+    for _, tc := range []struct {
+        size     int64
+        after    time.Duration
+        expected bool
+    }{
+        {60, 0, false},
+        {60, 900 * time.Millisecond, false},
+        {40, time.Second, false},
+        {60, 1100 * time.Millisecond, false},
+        {60, 2200 * time.Millisecond, true},
+        {100, 0, true},
+    } {
+        if over := c.overLimit(tc.size, limit, now.Add(tc.after)); over != tc.expected {
+            t.Errorf("error overLimit(%d) after %v: expected %v, got %v.\n", tc.size, tc.after, tc.expected, over)
+        }
+    }
+
+    // Beyond the hard limit, the output is dropped and the connection closed.
+    server, peer := net.Pipe()
+    defer peer.Close()
+    c, err := rs.registerClient(server)
+    if err != nil {
+        t.Fatal(err)
+    }
+    go c.writeLoop()
+    defer c.close()
+    c.write(bytes.Repeat([]byte("x"), 100))
+    c.write([]byte("+OK\r\n"))
+    if !c.killed.Load() {
+        t.Errorf("error the client should be closed beyond the hard limit.\n")
+    }
+    if n, err := peer.Read(make([]byte, 1)); err != io.EOF {
+        t.Errorf("error the connection should be closed, read %d bytes ( %v ).\n", n, err)
+    }
+}
+
+func TestRedisServer_ACL(t *testing.T) {
+    rs, addr := startTestServer(t)
+
+    admin, err := net.Dial(TCP, addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer admin.Close()
+    expectResponse(t, admin, command("auth", "secret"), []byte("-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?\r\n"))
+    // The clients already connected stay authenticated.
+    expectResponse(t, admin, command("config", "set", "requirepass", "secret"), []byte("+OK\r\n"))
+    expectResponse(t, admin, command("acl", "setuser", "alice", "on", ">pw", "~cache:*", "+get", "+set", "+acl|whoami", "+client"), []byte("+OK\r\n"))
+    expectResponse(t, admin, command("acl", "users"), command("alice", "default"))
+
+    conn, err := net.Dial(TCP, addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer conn.Close()
+    expectResponse(t, conn, command("ping"), []byte("-NOAUTH Authentication required.\r\n"))
+    expectResponse(t, conn, command("hello", "3"), []byte("-NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time\r\n"))
+    expectResponse(t, conn, command("auth", "wrong"), []byte("-WRONGPASS invalid username-password pair or user is disabled.\r\n"))
+    expectResponse(t, conn, command("auth", "secret"), []byte("+OK\r\n"))
+    expectResponse(t, conn, command("acl", "whoami"), []byte("$7\r\ndefault\r\n"))
+
+    if _, err = conn.Write(command("hello", "2", "auth", "alice", "pw", "setname", "app")); err != nil {
+        t.Fatal(err)
+    }
+    reply := make([]byte, 1024)
+    _ = conn.SetReadDeadline(time.Now().Add(time.Second))
+    n, err := conn.Read(reply)
+    _ = conn.SetReadDeadline(time.Time{})
+    if err != nil || !bytes.HasPrefix(reply[:n], []byte("*14\r\n$6\r\nserver\r\n")) {
+        t.Fatalf("error HELLO AUTH: got %q ( %v ).\n", reply[:n], err)
+    }
+    expectResponse(t, conn, command("acl", "whoami"), []byte("$5\r\nalice\r\n"))
+    expectResponse(t, conn, command("client", "getname"), []byte("$3\r\napp\r\n"))
+    expectResponse(t, conn, command("set", "cache:1", "v"), []byte("+OK\r\n"))
+    expectResponse(t, conn, command("get", "other"), []byte("-NOPERM No permissions to access a key\r\n"))
+    expectResponse(t, conn, command("flushall"), []byte("-NOPERM User alice has no permissions to run the 'flushall' command\r\n"))
+    // A denied command aborts the transaction.
+    expectResponse(t, conn, command("multi"), []byte("-NOPERM User alice has no permissions to run the 'multi' command\r\n"))
+
+    entries := rs.aclLog.Entries(-1)
+    if len(entries) != 4 || entries[0].Object != "multi" || entries[1].Object != "flushall" || entries[2].Reason != "key" ||
+        entries[3].Reason != "auth" || entries[3].Username != "default" {
+        t.Errorf("error ACL LOG: got %+v.\n", entries)
+    }
+    expectResponse(t, admin, command("acl", "dryrun", "alice", "get", "other"),
+        redisObject.Serialize(redisObject.BulkStrings, "User alice has no permissions to access the 'other' key"))
+    expectResponse(t, admin, command("acl", "dryrun", "alice", "get", "cache:1"), []byte("+OK\r\n"))
+    expectResponse(t, admin, command("client", "kill", "user", "bob"), []byte(":0\r\n"))
+
+    // The clients of a removed user are disconnected.
+    expectResponse(t, admin, command("acl", "deluser", "alice", "bob"), []byte(":1\r\n"))
+    if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+        t.Errorf("error the connection of a removed user should be closed, got %v.\n", err)
+    }
+    expectResponse(t, admin, command("acl", "deluser", "default"), []byte("-ERR The 'default' user cannot be removed\r\n"))
+}
+
+// writeTestCert writes a certificate for localhost and its key to dir/name.crt and dir/name.key, PEM encoded.
+// The certificate is signed by ca, or is a self-signed certificate authority if ca is nil.
+func writeTestCert(t *testing.T, dir, name string, ca *tls.Certificate) tls.Certificate {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(time.Now().UnixNano()),
+        Subject:      pkix.Name{CommonName: name},
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+        KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+        ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+        DNSNames:     []string{"localhost"},
+        IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+    }
+    parent, signer := template, key
+    if ca != nil {
+        parent, signer = ca.Leaf, ca.PrivateKey.(*ecdsa.PrivateKey)
+    } else {
+        template.IsCA, template.BasicConstraintsValid = true, true
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+    if err != nil {
+        t.Fatal(err)
+    }
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err = os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if err = os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+        t.Fatal(err)
+    }
+    leaf, err := x509.ParseCertificate(der)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestRedisServer_TLS(t *testing.T) {
+    const addr, tlsAddr = "localhost:6396", "localhost:6397"
+    dir := t.TempDir()
+    ca := writeTestCert(t, dir, "ca", nil)
+    writeTestCert(t, dir, "server", &ca)
+    clientCert := writeTestCert(t, dir, "client", &ca)
+
+    rs := New(addr, newTestStore(t, inMemoryDatabase.Config{}))
+    err := rs.Configure([]config.Directive{
+        {Name: "tls-port", Args: []string{"6397"}},
+        {Name: "tls-cert-file", Args: []string{filepath.Join(dir, "server.crt")}},
+        {Name: "tls-key-file", Args: []string{filepath.Join(dir, "server.key")}},
+        {Name: "tls-ca-cert-file", Args: []string{filepath.Join(dir, "ca.crt")}},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    go func() {
+        _ = rs.Run()
+    }()
+    defer rs.Close(context.Background())
+    <-rs.Ready()
+    ping := redisObject.Serialize(redisObject.Arrays, "ping")
+
+    roots := x509.NewCertPool()
+    roots.AddCert(ca.Leaf)
+    dialTLS := func(certs ...tls.Certificate) *tls.Conn {
+        t.Helper()
+        conn, err := tls.Dial(TCP, tlsAddr, &tls.Config{RootCAs: roots, Certificates: certs})
+        if err != nil {
+            t.Fatalf("error cannot connect to server: %v\n", err)
+        }
+        return conn
+    }
+
+    // The plaintext port is served as well.
+    plain, err := net.Dial(TCP, addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer plain.Close()
+    expectResponse(t, plain, ping, []byte("+PONG\r\n"))
+
+    conn := dialTLS(clientCert)
+    defer conn.Close()
+    expectResponse(t, conn, ping, []byte("+PONG\r\n"))
+
+    // Clients without a certificate are rejected, once the server verified the handshake.
+    anonymous := dialTLS()
+    _, _ = anonymous.Write(ping)
+    _ = anonymous.SetReadDeadline(time.Now().Add(time.Second))
+    if _, err = anonymous.Read(make([]byte, 16)); err == nil {
+        t.Errorf("error a client without certificate should be rejected.\n")
+    }
+    anonymous.Close()
+
+    // Certificates are reloaded without restarting, the connected clients are kept.
+    writeTestCert(t, dir, "renewed", &ca)
+    expectResponse(t, plain, redisObject.Serialize(redisObject.Arrays, "config", "set",
+        "tls-cert-file", filepath.Join(dir, "renewed.crt"), "tls-key-file", filepath.Join(dir, "renewed.key")), []byte("+OK\r\n"))
+    renewed := dialTLS(clientCert)
+    defer renewed.Close()
+    if name := renewed.ConnectionState().PeerCertificates[0].Subject.CommonName; name != "renewed" {
+        t.Errorf("error the reloaded certificate should be served, got %s.\n", name)
+    }
+    expectResponse(t, conn, ping, []byte("+PONG\r\n"))
+
+    // A certificate that can't be loaded leaves the previous one in place.
+    response := rs.configCommand([]string{"set", "tls-cert-file", filepath.Join(dir, "missing.crt")})
+    if !bytes.HasPrefix(response, []byte("-ERR CONFIG SET failed (possibly related to argument 'tls-cert-file')")) {
+        t.Errorf("error loading a missing certificate: got %q.\n", response)
+    }
+    expectResponse(t, plain, redisObject.Serialize(redisObject.Arrays, "config", "get", "tls-cert-file"),
+        redisObject.Serialize(redisObject.Arrays, "tls-cert-file", filepath.Join(dir, "renewed.crt")))
+
+    expectResponse(t, plain, redisObject.Serialize(redisObject.Arrays, "config", "set", "tls-auth-clients", "optional"), []byte("+OK\r\n"))
+    anonymous = dialTLS()
+    defer anonymous.Close()
+    expectResponse(t, anonymous, ping, []byte("+PONG\r\n"))
+}
+
+func TestRedisServer_UnixSocket(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "rredis.sock")
+    // A socket file left by a previous run is replaced.
+    if err := os.WriteFile(path, nil, 0644); err != nil {
+        t.Fatal(err)
+    }
+    rs, addr := startTestServer(t,
+        config.Directive{Name: "unixsocket", Args: []string{path}},
+        config.Directive{Name: "unixsocketperm", Args: []string{"700"}},
+    )
+
+    if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0700 || info.Mode().Type() != os.ModeSocket {
+        t.Errorf("error the unix socket should be created with the permissions 700, got %v ( %v ).\n", info, err)
+    }
+    unixConn, err := net.Dial(Unix, path)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer unixConn.Close()
+    tcpConn, err := net.Dial(TCP, addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer tcpConn.Close()
+
+    // Both listeners serve the same databases.
+    expectResponse(t, unixConn, command("set", "key", "value"), []byte("+OK\r\n"))
+    expectResponse(t, tcpConn, command("get", "key"), []byte("+value\r\n"))
+
+    if _, err = unixConn.Write(command("client", "info")); err != nil {
+        t.Fatal(err)
+    }
+    if info := readBulkString(t, unixConn); !strings.Contains(info, " addr="+path+":0 laddr="+path+":0 ") || !strings.Contains(info, " flags=U ") {
+        t.Errorf("error CLIENT INFO of a unix socket client: got %q.\n", info)
+    }
+    if _, err = tcpConn.Write(command("client", "info")); err != nil {
+        t.Fatal(err)
+    }
+    if info := readBulkString(t, tcpConn); !strings.Contains(info, " flags=N ") {
+        t.Errorf("error CLIENT INFO of a TCP client: got %q.\n", info)
+    }
+
+    expectResponse(t, tcpConn, command("config", "get", "unixsocketperm"), command("unixsocketperm", "700"))
+    if err = rs.Close(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    if _, err = os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("error the unix socket should be removed on shutdown, got %v.\n", err)
+    }
+}
+
+func TestRedisServer_Info(t *testing.T) {
+    rs, addr := startTestServer(t)
+
+    conn, err := net.Dial(TCP, addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer conn.Close()
+    expectResponse(t, conn, command("set", "foo", "bar"), []byte("+OK\r\n"))
+    expectResponse(t, conn, command("set", "volatile", "bar", "ex", "100"), []byte("+OK\r\n"))
+    expectResponse(t, conn, command("get", "foo"), []byte("+bar\r\n"))
+    expectResponse(t, conn, command("get", "missing"), []byte("$2\r\n-1\r\n"))
+    expectResponse(t, conn, command("subscribe", "news"), []byte("*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n"))
+
+    info := string(rs.info(nil))
+    var sections []string
+    for _, line := range strings.Split(info, "\r\n") {
+        if strings.HasPrefix(line, "# ") {
+            sections = append(sections, line[2:])
+        }
+    }
+    expected := []string{"Server", "Clients", "Memory", "Persistence", "Stats", "Keyspace"}
+    if !slices.Equal(sections, expected) {
+        t.Errorf("error INFO sections: expected %q, got %q.\n", expected, sections)
+    }
+    _, port, _ := net.SplitHostPort(addr)
+    for _, field := range []string{"redis_version:" + redisVersion, "tcp_port:" + port, "listener0:name=tcp,bind=127.0.0.1,port=" + port,
+        "connected_clients:1", "pubsub_clients:1", "total_connections_received:1", "total_commands_processed:5",
+        "keyspace_hits:1", "keyspace_misses:1", "pubsub_channels:1", "db0:keys=2,expires=1,avg_ttl="} {
+        if !strings.Contains(info, "\r\n"+field) {
+            t.Errorf("error INFO: expected %q in %q.\n", field, info)
+        }
+    }
+    if !strings.Contains(info, "\r\nused_memory:") || !strings.Contains(info, "\r\nrun_id:"+rs.runID+"\r\n") || len(rs.runID) != 40 {
+        t.Errorf("error INFO should report the memory and the run id, got %q.\n", info)
+    }
+
+    // Only the selected sections are returned.
+    info = string(rs.info([]string{"keyspace", "CLIENTS"}))
+    if !strings.HasPrefix(info, "$") || !strings.Contains(info, "# Clients\r\n") || !strings.Contains(info, "# Keyspace\r\n") || strings.Contains(info, "# Server") {
+        t.Errorf("error INFO keyspace clients: got %q.\n", info)
+    }
+
+    expectResponse(t, conn, command("unsubscribe"), []byte("*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:0\r\n"))
+    expectResponse(t, conn, command("config", "resetstat"), []byte("+OK\r\n"))
+    info = string(rs.info([]string{"stats"}))
+    for _, field := range []string{"total_commands_processed:0", "total_connections_received:0", "keyspace_hits:0", "keyspace_misses:0"} {
+        if !strings.Contains(info, "\r\n"+field+"\r\n") {
+            t.Errorf("error INFO after CONFIG RESETSTAT: expected %q in %q.\n", field, info)
+        }
+    }
+}
+
+func Test_bytesToHuman(t *testing.T) {
+    testCases := []struct {
+        n        uint64
+        expected string
+    }{
+        {0, "0B"},
+        {1023, "1023B"},
+        {1024, "1.00K"},
+        {1536 << 10, "1.50M"},
+        {3 << 30, "3.00G"},
+        {2048 << 40, "2048.00T"},
+    }
+    for _, tc := range testCases {
+        if human := bytesToHuman(tc.n); human != tc.expected {
+            t.Errorf("error formatting %d bytes: expected %s, got %s.\n", tc.n, tc.expected, human)
+        }
+    }
+}
+
+func TestRedisServer_CommandStats(t *testing.T) {
+    rs, addr := startTestServer(t)
+
+    conn, err := net.Dial(TCP, addr)
+    if err != nil {
+        t.Fatalf("error cannot connect to server: %#v\n", err)
+    }
+    defer conn.Close()
+    expectResponse(t, conn, command("set", "foo", "bar"), []byte("+OK\r\n"))
+    expectResponse(t, conn, command("set", "list", "a"), []byte("+OK\r\n"))
+    expectResponse(t, conn, command("incr", "foo"), []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"))
+    expectResponse(t, conn, command("client", "setname", "worker"), []byte("+OK\r\n"))
+    expectResponse(t, conn, command("multi"), []byte("+OK\r\n"))
+    expectResponse(t, conn, command("get", "foo"), []byte("+QUEUED\r\n"))
+    expectResponse(t, conn, command("exec"), []byte("*1\r\n+bar\r\n"))
+    expectResponse(t, conn, command("acl", "setuser", "default", "-flushall"), []byte("+OK\r\n"))
+    expectResponse(t, conn, command("flushall"), []byte("-NOPERM User default has no permissions to run the 'flushall' command\r\n"))
+    // Unknown subcommands are counted by their command, unknown commands aren't recorded.
+    expectResponse(t, conn, command("config", "nosuchsubcommand"),
+        []byte("-ERR unknown subcommand or wrong number of arguments for 'nosuchsubcommand'. Try CONFIG HELP.\r\n"))
+    rs.rejectCall("nosuchcommand")
+
+    info := string(rs.info([]string{"commandstats"}))
+    if !strings.Contains(info, "\r\ncmdstat_config:calls=1,") || strings.Contains(info, "nosuch") {
+        t.Errorf("error INFO commandstats should only report known commands, got %q.\n", info)
+    }
+    for _, field := range []string{"cmdstat_set:calls=2,", "cmdstat_incr:calls=1,", "cmdstat_client|setname:calls=1,", "cmdstat_multi:calls=1,",
+        "cmdstat_get:calls=1,", "cmdstat_exec:calls=1,", "cmdstat_flushall:calls=0,"} {
+        if !strings.Contains(info, "\r\n"+field) {
+            t.Errorf("error INFO commandstats: expected %q in %q.\n", field, info)
+        }
+    }
+    if !strings.Contains(info, ",rejected_calls=0,failed_calls=1\r\n") || !strings.Contains(info, ",rejected_calls=1,failed_calls=0\r\n") {
+        t.Errorf("error INFO commandstats should count the failed and rejected calls, got %q.\n", info)
+    }
+
+    info = string(rs.info([]string{"latencystats"}))
+    if !strings.Contains(info, "\r\nlatency_percentiles_usec_set:p50=") || !strings.Contains(info, ",p99=") || !strings.Contains(info, ",p99.9=") ||
+        strings.Contains(info, "usec_flushall") {
+        t.Errorf("error INFO latencystats: got %q.\n", info)
+    }
+    if info = string(rs.info(nil)); strings.Contains(info, "# Commandstats") {
+        t.Errorf("error the default sections shouldn't include commandstats, got %q.\n", info)
+    }
+
+    expectResponse(t, conn, command("config", "resetstat"), []byte("+OK\r\n"))
+    info = string(rs.info([]string{"everything"}))
+    if strings.Contains(info, "cmdstat_set") || strings.Contains(info, "latency_percentiles_usec_set") || !strings.Contains(info, "# Latencystats") {
+        t.Errorf("error CONFIG RESETSTAT should reset the command stats, got %q.\n", info)
+    }
+
+    // Latency tracking can be disabled, the calls are still counted.
+    expectResponse(t, conn, command("config", "set", "latency-tracking", "no"), []byte("+OK\r\n"))
+    expectResponse(t, conn, command("set", "foo", "bar"), []byte("+OK\r\n"))
+    if info = string(rs.info([]string{"commandstats", "latencystats"})); !strings.Contains(info, "cmdstat_set:calls=1,") || strings.Contains(info, "latency_percentiles_usec_set") {
+        t.Errorf("error INFO with latency-tracking disabled: got %q.\n", info)
+    }
+}
+
+func Test_histogram(t *testing.T) {
+    var h histogram
+    if p := h.percentile(50); p != 0 {
+        t.Errorf("error percentile of an empty histogram: expected 0, got %v.\n", p)
+    }
+    for i := 1; i <= 1000; i++ {
+        h.add(time.Duration(i) * time.Microsecond)
+    }
+    for _, tc := range []struct {
+        p        float64
+        expected time.Duration
+    }{{50, 500 * time.Microsecond}, {99, 990 * time.Microsecond}, {99.9, 999 * time.Microsecond}, {100, time.Millisecond}} {
+        // Durations are counted with a relative error of at most 1/2^histogramPrecision.
+        if p := h.percentile(tc.p); p < tc.expected || float64(p-tc.expected) > float64(tc.expected)/(1<<histogramPrecision) {
+            t.Errorf("error percentile %v: expected about %v, got %v.\n", tc.p, tc.expected, p)
+        }
+    }
+
+    for _, n := range []uint64{0, 31, 32, 33, 1000, 1 << 40, 1<<63 + 12345, math.MaxUint64} {
+        i := bucket(n)
+        if i < 0 || i >= histogramBuckets || bucketMax(i) < n || i > 0 && bucketMax(i-1) >= n {
+            t.Errorf("error bucket of %d: got %d, holding up to %d.\n", n, i, bucketMax(i))
+        }
+    }
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+    "MyOwnRedis/internal/database/inMemoryDatabase"
+    "MyOwnRedis/internal/redisObject"
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+)
+
+// DefaultShutdownTimeout is the default of shutdown-timeout.
+const DefaultShutdownTimeout = 10 * time.Second
+
+var (
+    ErrShutdownInProgress = errors.New("error a shutdown is already in progress")
+    ErrShutdownAborted    = errors.New("error the shutdown was aborted")
+    ErrServerClosed       = errors.New("error the server is closed")
+)
+
+// ShutdownOptions are the options of SHUTDOWN.
+type ShutdownOptions struct {
+    // NoSave skips the final save, Save saves even without save points.
+    NoSave, Save bool
+    // Now doesn't wait for the background save or AOF rewrite in progress.
+    Now bool
+    // Force shuts the server down even if the final save or the flush of the AOF fails.
+    Force bool
+}
+
+// Shutdown shuts the server down gracefully: commands aren't executed anymore once the in-flight ones finished,
+// the background save or AOF rewrite in progress, if any, is given shutdown-timeout to finish, the AOF is flushed to disk,
+// the databases are saved if save points are configured, then the listener and the client connections are closed.
+// If the final save or the flush fails, the shutdown is aborted and the server keeps on running, unless opts.Force is set.
+// Shutdown returns once every connection is closed, or when ctx is done.
+func (r *RedisServer) Shutdown(ctx context.Context, opts ShutdownOptions) error {
+    if err := r.shutdown(ctx, opts); err != nil {
+        return err
+    }
+
+    closed := make(chan struct{})
+    go func() {
+        r.conns.Wait()
+        close(closed)
+    }()
+    select {
+    case <-closed:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Close shuts the server down without saving, see Shutdown.
+func (r *RedisServer) Close(ctx context.Context) error {
+    return r.Shutdown(ctx, ShutdownOptions{NoSave: true})
+}
+
+// Done returns a channel closed once the server is shut down, e.g. with SHUTDOWN.
+func (r *RedisServer) Done() <-chan struct{} {
+    return r.stopped
+}
+
+// shutdown shuts the server down, without waiting for the connections to be closed.
+func (r *RedisServer) shutdown(ctx context.Context, opts ShutdownOptions) error {
+    r.Lock()
+    if r.shutdownAbort != nil || r.closed.Load() {
+        r.Unlock()
+        return ErrShutdownInProgress
+    }
+    abort := make(chan struct{})
+    r.shutdownAbort = abort
+    r.Unlock()
+    log.Println("Shutting down RRedis...")
+
+    // Once the in-flight commands finished, no command is executed anymore.
+    r.commandLock.Lock()
+    if err := r.prepareShutdown(ctx, abort, opts); err != nil {
+        r.commandLock.Unlock()
+        r.Lock()
+        r.shutdownAbort = nil
+        r.Unlock()
+        log.Printf("RRedis shutdown aborted: %v", err)
+        return err
+    }
+
+    // The commands waiting for the command lock fail from now on, and the background jobs don't start anymore.
+    r.closed.Store(true)
+    close(r.done)
+    r.Lock()
+    var err error
+    for _, l := range r.listeners {
+        err = errors.Join(err, l.Close())
+    }
+    r.shutdownAbort = nil
+    r.Unlock()
+    if r.aof != nil {
+        err = errors.Join(err, r.aof.Close())
+    }
+    r.disconnectClients()
+    r.commandLock.Unlock()
+
+    close(r.stopped)
+    log.Println("RRedis is now ready to exit, bye bye...")
+    return err
+}
+
+// prepareShutdown waits for the background jobs, then flushes the AOF and saves the databases before shutting down.
+// Callers must hold the command lock for writing.
+func (r *RedisServer) prepareShutdown(ctx context.Context, abort <-chan struct{}, opts ShutdownOptions) error {
+    r.RLock()
+    timeout, save := r.shutdownTimeout, len(r.savePoints) > 0
+    r.RUnlock()
+    if !opts.Now {
+        if err := r.waitBackgroundJobs(ctx, abort, timeout); err != nil {
+            return err
+        }
+    }
+
+    if r.aof != nil {
+        if err := r.aof.Sync(); err != nil {
+            if !opts.Force {
+                return fmt.Errorf("error flushing the append only file: %w", err)
+            }
+            log.Printf("RRedis error flushing the append only file, shutting down anyway: %v", err)
+        }
+    }
+
+    if opts.NoSave || !opts.Save && !save {
+        return nil
+    }
+    log.Println("RRedis saving the final snapshot before exiting")
+    // A background save in progress, with NOW, is waited for by the store.
+    if err := r.store.SaveDatabase(); err != nil && !errors.Is(err, inMemoryDatabase.ErrPersistenceDisabled) {
+        if !opts.Force {
+            return fmt.Errorf("error saving the final snapshot: %w", err)
+        }
+        log.Printf("RRedis error saving the final snapshot, shutting down anyway: %v", err)
+    }
+    return nil
+}
+
+// waitBackgroundJobs waits for the background save or AOF rewrite in progress, if any, for at most timeout.
+// ErrShutdownAborted is returned if the shutdown is aborted meanwhile.
+func (r *RedisServer) waitBackgroundJobs(ctx context.Context, abort <-chan struct{}, timeout time.Duration) error {
+    ctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+    ticker := time.NewTicker(10 * time.Millisecond)
+    defer ticker.Stop()
+
+    for {
+        r.RLock()
+        idle := r.bgsaveStart.IsZero() && r.aofRewriteStart.IsZero()
+        r.RUnlock()
+        if idle {
+            return nil
+        }
+
+        select {
+        case <-abort:
+            return ErrShutdownAborted
+        case <-ctx.Done():
+            log.Println("RRedis background job still running after shutdown-timeout, shutting down anyway")
+            return nil
+        case <-ticker.C:
+        }
+    }
+}
+
+// abortShutdown aborts the shutdown waiting for the background jobs, if any.
+func (r *RedisServer) abortShutdown() bool {
+    r.Lock()
+    defer r.Unlock()
+    if r.shutdownAbort == nil {
+        return false
+    }
+    close(r.shutdownAbort)
+    r.shutdownAbort = nil
+    return true
+}
+
+// disconnectClients closes the connection of every client, once the replies already queued are written.
+func (r *RedisServer) disconnectClients() {
+    r.clientsLock.RLock()
+    defer r.clientsLock.RUnlock()
+    for _, c := range r.clients {
+        c.kill()
+    }
+}
+
+// shutdownCommand handles `SHUTDOWN [NOSAVE | SAVE] [NOW] [FORCE] [ABORT]`. Nothing is replied on success, the connection is closed.
+func (r *RedisServer) shutdownCommand(args []string) []byte {
+    var opts ShutdownOptions
+    abort := false
+    for _, arg := range args {
+        switch strings.ToLower(arg) {
+        case "nosave":
+            opts.NoSave = true
+        case "save":
+            opts.Save = true
+        case "now":
+            opts.Now = true
+        case "force":
+            opts.Force = true
+        case "abort":
+            abort = true
+        default:
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+        }
+    }
+    if opts.NoSave && opts.Save || abort && len(args) > 1 {
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+    }
+
+    if abort {
+        if !r.abortShutdown() {
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR No shutdown in progress.")
+        }
+        return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+    }
+    if err := r.shutdown(context.Background(), opts); err != nil {
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR Errors trying to SHUTDOWN. Check logs.")
+    }
+    return nil
+}
@@ -0,0 +1,34 @@
+package server
+
+import "strings"
+
+// ClusterSlots is the number of hash slots keys and shard channels are distributed over.
+const ClusterSlots = 16384
+
+// keyHashSlot returns the hash slot of key, the CRC16 of the key modulo 16384.
+// If the key contains a non-empty hash tag, i.e. a substring between the first `{` and the next `}`,
+// only the hash tag is hashed, so related keys can be forced into the same slot: `{user1000}.following` and `{user1000}.followers`.
+func keyHashSlot(key string) int {
+    if start := strings.IndexByte(key, '{'); start != -1 {
+        if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+            key = key[start+1 : start+1+end]
+        }
+    }
+    return int(crc16(key)) & (ClusterSlots - 1)
+}
+
+// crc16 implements the CRC16-CCITT ( XMODEM ) checksum used by Redis Cluster.
+func crc16(s string) uint16 {
+    var crc uint16
+    for i := 0; i < len(s); i++ {
+        crc ^= uint16(s[i]) << 8
+        for j := 0; j < 8; j++ {
+            if crc&0x8000 != 0 {
+                crc = crc<<1 ^ 0x1021
+            } else {
+                crc <<= 1
+            }
+        }
+    }
+    return crc
+}
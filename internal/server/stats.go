@@ -0,0 +1,113 @@
+package server
+
+import (
+    "runtime"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// The instantaneous metrics of INFO stats are averaged over statsSamples samples, taken every statsSampleInterval.
+const (
+    statsSamples        = 16
+    statsSampleInterval = 100 * time.Millisecond
+)
+
+// serverStats are the counters of the server reported by INFO stats, reset by CONFIG RESETSTAT.
+type serverStats struct {
+    connections         atomic.Int64
+    rejectedConnections atomic.Int64
+    commands            atomic.Int64
+    // netInput and netOutput count the bytes read from and written to the clients.
+    netInput  atomic.Int64
+    netOutput atomic.Int64
+    // peakMemory is the highest memory allocated seen, see sampleMemory.
+    peakMemory atomic.Uint64
+    // ops, input and output sample commands, netInput and netOutput. They are guarded by the lock.
+    ops, input, output metricSamples
+    sync.Mutex
+}
+
+// metricSamples computes the instantaneous rate of a counter, averaged over its last samples.
+type metricSamples struct {
+    rates     [statsSamples]float64
+    next      int
+    lastTime  time.Time
+    lastValue int64
+}
+
+// add samples the value of the counter at now.
+func (m *metricSamples) add(now time.Time, value int64) {
+    if elapsed := now.Sub(m.lastTime); !m.lastTime.IsZero() && elapsed > 0 {
+        m.rates[m.next] = float64(value-m.lastValue) / elapsed.Seconds()
+        m.next = (m.next + 1) % statsSamples
+    }
+    m.lastTime, m.lastValue = now, value
+}
+
+// rate returns the average rate per second of the counter over the last samples.
+func (m *metricSamples) rate() float64 {
+    var sum float64
+    for _, rate := range m.rates {
+        sum += rate
+    }
+    return sum / statsSamples
+}
+
+// sample samples the counters of the instantaneous metrics.
+func (s *serverStats) sample(now time.Time) {
+    s.Lock()
+    defer s.Unlock()
+    s.ops.add(now, s.commands.Load())
+    s.input.add(now, s.netInput.Load())
+    s.output.add(now, s.netOutput.Load())
+}
+
+// rates returns the instantaneous commands per second, and the input and output in kilobytes per second.
+func (s *serverStats) rates() (ops, inputKbps, outputKbps float64) {
+    s.Lock()
+    defer s.Unlock()
+    return s.ops.rate(), s.input.rate() / 1024, s.output.rate() / 1024
+}
+
+// reset zeroes the counters, the peak memory being reset to the memory allocated now.
+func (s *serverStats) reset() {
+    s.Lock()
+    defer s.Unlock()
+    s.connections.Store(0)
+    s.rejectedConnections.Store(0)
+    s.commands.Store(0)
+    s.netInput.Store(0)
+    s.netOutput.Store(0)
+    s.ops, s.input, s.output = metricSamples{}, metricSamples{}, metricSamples{}
+    s.peakMemory.Store(0)
+    s.sampleMemory()
+}
+
+// sampleMemory returns the statistics of the memory allocated, updating its peak.
+func (s *serverStats) sampleMemory() runtime.MemStats {
+    var m runtime.MemStats
+    runtime.ReadMemStats(&m)
+    for {
+        peak := s.peakMemory.Load()
+        if m.HeapAlloc <= peak || s.peakMemory.CompareAndSwap(peak, m.HeapAlloc) {
+            return m
+        }
+    }
+}
+
+// statsCron samples the instantaneous metrics and the peak memory until the server shuts down.
+func (r *RedisServer) statsCron() {
+    ticker := time.NewTicker(statsSampleInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-r.done:
+            return
+        case now := <-ticker.C:
+            r.stats.sample(now)
+            r.stats.sampleMemory()
+        }
+    }
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "net"
+    "os"
+    "strings"
+    "time"
+)
+
+// tlsHandshakeTimeout is the time a client has to complete the TLS handshake once connected.
+const tlsHandshakeTimeout = 10 * time.Second
+
+var (
+    ErrTLSCertRequired    = errors.New("tls-cert-file and tls-key-file are required")
+    ErrTLSCACertRequired  = errors.New("tls-ca-cert-file is required to authenticate the clients")
+    ErrInvalidAuthClients = errors.New("argument must be 'yes', 'no' or 'optional'")
+)
+
+// tlsSettings are the settings of the TLS listener, see tls-port.
+type tlsSettings struct {
+    // port is the port of the TLS listener, 0 if TLS is disabled.
+    port                          int
+    certFile, keyFile, caCertFile string
+    // authClients tells whether the clients must authenticate with a certificate: yes, no or optional.
+    authClients string
+}
+
+// load reads the certificates and returns the configuration of the TLS connections.
+func (s tlsSettings) load() (*tls.Config, error) {
+    if s.certFile == "" || s.keyFile == "" {
+        return nil, ErrTLSCertRequired
+    }
+    cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+    if err != nil {
+        return nil, err
+    }
+    config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+    switch s.authClients {
+    case "yes":
+        config.ClientAuth = tls.RequireAndVerifyClientCert
+    case "optional":
+        config.ClientAuth = tls.VerifyClientCertIfGiven
+    default:
+        config.ClientAuth = tls.NoClientCert
+    }
+    if s.caCertFile == "" {
+        if config.ClientAuth != tls.NoClientCert {
+            return nil, ErrTLSCACertRequired
+        }
+        return config, nil
+    }
+    pem, err := os.ReadFile(s.caCertFile)
+    if err != nil {
+        return nil, err
+    }
+    config.ClientCAs = x509.NewCertPool()
+    if !config.ClientCAs.AppendCertsFromPEM(pem) {
+        return nil, fmt.Errorf("no certificate found in %s", s.caCertFile)
+    }
+    return config, nil
+}
+
+// parseAuthClients parses the value of tls-auth-clients.
+func parseAuthClients(value string) (string, error) {
+    value = strings.ToLower(value)
+    switch value {
+    case "yes", "no", "optional":
+        return value, nil
+    }
+    return "", ErrInvalidAuthClients
+}
+
+// reloadTLS loads the certificates of the TLS listener, used by the connections accepted from then on.
+// Nothing is loaded while TLS is disabled.
+func (r *RedisServer) reloadTLS() error {
+    r.RLock()
+    settings := r.tls
+    r.RUnlock()
+    if settings.port == 0 {
+        return nil
+    }
+    config, err := settings.load()
+    if err != nil {
+        return err
+    }
+    r.tlsConfig.Store(config)
+    return nil
+}
+
+// getTLSConfig returns the configuration of a new TLS connection, the one last loaded by reloadTLS.
+func (r *RedisServer) getTLSConfig(*tls.ClientHelloInfo) (*tls.Config, error) {
+    return r.tlsConfig.Load(), nil
+}
+
+// handshake completes the TLS handshake of a new connection, if it is one, before serving it.
+func handshake(conn net.Conn) error {
+    tc, ok := conn.(*tls.Conn)
+    if !ok {
+        return nil
+    }
+    _ = tc.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+    if err := tc.Handshake(); err != nil {
+        return err
+    }
+    return tc.SetDeadline(time.Time{})
+}
@@ -0,0 +1,418 @@
+package server
+
+import (
+    "MyOwnRedis/internal/redisObject"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// trackingChannel is the channel RESP2 clients subscribe to, to receive the invalidation messages redirected to them.
+const trackingChannel = "__redis__:invalidate"
+
+// trackingOptions are the options of a client that enabled CLIENT TRACKING.
+type trackingOptions struct {
+    // bcast enables the broadcasting mode: the client is sent an invalidation message for every modified key
+    // matching one of its prefixes, whether it read the key or not.
+    bcast    bool
+    prefixes []string
+    // optIn only tracks the keys read right after `CLIENT CACHING yes`,
+    // optOut tracks every key read except those read right after `CLIENT CACHING no`.
+    optIn, optOut bool
+    // noLoop skips the invalidation messages of the keys modified by the client itself.
+    noLoop bool
+    // redirect is the id of the client the invalidation messages are sent to instead, 0 if none.
+    redirect uint64
+}
+
+// tracking is the invalidation table of client side caching, remembering which clients may have cached which keys.
+type tracking struct {
+    // clients holds the clients that enabled tracking, by id.
+    clients map[uint64]*client
+    // keys maps each key read by clients in the default mode to the ids of those clients.
+    // Ids are stored rather than clients, so the keys of the clients that disabled tracking are dropped lazily, once invalidated.
+    keys map[string]map[uint64]struct{}
+    // reading counts the reads in progress of each key by the ids of the clients reading it. Their keys are tracked until
+    // the reads end, even once invalidated, see trackReadKeys.
+    reading map[string]map[uint64]int
+    // prefixes maps each prefix of the broadcasting mode to its clients.
+    prefixes map[string]map[*client]struct{}
+    sync.Mutex
+}
+
+// newTracking creates an empty invalidation table.
+func newTracking() *tracking {
+    return &tracking{
+        clients:  make(map[uint64]*client),
+        keys:     make(map[string]map[uint64]struct{}),
+        reading:  make(map[string]map[uint64]int),
+        prefixes: make(map[string]map[*client]struct{}),
+    }
+}
+
+// disable turns tracking off for c. Callers must hold the lock.
+func (t *tracking) disable(c *client) {
+    if c.tracking == nil {
+        return
+    }
+    for _, prefix := range c.tracking.prefixes {
+        delete(t.prefixes[prefix], c)
+        if len(t.prefixes[prefix]) == 0 {
+            delete(t.prefixes, prefix)
+        }
+    }
+    delete(t.clients, c.id)
+    c.tracking = nil
+}
+
+// disableTracking turns tracking off for c, e.g. when its connection is closed.
+func (r *RedisServer) disableTracking(c *client) {
+    r.tracking.Lock()
+    defer r.tracking.Unlock()
+    r.tracking.disable(c)
+}
+
+// readKeys returns the keys read by a command, those a client may cache.
+func readKeys(robj *redisObject.RObj) []string {
+    switch robj.Command {
+    case "get", "lrange":
+        return robj.Content[:1]
+    case "exists":
+        return robj.Content
+    }
+    return nil
+}
+
+// isClientCaching reports whether the command is CLIENT CACHING, which applies to the command following it.
+func isClientCaching(robj *redisObject.RObj) bool {
+    return robj.Command == "client" && strings.ToLower(robj.Content[0]) == "caching"
+}
+
+// trackReadKeys remembers the keys read by c in the default mode, so c is sent an invalidation message once they are modified.
+// The keys are tracked before being read, and stay tracked until the returned function is called once the command ran:
+// a concurrent write is then always invalidated, and c keeps tracking the value it reads after it.
+func (r *RedisServer) trackReadKeys(c *client, robj *redisObject.RObj) (done func()) {
+    options := c.tracking
+    if options == nil || options.bcast {
+        return func() {}
+    }
+    if options.optIn && !c.caching || options.optOut && c.caching {
+        return func() {}
+    }
+
+    keys := readKeys(robj)
+    if len(keys) == 0 {
+        return func() {}
+    }
+
+    t := r.tracking
+    t.Lock()
+    defer t.Unlock()
+    for _, key := range keys {
+        t.track(key, c.id)
+        if _, ok := t.reading[key]; !ok {
+            t.reading[key] = make(map[uint64]int)
+        }
+        t.reading[key][c.id]++
+    }
+    return func() {
+        t.Lock()
+        defer t.Unlock()
+        for _, key := range keys {
+            if t.reading[key][c.id]--; t.reading[key][c.id] == 0 {
+                delete(t.reading[key], c.id)
+            }
+            if len(t.reading[key]) == 0 {
+                delete(t.reading, key)
+            }
+        }
+    }
+}
+
+// track remembers that the client id may have cached key. Callers must hold the lock.
+func (t *tracking) track(key string, id uint64) {
+    if _, ok := t.keys[key]; !ok {
+        t.keys[key] = make(map[uint64]struct{})
+    }
+    t.keys[key][id] = struct{}{}
+}
+
+// trackReading tracks again the keys being read once they were invalidated, see trackReadKeys. Callers must hold the lock.
+func (t *tracking) trackReading(keys ...string) {
+    for _, key := range keys {
+        for id := range t.reading[key] {
+            t.track(key, id)
+        }
+    }
+}
+
+// invalidateKey sends an invalidation message for the modified key to the clients tracking it.
+// A key is only invalidated once in the default mode: clients must read it again to be notified of the next modification.
+// NOLOOP clients aren't sent the invalidations of their own writes, origin being the id of the client that modified the key.
+func (r *RedisServer) invalidateKey(key string, origin uint64) {
+    t := r.tracking
+    t.Lock()
+    defer t.Unlock()
+
+    for id := range t.keys[key] {
+        c, ok := t.clients[id]
+        if !ok || c.tracking.bcast || c.tracking.noLoop && c.id == origin {
+            continue
+        }
+        r.sendInvalidation(c, []string{key})
+    }
+    delete(t.keys, key)
+    t.trackReading(key)
+
+    for prefix, clients := range t.prefixes {
+        if !strings.HasPrefix(key, prefix) {
+            continue
+        }
+        for c := range clients {
+            if c.tracking.noLoop && c.id == origin {
+                continue
+            }
+            r.sendInvalidation(c, []string{key})
+        }
+    }
+}
+
+// invalidateAll sends an invalidation message for every key to all the tracking clients, once the databases are flushed.
+func (r *RedisServer) invalidateAll() {
+    t := r.tracking
+    t.Lock()
+    defer t.Unlock()
+
+    for _, c := range t.clients {
+        r.sendInvalidation(c, nil)
+    }
+    t.keys = make(map[string]map[uint64]struct{})
+    for key := range t.reading {
+        t.trackReading(key)
+    }
+}
+
+// sendInvalidation pushes an invalidation message for keys to c, or to the client it redirects to. Nil keys invalidate everything.
+// RESP3 clients receive an `invalidate` push, RESP2 clients must be redirected to a client subscribed to __redis__:invalidate.
+// Callers must hold the tracking lock.
+func (r *RedisServer) sendInvalidation(c *client, keys []string) {
+    target := c
+    if id := c.tracking.redirect; id != 0 {
+        if target = r.lookupClient(id); target == nil {
+            c.writePush(pushMessage{resp3: redisObject.SerializePush(
+                redisObject.Serialize(redisObject.BulkStrings, "tracking-redir-broken"),
+                redisObject.Serialize(redisObject.Integers, strconv.FormatUint(id, 10)),
+            )})
+            return
+        }
+    }
+
+    resp2Keys, resp3Keys := redisObject.NullBulkStrings, redisObject.Null
+    if keys != nil {
+        resp2Keys = redisObject.Serialize(redisObject.Arrays, keys...)
+        resp3Keys = resp2Keys
+    }
+
+    m := pushMessage{resp3: redisObject.SerializePush(redisObject.Serialize(redisObject.BulkStrings, "invalidate"), resp3Keys)}
+    if target != c && r.pubsub.subscribedTo(target, trackingChannel) {
+        m.resp2 = redisObject.SerializeArray(
+            redisObject.Serialize(redisObject.BulkStrings, "message"),
+            redisObject.Serialize(redisObject.BulkStrings, trackingChannel),
+            resp2Keys,
+        )
+    }
+    target.writePush(m)
+}
+
+// clientTracking handles `CLIENT TRACKING ON|OFF [REDIRECT client-id] [PREFIX prefix [PREFIX prefix ...]] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]`.
+// Enabling tracking again adds the options to the current ones.
+func (r *RedisServer) clientTracking(c *client, args []string) []byte {
+    var on bool
+    switch strings.ToLower(args[0]) {
+    case "on":
+        on = true
+    case "off":
+    default:
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+    }
+
+    var options trackingOptions
+    for i := 1; i < len(args); i++ {
+        switch strings.ToLower(args[i]) {
+        case "redirect":
+            if i+1 == len(args) {
+                return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+            }
+            i++
+            id, err := strconv.ParseUint(args[i], 10, 64)
+            if err != nil {
+                return redisObject.Serialize(redisObject.SimpleErrors, "ERR value is not an integer or out of range")
+            }
+            options.redirect = id
+        case "prefix":
+            if i+1 == len(args) {
+                return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+            }
+            i++
+            options.prefixes = append(options.prefixes, args[i])
+        case "bcast":
+            options.bcast = true
+        case "optin":
+            options.optIn = true
+        case "optout":
+            options.optOut = true
+        case "noloop":
+            options.noLoop = true
+        default:
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+        }
+    }
+
+    r.tracking.Lock()
+    defer r.tracking.Unlock()
+
+    if !on {
+        r.tracking.disable(c)
+        c.caching = false
+        return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+    }
+
+    if err := checkTrackingOptions(c.tracking, &options); err != "" {
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR "+err)
+    }
+    if options.redirect != 0 && r.lookupClient(options.redirect) == nil {
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR The client ID you want redirect to does not exist")
+    }
+
+    if c.tracking == nil {
+        c.tracking = &trackingOptions{}
+        r.tracking.clients[c.id] = c
+    }
+    c.tracking.bcast = c.tracking.bcast || options.bcast
+    c.tracking.optIn = c.tracking.optIn || options.optIn
+    c.tracking.optOut = c.tracking.optOut || options.optOut
+    c.tracking.noLoop = c.tracking.noLoop || options.noLoop
+    c.tracking.redirect = options.redirect
+
+    if options.bcast && len(options.prefixes) == 0 {
+        // Broadcasting without prefix tracks every key.
+        options.prefixes = []string{""}
+    }
+    for _, prefix := range options.prefixes {
+        if _, ok := r.tracking.prefixes[prefix]; !ok {
+            r.tracking.prefixes[prefix] = make(map[*client]struct{})
+        }
+        r.tracking.prefixes[prefix][c] = struct{}{}
+        c.tracking.prefixes = append(c.tracking.prefixes, prefix)
+    }
+    return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+}
+
+// checkTrackingOptions validates the options enabling tracking against each other and against the current ones, nil if tracking is off.
+// It returns the error message of invalid options, an empty string otherwise.
+func checkTrackingOptions(current, options *trackingOptions) string {
+    switch {
+    case len(options.prefixes) > 0 && !options.bcast:
+        return "PREFIX option requires BCAST mode to be enabled"
+    case current != nil && current.bcast != options.bcast:
+        return "You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode."
+    case options.bcast && (options.optIn || options.optOut):
+        return "OPTIN and OPTOUT are not compatible with BCAST"
+    case options.optIn && options.optOut:
+        return "You can't use both OPTIN and OPTOUT"
+    case current != nil && (options.optIn && current.optOut || options.optOut && current.optIn):
+        return "You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode."
+    }
+
+    // The prefixes of a client must not overlap, or a key would be invalidated twice.
+    for i, prefix := range options.prefixes {
+        if current != nil {
+            for _, existing := range current.prefixes {
+                if prefixesOverlap(prefix, existing) {
+                    return "Prefix '" + prefix + "' overlaps with an existing prefix '" + existing + "'. Prefixes for a single client must not overlap."
+                }
+            }
+        }
+        for _, other := range options.prefixes[i+1:] {
+            if prefixesOverlap(prefix, other) {
+                return "Prefix '" + prefix + "' overlaps with another provided prefix '" + other + "'. Prefixes for a single client must not overlap."
+            }
+        }
+    }
+    return ""
+}
+
+// prefixesOverlap reports whether one of the prefixes is a prefix of the other.
+func prefixesOverlap(a, b string) bool {
+    return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// trackingInfo handles `CLIENT TRACKINGINFO`, replying with the tracking flags, redirection and prefixes of c.
+func (r *RedisServer) trackingInfo(c *client) []byte {
+    r.tracking.Lock()
+    defer r.tracking.Unlock()
+
+    flags := []string{"off"}
+    redirect := "-1"
+    prefixes := make([]string, 0)
+    if options := c.tracking; options != nil {
+        flags = []string{"on"}
+        if options.bcast {
+            flags = append(flags, "bcast")
+        }
+        if options.optIn {
+            flags = append(flags, "optin")
+            if c.caching {
+                flags = append(flags, "caching-yes")
+            }
+        }
+        if options.optOut {
+            flags = append(flags, "optout")
+            if c.caching {
+                flags = append(flags, "caching-no")
+            }
+        }
+        if options.noLoop {
+            flags = append(flags, "noloop")
+        }
+        if options.redirect != 0 && r.lookupClient(options.redirect) == nil {
+            flags = append(flags, "broken_redirect")
+        }
+        redirect = strconv.FormatUint(options.redirect, 10)
+        if options.bcast {
+            prefixes = options.prefixes
+        }
+    }
+
+    return c.encodeMap(
+        redisObject.Serialize(redisObject.BulkStrings, "flags"),
+        redisObject.Serialize(redisObject.Arrays, flags...),
+        redisObject.Serialize(redisObject.BulkStrings, "redirect"),
+        redisObject.Serialize(redisObject.Integers, redirect),
+        redisObject.Serialize(redisObject.BulkStrings, "prefixes"),
+        redisObject.Serialize(redisObject.Arrays, prefixes...),
+    )
+}
+
+// clientCaching handles `CLIENT CACHING YES|NO`, overriding the tracking mode for the next command.
+func clientCaching(c *client, mode string) []byte {
+    if c.tracking == nil || !c.tracking.optIn && !c.tracking.optOut {
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+    }
+
+    switch strings.ToLower(mode) {
+    case "yes":
+        if !c.tracking.optIn {
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+        }
+    case "no":
+        if !c.tracking.optOut {
+            return redisObject.Serialize(redisObject.SimpleErrors, "ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+        }
+    default:
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR syntax error")
+    }
+
+    c.caching = true
+    return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+}
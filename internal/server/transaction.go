@@ -0,0 +1,88 @@
+package server
+
+import (
+    "MyOwnRedis/internal/redisObject"
+    "slices"
+)
+
+// multi handles `MULTI`, marking the start of a transaction block.
+func (r *RedisServer) multi(c *client) []byte {
+    if c.multi {
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR MULTI calls can not be nested")
+    }
+    c.multi = true
+    return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+}
+
+// exec handles `EXEC`, executing all the queued commands atomically.
+// The reply is an array holding the reply of each command, or a null array if a watched key was modified.
+func (r *RedisServer) exec(c *client) []byte {
+    if !c.multi {
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR EXEC without MULTI")
+    }
+    defer c.resetTransaction()
+    // Keys are watched until EXEC, regardless of whether the transaction was aborted or not.
+    defer c.unwatchAll()
+
+    if c.multiError {
+        return redisObject.Serialize(redisObject.SimpleErrors, "EXECABORT Transaction discarded because of previous errors.")
+    }
+
+    // Stop every other client from executing commands until the transaction is done.
+    r.commandLock.Lock()
+    defer r.commandLock.Unlock()
+
+    for _, w := range c.watched {
+        if w.db.Version(w.key) != w.version {
+            return redisObject.NullArray
+        }
+    }
+
+    // The writes of the transaction are logged to the AOF as a transaction too, so they are replayed all or nothing.
+    writes := r.aof != nil && slices.ContainsFunc(c.queue, func(robj *redisObject.RObj) bool {
+        return isWriteCommand(robj.Command)
+    })
+    if writes {
+        r.propagate(c.db, "multi")
+    }
+
+    responses := make([][]byte, 0, len(c.queue))
+    for _, robj := range c.queue {
+        // The permissions may have changed since the command was queued.
+        if response := r.checkPermission(c, robj); response != nil {
+            r.rejectCall(commandName(robj))
+            responses = append(responses, response)
+            continue
+        }
+        responses = append(responses, r.call(c, robj))
+    }
+
+    if writes {
+        r.propagate(c.db, "exec")
+    }
+    return redisObject.SerializeArray(responses...)
+}
+
+// discard handles `DISCARD`, flushing the queued commands of a transaction.
+func (r *RedisServer) discard(c *client) []byte {
+    if !c.multi {
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR DISCARD without MULTI")
+    }
+    c.resetTransaction()
+    c.unwatchAll()
+    return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+}
+
+// watch handles `WATCH key [key ...]`, marking the keys to be watched for conditional execution of a transaction.
+func (r *RedisServer) watch(c *client, keys []string) []byte {
+    if c.multi {
+        c.flagTransactionError()
+        return redisObject.Serialize(redisObject.SimpleErrors, "ERR WATCH inside MULTI is not allowed")
+    }
+
+    db := r.store.Db(c.db)
+    for _, key := range keys {
+        c.watched = append(c.watched, watchedKey{db: db, key: key, version: db.Watch(key)})
+    }
+    return redisObject.Serialize(redisObject.SimpleStrings, "OK")
+}